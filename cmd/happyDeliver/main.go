@@ -52,6 +52,10 @@ func main() {
 		if err := app.RunAnalyzer(cfg, flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
 			log.Fatalf("Analyzer error: %v", err)
 		}
+	case "scan":
+		if err := app.RunBlacklistScan(cfg, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Scan error: %v", err)
+		}
 	case "backup":
 		if err := app.RunBackup(cfg); err != nil {
 			log.Fatalf("Backup error: %v", err)
@@ -77,6 +81,7 @@ func printUsage() {
 	fmt.Println("\nCommand availables:")
 	fmt.Println("  happyDeliver server            - Start the API server")
 	fmt.Println("  happyDeliver analyze [-json]   - Analyze email from stdin and output results to terminal")
+	fmt.Println("  happyDeliver scan <CIDR|IP>... - Check an IP range or IP addresses against blacklists")
 	fmt.Println("  happyDeliver backup            - Backup database to stdout as JSON")
 	fmt.Println("  happyDeliver restore [file]    - Restore database from JSON file or stdin")
 	fmt.Println("  happyDeliver version           - Print version information")
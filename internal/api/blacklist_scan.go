@@ -0,0 +1,178 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+const (
+	// maxRunningScans is the number of blacklist scans that may run at once
+	maxRunningScans = 4
+
+	// scanRetention is how long a blacklist scan can be retrieved after it ends
+	scanRetention = time.Hour
+)
+
+// blacklistScans keeps the blacklist scans in memory, while they run and
+// for a while after they end
+type blacklistScans struct {
+	mu    sync.Mutex
+	scans map[uuid.UUID]*model.BlacklistScan
+}
+
+func newBlacklistScans() *blacklistScans {
+	return &blacklistScans{
+		scans: make(map[uuid.UUID]*model.BlacklistScan),
+	}
+}
+
+// start records a new running scan of total addresses, dropping the expired
+// ones. It returns false when too many scans are already running.
+func (s *blacklistScans) start(total int) (model.BlacklistScan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	running := 0
+	for id, scan := range s.scans {
+		if scan.FinishedAt != nil && now.Sub(*scan.FinishedAt) > scanRetention {
+			delete(s.scans, id)
+		} else if scan.Status == model.BlacklistScanStatusRunning {
+			running++
+		}
+	}
+	if running >= maxRunningScans {
+		return model.BlacklistScan{}, false
+	}
+
+	scan := &model.BlacklistScan{
+		Id:        uuid.New(),
+		Status:    model.BlacklistScanStatusRunning,
+		Total:     total,
+		CreatedAt: now,
+	}
+	s.scans[scan.Id] = scan
+	return *scan, true
+}
+
+// progress records the number of addresses scanned so far
+func (s *blacklistScans) progress(id uuid.UUID, done int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans[id].Completed = done
+}
+
+// finish records the results of a scan
+func (s *blacklistScans) finish(id uuid.UUID, lists []string, results []model.BlacklistScanResult, summary model.BlacklistScanSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scan := s.scans[id]
+	scan.Status = model.BlacklistScanStatusCompleted
+	scan.Completed = scan.Total
+	scan.FinishedAt = utils.PtrTo(time.Now())
+	scan.Lists = &lists
+	scan.Results = &results
+	scan.Summary = &summary
+}
+
+// get returns a copy of the scan, if it exists and has not expired
+func (s *blacklistScans) get(id uuid.UUID) (model.BlacklistScan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scan, ok := s.scans[id]
+	if !ok || (scan.FinishedAt != nil && time.Since(*scan.FinishedAt) > scanRetention) {
+		return model.BlacklistScan{}, false
+	}
+	return *scan, true
+}
+
+// CreateBlacklistScan starts scanning a CIDR range or a list of IP addresses
+// against DNS blacklists
+// (POST /blacklist/scan)
+func (h *APIHandler) CreateBlacklistScan(c *gin.Context) {
+	var request model.BlacklistScanRequest
+
+	// Bind and validate request
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.Error{
+			Error:   "invalid_request",
+			Message: "Invalid request body",
+			Details: utils.PtrTo(err.Error()),
+		})
+		return
+	}
+
+	targets, err := h.analyzer.BlacklistScanTargets(utils.DerefOrZero(request.Cidr), utils.DerefOrZero(request.Ips))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.Error{
+			Error:   "invalid_targets",
+			Message: "Invalid addresses to scan",
+			Details: utils.PtrTo(err.Error()),
+		})
+		return
+	}
+
+	scan, ok := h.scans.start(len(targets))
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, model.Error{
+			Error:   "too_many_scans",
+			Message: "Too many blacklist scans are running, try again later",
+		})
+		return
+	}
+
+	go func() {
+		lists, results, summary := h.analyzer.ScanBlacklist(targets, func(done int) {
+			h.scans.progress(scan.Id, done)
+		})
+		h.scans.finish(scan.Id, lists, results, summary)
+	}()
+
+	c.JSON(http.StatusAccepted, scan)
+}
+
+// GetBlacklistScan retrieves the progress and results of a blacklist scan
+// (GET /blacklist/scan/{id})
+func (h *APIHandler) GetBlacklistScan(c *gin.Context, id openapi_types.UUID) {
+	scan, ok := h.scans.get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, model.Error{
+			Error:   "not_found",
+			Message: "Blacklist scan not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, scan)
+}
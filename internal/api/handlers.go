@@ -22,6 +22,7 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -41,8 +42,14 @@ import (
 // This interface breaks the circular dependency with pkg/analyzer
 type EmailAnalyzer interface {
 	AnalyzeEmailBytes(rawEmail []byte, testID uuid.UUID) (reportJSON []byte, err error)
-	AnalyzeDomain(domain string) (dnsResults *model.DNSResults, score int, grade string)
+	ReanalyzeEmailBytes(rawEmail []byte, testID uuid.UUID, previous *model.DNSSnapshot) (reportJSON []byte, err error)
+	ReplayEmailBytes(rawEmail []byte, testID uuid.UUID, snapshot *model.DNSSnapshot) (reportJSON []byte, err error)
+	AnalyzeDomain(domain string, probeDKIM bool, dkimSelectors []string) (dnsResults *model.DNSResults, score int, grade string)
 	CheckBlacklistIP(ip string) (checks []model.BlacklistCheck, whitelists []model.BlacklistCheck, listedCount int, score int, grade string, err error)
+	BlacklistScanTargets(cidr string, ips []string) ([]string, error)
+	ScanBlacklist(ips []string, progress func(done int)) (lists []string, results []model.BlacklistScanResult, summary model.BlacklistScanSummary)
+	DNSCacheStatus() *model.DNSCacheStatus
+	DNSListHealth() []model.DNSListHealth
 }
 
 // APIHandler implements the ServerInterface for handling API requests
@@ -50,6 +57,7 @@ type APIHandler struct {
 	storage   storage.Storage
 	config    *config.Config
 	analyzer  EmailAnalyzer
+	scans     *blacklistScans
 	startTime time.Time
 }
 
@@ -59,6 +67,7 @@ func NewAPIHandler(store storage.Storage, cfg *config.Config, analyzer EmailAnal
 		storage:   store,
 		config:    cfg,
 		analyzer:  analyzer,
+		scans:     newBlacklistScans(),
 		startTime: time.Now(),
 	}
 }
@@ -206,7 +215,7 @@ func (h *APIHandler) GetRawEmail(c *gin.Context, id string) {
 
 // ReanalyzeReport re-analyzes an existing email and regenerates the report
 // (POST /report/{id}/reanalyze)
-func (h *APIHandler) ReanalyzeReport(c *gin.Context, id string) {
+func (h *APIHandler) ReanalyzeReport(c *gin.Context, id string, params ReanalyzeReportParams) {
 	// Convert base32 ID to UUID
 	testUUID, err := utils.Base32ToUUID(id)
 	if err != nil {
@@ -219,7 +228,7 @@ func (h *APIHandler) ReanalyzeReport(c *gin.Context, id string) {
 	}
 
 	// Retrieve the existing report (mainly to get the raw email)
-	_, rawEmail, err := h.storage.GetReport(testUUID)
+	previousJSON, rawEmail, err := h.storage.GetReport(testUUID)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			c.JSON(http.StatusNotFound, model.Error{
@@ -236,8 +245,28 @@ func (h *APIHandler) ReanalyzeReport(c *gin.Context, id string) {
 		return
 	}
 
+	// Keep the DNS answers of the previous analysis, to replay them or to
+	// show what changed
+	var previous model.Report
+	var snapshot *model.DNSSnapshot
+	if json.Unmarshal(previousJSON, &previous) == nil {
+		snapshot = previous.DnsSnapshot
+	}
+
 	// Re-analyze the email using the current analyzer
-	reportJSON, err := h.analyzer.AnalyzeEmailBytes(rawEmail, testUUID)
+	var reportJSON []byte
+	if params.Dns != nil && *params.Dns == Replay {
+		if snapshot == nil {
+			c.JSON(http.StatusConflict, model.Error{
+				Error:   "no_dns_snapshot",
+				Message: "The report has no DNS snapshot to replay",
+			})
+			return
+		}
+		reportJSON, err = h.analyzer.ReplayEmailBytes(rawEmail, testUUID, snapshot)
+	} else {
+		reportJSON, err = h.analyzer.ReanalyzeEmailBytes(rawEmail, testUUID, snapshot)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.Error{
 			Error:   "analysis_error",
@@ -279,6 +308,17 @@ func (h *APIHandler) GetStatus(c *gin.Context) {
 		overallStatus = model.StatusStatusUnhealthy
 	}
 
+	// Quarantined DNS lists leave blacklist scores incomplete
+	var dnsLists *[]model.DNSListHealth
+	if health := h.analyzer.DNSListHealth(); len(health) > 0 {
+		dnsLists = &health
+		for _, zone := range health {
+			if zone.Quarantined && overallStatus == model.StatusStatusHealthy {
+				overallStatus = model.StatusStatusDegraded
+			}
+		}
+	}
+
 	mtaStatus := model.StatusComponentsMtaUp
 	c.JSON(http.StatusOK, model.Status{
 		Status:  overallStatus,
@@ -290,10 +330,16 @@ func (h *APIHandler) GetStatus(c *gin.Context) {
 			Database: &dbStatus,
 			Mta:      &mtaStatus,
 		},
-		Uptime: &uptime,
+		Uptime:   &uptime,
+		DnsCache: h.analyzer.DNSCacheStatus(),
+		DnsLists: dnsLists,
 	})
 }
 
+// maxDKIMSelectors is the largest number of DKIM selectors a domain test
+// may probe, as declared by the maxItems of DomainTestRequest.
+const maxDKIMSelectors = 50
+
 // TestDomain performs synchronous domain analysis
 // (POST /domain)
 func (h *APIHandler) TestDomain(c *gin.Context) {
@@ -308,9 +354,17 @@ func (h *APIHandler) TestDomain(c *gin.Context) {
 		})
 		return
 	}
+	if selectors := utils.DerefOrZero(request.DkimSelectors); len(selectors) > maxDKIMSelectors {
+		c.JSON(http.StatusBadRequest, model.Error{
+			Error:   "invalid_request",
+			Message: "Too many DKIM selectors",
+			Details: utils.PtrTo(fmt.Sprintf("%d selectors given, the limit is %d", len(selectors), maxDKIMSelectors)),
+		})
+		return
+	}
 
 	// Perform domain analysis
-	dnsResults, score, grade := h.analyzer.AnalyzeDomain(request.Domain)
+	dnsResults, score, grade := h.analyzer.AnalyzeDomain(request.Domain, utils.DerefOrZero(request.ProbeDkim), utils.DerefOrZero(request.DkimSelectors))
 
 	// Convert grade string to DomainTestResponseGrade enum
 	var responseGrade model.DomainTestResponseGrade
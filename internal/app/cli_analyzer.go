@@ -28,10 +28,12 @@ import (
 	"io"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"git.happydns.org/happyDeliver/internal/config"
+	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/pkg/analyzer"
 )
 
@@ -47,6 +49,9 @@ func RunAnalyzer(cfg *config.Config, args []string, reader io.Reader, writer io.
 	if err := cfg.Validate(); err != nil {
 		return err
 	}
+	if err := analyzer.ValidateConfig(cfg); err != nil {
+		return err
+	}
 
 	log.Printf("Email analyzer ready, reading from stdin...")
 
@@ -138,10 +143,36 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 					status = "✗"
 				}
 				fmt.Fprintf(writer, "    %s [%d] %s", status, mx.Priority, mx.Host)
+				if mx.NullMx != nil && *mx.NullMx {
+					fmt.Fprint(writer, " (Null MX: the domain accepts no mail)")
+				}
 				if mx.Error != nil {
 					fmt.Fprintf(writer, " - ERROR: %s", *mx.Error)
 				}
 				fmt.Fprintln(writer)
+				if mx.Addresses != nil {
+					fmt.Fprintf(writer, "      Addresses: %s\n", strings.Join(*mx.Addresses, ", "))
+				}
+				if mx.Warnings != nil {
+					for _, warning := range *mx.Warnings {
+						fmt.Fprintf(writer, "      WARNING: %s\n", warning)
+					}
+				}
+				if probe := mx.Smtp; probe != nil {
+					switch {
+					case probe.Error != nil:
+						fmt.Fprintf(writer, "      SMTP %s: ERROR: %s\n", probe.Address, *probe.Error)
+					case probe.Banner != nil:
+						fmt.Fprintf(writer, "      SMTP %s: %s\n", probe.Address, *probe.Banner)
+					}
+					if probe.TlsVersion != nil {
+						fmt.Fprintf(writer, "      STARTTLS: %s\n", *probe.TlsVersion)
+					} else if probe.TlsError != nil {
+						fmt.Fprintf(writer, "      STARTTLS: ERROR: %s\n", *probe.TlsError)
+					} else if probe.Starttls != nil && !*probe.Starttls {
+						fmt.Fprintln(writer, "      STARTTLS: not offered")
+					}
+				}
 			}
 		}
 
@@ -160,6 +191,9 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 				if spf.AllQualifier != nil {
 					fmt.Fprintf(writer, " (all: %s)", *spf.AllQualifier)
 				}
+				if spf.DnsLookups != nil && spf.CumulativeLookups != nil {
+					fmt.Fprintf(writer, " [lookups: %d, running total: %d]", *spf.DnsLookups, *spf.CumulativeLookups)
+				}
 				fmt.Fprintln(writer)
 				if spf.Record != nil {
 					fmt.Fprintf(writer, "      %s\n", *spf.Record)
@@ -167,6 +201,65 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 				if spf.Error != nil {
 					fmt.Fprintf(writer, "      ERROR: %s\n", *spf.Error)
 				}
+				if spf.Warnings != nil {
+					for _, warning := range *spf.Warnings {
+						fmt.Fprintf(writer, "      WARNING: %s\n", warning)
+					}
+				}
+			}
+		}
+
+		// SPF lookup budget
+		if budget := dns.SpfLookupBudget; budget != nil {
+			fmt.Fprintf(writer, "\n  SPF Lookup Budget: %s (DNS lookups: %d/%d, void lookups: %d/%d)\n",
+				strings.ToUpper(string(budget.Status)), budget.DnsLookups, budget.DnsLookupLimit, budget.VoidLookups, budget.VoidLookupLimit)
+			if budget.Error != nil {
+				fmt.Fprintf(writer, "    ERROR: %s\n", *budget.Error)
+			}
+			if budget.FlattenedRecord != nil {
+				fmt.Fprintf(writer, "    Suggested flattened record")
+				if budget.FlattenedLookups != nil {
+					fmt.Fprintf(writer, " (%d lookups)", *budget.FlattenedLookups)
+				}
+				fmt.Fprintf(writer, ":\n      %s\n", *budget.FlattenedRecord)
+			}
+			if budget.FlatteningNotes != nil {
+				for _, note := range *budget.FlatteningNotes {
+					fmt.Fprintf(writer, "    - %s\n", note)
+				}
+			}
+		}
+
+		// SPF evaluation against the sender IP
+		if dns.SpfEvaluation != nil {
+			eval := dns.SpfEvaluation
+			fmt.Fprintf(writer, "\n  SPF Evaluation: %s (domain: %s, ip: %s, lookups: %d)\n",
+				strings.ToUpper(string(eval.Result)), eval.Domain, eval.Ip, eval.DnsLookups)
+			if eval.MatchedMechanism != nil {
+				fmt.Fprintf(writer, "    Matched: %s", *eval.MatchedMechanism)
+				if eval.MatchedDomain != nil {
+					fmt.Fprintf(writer, " (in %s)", *eval.MatchedDomain)
+				}
+				fmt.Fprintln(writer)
+			}
+			if eval.Error != nil {
+				fmt.Fprintf(writer, "    ERROR: %s\n", *eval.Error)
+			}
+			if eval.Trace != nil {
+				for _, step := range *eval.Trace {
+					marker := " "
+					switch step.Result {
+					case model.SPFTraceStepResultMatch:
+						marker = "✓"
+					case model.SPFTraceStepResultError:
+						marker = "✗"
+					}
+					fmt.Fprintf(writer, "    %s%s %s [%s]", strings.Repeat("  ", step.Depth), marker, step.Term, step.Domain)
+					if step.Details != nil {
+						fmt.Fprintf(writer, " - %s", *step.Details)
+					}
+					fmt.Fprintln(writer)
+				}
 			}
 		}
 
@@ -185,6 +278,9 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 				if dkim.Error != nil {
 					fmt.Fprintf(writer, "      ERROR: %s\n", *dkim.Error)
 				}
+				if dkim.WeakKey != nil && *dkim.WeakKey {
+					fmt.Fprintln(writer, "      WARNING: weak key (1024 bits or less)")
+				}
 			}
 		}
 
@@ -212,6 +308,23 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			if dns.DmarcRecord.Error != nil {
 				fmt.Fprintf(writer, "      ERROR: %s\n", *dns.DmarcRecord.Error)
 			}
+			if dns.DmarcRecord.ReportDestinations != nil {
+				for _, dest := range *dns.DmarcRecord.ReportDestinations {
+					status := "✓"
+					if dest.Status != model.DMARCReportDestinationStatusOk {
+						status = "✗"
+					}
+					fmt.Fprintf(writer, "      %s %s: %s (%s)\n", status, dest.Tag, dest.Uri, dest.Status)
+					if dest.Error != nil {
+						fmt.Fprintf(writer, "        %s\n", *dest.Error)
+					}
+				}
+			}
+			if dns.DmarcRecord.Warnings != nil {
+				for _, warning := range *dns.DmarcRecord.Warnings {
+					fmt.Fprintf(writer, "      WARNING: %s\n", warning)
+				}
+			}
 		}
 
 		// BIMI Record
@@ -235,6 +348,171 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			if dns.BimiRecord.Error != nil {
 				fmt.Fprintf(writer, "      ERROR: %s\n", *dns.BimiRecord.Error)
 			}
+			if logo := dns.BimiRecord.Logo; logo != nil {
+				status := "✓"
+				if !logo.Valid {
+					status = "✗"
+				}
+				fmt.Fprintf(writer, "      %s Logo (SVG Tiny PS): %t", status, logo.Valid)
+				if logo.Size != nil {
+					fmt.Fprintf(writer, ", %d bytes", *logo.Size)
+				}
+				fmt.Fprintln(writer)
+				if logo.Error != nil {
+					fmt.Fprintf(writer, "        ERROR: %s\n", *logo.Error)
+				}
+				if logo.Issues != nil {
+					for _, issue := range *logo.Issues {
+						fmt.Fprintf(writer, "        - %s\n", issue)
+					}
+				}
+				if logo.Warnings != nil {
+					for _, warning := range *logo.Warnings {
+						fmt.Fprintf(writer, "        ! %s\n", warning)
+					}
+				}
+			}
+			if cert := dns.BimiRecord.Certificate; cert != nil {
+				status := "✓"
+				if !cert.Valid {
+					status = "✗"
+				}
+				fmt.Fprintf(writer, "      %s Mark certificate: %t", status, cert.Valid)
+				if cert.Type != nil {
+					fmt.Fprintf(writer, " (%s", strings.ToUpper(string(*cert.Type)))
+					if cert.MarkType != nil {
+						fmt.Fprintf(writer, ", %s", *cert.MarkType)
+					}
+					fmt.Fprintf(writer, ")")
+				}
+				fmt.Fprintln(writer)
+				if cert.Subject != nil {
+					fmt.Fprintf(writer, "        Subject: %s\n", *cert.Subject)
+				}
+				if cert.Issuer != nil {
+					fmt.Fprintf(writer, "        Issuer: %s\n", *cert.Issuer)
+				}
+				if cert.NotAfter != nil {
+					fmt.Fprintf(writer, "        Expires: %s\n", cert.NotAfter.Format(time.RFC3339))
+				}
+				if cert.Error != nil {
+					fmt.Fprintf(writer, "        ERROR: %s\n", *cert.Error)
+				}
+				if cert.Issues != nil {
+					for _, issue := range *cert.Issues {
+						fmt.Fprintf(writer, "        - %s\n", issue)
+					}
+				}
+			}
+		}
+
+		// MTA-STS Record
+		if sts := dns.MtaStsRecord; sts != nil {
+			fmt.Fprintln(writer, "\n  MTA-STS Record:")
+			status := "✓"
+			if !sts.Valid {
+				status = "✗"
+			}
+			fmt.Fprintf(writer, "    %s Valid: %t, Domain: %s\n", status, sts.Valid, sts.Domain)
+			if sts.Record != nil {
+				fmt.Fprintf(writer, "      %s\n", *sts.Record)
+			}
+			if sts.Error != nil {
+				fmt.Fprintf(writer, "      ERROR: %s\n", *sts.Error)
+			}
+			if policy := sts.Policy; policy != nil && policy.Mode != nil {
+				fmt.Fprintf(writer, "      Policy: mode %s", *policy.Mode)
+				if policy.MaxAge != nil {
+					fmt.Fprintf(writer, ", max_age %d", *policy.MaxAge)
+				}
+				if policy.Mx != nil {
+					fmt.Fprintf(writer, ", mx %s", strings.Join(*policy.Mx, " "))
+				}
+				fmt.Fprintln(writer)
+			}
+			if sts.MxMatches != nil {
+				for _, match := range *sts.MxMatches {
+					status := "✓"
+					if !match.Matched {
+						status = "✗"
+					}
+					fmt.Fprintf(writer, "      %s MX %s", status, match.Host)
+					if match.Pattern != nil {
+						fmt.Fprintf(writer, " (matches %s)", *match.Pattern)
+					}
+					fmt.Fprintln(writer)
+				}
+			}
+			if sts.Warnings != nil {
+				for _, warning := range *sts.Warnings {
+					fmt.Fprintf(writer, "      WARNING: %s\n", warning)
+				}
+			}
+		}
+
+		// DANE TLSA Records
+		if dns.DaneRecords != nil && len(*dns.DaneRecords) > 0 {
+			fmt.Fprintln(writer, "\n  DANE (TLSA) Records:")
+			for _, dane := range *dns.DaneRecords {
+				status := "✓"
+				if !dane.Valid {
+					status = "✗"
+				}
+				dnssec := "insecure"
+				if dane.Dnssec {
+					dnssec = "DNSSEC"
+				}
+				fmt.Fprintf(writer, "    %s %s (%s)\n", status, dane.TlsaName, dnssec)
+				if dane.Records != nil {
+					for _, tlsa := range *dane.Records {
+						fmt.Fprintf(writer, "      %d %d %d %s", tlsa.Usage, tlsa.Selector, tlsa.MatchingType, tlsa.Data)
+						if tlsa.Description != nil {
+							fmt.Fprintf(writer, " (%s)", *tlsa.Description)
+						}
+						fmt.Fprintln(writer)
+						if tlsa.Error != nil {
+							fmt.Fprintf(writer, "        ERROR: %s\n", *tlsa.Error)
+						}
+					}
+				}
+				if dane.Error != nil {
+					fmt.Fprintf(writer, "      ERROR: %s\n", *dane.Error)
+				}
+			}
+		}
+
+		// TLS-RPT Record
+		if rpt := dns.TlsRptRecord; rpt != nil {
+			fmt.Fprintln(writer, "\n  TLS-RPT Record:")
+			status := "✓"
+			if !rpt.Valid {
+				status = "✗"
+			}
+			fmt.Fprintf(writer, "    %s Valid: %t, Domain: %s\n", status, rpt.Valid, rpt.Domain)
+			if rpt.Record != nil {
+				fmt.Fprintf(writer, "      %s\n", *rpt.Record)
+			}
+			if rpt.Error != nil {
+				fmt.Fprintf(writer, "      ERROR: %s\n", *rpt.Error)
+			}
+			if rpt.Rua != nil {
+				for _, uri := range *rpt.Rua {
+					status := "✓"
+					if !uri.Valid {
+						status = "✗"
+					}
+					fmt.Fprintf(writer, "      %s rua %s", status, uri.Uri)
+					if uri.Error != nil {
+						fmt.Fprintf(writer, " - %s", *uri.Error)
+					}
+					fmt.Fprintln(writer)
+				}
+			}
+			if rpt.Warnings != nil {
+				for _, warning := range *rpt.Warnings {
+					fmt.Fprintf(writer, "      WARNING: %s\n", warning)
+				}
+			}
 		}
 
 		// PTR Records
@@ -245,6 +523,61 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			}
 		}
 
+		// Authoritative nameservers consistency
+		if ns := dns.NsConsistency; ns != nil {
+			status := "✓"
+			if !ns.Consistent {
+				status = "✗"
+			}
+			fmt.Fprintf(writer, "\n  Authoritative Nameservers (%s):\n", ns.Zone)
+			fmt.Fprintf(writer, "    %s Consistent: %t\n", status, ns.Consistent)
+			if ns.Error != nil {
+				fmt.Fprintf(writer, "      ERROR: %s\n", *ns.Error)
+			}
+			for _, server := range ns.Servers {
+				fmt.Fprintf(writer, "    %s %s", server.Host, server.Address)
+				if server.Serial != nil {
+					fmt.Fprintf(writer, " (serial %d)", *server.Serial)
+				}
+				if server.Lame {
+					fmt.Fprint(writer, " LAME")
+				}
+				if server.Lagging {
+					fmt.Fprint(writer, " LAGGING")
+				}
+				if server.DifferingRecords != nil {
+					fmt.Fprintf(writer, " differs on %s", strings.Join(*server.DifferingRecords, ", "))
+				}
+				fmt.Fprintln(writer)
+				if server.Error != nil {
+					fmt.Fprintf(writer, "      ERROR: %s\n", *server.Error)
+				}
+			}
+			if ns.Records != nil {
+				for _, record := range *ns.Records {
+					if record.Consistent {
+						continue
+					}
+					fmt.Fprintf(writer, "    ✗ %s (%s %s):\n", record.Label, record.Name, record.Type)
+					for _, answer := range record.Answers {
+						fmt.Fprintf(writer, "      %s: %s\n", strings.Join(answer.Servers, ", "), strings.Join(answer.Records, " | "))
+					}
+				}
+			}
+		}
+
+		// DNSSEC status of the answers
+		if dns.DnssecAnswers != nil && len(*dns.DnssecAnswers) > 0 {
+			fmt.Fprintln(writer, "\n  DNSSEC:")
+			for _, answer := range *dns.DnssecAnswers {
+				fmt.Fprintf(writer, "    %-13s %s %s", answer.Status, answer.Name, answer.Type)
+				if answer.Reason != nil {
+					fmt.Fprintf(writer, " (%s)", *answer.Reason)
+				}
+				fmt.Fprintln(writer)
+			}
+		}
+
 		// DNS Errors
 		if dns.Errors != nil && len(*dns.Errors) > 0 {
 			fmt.Fprintln(writer, "\n  DNS Errors:")
@@ -293,6 +626,64 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			}
 		}
 
+		// DKIM signatures verified by happyDeliver
+		if auth.DkimVerifications != nil && len(*auth.DkimVerifications) > 0 {
+			fmt.Fprintln(writer, "\n  DKIM Signature Verification:")
+			for i, v := range *auth.DkimVerifications {
+				fmt.Fprintf(writer, "    [%d] %s (domain: %s, selector: %s)", i+1, strings.ToUpper(string(v.Result)), v.Domain, v.Selector)
+				if v.UpstreamResult != nil {
+					fmt.Fprintf(writer, " [upstream: %s]", *v.UpstreamResult)
+				}
+				if v.Discrepancy != nil && *v.Discrepancy {
+					fmt.Fprintf(writer, " ! DISAGREES WITH UPSTREAM")
+				}
+				if v.Reason != nil {
+					fmt.Fprintf(writer, "\n      Reason: %s", *v.Reason)
+				}
+				fmt.Fprintln(writer)
+			}
+		}
+
+		// DKIM robustness against in-transit modifications
+		if auth.DkimRobustness != nil && len(*auth.DkimRobustness) > 0 {
+			fmt.Fprintln(writer, "\n  DKIM Robustness (forwarding scenarios):")
+			for _, r := range *auth.DkimRobustness {
+				fmt.Fprintf(writer, "    %s (selector: %s)\n", r.Domain, r.Selector)
+				for _, scenario := range r.Scenarios {
+					status := "✓"
+					switch scenario.Result {
+					case model.DKIMRobustnessScenarioResultBreaks:
+						status = "✗"
+					case model.DKIMRobustnessScenarioResultNotApplicable:
+						status = "-"
+					}
+					fmt.Fprintf(writer, "      %s %s: %s", status, scenario.Scenario, scenario.Result)
+					if scenario.Reason != nil {
+						fmt.Fprintf(writer, " (%s)", *scenario.Reason)
+					}
+					fmt.Fprintln(writer)
+				}
+			}
+		}
+
+		// DKIM signature tag audit
+		if auth.DkimAudits != nil && len(*auth.DkimAudits) > 0 {
+			fmt.Fprintln(writer, "\n  DKIM Signature Audit:")
+			for _, audit := range *auth.DkimAudits {
+				fmt.Fprintf(writer, "    %s (selector: %s)", audit.Domain, audit.Selector)
+				if len(audit.Issues) == 0 {
+					fmt.Fprintf(writer, ": no issue found")
+				}
+				fmt.Fprintln(writer)
+				for _, issue := range audit.Issues {
+					fmt.Fprintf(writer, "      [%s] %s\n", strings.ToUpper(string(issue.Severity)), issue.Message)
+					if issue.Advice != nil {
+						fmt.Fprintf(writer, "        Advice: %s\n", *issue.Advice)
+					}
+				}
+			}
+		}
+
 		// DMARC
 		if auth.Dmarc != nil {
 			fmt.Fprintf(writer, "\n  DMARC: %s", strings.ToUpper(string(auth.Dmarc.Result)))
@@ -305,6 +696,30 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			fmt.Fprintln(writer)
 		}
 
+		// DMARC evaluated by happyDeliver
+		if auth.DmarcEvaluation != nil {
+			eval := auth.DmarcEvaluation
+			fmt.Fprintf(writer, "\n  DMARC Evaluation: %s (disposition: %s", strings.ToUpper(string(eval.Result)), eval.Disposition)
+			if eval.Policy != nil && eval.PolicySource != nil {
+				fmt.Fprintf(writer, ", policy: %s from %s=", *eval.Policy, *eval.PolicySource)
+			}
+			if eval.TestMode != nil && *eval.TestMode {
+				fmt.Fprintf(writer, ", testing")
+			}
+			fmt.Fprintf(writer, ")")
+			if eval.UpstreamResult != nil {
+				fmt.Fprintf(writer, " [upstream: %s]", *eval.UpstreamResult)
+			}
+			if eval.Discrepancy != nil && *eval.Discrepancy {
+				fmt.Fprintf(writer, " ! DISAGREES WITH UPSTREAM")
+			}
+			fmt.Fprintf(writer, "\n    SPF aligned: %t, DKIM aligned: %t", eval.SpfAligned, eval.DkimAligned)
+			if eval.Details != nil {
+				fmt.Fprintf(writer, "\n    Details: %s", *eval.Details)
+			}
+			fmt.Fprintln(writer)
+		}
+
 		// ARC
 		if auth.Arc != nil {
 			fmt.Fprintf(writer, "\n  ARC: %s", strings.ToUpper(string(auth.Arc.Result)))
@@ -317,6 +732,33 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			if auth.Arc.Details != nil {
 				fmt.Fprintf(writer, "\n    Details: %s", *auth.Arc.Details)
 			}
+			if auth.Arc.BrokenInstance != nil {
+				fmt.Fprintf(writer, "\n    Broken at instance %d", *auth.Arc.BrokenInstance)
+				if auth.Arc.BrokenDomain != nil && auth.Arc.BrokenSelector != nil {
+					fmt.Fprintf(writer, " (sealer d=%s s=%s)", *auth.Arc.BrokenDomain, *auth.Arc.BrokenSelector)
+				}
+			}
+			if auth.Arc.Hops != nil {
+				for _, hop := range *auth.Arc.Hops {
+					fmt.Fprintf(writer, "\n    i=%d", hop.Instance)
+					if hop.Domain != nil {
+						fmt.Fprintf(writer, " d=%s", *hop.Domain)
+					}
+					if hop.Selector != nil {
+						fmt.Fprintf(writer, " s=%s", *hop.Selector)
+					}
+					if hop.ChainValidation != nil {
+						fmt.Fprintf(writer, " cv=%s", *hop.ChainValidation)
+					}
+					fmt.Fprintf(writer, ": seal %s, message signature %s", hop.SealResult, hop.MessageSignatureResult)
+					if hop.SealReason != nil {
+						fmt.Fprintf(writer, "\n      Seal: %s", *hop.SealReason)
+					}
+					if hop.MessageSignatureReason != nil {
+						fmt.Fprintf(writer, "\n      Message signature: %s", *hop.MessageSignatureReason)
+					}
+				}
+			}
 			fmt.Fprintln(writer)
 		}
 
@@ -362,6 +804,9 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 			fmt.Fprintf(writer, "\n  IP Address: %s\n", ip)
 			for _, check := range checks {
 				status := "✓"
+				if check.TimedOut != nil && *check.TimedOut {
+					status = "?"
+				}
 				if check.Listed {
 					status = "✗"
 					totalListed++
@@ -369,13 +814,13 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 				fmt.Fprintf(writer, "    %s %s", status, check.Rbl)
 				if check.Listed {
 					fmt.Fprintf(writer, " - LISTED")
-					if check.Response != nil {
-						fmt.Fprintf(writer, " (%s)", *check.Response)
-					}
+					printListingDetails(writer, check)
+				} else if check.TimedOut != nil && *check.TimedOut {
+					fmt.Fprintln(writer, " - TIMED OUT")
 				} else {
 					fmt.Fprintf(writer, " - OK")
+					fmt.Fprintln(writer)
 				}
-				fmt.Fprintln(writer)
 				if check.Error != nil {
 					fmt.Fprintf(writer, "      ERROR: %s\n", *check.Error)
 				}
@@ -384,6 +829,39 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 		fmt.Fprintf(writer, "\n  Summary: %d/%d blacklists triggered\n", totalListed, totalChecks)
 	}
 
+	// Domain Blacklist Results
+	if report.DomainBlacklists != nil && len(*report.DomainBlacklists) > 0 {
+		fmt.Fprintln(writer, "\n"+strings.Repeat("-", 70))
+		fmt.Fprintln(writer, "DOMAIN BLACKLIST CHECKS")
+		fmt.Fprintln(writer, strings.Repeat("-", 70))
+
+		for _, domain := range *report.DomainBlacklists {
+			fmt.Fprintf(writer, "\n  Domain: %s (%s)\n", domain.Domain, strings.Join(domain.Sources, ", "))
+			for _, check := range domain.Checks {
+				status := "✓"
+				if check.TimedOut != nil && *check.TimedOut {
+					status = "?"
+				}
+				if check.Listed {
+					status = "✗"
+				}
+				fmt.Fprintf(writer, "    %s %s", status, check.Rbl)
+				if check.Listed {
+					fmt.Fprintf(writer, " - LISTED")
+					printListingDetails(writer, check)
+				} else if check.TimedOut != nil && *check.TimedOut {
+					fmt.Fprintln(writer, " - TIMED OUT")
+				} else {
+					fmt.Fprintf(writer, " - OK")
+					fmt.Fprintln(writer)
+				}
+				if check.Error != nil {
+					fmt.Fprintf(writer, "      ERROR: %s\n", *check.Error)
+				}
+			}
+		}
+	}
+
 	// Header Analysis
 	if report.HeaderAnalysis != nil {
 		fmt.Fprintln(writer, "\n"+strings.Repeat("-", 70))
@@ -635,3 +1113,30 @@ func outputHumanReadable(result *analyzer.AnalysisResult, emailAnalyzer *analyze
 
 	return nil
 }
+
+// printListingDetails completes the line of a listed DNS list check with the
+// meaning of the answer, then prints the reason given by the list, the
+// advice and where to request a delisting.
+func printListingDetails(writer io.Writer, check model.BlacklistCheck) {
+	if check.Category != nil {
+		fmt.Fprintf(writer, " (%s)", *check.Category)
+	} else if check.Response != nil {
+		fmt.Fprintf(writer, " (%s)", *check.Response)
+	}
+	if check.Severity != nil {
+		fmt.Fprintf(writer, " [%s]", *check.Severity)
+	}
+	fmt.Fprintln(writer)
+	if check.Reason != nil {
+		fmt.Fprintf(writer, "      Reason: %s\n", *check.Reason)
+	}
+	if check.Advice != nil {
+		fmt.Fprintf(writer, "      Advice: %s\n", *check.Advice)
+	}
+	if check.DelistUrl != nil {
+		fmt.Fprintf(writer, "      Delisting: %s\n", *check.DelistUrl)
+	}
+	if check.Quarantined != nil && *check.Quarantined {
+		fmt.Fprintln(writer, "      Not scored: the zone failed its health checks")
+	}
+}
@@ -0,0 +1,167 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"git.happydns.org/happyDeliver/internal/config"
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+	"git.happydns.org/happyDeliver/pkg/analyzer"
+)
+
+// RunBlacklistScan scans a CIDR range and/or IP addresses against DNS
+// blacklists
+func RunBlacklistScan(cfg *config.Config, args []string, writer io.Writer) error {
+	// Parse command-line flags
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "Output results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := analyzer.ValidateConfig(cfg); err != nil {
+		return err
+	}
+
+	// Tell the CIDR range apart from the single addresses
+	var cidr string
+	var ips []string
+	for _, arg := range fs.Args() {
+		if !strings.Contains(arg, "/") {
+			ips = append(ips, arg)
+		} else if cidr == "" {
+			cidr = arg
+		} else {
+			return fmt.Errorf("only one CIDR range can be scanned at once")
+		}
+	}
+	if cidr == "" && len(ips) == 0 {
+		return fmt.Errorf("usage: happyDeliver scan [-json] <CIDR|IP>...")
+	}
+
+	// Create analyzer with configuration
+	emailAnalyzer := analyzer.NewEmailAnalyzer(cfg)
+
+	targets, err := emailAnalyzer.BlacklistScanTargets(cidr, ips)
+	if err != nil {
+		return err
+	}
+
+	scan := model.BlacklistScan{
+		Id:        uuid.New(),
+		Status:    model.BlacklistScanStatusRunning,
+		Total:     len(targets),
+		CreatedAt: time.Now(),
+	}
+
+	log.Printf("Scanning %d addresses...", scan.Total)
+	lists, results, summary := emailAnalyzer.ScanBlacklist(targets, func(done int) {
+		if done%64 == 0 && done < scan.Total {
+			log.Printf("Scanned %d/%d addresses", done, scan.Total)
+		}
+	})
+
+	scan.Status = model.BlacklistScanStatusCompleted
+	scan.Completed = scan.Total
+	scan.FinishedAt = utils.PtrTo(time.Now())
+	scan.Lists = &lists
+	scan.Results = &results
+	scan.Summary = &summary
+
+	// Output results
+	if *jsonOutput {
+		scanJSON, err := json.MarshalIndent(scan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal scan: %w", err)
+		}
+		fmt.Fprintln(writer, string(scanJSON))
+		return nil
+	}
+	outputBlacklistScan(scan, writer)
+	return nil
+}
+
+// outputBlacklistScan outputs the listed addresses of a scan and the hits of
+// each list
+func outputBlacklistScan(scan model.BlacklistScan, writer io.Writer) {
+	fmt.Fprintln(writer, "\n"+strings.Repeat("=", 70))
+	fmt.Fprintln(writer, "BLACKLIST SCAN")
+	fmt.Fprintln(writer, strings.Repeat("=", 70))
+	fmt.Fprintf(writer, "\nAddresses scanned: %d\n", scan.Total)
+	fmt.Fprintf(writer, "Duration: %s\n", scan.FinishedAt.Sub(scan.CreatedAt).Round(time.Second))
+
+	fmt.Fprintln(writer, "\n"+strings.Repeat("-", 70))
+	fmt.Fprintln(writer, "LISTED ADDRESSES")
+	fmt.Fprintln(writer, strings.Repeat("-", 70))
+
+	if scan.Summary.ListedIps == 0 {
+		fmt.Fprintln(writer, "\n  No address is listed")
+	} else {
+		fmt.Fprintln(writer)
+		for _, result := range *scan.Results {
+			var listed []string
+			for _, check := range result.Checks {
+				if check.Listed && (check.Quarantined == nil || !*check.Quarantined) {
+					listed = append(listed, check.Rbl)
+				}
+			}
+			if len(listed) > 0 {
+				fmt.Fprintf(writer, "  ✗ %-39s %s\n", result.Ip, strings.Join(listed, ", "))
+			}
+		}
+	}
+
+	fmt.Fprintln(writer, "\n"+strings.Repeat("-", 70))
+	fmt.Fprintln(writer, "BLACKLISTS")
+	fmt.Fprintln(writer, strings.Repeat("-", 70))
+	fmt.Fprintln(writer)
+
+	for _, list := range scan.Summary.Lists {
+		status := "✓"
+		if list.ErrorCount > 0 {
+			status = "?"
+		}
+		if list.ListedCount > 0 {
+			status = "✗"
+		}
+		fmt.Fprintf(writer, "  %s %-35s %4d/%d listed", status, list.Rbl, list.ListedCount, scan.Total)
+		if list.ErrorCount > 0 {
+			fmt.Fprintf(writer, " (%d errors)", list.ErrorCount)
+		}
+		fmt.Fprintln(writer)
+	}
+
+	fmt.Fprintf(writer, "\n  Summary: %d/%d addresses listed\n", scan.Summary.ListedIps, scan.Total)
+}
@@ -43,6 +43,9 @@ func RunServer(cfg *config.Config) error {
 	if err := cfg.Validate(); err != nil {
 		return err
 	}
+	if err := analyzer.ValidateConfig(cfg); err != nil {
+		return err
+	}
 
 	// Initialize storage
 	store, err := storage.NewStorage(cfg.Database.Type, cfg.Database.DSN)
@@ -59,15 +62,20 @@ func RunServer(cfg *config.Config) error {
 	cleanupSvc.Start(ctx)
 	defer cleanupSvc.Stop()
 
+	// A single analyzer serves the LMTP server and the API, so that they
+	// share the DNS list query slots and health state
+	emailAnalyzer := analyzer.NewEmailAnalyzer(cfg)
+	emailAnalyzer.StartDNSListHealthChecks(ctx)
+
 	// Start LMTP server in background
 	go func() {
-		if err := lmtp.StartServer(cfg.Email.LMTPAddr, store, cfg); err != nil {
+		if err := lmtp.StartServer(cfg.Email.LMTPAddr, store, cfg, emailAnalyzer); err != nil {
 			log.Fatalf("Failed to start LMTP server: %v", err)
 		}
 	}()
 
 	// Create analyzer adapter for API
-	analyzerAdapter := analyzer.NewAPIAdapter(cfg)
+	analyzerAdapter := analyzer.NewAPIAdapterWithAnalyzer(emailAnalyzer)
 
 	// Create API handler
 	handler := api.NewAPIHandler(store, cfg, analyzerAdapter)
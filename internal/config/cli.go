@@ -38,7 +38,20 @@ func declareFlags(o *Config) {
 	flag.DurationVar(&o.Analysis.DNSTimeout, "dns-timeout", o.Analysis.DNSTimeout, "Timeout when performing DNS query")
 	flag.DurationVar(&o.Analysis.HTTPTimeout, "http-timeout", o.Analysis.HTTPTimeout, "Timeout when performing HTTP query")
 	flag.Var(&StringArray{&o.Analysis.RBLs}, "rbl", "Append a RBL (use this option multiple time to append multiple RBLs)")
+	flag.Var(&StringArray{&o.Analysis.DomainLists}, "domain-list", "Append a domain blocklist (RHSBL/URIBL) checked for the sender, DKIM and link domains, replacing the default lists (use this option multiple time to append multiple lists)")
+	flag.StringVar(&o.Analysis.DNSListCatalog, "dns-list-catalog", o.Analysis.DNSListCatalog, "JSON file describing the RBLs, DNSWLs and domain lists to query, with their type, weight, informational flag, test point and return codes; it replaces the default lists, -rbl and -domain-list then add to it")
+	flag.IntVar(&o.Analysis.DNSListConcurrency, "rbl-concurrency", o.Analysis.DNSListConcurrency, "Maximum number of RBL, DNSWL and domain list queries in flight, all messages together; blacklist scans have the same budget of their own (0 = no limit)")
+	flag.DurationVar(&o.Analysis.DNSListDeadline, "rbl-deadline", o.Analysis.DNSListDeadline, "Time budget of the RBL, DNSWL and domain list queries of a message; queries still running are reported as timed out (0 = only -dns-timeout)")
+	flag.DurationVar(&o.Analysis.DNSListHealthInterval, "rbl-health-interval", o.Analysis.DNSListHealthInterval, "Interval of the RFC 5782 test point checks of the RBL, DNSWL and domain list zones by the server; zones listing everything or no longer listing their test point are left out of the score (0 disables the checks)")
 	flag.BoolVar(&o.Analysis.CheckAllIPs, "check-all-ips", o.Analysis.CheckAllIPs, "Check all IPs found in email headers against RBLs (not just the first one)")
+	flag.Var(&StringArray{&o.Analysis.Resolvers}, "resolver", "Nameserver for the DNS record checks: IP[:port], tcp://IP[:port], tls://host[:port] or https://host/dns-query (use this option multiple time to append multiple nameservers)")
+	flag.Var(&StringArray{&o.Analysis.RBLResolvers}, "rbl-resolver", "Nameserver for the RBL, DNSWL and domain list queries, same syntax as -resolver (defaults to the system resolver, as RBLs often block public resolvers)")
+	flag.BoolVar(&o.Analysis.DNSSECValidation, "dnssec-validation", o.Analysis.DNSSECValidation, "Validate DNS answers with DNSSEC and report their status")
+	flag.IntVar(&o.Analysis.DNSCacheSize, "dns-cache-size", o.Analysis.DNSCacheSize, "Maximum number of DNS answers kept in cache, shared by the record checks and the RBL queries (0 disables the cache). To learn the TTLs of the answers, the cache queries the nameservers of /etc/resolv.conf directly when no resolver is set: /etc/hosts and the search domains don't apply")
+	flag.DurationVar(&o.Analysis.DNSCacheMaxTTL, "dns-cache-max-ttl", o.Analysis.DNSCacheMaxTTL, "Maximum time a DNS answer is kept in cache, whatever its TTL")
+	flag.Var(&StringArray{&o.Analysis.DKIMSelectors}, "dkim-selector", "Append a DKIM selector to probe in domain-only tests, replacing the list of common selectors (use this option multiple time to append multiple selectors)")
+	flag.BoolVar(&o.Analysis.SMTPProbe, "mx-smtp-probe", o.Analysis.SMTPProbe, "Connect to the MX hosts on port 25 to check their SMTP banner and STARTTLS support (outgoing port 25 must not be filtered)")
+	flag.StringVar(&o.Analysis.BIMIRoots, "bimi-roots", o.Analysis.BIMIRoots, "PEM file of the mark certificate authorities trusted to issue the VMCs and CMCs of BIMI records (without it, their chains are not verified)")
 	flag.StringVar(&o.Analysis.RspamdAPIURL, "rspamd-api-url", o.Analysis.RspamdAPIURL, "rspamd API URL for symbol descriptions (default: use embedded list)")
 	flag.DurationVar(&o.ReportRetention, "report-retention", o.ReportRetention, "How long to keep reports (e.g., 720h, 30d). 0 = keep forever")
 	flag.UintVar(&o.RateLimit, "rate-limit", o.RateLimit, "API rate limit (requests per second per IP)")
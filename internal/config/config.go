@@ -25,6 +25,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path"
@@ -75,6 +76,26 @@ type AnalysisConfig struct {
 	DNSWLs       []string
 	CheckAllIPs  bool   // Check all IPs found in headers, not just the first one
 	RspamdAPIURL string // rspamd API URL for fetching symbol descriptions (empty = use embedded list)
+
+	DomainLists    []string // Domain blocklists (RHSBL/URIBL) for the sender, DKIM and link domains (empty = default lists)
+	DNSListCatalog string   // JSON file describing the RBLs, DNSWLs and domain lists to query (empty = default catalogue)
+
+	DNSListConcurrency int           // Maximum number of RBL, DNSWL and domain list queries in flight, blacklist scans having the same budget of their own (0 = no limit)
+	DNSListDeadline    time.Duration // Time budget of the list queries of a message (0 = only the per-query DNS timeout)
+
+	DNSListHealthInterval time.Duration // Interval of the RFC 5782 test point checks of the list zones (0 = not monitored)
+
+	Resolvers        []string // Nameservers for the record checks (udp://, tcp://, tls:// or https://; empty = system resolver)
+	RBLResolvers     []string // Nameservers for the RBL, DNSWL and domain list queries (empty = system resolver)
+	DNSSECValidation bool     // Validate DNS answers with DNSSEC instead of trusting the resolver
+
+	DNSCacheSize   int           // Maximum number of DNS answers kept in cache, bypassing /etc/hosts to learn the TTLs (0 = no cache)
+	DNSCacheMaxTTL time.Duration // Maximum time a DNS answer is kept, whatever its TTL
+
+	DKIMSelectors []string // DKIM selectors probed by domain-only tests (empty = common selectors)
+	SMTPProbe     bool     // Connect to the MX hosts on port 25 to check their banner and STARTTLS
+
+	BIMIRoots string // PEM file of the trusted mark certificate authorities (empty = BIMI certificate chains are not verified)
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -99,7 +120,16 @@ func DefaultConfig() *Config {
 			HTTPTimeout: 10 * time.Second,
 			RBLs:        []string{},
 			DNSWLs:      []string{},
+			DomainLists: []string{},
 			CheckAllIPs: false, // By default, only check the first IP
+
+			DNSListConcurrency: 32,
+			DNSListDeadline:    15 * time.Second,
+
+			DNSListHealthInterval: time.Hour,
+
+			DNSCacheSize:   10000,
+			DNSCacheMaxTTL: time.Hour,
 		},
 	}
 }
@@ -174,9 +204,62 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("database DSN cannot be empty")
 	}
 
+	for _, spec := range c.Analysis.Resolvers {
+		if _, _, err := ParseNameserver(spec); err != nil {
+			return fmt.Errorf("invalid resolver option: %w", err)
+		}
+	}
+	for _, spec := range c.Analysis.RBLResolvers {
+		if _, _, err := ParseNameserver(spec); err != nil {
+			return fmt.Errorf("invalid rbl-resolver option: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// ParseNameserver parses a nameserver specification into the transport to
+// query it with and its address:
+//
+//	192.0.2.53, [2001:db8::53]:5353   plain DNS over UDP, with TCP fallback
+//	udp://192.0.2.53, tcp://192.0.2.53 plain DNS over the given transport
+//	tls://dns.example.net              DNS-over-TLS, on port 853 by default
+//	https://dns.example.net/dns-query  DNS-over-HTTPS
+//
+// The address is host:port, or the URL of the DNS-over-HTTPS endpoint.
+func ParseNameserver(spec string) (network, address string, err error) {
+	spec = strings.TrimSpace(spec)
+	if !strings.Contains(spec, "://") {
+		spec = "udp://" + spec
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid nameserver %q: %w", spec, err)
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid nameserver %q: missing host", spec)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return "https", u.String(), nil
+	case "udp", "tcp", "tls":
+		if u.Path != "" && u.Path != "/" {
+			return "", "", fmt.Errorf("invalid nameserver %q: unexpected path", spec)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "53"
+			if u.Scheme == "tls" {
+				port = "853"
+			}
+		}
+		return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+	}
+	return "", "", fmt.Errorf("invalid nameserver %q: unsupported scheme %q", spec, u.Scheme)
+}
+
 // parseLine treats a config line and place the read value in the variable
 // declared to the corresponding flag.
 func parseLine(o *Config, line string) (err error) {
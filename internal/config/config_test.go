@@ -95,9 +95,27 @@ func TestDefaultConfig(t *testing.T) {
 	if c.Analysis.DNSWLs == nil || len(c.Analysis.DNSWLs) != 0 {
 		t.Errorf("Analysis.DNSWLs = %v, want empty non-nil slice", c.Analysis.DNSWLs)
 	}
+	if c.Analysis.DomainLists == nil || len(c.Analysis.DomainLists) != 0 {
+		t.Errorf("Analysis.DomainLists = %v, want empty non-nil slice", c.Analysis.DomainLists)
+	}
 	if c.Analysis.CheckAllIPs {
 		t.Error("Analysis.CheckAllIPs = true, want false")
 	}
+	if c.Analysis.DNSListConcurrency != 32 {
+		t.Errorf("Analysis.DNSListConcurrency = %d, want 32", c.Analysis.DNSListConcurrency)
+	}
+	if c.Analysis.DNSListDeadline != 15*time.Second {
+		t.Errorf("Analysis.DNSListDeadline = %v, want 15s", c.Analysis.DNSListDeadline)
+	}
+	if c.Analysis.DNSListHealthInterval != time.Hour {
+		t.Errorf("Analysis.DNSListHealthInterval = %v, want 1h", c.Analysis.DNSListHealthInterval)
+	}
+	if c.Analysis.DNSCacheSize != 10000 {
+		t.Errorf("Analysis.DNSCacheSize = %d, want 10000", c.Analysis.DNSCacheSize)
+	}
+	if c.Analysis.DNSCacheMaxTTL != time.Hour {
+		t.Errorf("Analysis.DNSCacheMaxTTL = %v, want 1h", c.Analysis.DNSCacheMaxTTL)
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -119,6 +137,12 @@ func TestValidate(t *testing.T) {
 		{"invalid domain", func(c *Config) { c.Email.Domain = "not a valid domain" }, true},
 		{"unsupported db type", func(c *Config) { c.Database.Type = "mysql" }, true},
 		{"empty dsn", func(c *Config) { c.Database.DSN = "" }, true},
+		{"valid resolvers", func(c *Config) {
+			c.Analysis.Resolvers = []string{"192.0.2.53", "tls://dns.example.net"}
+			c.Analysis.RBLResolvers = []string{"https://dns.example.net/dns-query"}
+		}, false},
+		{"invalid resolver", func(c *Config) { c.Analysis.Resolvers = []string{"quic://dns.example.net"} }, true},
+		{"invalid rbl resolver", func(c *Config) { c.Analysis.RBLResolvers = []string{"udp://"} }, true},
 	}
 
 	for _, tc := range tests {
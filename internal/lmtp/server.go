@@ -32,6 +32,7 @@ import (
 	"git.happydns.org/happyDeliver/internal/config"
 	"git.happydns.org/happyDeliver/internal/receiver"
 	"git.happydns.org/happyDeliver/internal/storage"
+	"git.happydns.org/happyDeliver/pkg/analyzer"
 )
 
 // Backend implements smtp.Backend for LMTP server
@@ -42,8 +43,14 @@ type Backend struct {
 
 // NewBackend creates a new LMTP backend
 func NewBackend(store storage.Storage, cfg *config.Config) *Backend {
+	return NewBackendWithAnalyzer(store, cfg, analyzer.NewEmailAnalyzer(cfg))
+}
+
+// NewBackendWithAnalyzer creates an LMTP backend analyzing the received
+// emails with an existing analyzer
+func NewBackendWithAnalyzer(store storage.Storage, cfg *config.Config, emailAnalyzer *analyzer.EmailAnalyzer) *Backend {
 	return &Backend{
-		receiver: receiver.NewEmailReceiver(store, cfg),
+		receiver: receiver.NewEmailReceiverWithAnalyzer(store, cfg, emailAnalyzer),
 		config:   cfg,
 	}
 }
@@ -122,9 +129,10 @@ func (s *Session) Logout() error {
 	return nil
 }
 
-// StartServer starts an LMTP server on the specified address
-func StartServer(addr string, store storage.Storage, cfg *config.Config) error {
-	backend := NewBackend(store, cfg)
+// StartServer starts an LMTP server on the specified address, analyzing the
+// received emails with emailAnalyzer
+func StartServer(addr string, store storage.Storage, cfg *config.Config, emailAnalyzer *analyzer.EmailAnalyzer) error {
+	backend := NewBackendWithAnalyzer(store, cfg, emailAnalyzer)
 
 	server := smtp.NewServer(backend)
 	server.Addr = addr
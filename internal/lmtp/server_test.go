@@ -33,6 +33,7 @@ import (
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/storage"
 	"git.happydns.org/happyDeliver/internal/utils"
+	"git.happydns.org/happyDeliver/pkg/analyzer"
 )
 
 // mockStorage is a minimal in-memory storage.Storage implementation for tests.
@@ -169,7 +170,8 @@ func TestSessionLogout(t *testing.T) {
 func TestStartServerListenError(t *testing.T) {
 	// An unparseable address makes net.Listen fail immediately, so
 	// StartServer returns before blocking in Serve.
-	err := StartServer("invalid:address:99999", &mockStorage{}, testConfig())
+	cfg := testConfig()
+	err := StartServer("invalid:address:99999", &mockStorage{}, cfg, analyzer.NewEmailAnalyzer(cfg))
 	if err == nil {
 		t.Fatal("expected an error for an invalid bind address, got nil")
 	}
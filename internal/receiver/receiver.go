@@ -46,10 +46,16 @@ type EmailReceiver struct {
 
 // NewEmailReceiver creates a new email receiver
 func NewEmailReceiver(store storage.Storage, cfg *config.Config) *EmailReceiver {
+	return NewEmailReceiverWithAnalyzer(store, cfg, analyzer.NewEmailAnalyzer(cfg))
+}
+
+// NewEmailReceiverWithAnalyzer creates an email receiver analyzing the
+// received emails with an existing analyzer
+func NewEmailReceiverWithAnalyzer(store storage.Storage, cfg *config.Config, emailAnalyzer *analyzer.EmailAnalyzer) *EmailReceiver {
 	return &EmailReceiver{
 		storage:  store,
 		config:   cfg,
-		analyzer: analyzer.NewEmailAnalyzer(cfg),
+		analyzer: emailAnalyzer,
 	}
 }
 
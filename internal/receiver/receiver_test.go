@@ -33,6 +33,7 @@ import (
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/storage"
 	"git.happydns.org/happyDeliver/internal/utils"
+	"git.happydns.org/happyDeliver/pkg/analyzer"
 )
 
 // mockStorage is a minimal in-memory storage.Storage implementation for tests.
@@ -108,6 +109,15 @@ func TestNewEmailReceiver(t *testing.T) {
 	}
 }
 
+func TestNewEmailReceiverWithAnalyzer(t *testing.T) {
+	cfg := testConfig()
+	shared := analyzer.NewEmailAnalyzer(cfg)
+	r := NewEmailReceiverWithAnalyzer(&mockStorage{}, cfg, shared)
+	if r.analyzer != shared {
+		t.Error("analyzer not wired to the provided analyzer")
+	}
+}
+
 func TestProcessEmailReadError(t *testing.T) {
 	r := NewEmailReceiver(&mockStorage{}, testConfig())
 	err := r.ProcessEmail(errReader{}, testRecipient(uuid.New()))
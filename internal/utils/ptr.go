@@ -25,3 +25,12 @@ package utils
 func PtrTo[T any](v T) *T {
 	return &v
 }
+
+// DerefOrZero returns the value p points to, or the zero value when p is nil
+func DerefOrZero[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
@@ -62,3 +62,15 @@ func TestPtrTo(t *testing.T) {
 		}
 	})
 }
+
+func TestDerefOrZero(t *testing.T) {
+	if v := DerefOrZero(PtrTo("hello")); v != "hello" {
+		t.Errorf("expected %q, got %q", "hello", v)
+	}
+	if v := DerefOrZero[int](nil); v != 0 {
+		t.Errorf("expected 0, got %d", v)
+	}
+	if v := DerefOrZero[[]string](nil); v != nil {
+		t.Errorf("expected nil, got %v", v)
+	}
+}
@@ -23,8 +23,11 @@ package analyzer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -35,12 +38,40 @@ import (
 // EmailAnalyzer provides high-level email analysis functionality
 // This is the main entry point for analyzing emails from both LMTP and CLI
 type EmailAnalyzer struct {
-	generator *ReportGenerator
+	generator      *ReportGenerator
+	scanChecker    *DNSListChecker // Checks the blacklist scans, apart from the messages
+	dnsCache       *DNSCache       // nil when caching is disabled
+	healthInterval time.Duration   // Interval of the DNS list health checks (0 = not monitored)
 }
 
 // NewEmailAnalyzer creates a new email analyzer with the given configuration
 func NewEmailAnalyzer(cfg *config.Config) *EmailAnalyzer {
-	generator := NewReportGenerator(
+	upstreams := configuredUpstreams("resolver", cfg.Analysis.Resolvers)
+	recordResolver := NewStandardDNSResolverWithUpstreams(upstreams)
+	if cfg.Analysis.DNSSECValidation {
+		recordResolver = NewDNSSECResolver(upstreams)
+	}
+	listUpstreams := configuredUpstreams("rbl-resolver", cfg.Analysis.RBLResolvers)
+	listResolver := NewStandardDNSResolverWithUpstreams(listUpstreams)
+	scanResolver := listResolver
+
+	// A single cache serves the record checks and the RBL queries, which
+	// also share their entries when they use the same resolver
+	var dnsCache *DNSCache
+	if cfg.Analysis.DNSCacheSize > 0 {
+		dnsCache = NewDNSCache(cfg.Analysis.DNSCacheSize, cfg.Analysis.DNSCacheMaxTTL)
+		cachedRecordResolver := dnsCache.Resolver(recordResolver)
+		if len(upstreams) == 0 && len(listUpstreams) == 0 && !cfg.Analysis.DNSSECValidation {
+			listResolver = cachedRecordResolver
+		} else {
+			listResolver = dnsCache.Resolver(listResolver)
+		}
+		recordResolver = cachedRecordResolver
+	}
+
+	generator := NewReportGeneratorWithResolvers(
+		recordResolver,
+		listResolver,
 		cfg.Email.ReceiverHostname,
 		cfg.Analysis.DNSTimeout,
 		cfg.Analysis.HTTPTimeout,
@@ -50,9 +81,90 @@ func NewEmailAnalyzer(cfg *config.Config) *EmailAnalyzer {
 		cfg.Analysis.RspamdAPIURL,
 	)
 
+	// ValidateConfig rejects the files that can't be loaded at startup; should
+	// the configuration not have been validated, they are ignored
+	if cfg.Analysis.DNSListCatalog != "" {
+		catalog, err := LoadDNSListCatalog(cfg.Analysis.DNSListCatalog)
+		if err != nil {
+			log.Printf("Ignoring the dns-list-catalog option, using the default lists: %v", err)
+		} else {
+			generator.rblChecker.UseCatalog(catalog, cfg.Analysis.RBLs)
+			generator.dnswlChecker.UseCatalog(catalog, cfg.Analysis.DNSWLs)
+			generator.domainChecker.UseCatalog(catalog, cfg.Analysis.DomainLists)
+		}
+	}
+	if len(cfg.Analysis.DomainLists) > 0 && generator.domainChecker.catalog == nil {
+		generator.domainChecker.Lists = cfg.Analysis.DomainLists
+	}
+
+	// The list queries of all the messages share the same slots
+	var slots chan struct{}
+	if cfg.Analysis.DNSListConcurrency > 0 {
+		slots = make(chan struct{}, cfg.Analysis.DNSListConcurrency)
+	}
+	for _, checker := range generator.dnsListCheckers() {
+		checker.slots = slots
+		checker.Deadline = cfg.Analysis.DNSListDeadline
+	}
+
+	// Blacklist scans have slots of their own and bypass the cache, so that
+	// they neither hold up the checks of the messages nor evict their answers
+	scanChecker := *generator.rblChecker
+	scanChecker.resolver = scanResolver
+	if cfg.Analysis.DNSListConcurrency > 0 {
+		scanChecker.slots = make(chan struct{}, cfg.Analysis.DNSListConcurrency)
+	}
+
+	if len(cfg.Analysis.DKIMSelectors) > 0 {
+		generator.dnsAnalyzer.DKIMSelectors = cfg.Analysis.DKIMSelectors
+	}
+	generator.dnsAnalyzer.SMTPProbe = cfg.Analysis.SMTPProbe
+	generator.dnsAnalyzer.SMTPHelo = cfg.Email.ReceiverHostname
+
+	if cfg.Analysis.BIMIRoots != "" {
+		roots, err := LoadBIMIRoots(cfg.Analysis.BIMIRoots)
+		if err != nil {
+			log.Printf("Ignoring the bimi-roots option, mark certificate chains won't be verified: %v", err)
+		} else {
+			generator.dnsAnalyzer.BIMIRoots = roots
+		}
+	}
+
 	return &EmailAnalyzer{
-		generator: generator,
+		generator:      generator,
+		scanChecker:    &scanChecker,
+		dnsCache:       dnsCache,
+		healthInterval: cfg.Analysis.DNSListHealthInterval,
+	}
+}
+
+// ValidateConfig checks the files the analysis options point to, so that a
+// mistake stops the startup instead of the analyzer ignoring them.
+func ValidateConfig(cfg *config.Config) error {
+	if cfg.Analysis.DNSListCatalog != "" {
+		if _, err := LoadDNSListCatalog(cfg.Analysis.DNSListCatalog); err != nil {
+			return fmt.Errorf("invalid dns-list-catalog option: %w", err)
+		}
+	}
+	if cfg.Analysis.BIMIRoots != "" {
+		if _, err := LoadBIMIRoots(cfg.Analysis.BIMIRoots); err != nil {
+			return fmt.Errorf("invalid bimi-roots option: %w", err)
+		}
 	}
+
+	return nil
+}
+
+// configuredUpstreams parses the nameservers of a configuration option.
+// Config.Validate rejects invalid ones; should the configuration not have
+// been validated, the system resolver is used instead.
+func configuredUpstreams(option string, specs []string) []DNSUpstream {
+	upstreams, err := ParseDNSUpstreams(specs)
+	if err != nil {
+		log.Printf("Ignoring the %s option, using the system resolver: %v", option, err)
+		return nil
+	}
+	return upstreams
 }
 
 // AnalysisResult contains the complete analysis result
@@ -64,6 +176,72 @@ type AnalysisResult struct {
 
 // AnalyzeEmailBytes performs complete email analysis from raw bytes
 func (a *EmailAnalyzer) AnalyzeEmailBytes(rawEmail []byte, testID uuid.UUID) (*AnalysisResult, error) {
+	return a.analyzeEmailBytes(rawEmail, testID, a.generator.AnalyzeEmail)
+}
+
+// ReanalyzeEmailBytes performs complete email analysis from raw bytes, like
+// AnalyzeEmailBytes, with fresh DNS answers instead of cached ones. When the
+// DNS snapshot of a previous analysis is given, the report lists the
+// answers that changed since.
+func (a *EmailAnalyzer) ReanalyzeEmailBytes(rawEmail []byte, testID uuid.UUID, previous *model.DNSSnapshot) (*AnalysisResult, error) {
+	result, err := a.analyzeEmailBytes(rawEmail, testID, a.generator.withFreshDNS().AnalyzeEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		changes := DiffDNSSnapshots(previous, result.Report.DnsSnapshot)
+		if changes == nil {
+			changes = []model.DNSChange{}
+		}
+		result.Report.DnsChanges = &changes
+	}
+	return result, nil
+}
+
+// ReplayEmailBytes performs complete email analysis from raw bytes offline,
+// answering the DNS queries from the snapshot of a previous analysis.
+func (a *EmailAnalyzer) ReplayEmailBytes(rawEmail []byte, testID uuid.UUID, snapshot *model.DNSSnapshot) (*AnalysisResult, error) {
+	return a.analyzeEmailBytes(rawEmail, testID, func(email *EmailMessage) *AnalysisResults {
+		return a.generator.ReplayEmail(email, snapshot)
+	})
+}
+
+// DNSCacheStats returns the counters of the DNS cache, and whether there is
+// one.
+func (a *EmailAnalyzer) DNSCacheStats() (DNSCacheStats, bool) {
+	if a.dnsCache == nil {
+		return DNSCacheStats{}, false
+	}
+	return a.dnsCache.Stats(), true
+}
+
+// StartDNSListHealthChecks checks the RBL, DNSWL and domain list zones
+// against their test points now, then at the configured interval until ctx
+// is done, quarantining the misbehaving ones.
+func (a *EmailAnalyzer) StartDNSListHealthChecks(ctx context.Context) {
+	if a.healthInterval <= 0 {
+		return
+	}
+	for _, checker := range a.generator.dnsListCheckers() {
+		checker.StartHealthChecks(ctx, a.healthInterval)
+	}
+}
+
+// BlacklistScanTargets returns the addresses of a blacklist scan of the CIDR
+// range and the IPs
+func (a *EmailAnalyzer) BlacklistScanTargets(cidr string, ips []string) ([]string, error) {
+	return a.scanChecker.ScanTargets(cidr, ips)
+}
+
+// ScanBlacklist checks each address against the RBLs and returns the lists
+// queried, the checks of each address and the hits of each list
+func (a *EmailAnalyzer) ScanBlacklist(ips []string, progress func(done int)) ([]string, []model.BlacklistScanResult, model.BlacklistScanSummary) {
+	results, summary := a.scanChecker.ScanIPs(ips, progress)
+	return a.scanChecker.Lists, results, summary
+}
+
+func (a *EmailAnalyzer) analyzeEmailBytes(rawEmail []byte, testID uuid.UUID, analyze func(*EmailMessage) *AnalysisResults) (*AnalysisResult, error) {
 	// Parse the email
 	emailMsg, err := ParseEmail(bytes.NewReader(rawEmail))
 	if err != nil {
@@ -71,7 +249,7 @@ func (a *EmailAnalyzer) AnalyzeEmailBytes(rawEmail []byte, testID uuid.UUID) (*A
 	}
 
 	// Analyze the email
-	results := a.generator.AnalyzeEmail(emailMsg)
+	results := analyze(emailMsg)
 
 	// Generate the report
 	report := a.generator.GenerateReport(testID, results)
@@ -91,14 +269,36 @@ type APIAdapter struct {
 
 // NewAPIAdapter creates a new API adapter for the email analyzer
 func NewAPIAdapter(cfg *config.Config) *APIAdapter {
+	return NewAPIAdapterWithAnalyzer(NewEmailAnalyzer(cfg))
+}
+
+// NewAPIAdapterWithAnalyzer creates an API adapter for an existing email
+// analyzer, shared with the other components analyzing emails
+func NewAPIAdapterWithAnalyzer(analyzer *EmailAnalyzer) *APIAdapter {
 	return &APIAdapter{
-		analyzer: NewEmailAnalyzer(cfg),
+		analyzer: analyzer,
 	}
 }
 
 // AnalyzeEmailBytes performs analysis and returns JSON bytes directly
 func (a *APIAdapter) AnalyzeEmailBytes(rawEmail []byte, testID uuid.UUID) ([]byte, error) {
-	result, err := a.analyzer.AnalyzeEmailBytes(rawEmail, testID)
+	return marshalReport(a.analyzer.AnalyzeEmailBytes(rawEmail, testID))
+}
+
+// ReanalyzeEmailBytes performs analysis with fresh DNS answers, listing
+// the changes since the previous DNS snapshot if any, and returns JSON
+// bytes directly
+func (a *APIAdapter) ReanalyzeEmailBytes(rawEmail []byte, testID uuid.UUID, previous *model.DNSSnapshot) ([]byte, error) {
+	return marshalReport(a.analyzer.ReanalyzeEmailBytes(rawEmail, testID, previous))
+}
+
+// ReplayEmailBytes performs analysis against a DNS snapshot and returns
+// JSON bytes directly
+func (a *APIAdapter) ReplayEmailBytes(rawEmail []byte, testID uuid.UUID, snapshot *model.DNSSnapshot) ([]byte, error) {
+	return marshalReport(a.analyzer.ReplayEmailBytes(rawEmail, testID, snapshot))
+}
+
+func marshalReport(result *AnalysisResult, err error) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -112,10 +312,49 @@ func (a *APIAdapter) AnalyzeEmailBytes(rawEmail []byte, testID uuid.UUID) ([]byt
 	return reportJSON, nil
 }
 
+// DNSCacheStatus returns the counters of the DNS cache, or nil when caching
+// is disabled
+func (a *APIAdapter) DNSCacheStatus() *model.DNSCacheStatus {
+	stats, ok := a.analyzer.DNSCacheStats()
+	if !ok {
+		return nil
+	}
+	return &model.DNSCacheStatus{
+		Hits:    int64(stats.Hits),
+		Misses:  int64(stats.Misses),
+		Entries: stats.Entries,
+	}
+}
+
+// DNSListHealth returns the health of the DNS list zones, or nil when they
+// are not monitored
+func (a *APIAdapter) DNSListHealth() []model.DNSListHealth {
+	return a.analyzer.generator.DNSListHealth()
+}
+
+// BlacklistScanTargets returns the addresses of a blacklist scan of the CIDR
+// range and the IPs
+func (a *APIAdapter) BlacklistScanTargets(cidr string, ips []string) ([]string, error) {
+	return a.analyzer.BlacklistScanTargets(cidr, ips)
+}
+
+// ScanBlacklist checks each address against DNS blacklists
+func (a *APIAdapter) ScanBlacklist(ips []string, progress func(done int)) ([]string, []model.BlacklistScanResult, model.BlacklistScanSummary) {
+	return a.analyzer.ScanBlacklist(ips, progress)
+}
+
 // AnalyzeDomain performs DNS analysis for a domain and returns the results
-func (a *APIAdapter) AnalyzeDomain(domain string) (*model.DNSResults, int, string) {
+// When probeDKIM is set, the DKIM keys published under the given selectors,
+// or under the configured ones when none are given, are reported.
+func (a *APIAdapter) AnalyzeDomain(domain string, probeDKIM bool, dkimSelectors []string) (*model.DNSResults, int, string) {
+	if !probeDKIM {
+		dkimSelectors = nil
+	} else if len(dkimSelectors) == 0 {
+		dkimSelectors = a.analyzer.generator.dnsAnalyzer.DKIMSelectors
+	}
+
 	// Perform DNS analysis
-	dnsResults := a.analyzer.generator.dnsAnalyzer.AnalyzeDomainOnly(domain)
+	dnsResults := a.analyzer.generator.dnsAnalyzer.AnalyzeDomainOnly(domain, dkimSelectors...)
 
 	// Calculate score
 	score, grade := a.analyzer.generator.dnsAnalyzer.CalculateDomainOnlyScore(dnsResults)
@@ -134,9 +373,13 @@ func (a *APIAdapter) CheckBlacklistIP(ip string) ([]model.BlacklistCheck, []mode
 	// Calculate score using the existing function
 	// Create a minimal RBLResults structure for scoring
 	results := &DNSListResults{
-		Checks:      map[string][]model.BlacklistCheck{ip: checks},
-		IPsChecked:  []string{ip},
-		ListedCount: listedCount,
+		Checks:     map[string][]model.BlacklistCheck{ip: checks},
+		IPsChecked: []string{ip},
+	}
+	for _, check := range checks {
+		if check.Listed {
+			a.analyzer.generator.rblChecker.countListing(results, check.Rbl)
+		}
 	}
 	score, grade := a.analyzer.generator.rblChecker.CalculateScore(results, false)
 
@@ -0,0 +1,321 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// arcMaxInstances is the highest instance number allowed in an ARC chain (RFC 8617 section 4.2.1).
+const arcMaxInstances = 50
+
+// arcSet groups the three header fields added by one ARC intermediary.
+type arcSet struct {
+	Instance int
+	AAR      *rawHeaderField
+	AMS      *rawHeaderField
+	AS       *rawHeaderField
+}
+
+// arcSeal is a parsed ARC-Seal header field.
+type arcSeal struct {
+	*dkimSignature
+	Instance        int
+	ChainValidation string
+}
+
+// arcHopVerification is the outcome of verifying the ARC set of one intermediary.
+type arcHopVerification struct {
+	Instance               int
+	Seal                   *arcSeal
+	SealResult             model.DKIMVerificationResult
+	SealReason             string
+	MessageSignatureResult model.DKIMVerificationResult
+	MessageSignatureReason string
+}
+
+// arcChainVerification is the outcome of verifying a whole ARC chain.
+type arcChainVerification struct {
+	Hops []arcHopVerification
+
+	// BrokenInstance is the first instance at which the chain is broken, or
+	// 0 when the chain validates.
+	BrokenInstance int
+	BrokenDomain   string
+	BrokenSelector string
+	Reason         string
+}
+
+// Valid reports whether the chain validated.
+func (c *arcChainVerification) Valid() bool {
+	return c.BrokenInstance == 0
+}
+
+// breakAt records the first point at which the chain is broken.
+func (c *arcChainVerification) breakAt(instance int, seal *arcSeal, reason string) {
+	if c.BrokenInstance != 0 {
+		return
+	}
+	c.BrokenInstance = instance
+	c.Reason = reason
+	if seal != nil {
+		c.BrokenDomain = seal.Domain
+		c.BrokenSelector = seal.Selector
+	}
+}
+
+// arcInstance extracts the i= instance number of an ARC header field.
+func arcInstance(field rawHeaderField) (int, error) {
+	value := field.Value()
+	if strings.EqualFold(field.Name, "ARC-Authentication-Results") {
+		// The instance is the first element, before the authserv-id
+		value, _, _ = strings.Cut(value, ";")
+	}
+
+	i, ok := parseDKIMSignatureTags(value)["i"]
+	if !ok {
+		return 0, fmt.Errorf("%s has no i= tag", field.Name)
+	}
+	instance, err := strconv.Atoi(i)
+	if err != nil || instance < 1 || instance > arcMaxInstances {
+		return 0, fmt.Errorf("%s has an invalid instance i=%s", field.Name, i)
+	}
+	return instance, nil
+}
+
+// collectARCSets groups the ARC header fields of a message by instance and
+// checks the structure of the chain: every instance from 1 to N must have
+// exactly one field of each kind.
+func collectARCSets(msg *rawMessage) ([]*arcSet, int, error) {
+	byInstance := map[int]*arcSet{}
+	highest := 0
+
+	for _, name := range []string{"ARC-Authentication-Results", "ARC-Message-Signature", "ARC-Seal"} {
+		for _, field := range msg.FieldsNamed(name) {
+			instance, err := arcInstance(field)
+			if err != nil {
+				return nil, 0, err
+			}
+			highest = max(highest, instance)
+
+			set, ok := byInstance[instance]
+			if !ok {
+				set = &arcSet{Instance: instance}
+				byInstance[instance] = set
+			}
+
+			slot := &set.AAR
+			switch name {
+			case "ARC-Message-Signature":
+				slot = &set.AMS
+			case "ARC-Seal":
+				slot = &set.AS
+			}
+			if *slot != nil {
+				return nil, instance, fmt.Errorf("instance %d has more than one %s", instance, name)
+			}
+			f := field
+			*slot = &f
+		}
+	}
+
+	sets := make([]*arcSet, 0, highest)
+	for i := 1; i <= highest; i++ {
+		set, ok := byInstance[i]
+		if !ok {
+			return nil, i, fmt.Errorf("instance %d is missing from the chain", i)
+		}
+		if set.AAR == nil || set.AMS == nil || set.AS == nil {
+			return nil, i, fmt.Errorf("instance %d is incomplete", i)
+		}
+		sets = append(sets, set)
+	}
+
+	return sets, 0, nil
+}
+
+// parseARCSeal parses the value of an ARC-Seal header field. As with
+// parseDKIMSignature, the returned seal is never nil.
+func parseARCSeal(value string) (*arcSeal, error) {
+	tags := parseDKIMSignatureTags(value)
+
+	seal := &arcSeal{
+		dkimSignature: &dkimSignature{
+			Tags:        tags,
+			Algorithm:   strings.ToLower(tags["a"]),
+			Domain:      strings.ToLower(strings.TrimSuffix(tags["d"], ".")),
+			Selector:    tags["s"],
+			HeaderCanon: "relaxed",
+			BodyLength:  -1,
+		},
+		ChainValidation: strings.ToLower(tags["cv"]),
+	}
+
+	for _, required := range []string{"i", "a", "b", "d", "s", "cv"} {
+		if tags[required] == "" {
+			return seal, fmt.Errorf("missing required tag %s=", required)
+		}
+	}
+
+	var err error
+	if seal.Instance, err = strconv.Atoi(tags["i"]); err != nil {
+		return seal, fmt.Errorf("malformed i= tag")
+	}
+
+	// RFC 8617 section 4.1.3: an ARC-Seal carrying h= is invalid
+	if _, ok := tags["h"]; ok {
+		return seal, fmt.Errorf("ARC-Seal must not have an h= tag")
+	}
+
+	switch seal.Algorithm {
+	case "rsa-sha256", "ed25519-sha256":
+	default:
+		return seal, fmt.Errorf("unsupported signing algorithm %q", tags["a"])
+	}
+
+	switch seal.ChainValidation {
+	case "none", "pass", "fail":
+	default:
+		return seal, fmt.Errorf("invalid chain validation status cv=%s", tags["cv"])
+	}
+
+	if seal.Signature, err = base64.StdEncoding.DecodeString(tags["b"]); err != nil {
+		return seal, fmt.Errorf("malformed b= tag")
+	}
+
+	return seal, nil
+}
+
+// arcSealFields returns the header fields covered by the ARC-Seal of the
+// given instance: every ARC set up to that instance, in increasing order,
+// except the seal itself.
+func arcSealFields(sets []*arcSet, instance int) []rawHeaderField {
+	var fields []rawHeaderField
+	for _, set := range sets[:instance] {
+		fields = append(fields, *set.AAR, *set.AMS)
+		if set.Instance != instance {
+			fields = append(fields, *set.AS)
+		}
+	}
+	return fields
+}
+
+// verifyARCSeal checks the signature of the ARC-Seal of the given instance.
+func verifyARCSeal(ctx context.Context, resolver DNSResolver, sets []*arcSet, seal *arcSeal) (model.DKIMVerificationResult, string) {
+	key, err := fetchDKIMKey(ctx, resolver, seal.Selector, seal.Domain)
+	if err != nil {
+		return dkimKeyErrorResult(err), err.Error()
+	}
+
+	if reason := checkDKIMKeyForSignature(key, seal.dkimSignature); reason != "" {
+		return model.DKIMVerificationResultPermerror, reason
+	}
+
+	newHash, hashID := dkimHashFunc(seal.HashAlgorithm())
+	digest := dkimHeaderHash(arcSealFields(sets, seal.Instance), *sets[seal.Instance-1].AS, "relaxed", newHash)
+	if !verifyDKIMCrypto(key, hashID, digest, seal.Signature) {
+		return model.DKIMVerificationResultFail, "seal did not verify"
+	}
+
+	return model.DKIMVerificationResultPass, ""
+}
+
+// verifyARCMessageSignature checks an ARC-Message-Signature the same way as
+// a DKIM-Signature, without the DKIM-specific v= and i= rules.
+func verifyARCMessageSignature(ctx context.Context, resolver DNSResolver, msg *rawMessage, field rawHeaderField) (model.DKIMVerificationResult, string) {
+	sig, err := parseDKIMSignature(field.Value())
+	if err != nil {
+		return model.DKIMVerificationResultPermerror, err.Error()
+	}
+
+	v := verifySignedMessage(ctx, resolver, msg, field, &dkimVerification{Signature: sig})
+	return v.Result, v.Reason
+}
+
+// verifyARCChain validates the ARC chain of a message as described in RFC
+// 8617 section 5.2. Every seal and message signature is checked, so that
+// the first hop where the chain broke can be reported, even though only the
+// newest ARC-Message-Signature has to validate.
+func verifyARCChain(resolver DNSResolver, timeout time.Duration, msg *rawMessage) *arcChainVerification {
+	chain := &arcChainVerification{}
+
+	sets, instance, err := collectARCSets(msg)
+	if err != nil {
+		chain.BrokenInstance = max(instance, 1)
+		chain.Reason = err.Error()
+		for _, field := range msg.FieldsNamed("ARC-Seal") {
+			if seal, _ := parseARCSeal(field.Value()); seal.Instance == chain.BrokenInstance {
+				chain.BrokenDomain, chain.BrokenSelector = seal.Domain, seal.Selector
+			}
+		}
+		return chain
+	}
+	if len(sets) == 0 {
+		return chain
+	}
+
+	for _, set := range sets {
+		hop := arcHopVerification{Instance: set.Instance}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		hop.MessageSignatureResult, hop.MessageSignatureReason = verifyARCMessageSignature(ctx, resolver, msg, *set.AMS)
+		cancel()
+
+		seal, err := parseARCSeal(set.AS.Value())
+		hop.Seal = seal
+		if err != nil {
+			hop.SealResult, hop.SealReason = model.DKIMVerificationResultPermerror, err.Error()
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			hop.SealResult, hop.SealReason = verifyARCSeal(ctx, resolver, sets, seal)
+			cancel()
+		}
+
+		chain.Hops = append(chain.Hops, hop)
+	}
+
+	for _, hop := range chain.Hops {
+		switch {
+		case hop.SealResult != model.DKIMVerificationResultPass:
+			chain.breakAt(hop.Instance, hop.Seal, fmt.Sprintf("ARC-Seal of instance %d is not valid: %s", hop.Instance, hop.SealReason))
+		case hop.Seal.ChainValidation == "fail":
+			chain.breakAt(hop.Instance, hop.Seal, fmt.Sprintf("intermediary of instance %d found the chain already broken (cv=fail)", hop.Instance))
+		case hop.Instance == 1 && hop.Seal.ChainValidation != "none":
+			chain.breakAt(hop.Instance, hop.Seal, fmt.Sprintf("first ARC-Seal must have cv=none, got cv=%s", hop.Seal.ChainValidation))
+		case hop.Instance > 1 && hop.Seal.ChainValidation != "pass":
+			chain.breakAt(hop.Instance, hop.Seal, fmt.Sprintf("ARC-Seal of instance %d must have cv=pass, got cv=%s", hop.Instance, hop.Seal.ChainValidation))
+		}
+	}
+
+	if last := chain.Hops[len(chain.Hops)-1]; last.MessageSignatureResult != model.DKIMVerificationResultPass {
+		chain.breakAt(last.Instance, last.Seal, fmt.Sprintf("newest ARC-Message-Signature is not valid: %s", last.MessageSignatureReason))
+	}
+
+	return chain
+}
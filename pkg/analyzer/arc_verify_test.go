@@ -0,0 +1,279 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// addTestARCSet prepends the next ARC set to message, sealed by
+// d=hop<instance>.example.org s=arc.
+func addTestARCSet(t *testing.T, message string, key ed25519.PrivateKey, cv string) string {
+	t.Helper()
+
+	msg := splitRawMessage([]byte(message))
+	sets, _, err := collectARCSets(msg)
+	if err != nil {
+		t.Fatalf("collectARCSets: %v", err)
+	}
+	instance := len(sets) + 1
+	domain := fmt.Sprintf("hop%d.example.org", instance)
+
+	bh, err := dkimBodyHash(msg.Body, "relaxed", -1, sha256.New)
+	if err != nil {
+		t.Fatalf("dkimBodyHash: %v", err)
+	}
+
+	aar := rawHeaderField{
+		Name: "ARC-Authentication-Results",
+		Raw:  fmt.Sprintf("ARC-Authentication-Results: i=%d; %s; spf=pass smtp.mailfrom=example.com", instance, domain),
+	}
+	ams := rawHeaderField{
+		Name: "ARC-Message-Signature",
+		Raw: fmt.Sprintf("ARC-Message-Signature: i=%d; a=ed25519-sha256; c=relaxed/relaxed; d=%s; s=arc;\r\n\th=from:to:subject;\r\n\tbh=%s;\r\n\tb=",
+			instance, domain, base64.StdEncoding.EncodeToString(bh)),
+	}
+	digest := dkimHeaderHash(selectSignedHeaders(msg.Fields, []string{"from", "to", "subject"}), ams, "relaxed", sha256.New)
+	ams.Raw += base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+
+	as := rawHeaderField{
+		Name: "ARC-Seal",
+		Raw:  fmt.Sprintf("ARC-Seal: i=%d; a=ed25519-sha256; cv=%s; d=%s; s=arc;\r\n\tb=", instance, cv, domain),
+	}
+	sets = append(sets, &arcSet{Instance: instance, AAR: &aar, AMS: &ams, AS: &as})
+	digest = dkimHeaderHash(arcSealFields(sets, instance), as, "relaxed", sha256.New)
+	as.Raw += base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+
+	return as.Raw + "\r\n" + ams.Raw + "\r\n" + aar.Raw + "\r\n" + message
+}
+
+func TestVerifyARCChain(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRecord := []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}
+	txt := map[string][]string{
+		"arc._domainkey.hop1.example.org": keyRecord,
+		"arc._domainkey.hop2.example.org": keyRecord,
+	}
+
+	oneHop := addTestARCSet(t, testDKIMMessage, key, "none")
+	twoHops := addTestARCSet(t, oneHop, key, "pass")
+
+	tests := []struct {
+		name           string
+		message        string
+		txt            map[string][]string
+		valid          bool
+		hops           int
+		brokenInstance int
+		brokenDomain   string
+		amsResults     []model.DKIMVerificationResult
+	}{
+		{
+			name:       "single hop",
+			message:    oneHop,
+			valid:      true,
+			hops:       1,
+			amsResults: []model.DKIMVerificationResult{model.DKIMVerificationResultPass},
+		},
+		{
+			name:       "two hops",
+			message:    twoHops,
+			valid:      true,
+			hops:       2,
+			amsResults: []model.DKIMVerificationResult{model.DKIMVerificationResultPass, model.DKIMVerificationResultPass},
+		},
+		{
+			name:       "mailing list footer added between hops",
+			message:    addTestARCSet(t, oneHop+"-- \r\nList footer\r\n", key, "pass"),
+			valid:      true,
+			hops:       2,
+			amsResults: []model.DKIMVerificationResult{model.DKIMVerificationResultFail, model.DKIMVerificationResultPass},
+		},
+		{
+			name:           "body modified after the last hop",
+			message:        twoHops + "-- \r\nFooter\r\n",
+			hops:           2,
+			brokenInstance: 2,
+			brokenDomain:   "hop2.example.org",
+			amsResults:     []model.DKIMVerificationResult{model.DKIMVerificationResultFail, model.DKIMVerificationResultFail},
+		},
+		{
+			name:           "first hop results tampered with",
+			message:        strings.Replace(twoHops, "i=1; hop1.example.org; spf=pass", "i=1; hop1.example.org; spf=fail", 1),
+			hops:           2,
+			brokenInstance: 1,
+			brokenDomain:   "hop1.example.org",
+			amsResults:     []model.DKIMVerificationResult{model.DKIMVerificationResultPass, model.DKIMVerificationResultPass},
+		},
+		{
+			name:           "intermediary reported a broken chain",
+			message:        addTestARCSet(t, oneHop, key, "fail"),
+			hops:           2,
+			brokenInstance: 2,
+			brokenDomain:   "hop2.example.org",
+			amsResults:     []model.DKIMVerificationResult{model.DKIMVerificationResultPass, model.DKIMVerificationResultPass},
+		},
+		{
+			name:           "first hop claims cv=pass",
+			message:        addTestARCSet(t, testDKIMMessage, key, "pass"),
+			hops:           1,
+			brokenInstance: 1,
+			brokenDomain:   "hop1.example.org",
+			amsResults:     []model.DKIMVerificationResult{model.DKIMVerificationResultPass},
+		},
+		{
+			name:           "missing sealer key",
+			message:        twoHops,
+			txt:            map[string][]string{"arc._domainkey.hop1.example.org": keyRecord},
+			hops:           2,
+			brokenInstance: 2,
+			brokenDomain:   "hop2.example.org",
+			amsResults:     []model.DKIMVerificationResult{model.DKIMVerificationResultPass, model.DKIMVerificationResultPermerror},
+		},
+		{
+			name:           "missing instance",
+			message:        strings.Replace(oneHop, "i=1;", "i=2;", 3),
+			brokenInstance: 1,
+		},
+		{
+			name:           "duplicate seal",
+			message:        "ARC-Seal: i=1; a=ed25519-sha256; cv=none; d=other.example.org; s=arc; b=AAAA\r\n" + oneHop,
+			brokenInstance: 1,
+			brokenDomain:   "hop1.example.org",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.txt
+			if records == nil {
+				records = txt
+			}
+			resolver := &mockDNSResolver{txt: records, err: map[string]error{}}
+
+			chain := verifyARCChain(resolver, time.Second, splitRawMessage([]byte(tt.message)))
+
+			if chain.Valid() != tt.valid {
+				t.Errorf("Valid() = %v, want %v (reason: %s)", chain.Valid(), tt.valid, chain.Reason)
+			}
+			if chain.BrokenInstance != tt.brokenInstance {
+				t.Errorf("BrokenInstance = %d, want %d (reason: %s)", chain.BrokenInstance, tt.brokenInstance, chain.Reason)
+			}
+			if chain.BrokenDomain != tt.brokenDomain {
+				t.Errorf("BrokenDomain = %q, want %q", chain.BrokenDomain, tt.brokenDomain)
+			}
+			if len(chain.Hops) != tt.hops {
+				t.Fatalf("got %d hops, want %d", len(chain.Hops), tt.hops)
+			}
+			for i, want := range tt.amsResults {
+				if got := chain.Hops[i].MessageSignatureResult; got != want {
+					t.Errorf("hop %d AMS result = %s, want %s (%s)", i+1, got, want, chain.Hops[i].MessageSignatureReason)
+				}
+			}
+		})
+	}
+}
+
+func TestParseARCSeal(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"valid", "i=1; a=rsa-sha256; cv=none; d=example.org; s=arc; t=1700000000; b=AAAA", false},
+		{"missing cv", "i=1; a=rsa-sha256; d=example.org; s=arc; b=AAAA", true},
+		{"invalid cv", "i=1; a=rsa-sha256; cv=maybe; d=example.org; s=arc; b=AAAA", true},
+		{"h= tag", "i=1; a=rsa-sha256; cv=none; d=example.org; s=arc; h=from; b=AAAA", true},
+		{"rsa-sha1", "i=1; a=rsa-sha1; cv=none; d=example.org; s=arc; b=AAAA", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seal, err := parseARCSeal(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseARCSeal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if seal == nil {
+				t.Fatal("parseARCSeal() returned a nil seal")
+			}
+		})
+	}
+}
+
+func TestParseARCHeadersVerification(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyRecord := []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}
+	resolver := &mockDNSResolver{
+		txt: map[string][]string{
+			"arc._domainkey.hop1.example.org": keyRecord,
+			"arc._domainkey.hop2.example.org": keyRecord,
+		},
+		err: map[string]error{},
+	}
+	analyzer := NewAuthenticationAnalyzerWithResolver("", time.Second, resolver)
+
+	// Structurally complete, but the message was altered after the last hop
+	message := addTestARCSet(t, addTestARCSet(t, testDKIMMessage, key, "none"), key, "pass") + "-- \r\nFooter\r\n"
+	email, err := ParseEmail(bytes.NewBufferString(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := analyzer.parseARCHeaders(email)
+	if result == nil {
+		t.Fatal("expected an ARC result")
+	}
+	if result.Result != model.ARCResultResultFail {
+		t.Errorf("Result = %v, want %v", result.Result, model.ARCResultResultFail)
+	}
+	if result.ChainValid == nil || *result.ChainValid {
+		t.Errorf("ChainValid = %v, want false", result.ChainValid)
+	}
+	if result.BrokenInstance == nil || *result.BrokenInstance != 2 {
+		t.Errorf("BrokenInstance = %v, want 2", result.BrokenInstance)
+	}
+	if result.BrokenDomain == nil || *result.BrokenDomain != "hop2.example.org" {
+		t.Errorf("BrokenDomain = %v, want hop2.example.org", result.BrokenDomain)
+	}
+	if result.Hops == nil || len(*result.Hops) != 2 {
+		t.Fatalf("expected 2 hops, got %v", result.Hops)
+	}
+	if hop := (*result.Hops)[1]; hop.SealResult != model.ARCHopSealResultPass || hop.MessageSignatureResult != model.ARCHopMessageSignatureResultFail {
+		t.Errorf("unexpected hop 2 results: seal %s, AMS %s", hop.SealResult, hop.MessageSignatureResult)
+	}
+}
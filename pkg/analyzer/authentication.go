@@ -23,6 +23,7 @@ package analyzer
 
 import (
 	"strings"
+	"time"
 
 	"git.happydns.org/happyDeliver/internal/model"
 )
@@ -30,11 +31,30 @@ import (
 // AuthenticationAnalyzer analyzes email authentication results
 type AuthenticationAnalyzer struct {
 	receiverHostname string
+	Timeout          time.Duration
+	resolver         DNSResolver
 }
 
 // NewAuthenticationAnalyzer creates a new authentication analyzer
 func NewAuthenticationAnalyzer(receiverHostname string) *AuthenticationAnalyzer {
-	return &AuthenticationAnalyzer{receiverHostname: receiverHostname}
+	return NewAuthenticationAnalyzerWithResolver(receiverHostname, 0, nil)
+}
+
+// NewAuthenticationAnalyzerWithResolver creates a new authentication analyzer
+// with a custom resolver, used to fetch the keys needed to verify signatures.
+// If resolver is nil, a StandardDNSResolver is used.
+func NewAuthenticationAnalyzerWithResolver(receiverHostname string, timeout time.Duration, resolver DNSResolver) *AuthenticationAnalyzer {
+	if timeout == 0 {
+		timeout = 10 * time.Second // Default timeout
+	}
+	if resolver == nil {
+		resolver = NewStandardDNSResolver()
+	}
+	return &AuthenticationAnalyzer{
+		receiverHostname: receiverHostname,
+		Timeout:          timeout,
+		resolver:         resolver,
+	}
 }
 
 // AnalyzeAuthentication extracts and analyzes authentication results from email headers
@@ -52,6 +72,26 @@ func (a *AuthenticationAnalyzer) AnalyzeAuthentication(email *EmailMessage) *mod
 		results.Spf = a.parseLegacySPF(email)
 	}
 
+	// Verify DKIM signatures ourselves, to compare with the upstream verdict
+	// and to have one when no Authentication-Results header is present
+	dkimVerified := a.verifyDKIMMessage(email)
+	if verifications := verifyDKIMSignatures(dkimVerified, results.Dkim); len(verifications) > 0 {
+		results.DkimVerifications = &verifications
+		if results.Dkim == nil {
+			results.Dkim = dkimResultsFromVerifications(verifications)
+		}
+	}
+
+	// Check whether the valid DKIM signatures would survive forwarding
+	if robustness := a.simulateDKIMRobustness(dkimVerified); len(robustness) > 0 {
+		results.DkimRobustness = &robustness
+	}
+
+	// Look for replay and weak-coverage risks in the DKIM signature tags
+	if audits := a.auditDKIMSignatures(email); len(audits) > 0 {
+		results.DkimAudits = &audits
+	}
+
 	// Parse ARC headers if not already parsed from Authentication-Results
 	if results.Arc == nil {
 		results.Arc = a.parseARCHeaders(email)
@@ -187,6 +227,9 @@ func (a *AuthenticationAnalyzer) CalculateAuthenticationScore(results *model.Aut
 	// Penalty-only: X-Google-DKIM (up to -12 points on failure)
 	score += 12 * a.calculateXGoogleDKIMScore(results) / 100
 
+	// Penalty-only: DKIM signature audit (up to -10 points)
+	score += 10 * a.calculateDKIMAuditScore(results) / 100
+
 	// Penalty-only: X-Aligned-From (up to -5 points on failure)
 	score += 5 * a.calculateXAlignedFromScore(results) / 100
 
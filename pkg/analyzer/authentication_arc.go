@@ -91,6 +91,11 @@ func (a *AuthenticationAnalyzer) parseARCHeaders(email *EmailMessage) *model.ARC
 
 	// Validate the ARC chain
 	chainValid := a.validateARCChain(arcAuthResults, arcMessageSig, arcSeal)
+	chain := a.verifyARC(email)
+	if chain != nil {
+		chainValid = chain.Valid()
+		applyARCVerification(result, chain)
+	}
 	result.ChainValid = &chainValid
 
 	// Determine overall result
@@ -98,6 +103,10 @@ func (a *AuthenticationAnalyzer) parseARCHeaders(email *EmailMessage) *model.ARC
 		result.Result = model.ARCResultResultNone
 		details := "No ARC chain present"
 		result.Details = &details
+	} else if !chainValid && chain != nil {
+		result.Result = model.ARCResultResultFail
+		details := fmt.Sprintf("ARC chain broken at instance %d: %s", chain.BrokenInstance, chain.Reason)
+		result.Details = &details
 	} else if !chainValid {
 		result.Result = model.ARCResultResultFail
 		details := fmt.Sprintf("ARC chain validation failed (chain length: %d)", chainLength)
@@ -131,10 +140,71 @@ func (a *AuthenticationAnalyzer) enhanceARCResult(email *EmailMessage, arcResult
 	// Validate chain if not already validated
 	if arcResult.ChainValid == nil {
 		chainValid := a.validateARCChain(arcAuthResults, arcMessageSig, arcSeal)
+		if chain := a.verifyARC(email); chain != nil {
+			chainValid = chain.Valid()
+			applyARCVerification(arcResult, chain)
+		}
 		arcResult.ChainValid = &chainValid
 	}
 }
 
+// verifyARC cryptographically validates the ARC chain of the message. It
+// returns nil when the message as received is not available.
+func (a *AuthenticationAnalyzer) verifyARC(email *EmailMessage) *arcChainVerification {
+	if len(email.RawMessage) == 0 {
+		return nil
+	}
+
+	msg := splitRawMessage(email.RawMessage)
+	if len(msg.FieldsNamed("ARC-Seal")) == 0 && len(msg.FieldsNamed("ARC-Message-Signature")) == 0 && len(msg.FieldsNamed("ARC-Authentication-Results")) == 0 {
+		return nil
+	}
+
+	return verifyARCChain(a.resolver, a.Timeout, msg)
+}
+
+// applyARCVerification copies the per-hop outcome of a chain verification
+// into an ARC result.
+func applyARCVerification(result *model.ARCResult, chain *arcChainVerification) {
+	if len(chain.Hops) > 0 {
+		hops := make([]model.ARCHop, 0, len(chain.Hops))
+		for _, h := range chain.Hops {
+			hop := model.ARCHop{
+				Instance:               h.Instance,
+				SealResult:             model.ARCHopSealResult(h.SealResult),
+				MessageSignatureResult: model.ARCHopMessageSignatureResult(h.MessageSignatureResult),
+			}
+			if h.Seal.Domain != "" {
+				hop.Domain = utils.PtrTo(h.Seal.Domain)
+			}
+			if h.Seal.Selector != "" {
+				hop.Selector = utils.PtrTo(h.Seal.Selector)
+			}
+			if h.Seal.ChainValidation != "" {
+				hop.ChainValidation = utils.PtrTo(h.Seal.ChainValidation)
+			}
+			if h.SealReason != "" {
+				hop.SealReason = utils.PtrTo(h.SealReason)
+			}
+			if h.MessageSignatureReason != "" {
+				hop.MessageSignatureReason = utils.PtrTo(h.MessageSignatureReason)
+			}
+			hops = append(hops, hop)
+		}
+		result.Hops = &hops
+	}
+
+	if !chain.Valid() {
+		result.BrokenInstance = utils.PtrTo(chain.BrokenInstance)
+		if chain.BrokenDomain != "" {
+			result.BrokenDomain = utils.PtrTo(chain.BrokenDomain)
+		}
+		if chain.BrokenSelector != "" {
+			result.BrokenSelector = utils.PtrTo(chain.BrokenSelector)
+		}
+	}
+}
+
 // validateARCChain validates the ARC chain for completeness
 // Each instance should have all three headers with matching instance numbers
 func (a *AuthenticationAnalyzer) validateARCChain(arcAuthResults, arcMessageSig, arcSeal []string) bool {
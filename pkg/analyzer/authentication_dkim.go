@@ -22,8 +22,11 @@
 package analyzer
 
 import (
+	"context"
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/utils"
@@ -85,3 +88,117 @@ func (a *AuthenticationAnalyzer) calculateDKIMScore(results *model.Authenticatio
 
 	return 0
 }
+
+// dkimMessageVerification holds the message split for verification and the
+// verification of each of its DKIM-Signature fields, so that the report and
+// the robustness simulation share the parsed signatures and fetched keys.
+type dkimMessageVerification struct {
+	msg        *rawMessage
+	signatures []verifiedDKIMField
+}
+
+// verifiedDKIMField is the verification of the DKIM-Signature field at
+// index in the fields of the message.
+type verifiedDKIMField struct {
+	index int
+	*dkimVerification
+}
+
+// verifyDKIMMessage cryptographically verifies every DKIM-Signature of the
+// message. It returns nil when the message has none.
+func (a *AuthenticationAnalyzer) verifyDKIMMessage(email *EmailMessage) *dkimMessageVerification {
+	if len(email.RawMessage) == 0 {
+		return nil
+	}
+
+	verified := &dkimMessageVerification{msg: splitRawMessage(email.RawMessage)}
+	for idx, field := range verified.msg.Fields {
+		if !strings.EqualFold(field.Name, "DKIM-Signature") {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+		v := verifyDKIMSignature(ctx, a.resolver, verified.msg, field, time.Now())
+		cancel()
+
+		verified.signatures = append(verified.signatures, verifiedDKIMField{index: idx, dkimVerification: v})
+	}
+	if len(verified.signatures) == 0 {
+		return nil
+	}
+
+	return verified
+}
+
+// verifyDKIMSignatures reports the verification of every DKIM-Signature of
+// the message, and compares each outcome with the matching upstream result.
+func verifyDKIMSignatures(verified *dkimMessageVerification, upstream *[]model.AuthResult) []model.DKIMVerification {
+	if verified == nil {
+		return nil
+	}
+
+	var upstreamResults []model.AuthResult
+	if upstream != nil {
+		upstreamResults = *upstream
+	}
+	matched := make([]bool, len(upstreamResults))
+
+	var verifications []model.DKIMVerification
+	for _, v := range verified.signatures {
+		verification := model.DKIMVerification{
+			Domain:        v.Signature.Domain,
+			Selector:      v.Signature.Selector,
+			Result:        v.Result,
+			BodyHashValid: v.BodyHashValid,
+		}
+		if v.Signature.Algorithm != "" {
+			verification.Algorithm = utils.PtrTo(v.Signature.Algorithm)
+		}
+		if c, ok := v.Signature.Tags["c"]; ok {
+			verification.Canonicalization = utils.PtrTo(c)
+		}
+		if len(v.Signature.SignedHeaders) > 0 {
+			verification.SignedHeaders = utils.PtrTo(v.Signature.SignedHeaders)
+		}
+		if v.Reason != "" {
+			verification.Reason = utils.PtrTo(v.Reason)
+		}
+
+		// Pair with the first unmatched upstream result for the same domain and selector
+		for i, res := range upstreamResults {
+			if matched[i] || res.Domain == nil || !strings.EqualFold(*res.Domain, verification.Domain) {
+				continue
+			}
+			if res.Selector != nil && *res.Selector != verification.Selector {
+				continue
+			}
+			matched[i] = true
+			verification.UpstreamResult = utils.PtrTo(string(res.Result))
+			verification.Discrepancy = utils.PtrTo((res.Result == model.AuthResultResultPass) != (v.Result == model.DKIMVerificationResultPass))
+			break
+		}
+
+		verifications = append(verifications, verification)
+	}
+
+	return verifications
+}
+
+// dkimResultsFromVerifications builds DKIM results out of our own signature
+// verifications, for messages without an upstream DKIM verdict.
+func dkimResultsFromVerifications(verifications []model.DKIMVerification) *[]model.AuthResult {
+	results := make([]model.AuthResult, 0, len(verifications))
+	for _, v := range verifications {
+		details := "verified by happyDeliver"
+		if v.Reason != nil {
+			details = fmt.Sprintf("verified by happyDeliver: %s", *v.Reason)
+		}
+		results = append(results, model.AuthResult{
+			Result:   model.AuthResultResult(v.Result),
+			Domain:   utils.PtrTo(v.Domain),
+			Selector: utils.PtrTo(v.Selector),
+			Details:  utils.PtrTo(details),
+		})
+	}
+	return &results
+}
@@ -0,0 +1,209 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+const (
+	// dkimMinExpiry is the shortest signature lifetime that still leaves
+	// room for queued and retried deliveries.
+	dkimMinExpiry = 24 * time.Hour
+
+	// dkimClockSkew is the tolerated difference between the signer's clock and ours.
+	dkimClockSkew = 5 * time.Minute
+)
+
+// dkimOversignedHeaders are the header fields that should be oversigned,
+// so that another instance cannot be added without breaking the signature.
+var dkimOversignedHeaders = []string{"from", "to", "subject"}
+
+// auditDKIMSignatures looks for replay and weak-coverage risks in the tags
+// of every DKIM-Signature of the message.
+func (a *AuthenticationAnalyzer) auditDKIMSignatures(email *EmailMessage) []model.DKIMSignatureAudit {
+	headerCounts := map[string]int{}
+	for _, name := range dkimOversignedHeaders {
+		headerCounts[name] = len(email.Header[textprotoCanonical(name)])
+	}
+
+	var audits []model.DKIMSignatureAudit
+	for _, value := range email.Header[textprotoCanonical("DKIM-Signature")] {
+		sig, err := parseDKIMSignature(value)
+		if sig.Domain == "" {
+			continue
+		}
+		audit := model.DKIMSignatureAudit{
+			Domain:   sig.Domain,
+			Selector: sig.Selector,
+		}
+		if err != nil {
+			// The tags after the faulty one may not have been parsed: the
+			// signature can't be audited any further
+			audit.Issues = []model.DKIMSignatureIssue{malformedDKIMSignatureIssue(err)}
+		} else {
+			audit.Issues = auditDKIMSignature(sig, headerCounts, time.Now())
+		}
+		audits = append(audits, audit)
+	}
+
+	return audits
+}
+
+// malformedDKIMSignatureIssue reports a signature rejected when parsing it.
+func malformedDKIMSignatureIssue(err error) model.DKIMSignatureIssue {
+	return model.DKIMSignatureIssue{
+		Type:     model.DKIMSignatureIssueTypeMalformed,
+		Severity: model.DKIMSignatureIssueSeverityHigh,
+		Message:  fmt.Sprintf("Signature is malformed (%s): verifiers ignore it", err),
+		Advice:   utils.PtrTo("Check the configuration of your DKIM signer: the signature must follow RFC 6376"),
+	}
+}
+
+// auditDKIMSignature checks the tags of one signature. headerCounts gives
+// how many times each of dkimOversignedHeaders appears in the message.
+func auditDKIMSignature(sig *dkimSignature, headerCounts map[string]int, now time.Time) []model.DKIMSignatureIssue {
+	issues := []model.DKIMSignatureIssue{}
+	add := func(issueType model.DKIMSignatureIssueType, severity model.DKIMSignatureIssueSeverity, message, advice string) {
+		issues = append(issues, model.DKIMSignatureIssue{
+			Type:     issueType,
+			Severity: severity,
+			Message:  message,
+			Advice:   utils.PtrTo(advice),
+		})
+	}
+
+	if sig.BodyLength >= 0 {
+		add(model.DKIMSignatureIssueTypeBodyLength, model.DKIMSignatureIssueSeverityHigh,
+			fmt.Sprintf("Signature only covers the first %d bytes of the body (l=%d): content appended afterwards still verifies", sig.BodyLength, sig.BodyLength),
+			"Remove the l= tag from your DKIM signer configuration so that the whole body is signed")
+	}
+
+	signedCounts := map[string]int{}
+	for _, h := range sig.SignedHeaders {
+		signedCounts[h]++
+	}
+
+	if signedCounts["from"] == 0 {
+		add(model.DKIMSignatureIssueTypeFromNotSigned, model.DKIMSignatureIssueSeverityCritical,
+			"The From header is not signed (missing from h=): the signature does not vouch for the visible sender",
+			"Add from to the list of signed headers (h=); RFC 6376 requires it")
+	}
+
+	var unsigned, notOversigned []string
+	for _, name := range dkimOversignedHeaders {
+		switch {
+		case signedCounts[name] == 0:
+			if name != "from" && headerCounts[name] > 0 {
+				unsigned = append(unsigned, textprotoCanonical(name))
+			}
+		case signedCounts[name] <= headerCounts[name]:
+			notOversigned = append(notOversigned, textprotoCanonical(name))
+		}
+	}
+	if len(unsigned) > 0 {
+		add(model.DKIMSignatureIssueTypeWeakCoverage, model.DKIMSignatureIssueSeverityMedium,
+			fmt.Sprintf("%s not signed: it can be changed without breaking the signature", strings.Join(unsigned, " and ")+pluralVerb(len(unsigned))),
+			"Sign at least From, To, Subject, Date, Message-ID, Reply-To and the MIME headers")
+	}
+	if len(notOversigned) > 0 {
+		add(model.DKIMSignatureIssueTypeNotOversigned, model.DKIMSignatureIssueSeverityMedium,
+			fmt.Sprintf("%s not oversigned: another instance can be added to a replayed message without breaking the signature", strings.Join(notOversigned, ", ")+pluralVerb(len(notOversigned))),
+			"List these headers in h= one more time than they appear in the message (e.g. OversignHeaders in OpenDKIM, sign_headers with (o) prefix in Rspamd)")
+	}
+
+	if sig.Expiration != 0 {
+		expiry := time.Unix(sig.Expiration, 0)
+		switch {
+		case now.After(expiry):
+			add(model.DKIMSignatureIssueTypeExpired, model.DKIMSignatureIssueSeverityHigh,
+				fmt.Sprintf("Signature expired on %s (x=%d)", expiry.UTC().Format(time.RFC1123), sig.Expiration),
+				"Check the expiration delay of your DKIM signer and the clock of the signing host")
+		case sig.Timestamp != 0 && expiry.Sub(time.Unix(sig.Timestamp, 0)) < dkimMinExpiry:
+			add(model.DKIMSignatureIssueTypeShortExpiry, model.DKIMSignatureIssueSeverityLow,
+				fmt.Sprintf("Signature is only valid for %s (x=%d): delayed or retried deliveries will fail", expiry.Sub(time.Unix(sig.Timestamp, 0)), sig.Expiration),
+				"Use an expiration of a few days, so that queued messages still verify while replays stay time-limited")
+		}
+	} else {
+		add(model.DKIMSignatureIssueTypeNoExpiry, model.DKIMSignatureIssueSeverityInfo,
+			"Signature has no expiration (no x= tag): a captured message can be replayed indefinitely",
+			"Set an expiration of a few days (x=) to limit the window for DKIM replay")
+	}
+
+	if sig.Timestamp != 0 && time.Unix(sig.Timestamp, 0).After(now.Add(dkimClockSkew)) {
+		add(model.DKIMSignatureIssueTypeFutureTimestamp, model.DKIMSignatureIssueSeverityMedium,
+			fmt.Sprintf("Signature timestamp is in the future (t=%d)", sig.Timestamp),
+			"Synchronize the clock of the signing host with NTP")
+	}
+
+	if sig.HashAlgorithm() == "sha1" {
+		add(model.DKIMSignatureIssueTypeWeakAlgorithm, model.DKIMSignatureIssueSeverityHigh,
+			fmt.Sprintf("Signature uses %s, which verifiers must no longer accept (RFC 8301)", sig.Algorithm),
+			"Sign with rsa-sha256 (and optionally ed25519-sha256 as a second signature)")
+	}
+
+	if sig.HeaderCanon == "simple" && sig.BodyCanon == "simple" {
+		add(model.DKIMSignatureIssueTypeFragileCanonicalization, model.DKIMSignatureIssueSeverityLow,
+			"Signature uses simple/simple canonicalization: any whitespace change in transit breaks it",
+			"Use c=relaxed/relaxed")
+	}
+
+	return issues
+}
+
+// pluralVerb returns " is" or " are" based on count
+func pluralVerb(count int) string {
+	if count == 1 {
+		return " is"
+	}
+	return " are"
+}
+
+// calculateDKIMAuditScore returns a penalty, from 0 to -100, for the risks
+// found in the DKIM signatures.
+func (a *AuthenticationAnalyzer) calculateDKIMAuditScore(results *model.AuthenticationResults) (score int) {
+	if results.DkimAudits == nil {
+		return 0
+	}
+
+	for _, audit := range *results.DkimAudits {
+		for _, issue := range audit.Issues {
+			switch issue.Severity {
+			case model.DKIMSignatureIssueSeverityCritical:
+				score -= 100
+			case model.DKIMSignatureIssueSeverityHigh:
+				score -= 50
+			case model.DKIMSignatureIssueSeverityMedium:
+				score -= 25
+			case model.DKIMSignatureIssueSeverityLow:
+				score -= 10
+			}
+		}
+	}
+
+	return max(score, -100)
+}
@@ -0,0 +1,154 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"net/mail"
+	"slices"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestAuditDKIMSignature(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	counts := map[string]int{"from": 1, "to": 1, "subject": 1}
+
+	tests := []struct {
+		name      string
+		signature string
+		want      []model.DKIMSignatureIssueType
+	}{
+		{
+			name:      "well configured signature",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; t=1759990000; x=1760590000; h=from:from:to:to:subject:subject:date; bh=AAAA; b=AAAA",
+		},
+		{
+			name:      "body length limit",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; l=120; x=1760590000; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeBodyLength},
+		},
+		{
+			name:      "From not signed",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; x=1760590000; h=to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeFromNotSigned},
+		},
+		{
+			name:      "not oversigned and Subject not signed",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; x=1760590000; h=from:to; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeWeakCoverage, model.DKIMSignatureIssueTypeNotOversigned},
+		},
+		{
+			name:      "expired",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; t=1750000000; x=1750600000; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeExpired},
+		},
+		{
+			name:      "short expiry",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; t=1759999000; x=1760002600; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeShortExpiry},
+		},
+		{
+			name:      "no expiry",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeNoExpiry},
+		},
+		{
+			name:      "timestamp in the future",
+			signature: "v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=sel; t=1760100000; x=1760900000; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeFutureTimestamp},
+		},
+		{
+			name:      "rsa-sha1 with default canonicalization",
+			signature: "v=1; a=rsa-sha1; d=example.com; s=sel; x=1760590000; h=from:from:to:to:subject:subject; bh=AAAA; b=AAAA",
+			want:      []model.DKIMSignatureIssueType{model.DKIMSignatureIssueTypeWeakAlgorithm, model.DKIMSignatureIssueTypeFragileCanonicalization},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := parseDKIMSignature(tt.signature)
+			if err != nil {
+				t.Fatalf("parseDKIMSignature: %v", err)
+			}
+
+			var got []model.DKIMSignatureIssueType
+			for _, issue := range auditDKIMSignature(sig, counts, now) {
+				got = append(got, issue.Type)
+				if issue.Advice == nil || *issue.Advice == "" {
+					t.Errorf("issue %s has no advice", issue.Type)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("issues = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditDKIMSignaturesMalformed(t *testing.T) {
+	analyzer := NewAuthenticationAnalyzer("")
+
+	for _, signature := range []string{
+		"v=1; a=rsa-md5; c=relaxed/relaxed; d=example.com; s=sel; h=to; bh=AAAA; b=AAAA",
+		"v=1; a=rsa-sha256; c=loose/relaxed; d=example.com; s=sel; h=to; bh=AAAA; b=AAAA",
+	} {
+		email := &EmailMessage{Header: mail.Header{"Dkim-Signature": {signature}}}
+		audits := analyzer.auditDKIMSignatures(email)
+		if len(audits) != 1 || len(audits[0].Issues) != 1 || audits[0].Issues[0].Type != model.DKIMSignatureIssueTypeMalformed {
+			t.Errorf("auditDKIMSignatures(%q) = %+v, want a single malformed issue", signature, audits)
+		}
+	}
+}
+
+func TestCalculateDKIMAuditScore(t *testing.T) {
+	analyzer := NewAuthenticationAnalyzer("")
+
+	audit := func(severities ...model.DKIMSignatureIssueSeverity) *[]model.DKIMSignatureAudit {
+		var issues []model.DKIMSignatureIssue
+		for _, s := range severities {
+			issues = append(issues, model.DKIMSignatureIssue{Severity: s})
+		}
+		return &[]model.DKIMSignatureAudit{{Domain: "example.com", Selector: "sel", Issues: issues}}
+	}
+
+	tests := []struct {
+		name   string
+		audits *[]model.DKIMSignatureAudit
+		want   int
+	}{
+		{"no signature", nil, 0},
+		{"informational only", audit(model.DKIMSignatureIssueSeverityInfo), 0},
+		{"high and low", audit(model.DKIMSignatureIssueSeverityHigh, model.DKIMSignatureIssueSeverityLow), -60},
+		{"capped", audit(model.DKIMSignatureIssueSeverityCritical, model.DKIMSignatureIssueSeverityHigh), -100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := &model.AuthenticationResults{DkimAudits: tt.audits}
+			if got := analyzer.calculateDKIMAuditScore(results); got != tt.want {
+				t.Errorf("calculateDKIMAuditScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
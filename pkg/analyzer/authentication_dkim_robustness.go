@@ -0,0 +1,186 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"mime/quotedprintable"
+	"slices"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// dkimMutation simulates a modification commonly made to messages in
+// transit. apply modifies a copy of the message in place, and returns a
+// reason when the modification would not happen to this message.
+type dkimMutation struct {
+	scenario model.DKIMRobustnessScenarioScenario
+	apply    func(msg *rawMessage) (notApplicable string)
+}
+
+var dkimMutations = []dkimMutation{
+	{model.DKIMRobustnessScenarioScenarioMailingListFooter, appendListFooter},
+	{model.DKIMRobustnessScenarioScenarioSubjectTag, addSubjectTag},
+	{model.DKIMRobustnessScenarioScenarioWhitespaceRewrap, rewrapWhitespace},
+	{model.DKIMRobustnessScenarioScenarioTransferEncoding, reencodeQuotedPrintable},
+}
+
+// simulateDKIMRobustness re-verifies each valid DKIM signature against
+// modified copies of the message, to tell which forwarding scenarios would
+// break it. The signatures and keys of the first verification are reused.
+func (a *AuthenticationAnalyzer) simulateDKIMRobustness(verified *dkimMessageVerification) []model.DKIMRobustness {
+	if verified == nil {
+		return nil
+	}
+	msg := verified.msg
+
+	var results []model.DKIMRobustness
+	for _, v := range verified.signatures {
+		// Only a signature that verifies as received can break in transit
+		if v.Result != model.DKIMVerificationResultPass {
+			continue
+		}
+
+		robustness := model.DKIMRobustness{
+			Domain:    v.Signature.Domain,
+			Selector:  v.Signature.Selector,
+			Scenarios: make([]model.DKIMRobustnessScenario, 0, len(dkimMutations)),
+		}
+
+		for _, mutation := range dkimMutations {
+			scenario := model.DKIMRobustnessScenario{Scenario: mutation.scenario}
+
+			mutated := msg.clone()
+			if reason := mutation.apply(mutated); reason != "" {
+				scenario.Result = model.DKIMRobustnessScenarioResultNotApplicable
+				scenario.Reason = utils.PtrTo(reason)
+			} else {
+				// Mutations keep the fields in place, so the index still designates the signature
+				mv := verifySignedMessage(context.Background(), a.resolver, mutated, mutated.Fields[v.index], &dkimVerification{Signature: v.Signature, Key: v.Key})
+				if mv.Result == model.DKIMVerificationResultPass {
+					scenario.Result = model.DKIMRobustnessScenarioResultSurvives
+				} else {
+					scenario.Result = model.DKIMRobustnessScenarioResultBreaks
+					scenario.Reason = utils.PtrTo(mv.Reason)
+				}
+			}
+
+			robustness.Scenarios = append(robustness.Scenarios, scenario)
+		}
+
+		results = append(results, robustness)
+	}
+
+	return results
+}
+
+// clone returns a deep copy of the message.
+func (m *rawMessage) clone() *rawMessage {
+	return &rawMessage{
+		Fields: slices.Clone(m.Fields),
+		Body:   bytes.Clone(m.Body),
+	}
+}
+
+// appendListFooter appends a footer to the body, as mailing list managers do.
+func appendListFooter(msg *rawMessage) string {
+	if len(msg.Body) > 0 && !bytes.HasSuffix(msg.Body, []byte("\r\n")) {
+		msg.Body = append(msg.Body, "\r\n"...)
+	}
+	msg.Body = append(msg.Body, "_______________________________________________\r\nList mailing list\r\nhttps://lists.example.org/listinfo/list\r\n"...)
+	return ""
+}
+
+// addSubjectTag prefixes the Subject with a list tag, adding a Subject
+// field when there is none.
+func addSubjectTag(msg *rawMessage) string {
+	for i, f := range msg.Fields {
+		if strings.EqualFold(f.Name, "Subject") {
+			name, value, _ := strings.Cut(f.Raw, ":")
+			msg.Fields[i].Raw = name + ": [list]" + value
+			return ""
+		}
+	}
+	msg.Fields = append(msg.Fields, rawHeaderField{Name: "Subject", Raw: "Subject: [list]"})
+	return ""
+}
+
+// rewrapWhitespace changes whitespace the way some MTAs do: folded header
+// fields are unfolded, long ones are folded, and trailing whitespace is
+// stripped from body lines.
+func rewrapWhitespace(msg *rawMessage) string {
+	for i, f := range msg.Fields {
+		if strings.Contains(f.Raw, "\r\n") {
+			msg.Fields[i].Raw = strings.ReplaceAll(f.Raw, "\r\n", "")
+		} else if len(f.Raw) > 78 {
+			if idx := strings.LastIndexAny(f.Raw[:78], " \t"); idx > len(f.Name)+1 {
+				msg.Fields[i].Raw = f.Raw[:idx] + "\r\n" + f.Raw[idx:]
+			}
+		}
+	}
+
+	lines := bytes.Split(msg.Body, []byte("\r\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t")
+	}
+	msg.Body = bytes.Join(lines, []byte("\r\n"))
+	return ""
+}
+
+// reencodeQuotedPrintable converts an 8-bit body to quoted-printable, as
+// relays do when the next hop does not support 8BITMIME.
+func reencodeQuotedPrintable(msg *rawMessage) string {
+	if !slices.ContainsFunc(msg.Body, func(c byte) bool { return c >= 0x80 }) {
+		return "message is 7-bit clean, relays have nothing to re-encode"
+	}
+
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write(msg.Body)
+	w.Close()
+	msg.Body = bytes.ReplaceAll(bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+
+	// A single part message announces its encoding in its own header
+	multipart := false
+	for _, f := range msg.Fields {
+		if strings.EqualFold(f.Name, "Content-Type") && strings.Contains(strings.ToLower(f.Value()), "multipart/") {
+			multipart = true
+		}
+	}
+	if !multipart {
+		encodingSet := false
+		for i, f := range msg.Fields {
+			if strings.EqualFold(f.Name, "Content-Transfer-Encoding") {
+				msg.Fields[i].Raw = f.Name + ": quoted-printable"
+				encodingSet = true
+			}
+		}
+		if !encodingSet {
+			msg.Fields = append(msg.Fields, rawHeaderField{Name: "Content-Transfer-Encoding", Raw: "Content-Transfer-Encoding: quoted-printable"})
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,120 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestSimulateDKIMRobustness(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &countingResolver{spfMockResolver: spfMockResolver{
+		txt: map[string][]string{"sel._domainkey.example.com": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}},
+	}}
+	analyzer := NewAuthenticationAnalyzerWithResolver("", time.Second, resolver)
+
+	eightBit := strings.Replace(testDKIMMessage, "Hi Bob,", "Salut Bob, ça va ?", 1)
+	bodyLength := len(canonicalizeBody(splitRawMessage([]byte(testDKIMMessage)).Body, "relaxed"))
+
+	const (
+		survives      = model.DKIMRobustnessScenarioResultSurvives
+		breaks        = model.DKIMRobustnessScenarioResultBreaks
+		notApplicable = model.DKIMRobustnessScenarioResultNotApplicable
+	)
+
+	tests := []struct {
+		name    string
+		message string
+		// Expected results for footer, subject tag, whitespace and transfer encoding
+		want []model.DKIMRobustnessScenarioResult
+	}{
+		{
+			name:    "relaxed/relaxed",
+			message: signTestMessage(t, testDKIMMessage, key, "ed25519-sha256", "relaxed/relaxed", []string{"from", "subject"}, ""),
+			want:    []model.DKIMRobustnessScenarioResult{breaks, breaks, survives, notApplicable},
+		},
+		{
+			name:    "simple/simple",
+			message: signTestMessage(t, testDKIMMessage, key, "ed25519-sha256", "simple/simple", []string{"from", "subject"}, ""),
+			want:    []model.DKIMRobustnessScenarioResult{breaks, breaks, breaks, notApplicable},
+		},
+		{
+			name:    "body length limit and unsigned Subject",
+			message: signTestMessage(t, testDKIMMessage, key, "ed25519-sha256", "relaxed/relaxed", []string{"from"}, fmt.Sprintf(" l=%d;", bodyLength)),
+			want:    []model.DKIMRobustnessScenarioResult{survives, survives, survives, notApplicable},
+		},
+		{
+			name:    "8-bit body",
+			message: signTestMessage(t, eightBit, key, "ed25519-sha256", "relaxed/relaxed", []string{"from", "subject"}, ""),
+			want:    []model.DKIMRobustnessScenarioResult{breaks, breaks, survives, breaks},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := ParseEmail(bytes.NewBufferString(tt.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resolver.calls.Store(0)
+			results := analyzer.simulateDKIMRobustness(analyzer.verifyDKIMMessage(email))
+			if len(results) != 1 {
+				t.Fatalf("expected one signature, got %d", len(results))
+			}
+			if calls := resolver.calls.Load(); calls != 1 {
+				t.Errorf("the key was looked up %d times, want it reused from the verification", calls)
+			}
+			if len(results[0].Scenarios) != len(tt.want) {
+				t.Fatalf("got %d scenarios, want %d", len(results[0].Scenarios), len(tt.want))
+			}
+			for i, scenario := range results[0].Scenarios {
+				if scenario.Result != tt.want[i] {
+					t.Errorf("%s: result = %s, want %s (%v)", scenario.Scenario, scenario.Result, tt.want[i], scenario.Reason)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid signature is not simulated", func(t *testing.T) {
+		signed := signTestMessage(t, testDKIMMessage, key, "ed25519-sha256", "relaxed/relaxed", []string{"from"}, "")
+		email, err := ParseEmail(bytes.NewBufferString(strings.Replace(signed, "Hi Bob", "Hi Eve", 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results := analyzer.simulateDKIMRobustness(analyzer.verifyDKIMMessage(email)); len(results) != 0 {
+			t.Errorf("expected no simulation, got %v", results)
+		}
+	})
+}
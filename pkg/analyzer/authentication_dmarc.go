@@ -22,6 +22,7 @@
 package analyzer
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -53,6 +54,158 @@ func (a *AuthenticationAnalyzer) parseDMARCResult(part string) *model.AuthResult
 	return result
 }
 
+// dmarcAligned reports whether an authenticated domain is aligned with the
+// From domain, in strict (exact match) or relaxed (same organizational domain)
+// mode.
+func dmarcAligned(authDomain, fromDomain string, strict bool) bool {
+	authDomain = strings.ToLower(strings.TrimSuffix(authDomain, "."))
+	fromDomain = strings.ToLower(strings.TrimSuffix(fromDomain, "."))
+	if authDomain == "" || fromDomain == "" {
+		return false
+	}
+	if authDomain == fromDomain {
+		return true
+	}
+	return !strict && getOrganizationalDomain(authDomain) == getOrganizationalDomain(fromDomain)
+}
+
+// EvaluateDMARC computes the DMARC result from happyDeliver's own SPF and DKIM
+// results (falling back to the upstream ones when unavailable), identifier
+// alignment and the DMARC record found in DNS. The computed result is compared
+// with the upstream verdict, and takes its place when there is none.
+func (a *AuthenticationAnalyzer) EvaluateDMARC(results *model.AuthenticationResults, dns *model.DNSResults) {
+	if results == nil || dns == nil || dns.FromDomain == "" {
+		return
+	}
+
+	evaluation := &model.DMARCEvaluation{
+		Result:      model.DMARCEvaluationResultNone,
+		FromDomain:  dns.FromDomain,
+		Disposition: model.DMARCEvaluationDispositionNone,
+	}
+
+	// SPF identity: our own evaluation first, then the upstream result
+	spfPass := false
+	if dns.SpfEvaluation != nil {
+		evaluation.SpfDomain = utils.PtrTo(dns.SpfEvaluation.Domain)
+		spfPass = dns.SpfEvaluation.Result == model.SPFEvaluationResultPass
+	} else if results.Spf != nil && results.Spf.Domain != nil {
+		evaluation.SpfDomain = results.Spf.Domain
+		spfPass = results.Spf.Result == model.AuthResultResultPass
+	}
+
+	// Valid DKIM signatures: our own verification first, then the upstream results
+	var dkimDomains []string
+	if results.DkimVerifications != nil {
+		for _, v := range *results.DkimVerifications {
+			if v.Result == model.DKIMVerificationResultPass {
+				dkimDomains = append(dkimDomains, v.Domain)
+			}
+		}
+	} else if results.Dkim != nil {
+		for _, d := range *results.Dkim {
+			if d.Result == model.AuthResultResultPass && d.Domain != nil {
+				dkimDomains = append(dkimDomains, *d.Domain)
+			}
+		}
+	}
+
+	record := dns.DmarcRecord
+	if record == nil || !record.Valid || record.Domain == nil {
+		evaluation.Details = utils.PtrTo("No valid DMARC record applies to the From domain")
+		if record != nil && record.Error != nil {
+			evaluation.Details = record.Error
+		}
+	} else {
+		evaluation.PolicyDomain = record.Domain
+
+		strictSPF := record.SpfAlignment != nil && *record.SpfAlignment == model.DMARCRecordSpfAlignmentStrict
+		strictDKIM := record.DkimAlignment != nil && *record.DkimAlignment == model.DMARCRecordDkimAlignmentStrict
+
+		evaluation.SpfAligned = spfPass && evaluation.SpfDomain != nil && dmarcAligned(*evaluation.SpfDomain, dns.FromDomain, strictSPF)
+		for _, domain := range dkimDomains {
+			if dmarcAligned(domain, dns.FromDomain, strictDKIM) {
+				evaluation.DkimAligned = true
+				evaluation.DkimDomain = utils.PtrTo(domain)
+				break
+			}
+		}
+		if evaluation.DkimDomain == nil && len(dkimDomains) > 0 {
+			evaluation.DkimDomain = utils.PtrTo(dkimDomains[0])
+		}
+
+		// Requested policy: p= for the domain owning the record, sp= for its
+		// subdomains, np= for subdomains that do not exist
+		policy, source := "none", model.DMARCEvaluationPolicySourceP
+		if record.Policy != nil && *record.Policy != model.DMARCRecordPolicyUnknown {
+			policy = string(*record.Policy)
+		}
+		if !strings.EqualFold(dns.FromDomain, *record.Domain) {
+			if record.SubdomainPolicy != nil && *record.SubdomainPolicy != model.DMARCRecordSubdomainPolicyUnknown {
+				policy, source = string(*record.SubdomainPolicy), model.DMARCEvaluationPolicySourceSp
+			}
+			if record.NonexistentSubdomainPolicy != nil && *record.NonexistentSubdomainPolicy != model.DMARCRecordNonexistentSubdomainPolicyUnknown && fromDomainNonexistent(dns) {
+				policy, source = string(*record.NonexistentSubdomainPolicy), model.DMARCEvaluationPolicySourceNp
+			}
+		}
+		evaluation.Policy = utils.PtrTo(model.DMARCEvaluationPolicy(policy))
+		evaluation.PolicySource = utils.PtrTo(source)
+
+		testMode := record.TestMode != nil && *record.TestMode
+		if testMode {
+			evaluation.TestMode = utils.PtrTo(true)
+		}
+
+		switch {
+		case evaluation.DkimAligned && evaluation.SpfAligned:
+			evaluation.Result = model.DMARCEvaluationResultPass
+			evaluation.Details = utils.PtrTo(fmt.Sprintf("Both SPF (%s) and DKIM (%s) are aligned with %s", *evaluation.SpfDomain, *evaluation.DkimDomain, dns.FromDomain))
+		case evaluation.DkimAligned:
+			evaluation.Result = model.DMARCEvaluationResultPass
+			evaluation.Details = utils.PtrTo(fmt.Sprintf("DKIM signature from %s is aligned with %s", *evaluation.DkimDomain, dns.FromDomain))
+		case evaluation.SpfAligned:
+			evaluation.Result = model.DMARCEvaluationResultPass
+			evaluation.Details = utils.PtrTo(fmt.Sprintf("SPF pass for %s is aligned with %s", *evaluation.SpfDomain, dns.FromDomain))
+		default:
+			evaluation.Result = model.DMARCEvaluationResultFail
+			evaluation.Disposition = model.DMARCEvaluationDisposition(policy)
+			if testMode {
+				// t=y: receivers apply the next lower policy level
+				switch policy {
+				case "reject":
+					evaluation.Disposition = model.DMARCEvaluationDispositionQuarantine
+				case "quarantine":
+					evaluation.Disposition = model.DMARCEvaluationDispositionNone
+				}
+			}
+			evaluation.Details = utils.PtrTo(fmt.Sprintf("Neither SPF nor DKIM produced a passing result aligned with %s", dns.FromDomain))
+		}
+	}
+
+	if results.Dmarc != nil {
+		evaluation.UpstreamResult = utils.PtrTo(string(results.Dmarc.Result))
+		evaluation.Discrepancy = utils.PtrTo((results.Dmarc.Result == model.AuthResultResultPass) != (evaluation.Result == model.DMARCEvaluationResultPass))
+	} else {
+		results.Dmarc = &model.AuthResult{
+			Result:  model.AuthResultResult(evaluation.Result),
+			Domain:  utils.PtrTo(dns.FromDomain),
+			Details: utils.PtrTo(fmt.Sprintf("evaluated by happyDeliver: %s", *evaluation.Details)),
+		}
+	}
+
+	results.DmarcEvaluation = evaluation
+}
+
+// fromDomainNonexistent approximates the DMARCbis "non-existent domain" test
+// with the ReturnOK lookups: the From domain has neither MX nor address records.
+func fromDomainNonexistent(dns *model.DNSResults) bool {
+	if dns.ReturnOk == nil || dns.ReturnOk.From == nil {
+		return false
+	}
+	from := dns.ReturnOk.From
+	return from.OrgDomain != nil || from.Status == returnOKStatusFail
+}
+
 func (a *AuthenticationAnalyzer) calculateDMARCScore(results *model.AuthenticationResults) (score int) {
 	if results.Dmarc != nil {
 		switch results.Dmarc.Result {
@@ -25,6 +25,7 @@ import (
 	"testing"
 
 	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
 )
 
 func TestParseDMARCResult(t *testing.T) {
@@ -67,3 +68,182 @@ func TestParseDMARCResult(t *testing.T) {
 		})
 	}
 }
+
+func TestDMARCAligned(t *testing.T) {
+	tests := []struct {
+		auth, from string
+		strict     bool
+		want       bool
+	}{
+		{"example.com", "example.com", true, true},
+		{"mail.example.com", "example.com", false, true},
+		{"mail.example.com", "example.com", true, false},
+		{"example.co.uk", "other.co.uk", false, false},
+		{"news.example.co.uk", "example.co.uk", false, true},
+		{"example.net", "example.com", false, false},
+		{"", "example.com", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := dmarcAligned(tt.auth, tt.from, tt.strict); got != tt.want {
+			t.Errorf("dmarcAligned(%q, %q, %v) = %v, want %v", tt.auth, tt.from, tt.strict, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateDMARC(t *testing.T) {
+	record := func(policy string, modify func(*model.DMARCRecord)) *model.DMARCRecord {
+		rec := &model.DMARCRecord{
+			Domain:        utils.PtrTo("example.com"),
+			Policy:        utils.PtrTo(model.DMARCRecordPolicy(policy)),
+			SpfAlignment:  utils.PtrTo(model.DMARCRecordSpfAlignmentRelaxed),
+			DkimAlignment: utils.PtrTo(model.DMARCRecordDkimAlignmentRelaxed),
+			Valid:         true,
+		}
+		if modify != nil {
+			modify(rec)
+		}
+		return rec
+	}
+	spfEval := func(domain string, result model.SPFEvaluationResult) *model.SPFEvaluation {
+		return &model.SPFEvaluation{Domain: domain, Result: result, Ip: "192.0.2.1"}
+	}
+	dkimPass := func(domain string) *[]model.DKIMVerification {
+		return &[]model.DKIMVerification{{Domain: domain, Selector: "s", Result: model.DKIMVerificationResultPass}}
+	}
+
+	tests := []struct {
+		name            string
+		fromDomain      string
+		auth            *model.AuthenticationResults
+		dns             *model.DNSResults
+		wantResult      model.DMARCEvaluationResult
+		wantDisposition model.DMARCEvaluationDisposition
+		wantSource      model.DMARCEvaluationPolicySource
+		wantDiscrepancy *bool
+	}{
+		{
+			name:            "aligned DKIM passes",
+			fromDomain:      "example.com",
+			auth:            &model.AuthenticationResults{DkimVerifications: dkimPass("example.com")},
+			dns:             &model.DNSResults{DmarcRecord: record("reject", nil)},
+			wantResult:      model.DMARCEvaluationResultPass,
+			wantDisposition: model.DMARCEvaluationDispositionNone,
+		},
+		{
+			name:            "relaxed SPF alignment on a bounce subdomain",
+			fromDomain:      "example.com",
+			auth:            &model.AuthenticationResults{},
+			dns:             &model.DNSResults{DmarcRecord: record("reject", nil), SpfEvaluation: spfEval("bounces.example.com", model.SPFEvaluationResultPass)},
+			wantResult:      model.DMARCEvaluationResultPass,
+			wantDisposition: model.DMARCEvaluationDispositionNone,
+		},
+		{
+			name:       "strict SPF alignment on a bounce subdomain fails",
+			fromDomain: "example.com",
+			auth:       &model.AuthenticationResults{},
+			dns: &model.DNSResults{
+				DmarcRecord: record("quarantine", func(r *model.DMARCRecord) {
+					r.SpfAlignment = utils.PtrTo(model.DMARCRecordSpfAlignmentStrict)
+				}),
+				SpfEvaluation: spfEval("bounces.example.com", model.SPFEvaluationResultPass),
+			},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionQuarantine,
+		},
+		{
+			name:            "unaligned DKIM from an ESP fails",
+			fromDomain:      "example.com",
+			auth:            &model.AuthenticationResults{DkimVerifications: dkimPass("esp.example.net")},
+			dns:             &model.DNSResults{DmarcRecord: record("reject", nil)},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionReject,
+		},
+		{
+			name:       "testing mode lowers the disposition",
+			fromDomain: "example.com",
+			auth:       &model.AuthenticationResults{},
+			dns: &model.DNSResults{DmarcRecord: record("reject", func(r *model.DMARCRecord) {
+				r.TestMode = utils.PtrTo(true)
+			})},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionQuarantine,
+		},
+		{
+			name:       "subdomain uses sp=",
+			fromDomain: "news.example.com",
+			auth:       &model.AuthenticationResults{},
+			dns: &model.DNSResults{DmarcRecord: record("reject", func(r *model.DMARCRecord) {
+				r.SubdomainPolicy = utils.PtrTo(model.DMARCRecordSubdomainPolicyNone)
+			})},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionNone,
+			wantSource:      model.DMARCEvaluationPolicySourceSp,
+		},
+		{
+			name:       "non-existent subdomain uses np=",
+			fromDomain: "ghost.example.com",
+			auth:       &model.AuthenticationResults{},
+			dns: &model.DNSResults{
+				DmarcRecord: record("none", func(r *model.DMARCRecord) {
+					r.NonexistentSubdomainPolicy = utils.PtrTo(model.DMARCRecordNonexistentSubdomainPolicyReject)
+				}),
+				ReturnOk: &model.ReturnOK{From: &model.ReturnOKDomain{Domain: "ghost.example.com", Status: returnOKStatusFail}},
+			},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionReject,
+			wantSource:      model.DMARCEvaluationPolicySourceNp,
+		},
+		{
+			name:            "no record",
+			fromDomain:      "example.com",
+			auth:            &model.AuthenticationResults{DkimVerifications: dkimPass("example.com")},
+			dns:             &model.DNSResults{DmarcRecord: &model.DMARCRecord{Valid: false, Error: utils.PtrTo("No DMARC record found")}},
+			wantResult:      model.DMARCEvaluationResultNone,
+			wantDisposition: model.DMARCEvaluationDispositionNone,
+		},
+		{
+			name:       "upstream disagrees",
+			fromDomain: "example.com",
+			auth: &model.AuthenticationResults{
+				Dmarc:             &model.AuthResult{Result: model.AuthResultResultPass},
+				DkimVerifications: &[]model.DKIMVerification{{Domain: "example.com", Selector: "s", Result: model.DKIMVerificationResultFail}},
+			},
+			dns:             &model.DNSResults{DmarcRecord: record("reject", nil)},
+			wantResult:      model.DMARCEvaluationResultFail,
+			wantDisposition: model.DMARCEvaluationDispositionReject,
+			wantDiscrepancy: utils.PtrTo(true),
+		},
+	}
+
+	analyzer := NewAuthenticationAnalyzer("")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dns.FromDomain = tt.fromDomain
+			hadUpstream := tt.auth.Dmarc != nil
+
+			analyzer.EvaluateDMARC(tt.auth, tt.dns)
+
+			eval := tt.auth.DmarcEvaluation
+			if eval == nil {
+				t.Fatal("expected a DMARC evaluation")
+			}
+			if eval.Result != tt.wantResult {
+				t.Errorf("result = %s (%v), want %s", eval.Result, eval.Details, tt.wantResult)
+			}
+			if eval.Disposition != tt.wantDisposition {
+				t.Errorf("disposition = %s, want %s", eval.Disposition, tt.wantDisposition)
+			}
+			if tt.wantSource != "" && (eval.PolicySource == nil || *eval.PolicySource != tt.wantSource) {
+				t.Errorf("policy source = %v, want %s", eval.PolicySource, tt.wantSource)
+			}
+			if tt.wantDiscrepancy != nil && (eval.Discrepancy == nil || *eval.Discrepancy != *tt.wantDiscrepancy) {
+				t.Errorf("discrepancy = %v, want %v", eval.Discrepancy, *tt.wantDiscrepancy)
+			}
+			if !hadUpstream && (tt.auth.Dmarc == nil || string(tt.auth.Dmarc.Result) != string(tt.wantResult)) {
+				t.Errorf("expected the DMARC result to fall back to the evaluation, got %v", tt.auth.Dmarc)
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@
 package analyzer
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -98,6 +99,31 @@ func (a *AuthenticationAnalyzer) parseLegacySPF(email *EmailMessage) *model.Auth
 	return result
 }
 
+// ReconcileSPF fills in the SPF result from happyDeliver's own evaluation of
+// the sender's SPF policy when neither Authentication-Results nor Received-SPF
+// provided one.
+func (a *AuthenticationAnalyzer) ReconcileSPF(results *model.AuthenticationResults, evaluation *model.SPFEvaluation) {
+	if results == nil || results.Spf != nil || evaluation == nil {
+		return
+	}
+
+	details := fmt.Sprintf("evaluated by happyDeliver for %s", evaluation.Ip)
+	if evaluation.MatchedMechanism != nil {
+		details += fmt.Sprintf(": matched %s", *evaluation.MatchedMechanism)
+		if evaluation.MatchedDomain != nil && *evaluation.MatchedDomain != evaluation.Domain {
+			details += fmt.Sprintf(" in %s", *evaluation.MatchedDomain)
+		}
+	} else if evaluation.Error != nil {
+		details += ": " + *evaluation.Error
+	}
+
+	results.Spf = &model.AuthResult{
+		Result:  model.AuthResultResult(evaluation.Result),
+		Domain:  utils.PtrTo(evaluation.Domain),
+		Details: utils.PtrTo(details),
+	}
+}
+
 func (a *AuthenticationAnalyzer) calculateSPFScore(results *model.AuthenticationResults) (score int) {
 	if results.Spf != nil {
 		switch results.Spf.Result {
@@ -0,0 +1,654 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// rawHeaderField is a header field exactly as it appears in the message:
+// name, colon and value, folding included, without the final CRLF.
+type rawHeaderField struct {
+	Name string
+	Raw  string
+}
+
+// Value returns the field body (everything after the colon), still folded.
+func (f rawHeaderField) Value() string {
+	_, value, _ := strings.Cut(f.Raw, ":")
+	return value
+}
+
+// rawMessage is a message split into ordered header fields and body, with
+// line endings normalized to CRLF as DKIM canonicalization expects.
+type rawMessage struct {
+	Fields []rawHeaderField
+	Body   []byte
+}
+
+// splitRawMessage splits a raw RFC 5322 message into its header fields, in
+// the order they appear, and its body. Lines without a colon (such as an
+// mbox "From " line) are ignored.
+func splitRawMessage(raw []byte) *rawMessage {
+	text := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n", "\r\n")
+
+	var headers, body string
+	if strings.HasPrefix(text, "\r\n") {
+		body = text[2:]
+	} else if idx := strings.Index(text, "\r\n\r\n"); idx >= 0 {
+		headers, body = text[:idx], text[idx+4:]
+	} else {
+		headers = strings.TrimSuffix(text, "\r\n")
+	}
+
+	msg := &rawMessage{Body: []byte(body)}
+	if headers == "" {
+		return msg
+	}
+
+	for _, line := range strings.Split(headers, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(msg.Fields) > 0 {
+			msg.Fields[len(msg.Fields)-1].Raw += "\r\n" + line
+			continue
+		}
+		name, _, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		msg.Fields = append(msg.Fields, rawHeaderField{Name: strings.TrimRight(name, " \t"), Raw: line})
+	}
+
+	return msg
+}
+
+// FieldsNamed returns the header fields with the given (case-insensitive) name,
+// top to bottom.
+func (m *rawMessage) FieldsNamed(name string) []rawHeaderField {
+	var fields []rawHeaderField
+	for _, f := range m.Fields {
+		if strings.EqualFold(f.Name, name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// collapseWSP reduces every run of spaces and tabs to a single space.
+func collapseWSP(s string) string {
+	var b strings.Builder
+	inWSP := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == ' ' || c == '\t' {
+			if !inWSP {
+				b.WriteByte(' ')
+			}
+			inWSP = true
+			continue
+		}
+		inWSP = false
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// canonicalizeHeader applies the "simple" or "relaxed" header
+// canonicalization algorithm (RFC 6376 section 3.4.1 and 3.4.2).
+func canonicalizeHeader(raw, canon string) string {
+	if canon != "relaxed" {
+		return raw + "\r\n"
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+	value = collapseWSP(strings.ReplaceAll(value, "\r\n", ""))
+	return name + ":" + strings.Trim(value, " ") + "\r\n"
+}
+
+// canonicalizeBody applies the "simple" or "relaxed" body canonicalization
+// algorithm (RFC 6376 section 3.4.3 and 3.4.4). The body must use CRLF line
+// endings.
+func canonicalizeBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canon == "relaxed" {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(collapseWSP(line), " ")
+		}
+	}
+
+	// Ignore all empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canon == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// dkimSignature holds the tags of a DKIM-Signature header field. The same
+// structure is used for ARC-Message-Signature and ARC-Seal, which share the
+// DKIM tag syntax.
+type dkimSignature struct {
+	Tags          map[string]string
+	Algorithm     string
+	Domain        string
+	Selector      string
+	HeaderCanon   string
+	BodyCanon     string
+	SignedHeaders []string
+	BodyHash      []byte
+	Signature     []byte
+	BodyLength    int64 // -1 when the signature has no l= tag
+	Timestamp     int64 // 0 when the signature has no t= tag
+	Expiration    int64 // 0 when the signature has no x= tag
+}
+
+// KeyType returns the key type part of the signing algorithm (e.g. "rsa").
+func (s *dkimSignature) KeyType() string {
+	keyType, _, _ := strings.Cut(s.Algorithm, "-")
+	return keyType
+}
+
+// HashAlgorithm returns the hash part of the signing algorithm (e.g. "sha256").
+func (s *dkimSignature) HashAlgorithm() string {
+	_, hashAlg, _ := strings.Cut(s.Algorithm, "-")
+	return hashAlg
+}
+
+// parseDKIMSignatureTags splits a DKIM-Signature field value into its tags.
+// Folding whitespace is removed from values where it is not significant.
+func parseDKIMSignatureTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		key, val, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		switch key {
+		case "b", "bh", "h", "p":
+			val = strings.Join(strings.Fields(val), "")
+		default:
+			val = strings.TrimSpace(val)
+		}
+		tags[key] = val
+	}
+	return tags
+}
+
+// parseDKIMSignature parses and syntactically validates a DKIM-Signature
+// field value. The v= tag is not checked here, as ARC signatures do not carry
+// one. Errors describe why the signature is a permanent error; the returned
+// signature is never nil so that d= and s= can still be reported.
+func parseDKIMSignature(value string) (*dkimSignature, error) {
+	tags := parseDKIMSignatureTags(value)
+
+	sig := &dkimSignature{
+		Tags:       tags,
+		Algorithm:  strings.ToLower(tags["a"]),
+		Domain:     strings.ToLower(strings.TrimSuffix(tags["d"], ".")),
+		Selector:   tags["s"],
+		BodyLength: -1,
+	}
+
+	for _, required := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if tags[required] == "" {
+			return sig, fmt.Errorf("missing required tag %s=", required)
+		}
+	}
+
+	switch sig.Algorithm {
+	case "rsa-sha256", "rsa-sha1", "ed25519-sha256":
+	default:
+		return sig, fmt.Errorf("unsupported signing algorithm %q", tags["a"])
+	}
+
+	sig.HeaderCanon, sig.BodyCanon = "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		headerCanon, bodyCanon, hasBody := strings.Cut(strings.ToLower(c), "/")
+		sig.HeaderCanon = headerCanon
+		if hasBody {
+			sig.BodyCanon = bodyCanon
+		}
+		for _, canon := range []string{sig.HeaderCanon, sig.BodyCanon} {
+			if canon != "simple" && canon != "relaxed" {
+				return sig, fmt.Errorf("unsupported canonicalization %q", c)
+			}
+		}
+	}
+
+	for _, h := range strings.Split(tags["h"], ":") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			sig.SignedHeaders = append(sig.SignedHeaders, h)
+		}
+	}
+
+	var err error
+	if sig.BodyHash, err = base64.StdEncoding.DecodeString(tags["bh"]); err != nil {
+		return sig, fmt.Errorf("malformed bh= tag")
+	}
+	if sig.Signature, err = base64.StdEncoding.DecodeString(tags["b"]); err != nil {
+		return sig, fmt.Errorf("malformed b= tag")
+	}
+
+	if l, ok := tags["l"]; ok {
+		if sig.BodyLength, err = strconv.ParseInt(l, 10, 64); err != nil || sig.BodyLength < 0 {
+			return sig, fmt.Errorf("malformed l= tag")
+		}
+	}
+	if t, ok := tags["t"]; ok {
+		if sig.Timestamp, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return sig, fmt.Errorf("malformed t= tag")
+		}
+	}
+	if x, ok := tags["x"]; ok {
+		if sig.Expiration, err = strconv.ParseInt(x, 10, 64); err != nil {
+			return sig, fmt.Errorf("malformed x= tag")
+		}
+		if sig.Timestamp != 0 && sig.Expiration <= sig.Timestamp {
+			return sig, fmt.Errorf("x= is not after t=")
+		}
+	}
+
+	return sig, nil
+}
+
+// dkimPublicKey is a public key published in a DKIM key record.
+type dkimPublicKey struct {
+	KeyType  string
+	Key      crypto.PublicKey
+	Bits     int
+	HashAlgs []string // from h=, empty when all algorithms are acceptable
+	Flags    []string // from t=
+}
+
+// dkimKeyError is returned by fetchDKIMKey. Temporary is true when the lookup
+// may succeed later (temperror), false for a permanent error.
+type dkimKeyError struct {
+	Temporary bool
+	Reason    string
+}
+
+func (e *dkimKeyError) Error() string {
+	return e.Reason
+}
+
+// fetchDKIMKey retrieves and parses the public key published at
+// <selector>._domainkey.<domain>.
+func fetchDKIMKey(ctx context.Context, resolver DNSResolver, selector, domain string) (*dkimPublicKey, error) {
+	name := fmt.Sprintf("%s._domainkey.%s", selector, domain)
+
+	txtRecords, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return nil, &dkimKeyError{Reason: fmt.Sprintf("no key published at %s", name)}
+		}
+		return nil, &dkimKeyError{Temporary: true, Reason: fmt.Sprintf("key lookup failed: %s", formatDNSError(err))}
+	}
+	if len(txtRecords) == 0 {
+		return nil, &dkimKeyError{Reason: fmt.Sprintf("no key published at %s", name)}
+	}
+
+	var lastErr error
+	for _, txt := range txtRecords {
+		key, err := parseDKIMKeyRecord(txt)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// parseDKIMKeyRecord parses a DKIM key record (RFC 6376 section 3.6.1).
+func parseDKIMKeyRecord(record string) (*dkimPublicKey, error) {
+	tags := parseDKIMSignatureTags(record)
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, &dkimKeyError{Reason: fmt.Sprintf("invalid key record version %q", v)}
+	}
+
+	if s, ok := tags["s"]; ok {
+		acceptable := false
+		for _, service := range strings.Split(s, ":") {
+			if service = strings.TrimSpace(service); service == "*" || service == "email" {
+				acceptable = true
+			}
+		}
+		if !acceptable {
+			return nil, &dkimKeyError{Reason: "key is not valid for email"}
+		}
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, &dkimKeyError{Reason: "key record has no p= tag"}
+	}
+	if p == "" {
+		return nil, &dkimKeyError{Reason: "key has been revoked (empty p=)"}
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, &dkimKeyError{Reason: "key record p= is not valid base64"}
+	}
+
+	key := &dkimPublicKey{KeyType: "rsa"}
+	if k, ok := tags["k"]; ok {
+		key.KeyType = strings.ToLower(k)
+	}
+	if h, ok := tags["h"]; ok {
+		for _, alg := range strings.Split(h, ":") {
+			key.HashAlgs = append(key.HashAlgs, strings.ToLower(strings.TrimSpace(alg)))
+		}
+	}
+	if t, ok := tags["t"]; ok {
+		for _, flag := range strings.Split(t, ":") {
+			key.Flags = append(key.Flags, strings.TrimSpace(flag))
+		}
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			// Some signers publish a bare PKCS#1 RSAPublicKey
+			pub, err = x509.ParsePKCS1PublicKey(der)
+			if err != nil {
+				return nil, &dkimKeyError{Reason: "unable to parse RSA public key"}
+			}
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, &dkimKeyError{Reason: "key record does not contain an RSA public key"}
+		}
+		key.Key = rsaPub
+		key.Bits = rsaPub.N.BitLen()
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, &dkimKeyError{Reason: "Ed25519 public key has an invalid length"}
+		}
+		key.Key = ed25519.PublicKey(der)
+		key.Bits = 256
+	default:
+		return nil, &dkimKeyError{Reason: fmt.Sprintf("unsupported key type %q", key.KeyType)}
+	}
+
+	return key, nil
+}
+
+// dkimBodyHash computes the hash of the canonicalized body, truncated to
+// bodyLength octets when bodyLength is not negative.
+func dkimBodyHash(body []byte, canon string, bodyLength int64, newHash func() hash.Hash) ([]byte, error) {
+	canonical := canonicalizeBody(body, canon)
+	if bodyLength >= 0 {
+		if bodyLength > int64(len(canonical)) {
+			return nil, fmt.Errorf("body is shorter than the l= length")
+		}
+		canonical = canonical[:bodyLength]
+	}
+
+	h := newHash()
+	h.Write(canonical)
+	return h.Sum(nil), nil
+}
+
+// selectSignedHeaders picks, for each name listed in signedHeaders, the
+// bottom-most field not already used (RFC 6376 section 5.4.2). Names without
+// a remaining instance contribute nothing.
+func selectSignedHeaders(fields []rawHeaderField, signedHeaders []string) []rawHeaderField {
+	byName := make(map[string][]rawHeaderField)
+	for _, f := range fields {
+		name := strings.ToLower(f.Name)
+		byName[name] = append(byName[name], f)
+	}
+
+	var selected []rawHeaderField
+	for _, name := range signedHeaders {
+		instances := byName[name]
+		if len(instances) == 0 {
+			continue
+		}
+		selected = append(selected, instances[len(instances)-1])
+		byName[name] = instances[:len(instances)-1]
+	}
+	return selected
+}
+
+// stripSignatureValue empties the b= tag of a signature header field,
+// keeping everything else (including folding) untouched.
+func stripSignatureValue(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		key, _, found := strings.Cut(part, "=")
+		if found && strings.TrimSpace(key) == "b" {
+			parts[i] = key + "="
+		}
+	}
+	return name + ":" + strings.Join(parts, ";")
+}
+
+// dkimHeaderHash computes the hash over the given header fields followed by
+// the signature field itself, with its b= value emptied and no trailing CRLF.
+func dkimHeaderHash(fields []rawHeaderField, sigField rawHeaderField, canon string, newHash func() hash.Hash) []byte {
+	h := newHash()
+	for _, f := range fields {
+		h.Write([]byte(canonicalizeHeader(f.Raw, canon)))
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalizeHeader(stripSignatureValue(sigField.Raw), canon), "\r\n")))
+	return h.Sum(nil)
+}
+
+// dkimHashFunc returns the hash constructor and identifier for a DKIM hash
+// algorithm name.
+func dkimHashFunc(name string) (func() hash.Hash, crypto.Hash) {
+	if name == "sha1" {
+		return sha1.New, crypto.SHA1
+	}
+	return sha256.New, crypto.SHA256
+}
+
+// verifyDKIMCrypto checks a signature over a digest with the given key.
+func verifyDKIMCrypto(key *dkimPublicKey, hashID crypto.Hash, digest, signature []byte) bool {
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hashID, digest, signature) == nil
+	case ed25519.PublicKey:
+		// RFC 8463: Ed25519 signs the hash of the canonicalized headers
+		return ed25519.Verify(pub, digest, signature)
+	}
+	return false
+}
+
+// dkimVerification is the outcome of verifying one DKIM-Signature field.
+type dkimVerification struct {
+	Signature     *dkimSignature
+	Key           *dkimPublicKey
+	Result        model.DKIMVerificationResult
+	BodyHashValid *bool
+	Reason        string
+}
+
+// verifyDKIMSignature verifies a single DKIM-Signature field of msg, fetching
+// the signer's key through resolver.
+func verifyDKIMSignature(ctx context.Context, resolver DNSResolver, msg *rawMessage, field rawHeaderField, now time.Time) *dkimVerification {
+	sig, err := parseDKIMSignature(field.Value())
+	v := &dkimVerification{Signature: sig}
+	if err != nil {
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, err.Error()
+		return v
+	}
+
+	if version := sig.Tags["v"]; version != "1" {
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, fmt.Sprintf("unsupported signature version %q", version)
+		return v
+	}
+
+	fromSigned := false
+	for _, h := range sig.SignedHeaders {
+		if h == "from" {
+			fromSigned = true
+		}
+	}
+	if !fromSigned {
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, "From header is not signed"
+		return v
+	}
+
+	if i, ok := sig.Tags["i"]; ok {
+		_, identityDomain, _ := strings.Cut(i, "@")
+		identityDomain = strings.ToLower(strings.TrimSuffix(identityDomain, "."))
+		if identityDomain != sig.Domain && !strings.HasSuffix(identityDomain, "."+sig.Domain) {
+			v.Result, v.Reason = model.DKIMVerificationResultPermerror, "i= domain is not d= or one of its subdomains"
+			return v
+		}
+	}
+
+	if sig.Expiration != 0 && now.Unix() > sig.Expiration {
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, "signature has expired"
+		return v
+	}
+
+	return verifySignedMessage(ctx, resolver, msg, field, v)
+}
+
+// verifySignedMessage checks the key, body hash and header signature of an
+// already parsed DKIM-style signature. It is shared by DKIM-Signature and
+// ARC-Message-Signature verification.
+func verifySignedMessage(ctx context.Context, resolver DNSResolver, msg *rawMessage, field rawHeaderField, v *dkimVerification) *dkimVerification {
+	sig := v.Signature
+
+	if sig.HashAlgorithm() == "sha1" {
+		// RFC 8301: verifiers must not consider rsa-sha1 signatures valid
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, "rsa-sha1 signatures are no longer acceptable (RFC 8301)"
+		return v
+	}
+
+	// The key may already be known, e.g. when re-verifying a modified copy
+	if v.Key == nil {
+		key, err := fetchDKIMKey(ctx, resolver, sig.Selector, sig.Domain)
+		if err != nil {
+			v.Result, v.Reason = dkimKeyErrorResult(err), err.Error()
+			return v
+		}
+		v.Key = key
+	}
+	key := v.Key
+
+	if reason := checkDKIMKeyForSignature(key, sig); reason != "" {
+		v.Result, v.Reason = model.DKIMVerificationResultPermerror, reason
+		return v
+	}
+
+	newHash, hashID := dkimHashFunc(sig.HashAlgorithm())
+
+	bodyHash, err := dkimBodyHash(msg.Body, sig.BodyCanon, sig.BodyLength, newHash)
+	bodyHashValid := err == nil && bytes.Equal(bodyHash, sig.BodyHash)
+	v.BodyHashValid = &bodyHashValid
+	if err != nil {
+		v.Result, v.Reason = model.DKIMVerificationResultFail, err.Error()
+		return v
+	}
+	if !bodyHashValid {
+		v.Result, v.Reason = model.DKIMVerificationResultFail, "body hash did not verify"
+		return v
+	}
+
+	digest := dkimHeaderHash(selectSignedHeaders(msg.Fields, sig.SignedHeaders), field, sig.HeaderCanon, newHash)
+	if !verifyDKIMCrypto(key, hashID, digest, sig.Signature) {
+		v.Result, v.Reason = model.DKIMVerificationResultFail, "signature did not verify"
+		return v
+	}
+
+	v.Result = model.DKIMVerificationResultPass
+	return v
+}
+
+// dkimKeyErrorResult maps a key retrieval error to a verification result.
+func dkimKeyErrorResult(err error) model.DKIMVerificationResult {
+	var keyErr *dkimKeyError
+	if errors.As(err, &keyErr) && keyErr.Temporary {
+		return model.DKIMVerificationResultTemperror
+	}
+	return model.DKIMVerificationResultPermerror
+}
+
+// checkDKIMKeyForSignature checks that a key may be used to verify the given
+// signature. It returns the reason when it may not, or "" otherwise.
+func checkDKIMKeyForSignature(key *dkimPublicKey, sig *dkimSignature) string {
+	if key.KeyType != sig.KeyType() {
+		return fmt.Sprintf("key type %q does not match signing algorithm %q", key.KeyType, sig.Algorithm)
+	}
+
+	if len(key.HashAlgs) > 0 {
+		acceptable := false
+		for _, alg := range key.HashAlgs {
+			if alg == sig.HashAlgorithm() {
+				acceptable = true
+			}
+		}
+		if !acceptable {
+			return fmt.Sprintf("key does not allow %s hashes", sig.HashAlgorithm())
+		}
+	}
+
+	for _, flag := range key.Flags {
+		if flag == "s" {
+			// ARC-Message-Signature reuses i= for the instance number
+			if _, identityDomain, ok := strings.Cut(sig.Tags["i"], "@"); ok {
+				if !strings.EqualFold(strings.TrimSuffix(identityDomain, "."), sig.Domain) {
+					return "key is restricted to the d= domain (t=s) but i= is a subdomain"
+				}
+			}
+		}
+	}
+
+	if key.KeyType == "rsa" && key.Bits < 1024 {
+		return fmt.Sprintf("RSA key is too short (%d bits)", key.Bits)
+	}
+
+	return ""
+}
@@ -0,0 +1,428 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+const testDKIMMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: Bob <bob@example.net>\r\n" +
+	"Subject: Hello\r\n" +
+	"  world\r\n" +
+	"Date: Mon, 06 Oct 2025 10:00:00 +0000\r\n" +
+	"Message-ID: <1234@example.com>\r\n" +
+	"\r\n" +
+	"Hi Bob,\r\n" +
+	"\r\n" +
+	"This is a  test.  \r\n" +
+	"\r\n" +
+	"\r\n"
+
+// signTestMessage prepends a DKIM-Signature for d=example.com s=sel to message.
+func signTestMessage(t *testing.T, message string, signer crypto.Signer, algorithm, canon string, headers []string, extraTags string) string {
+	t.Helper()
+
+	msg := splitRawMessage([]byte(message))
+	headerCanon, bodyCanon, _ := strings.Cut(canon, "/")
+
+	bh, err := dkimBodyHash(msg.Body, bodyCanon, -1, sha256.New)
+	if err != nil {
+		t.Fatalf("dkimBodyHash: %v", err)
+	}
+
+	field := rawHeaderField{
+		Name: "DKIM-Signature",
+		Raw: fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=%s; d=example.com; s=sel;%s\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+			algorithm, canon, extraTags, strings.Join(headers, ":"), base64.StdEncoding.EncodeToString(bh)),
+	}
+	digest := dkimHeaderHash(selectSignedHeaders(msg.Fields, headers), field, headerCanon, sha256.New)
+
+	var sig []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatalf("SignPKCS1v15: %v", err)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, digest)
+	}
+
+	return field.Raw + base64.StdEncoding.EncodeToString(sig) + "\r\n" + message
+}
+
+func rsaKeyRecord(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+}
+
+func TestCanonicalizeHeader(t *testing.T) {
+	// Examples from RFC 6376 section 3.4.5
+	tests := []struct {
+		raw    string
+		simple string
+		relax  string
+	}{
+		{"A: X", "A: X\r\n", "a:X\r\n"},
+		{"B : Y\t\r\n\tZ  ", "B : Y\t\r\n\tZ  \r\n", "b:Y Z\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalizeHeader(tt.raw, "simple"); got != tt.simple {
+			t.Errorf("canonicalizeHeader(%q, simple) = %q, want %q", tt.raw, got, tt.simple)
+		}
+		if got := canonicalizeHeader(tt.raw, "relaxed"); got != tt.relax {
+			t.Errorf("canonicalizeHeader(%q, relaxed) = %q, want %q", tt.raw, got, tt.relax)
+		}
+	}
+}
+
+func TestCanonicalizeBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		simple string
+		relax  string
+	}{
+		{
+			name:   "RFC 6376 example",
+			body:   " C \r\nD \t E\r\n\r\n\r\n",
+			simple: " C \r\nD \t E\r\n",
+			relax:  " C\r\nD E\r\n",
+		},
+		{
+			name:   "empty body",
+			body:   "",
+			simple: "\r\n",
+			relax:  "",
+		},
+		{
+			name:   "missing final CRLF",
+			body:   "abc",
+			simple: "abc\r\n",
+			relax:  "abc\r\n",
+		},
+		{
+			name:   "only whitespace lines",
+			body:   "  \r\n\t\r\n",
+			simple: "  \r\n\t\r\n",
+			relax:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(canonicalizeBody([]byte(tt.body), "simple")); got != tt.simple {
+				t.Errorf("simple = %q, want %q", got, tt.simple)
+			}
+			if got := string(canonicalizeBody([]byte(tt.body), "relaxed")); got != tt.relax {
+				t.Errorf("relaxed = %q, want %q", got, tt.relax)
+			}
+		})
+	}
+}
+
+func TestSplitRawMessage(t *testing.T) {
+	msg := splitRawMessage([]byte("From: a@example.com\nSubject: one\n two\n\nbody\n"))
+
+	if len(msg.Fields) != 2 {
+		t.Fatalf("got %d fields, want 2", len(msg.Fields))
+	}
+	if msg.Fields[1].Raw != "Subject: one\r\n two" {
+		t.Errorf("folded field = %q", msg.Fields[1].Raw)
+	}
+	if string(msg.Body) != "body\r\n" {
+		t.Errorf("body = %q", msg.Body)
+	}
+}
+
+func TestStripSignatureValue(t *testing.T) {
+	raw := "DKIM-Signature: v=1; bh=abc=; b=dGVz\r\n\tdA==; d=example.com"
+	want := "DKIM-Signature: v=1; bh=abc=; b=; d=example.com"
+	if got := stripSignatureValue(raw); got != want {
+		t.Errorf("stripSignatureValue() = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyDKIMSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaRecord := rsaKeyRecord(t, rsaKey)
+	edRecord := "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)
+	headers := []string{"from", "to", "subject", "date", "message-id"}
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name       string
+		message    string
+		txt        map[string][]string
+		errMap     map[string]error
+		wantResult model.DKIMVerificationResult
+		wantReason string
+	}{
+		{
+			name:       "rsa-sha256 relaxed/relaxed",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPass,
+		},
+		{
+			name:       "rsa-sha256 simple/simple",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "simple/simple", headers, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPass,
+		},
+		{
+			name:       "ed25519-sha256",
+			message:    signTestMessage(t, testDKIMMessage, edKey, "ed25519-sha256", "relaxed/simple", headers, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {edRecord}},
+			wantResult: model.DKIMVerificationResultPass,
+		},
+		{
+			name:       "relaxed survives whitespace changes",
+			message:    strings.Replace(signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""), "a  test.", "a test.", 1),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPass,
+		},
+		{
+			name:       "body modified",
+			message:    strings.Replace(signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""), "Hi Bob", "Hi Eve", 1),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultFail,
+			wantReason: "body hash did not verify",
+		},
+		{
+			name:       "signed header modified",
+			message:    strings.Replace(signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""), "Subject: Hello", "Subject: [list] Hello", 1),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultFail,
+			wantReason: "signature did not verify",
+		},
+		{
+			name:       "body length limit allows appended content",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, fmt.Sprintf(" l=%d;", len(canonicalizeBody(splitRawMessage([]byte(testDKIMMessage)).Body, "relaxed")))) + "-- \r\nList footer\r\n",
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPass,
+		},
+		{
+			name:       "no key published",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""),
+			wantResult: model.DKIMVerificationResultPermerror,
+			wantReason: "no key published",
+		},
+		{
+			name:       "key lookup failure",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""),
+			errMap:     map[string]error{"sel._domainkey.example.com": errors.New("server misbehaving")},
+			wantResult: model.DKIMVerificationResultTemperror,
+		},
+		{
+			name:       "revoked key",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {"v=DKIM1; k=rsa; p="}},
+			wantResult: model.DKIMVerificationResultPermerror,
+			wantReason: "revoked",
+		},
+		{
+			name:       "key type mismatch",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {edRecord}},
+			wantResult: model.DKIMVerificationResultPermerror,
+			wantReason: "does not match",
+		},
+		{
+			name:       "From not signed",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", []string{"to", "subject"}, ""),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPermerror,
+			wantReason: "From header is not signed",
+		},
+		{
+			name:       "expired signature",
+			message:    signTestMessage(t, testDKIMMessage, rsaKey, "rsa-sha256", "relaxed/relaxed", headers, fmt.Sprintf(" x=%d;", past)),
+			txt:        map[string][]string{"sel._domainkey.example.com": {rsaRecord}},
+			wantResult: model.DKIMVerificationResultPermerror,
+			wantReason: "expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errMap := tt.errMap
+			if errMap == nil {
+				errMap = map[string]error{}
+			}
+			resolver := &mockDNSResolver{txt: tt.txt, err: errMap}
+
+			msg := splitRawMessage([]byte(tt.message))
+			fields := msg.FieldsNamed("DKIM-Signature")
+			if len(fields) != 1 {
+				t.Fatalf("got %d DKIM-Signature fields, want 1", len(fields))
+			}
+
+			v := verifyDKIMSignature(context.Background(), resolver, msg, fields[0], time.Now())
+			if v.Result != tt.wantResult {
+				t.Errorf("result = %s (%s), want %s", v.Result, v.Reason, tt.wantResult)
+			}
+			if tt.wantReason != "" && !strings.Contains(v.Reason, tt.wantReason) {
+				t.Errorf("reason = %q, want it to contain %q", v.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// rfc8463Message is the ed25519-sha256 signed example of RFC 8463
+// Appendix A.3.
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func TestVerifyDKIMSignatureRFC8463(t *testing.T) {
+	// Published vector, independent from the signing helpers above
+	resolver := &mockDNSResolver{
+		txt: map[string][]string{
+			"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		},
+		err: map[string]error{},
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    model.DKIMVerificationResult
+	}{
+		{"published message", rfc8463Message, model.DKIMVerificationResultPass},
+		{"modified body", strings.Replace(rfc8463Message, "We lost", "We won", 1), model.DKIMVerificationResultFail},
+		{"modified header", strings.Replace(rfc8463Message, "Is dinner ready?", "Is lunch ready?", 1), model.DKIMVerificationResultFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := splitRawMessage([]byte(tt.message))
+			fields := msg.FieldsNamed("DKIM-Signature")
+			if len(fields) != 1 {
+				t.Fatalf("got %d DKIM-Signature fields, want 1", len(fields))
+			}
+			v := verifyDKIMSignature(context.Background(), resolver, msg, fields[0], time.Now())
+			if v.Result != tt.want {
+				t.Errorf("result = %s (%s), want %s", v.Result, v.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeAuthenticationDKIMVerification(t *testing.T) {
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &mockDNSResolver{
+		txt: map[string][]string{"sel._domainkey.example.com": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)}},
+		err: map[string]error{},
+	}
+	analyzer := NewAuthenticationAnalyzerWithResolver("mx.happydeliver.local", time.Second, resolver)
+	signed := signTestMessage(t, testDKIMMessage, edKey, "ed25519-sha256", "relaxed/relaxed", []string{"from", "subject"}, "")
+
+	t.Run("no upstream verdict", func(t *testing.T) {
+		email, err := ParseEmail(bytes.NewBufferString(signed))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := analyzer.AnalyzeAuthentication(email)
+		if results.DkimVerifications == nil || len(*results.DkimVerifications) != 1 {
+			t.Fatalf("expected one DKIM verification, got %v", results.DkimVerifications)
+		}
+		if results.Dkim == nil || len(*results.Dkim) != 1 || (*results.Dkim)[0].Result != model.AuthResultResultPass {
+			t.Errorf("expected DKIM results to fall back to native verification, got %v", results.Dkim)
+		}
+	})
+
+	t.Run("upstream disagrees", func(t *testing.T) {
+		tampered := "Authentication-Results: mx.happydeliver.local; dkim=pass header.d=example.com header.s=sel\r\n" +
+			strings.Replace(signed, "Hi Bob", "Hi Eve", 1)
+		email, err := ParseEmail(bytes.NewBufferString(tampered))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := analyzer.AnalyzeAuthentication(email)
+		if results.DkimVerifications == nil || len(*results.DkimVerifications) != 1 {
+			t.Fatalf("expected one DKIM verification, got %v", results.DkimVerifications)
+		}
+		v := (*results.DkimVerifications)[0]
+		if v.Result != model.DKIMVerificationResultFail {
+			t.Errorf("result = %s, want fail", v.Result)
+		}
+		if v.UpstreamResult == nil || *v.UpstreamResult != "pass" {
+			t.Errorf("upstream result = %v, want pass", v.UpstreamResult)
+		}
+		if v.Discrepancy == nil || !*v.Discrepancy {
+			t.Error("expected a discrepancy with the upstream verdict")
+		}
+		if len(*results.Dkim) != 1 || (*results.Dkim)[0].Result != model.AuthResultResultPass {
+			t.Error("upstream DKIM results must be kept as-is")
+		}
+	})
+}
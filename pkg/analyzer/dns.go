@@ -22,6 +22,9 @@
 package analyzer
 
 import (
+	"crypto/x509"
+	"net/http"
+	"strings"
 	"time"
 
 	"git.happydns.org/happyDeliver/internal/model"
@@ -31,6 +34,44 @@ import (
 type DNSAnalyzer struct {
 	Timeout  time.Duration
 	resolver DNSResolver
+
+	// HTTPClient fetches BIMI logos, mark certificates and MTA-STS
+	// policies. When nil, a client refusing to connect to reserved and
+	// private addresses is used.
+	HTTPClient *http.Client
+
+	// BIMIRoots holds the trusted mark certificate authorities. When nil,
+	// the chains of the mark certificates are not verified: the system
+	// pool holds no mark certificate authority.
+	BIMIRoots *x509.CertPool
+
+	// DKIMSelectors are the selectors probed by domain-only tests when
+	// asked to discover the DKIM keys of the domain.
+	DKIMSelectors []string
+
+	// SMTPProbe enables connecting to the MX hosts on port 25 to read
+	// their greeting and check STARTTLS. Outgoing port 25 is often
+	// filtered, so it is disabled by default.
+	SMTPProbe bool
+
+	// SMTPHelo is the name announced by the SMTP probes. When empty,
+	// "localhost" is used.
+	SMTPHelo string
+
+	// SMTPRoots holds the certificate authorities trusted for the MX
+	// hosts certificates. When nil, the system certificate pool is used.
+	SMTPRoots *x509.CertPool
+
+	// authoritativeExchange queries a nameserver address directly. When
+	// nil, exchangeAuthoritative is used.
+	authoritativeExchange dnsExchangeFunc
+
+	// httpTransport, when set, replaces the transport of the HTTP client.
+	httpTransport http.RoundTripper
+
+	// smtpProber probes the SMTP server of an MX host. When nil,
+	// probeSMTP is used.
+	smtpProber func(host, addr string) *model.MXSMTPProbe
 }
 
 // NewDNSAnalyzer creates a new DNS analyzer with configurable timeout
@@ -48,13 +89,30 @@ func NewDNSAnalyzerWithResolver(timeout time.Duration, resolver DNSResolver) *DN
 		resolver = NewStandardDNSResolver()
 	}
 	return &DNSAnalyzer{
-		Timeout:  timeout,
-		resolver: resolver,
+		Timeout:       timeout,
+		resolver:      resolver,
+		DKIMSelectors: DefaultDKIMSelectors,
 	}
 }
 
 // AnalyzeDNS performs DNS validation for the email's domain
 func (d *DNSAnalyzer) AnalyzeDNS(email *EmailMessage, headersResults *model.HeaderAnalysis) *model.DNSResults {
+	analyzer, recorder := d.withDNSSECRecorder()
+	results := analyzer.analyzeDNS(email, headersResults)
+	recorder.apply(results)
+	return results
+}
+
+// withDNSSECRecorder returns a copy of the analyzer collecting the DNSSEC
+// status its resolver reports for each lookup.
+func (d *DNSAnalyzer) withDNSSECRecorder() (*DNSAnalyzer, *dnssecRecorder) {
+	recorder := newDNSSECRecorder(d.resolver)
+	analyzer := *d
+	analyzer.resolver = recorder
+	return &analyzer, recorder
+}
+
+func (d *DNSAnalyzer) analyzeDNS(email *EmailMessage, headersResults *model.HeaderAnalysis) *model.DNSResults {
 	// Extract domain from From address
 	if headersResults.DomainAlignment.FromDomain == nil || *headersResults.DomainAlignment.FromDomain == "" {
 		return &model.DNSResults{
@@ -76,7 +134,7 @@ func (d *DNSAnalyzer) AnalyzeDNS(email *EmailMessage, headersResults *model.Head
 	}
 
 	// Store sender IP for later use in scoring
-	var senderIP string
+	var senderIP, helo string
 	if headersResults.ReceivedChain != nil && len(*headersResults.ReceivedChain) > 0 {
 		firstHop := (*headersResults.ReceivedChain)[0]
 		if firstHop.Ip != nil && *firstHop.Ip != "" {
@@ -91,7 +149,7 @@ func (d *DNSAnalyzer) AnalyzeDNS(email *EmailMessage, headersResults *model.Head
 
 			// Record the announced HELO name and whether it matches the PTR record
 			if firstHop.From != nil && *firstHop.From != "" {
-				helo := *firstHop.From
+				helo = *firstHop.From
 				results.HeloHostname = &helo
 				if len(ptrRecords) > 0 {
 					match := checkHeloPtrMatch(helo, ptrRecords)
@@ -121,10 +179,17 @@ func (d *DNSAnalyzer) AnalyzeDNS(email *EmailMessage, headersResults *model.Head
 
 	// Check SPF records (for Return-Path domain - this is the envelope sender)
 	// SPF validates the MAIL FROM command, which corresponds to Return-Path
-	results.SpfRecords = d.checkSPFRecords(spfDomain)
+	results.SpfRecords, results.SpfLookupBudget = d.checkSPFRecordsWithBudget(spfDomain)
+
+	// Evaluate the SPF policy against the sending IP, as the receiving MTA would
+	if senderIP != "" {
+		envelopeSender := strings.Trim(strings.TrimSpace(email.ReturnPath), "<>")
+		results.SpfEvaluation = d.evaluateSPF(spfDomain, senderIP, envelopeSender, helo)
+	}
 
 	// Check DKIM records by parsing DKIM-Signature headers directly
-	for _, sig := range parseDKIMSignatures(email.Header["Dkim-Signature"]) {
+	dkimSignatures := parseDKIMSignatures(email.Header["Dkim-Signature"])
+	for _, sig := range dkimSignatures {
 		dkimRecord := d.checkDKIMRecord(sig)
 		if dkimRecord != nil {
 			if results.DkimRecords == nil {
@@ -141,12 +206,34 @@ func (d *DNSAnalyzer) AnalyzeDNS(email *EmailMessage, headersResults *model.Head
 	// Check BIMI record (for From domain - branding is based on visible sender)
 	results.BimiRecord = d.checkBIMIRecord(fromDomain, "default")
 
+	// Check MTA-STS policy (for From domain - protects replies sent to it)
+	results.MtaStsRecord = d.checkMTASTS(fromDomain, results.FromMxRecords)
+
+	// Check DANE TLSA records of the From domain MX hosts
+	results.DaneRecords = d.checkDANERecords(results.FromMxRecords)
+
+	// Check TLS-RPT record (for From domain - where TLS failures are reported)
+	results.TlsRptRecord = d.checkTLSRPTRecord(fromDomain)
+	flagMissingTLSRPT(results)
+
+	// Compare the answers of each authoritative nameserver of the From domain
+	results.NsConsistency = d.checkNSConsistency(fromDomain, nsRecordChecks(fromDomain, spfDomain, dkimSignatures))
+
 	return results
 }
 
 // AnalyzeDomainOnly performs DNS validation for a domain without email context
 // This is useful for checking domain configuration without sending an actual email
-func (d *DNSAnalyzer) AnalyzeDomainOnly(domain string) *model.DNSResults {
+// The DKIM keys published under the given selectors, if any, are probed as
+// there is no DKIM-Signature to read them from.
+func (d *DNSAnalyzer) AnalyzeDomainOnly(domain string, dkimSelectors ...string) *model.DNSResults {
+	analyzer, recorder := d.withDNSSECRecorder()
+	results := analyzer.analyzeDomainOnly(domain, dkimSelectors)
+	recorder.apply(results)
+	return results
+}
+
+func (d *DNSAnalyzer) analyzeDomainOnly(domain string, dkimSelectors []string) *model.DNSResults {
 	results := &model.DNSResults{
 		FromDomain: domain,
 	}
@@ -155,19 +242,42 @@ func (d *DNSAnalyzer) AnalyzeDomainOnly(domain string) *model.DNSResults {
 	results.FromMxRecords = d.checkMXRecords(domain)
 
 	// Check SPF records
-	results.SpfRecords = d.checkSPFRecords(domain)
+	results.SpfRecords, results.SpfLookupBudget = d.checkSPFRecordsWithBudget(domain)
 
 	// Verify the domain can receive replies/bounces (MX, with A/AAAA fallback)
 	results.ReturnOk = &model.ReturnOK{
 		From: d.checkReturnOKDomain(domain, ""),
 	}
 
+	// Probe DKIM selectors
+	var dkimKeys []DKIMHeader
+	if len(dkimSelectors) > 0 {
+		records := d.probeDKIMSelectors(domain, dkimSelectors)
+		results.DkimRecords = &records
+		for _, record := range records {
+			dkimKeys = append(dkimKeys, DKIMHeader{Domain: record.Domain, Selector: record.Selector})
+		}
+	}
+
 	// Check DMARC record
 	results.DmarcRecord = d.checkDMARCRecord(domain)
 
 	// Check BIMI record with default selector
 	results.BimiRecord = d.checkBIMIRecord(domain, "default")
 
+	// Check MTA-STS policy
+	results.MtaStsRecord = d.checkMTASTS(domain, results.FromMxRecords)
+
+	// Check DANE TLSA records of the MX hosts
+	results.DaneRecords = d.checkDANERecords(results.FromMxRecords)
+
+	// Check TLS-RPT record
+	results.TlsRptRecord = d.checkTLSRPTRecord(domain)
+	flagMissingTLSRPT(results)
+
+	// Compare the answers of each authoritative nameserver
+	results.NsConsistency = d.checkNSConsistency(domain, nsRecordChecks(domain, domain, dkimKeys))
+
 	return results
 }
 
@@ -190,8 +300,14 @@ func (d *DNSAnalyzer) CalculateDomainOnlyScore(results *model.DNSResults) (int,
 	// SPF Records: 30 points
 	score += 30 * d.calculateSPFScore(results) / 100
 
-	// DMARC Record: 40 points
-	score += 40 * d.calculateDMARCScore(results) / 100
+	// DMARC Record: 35 points
+	score += 35 * d.calculateDMARCScore(results) / 100
+
+	// TLS-RPT Record: 5 points, the domain hears about broken transport
+	// security
+	if results.TlsRptRecord != nil && results.TlsRptRecord.Valid {
+		score += 5
+	}
 
 	// Penalty when a sender domain cannot receive replies/bounces at all
 	score += calculateReturnOKPenalty(results)
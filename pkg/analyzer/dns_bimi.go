@@ -24,12 +24,20 @@ package analyzer
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"strings"
 
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/utils"
 )
 
+// bimiMaxFetchSize bounds the size of a downloaded logo or mark certificate.
+const bimiMaxFetchSize = 1 << 20
+
 // checkBIMIRecord looks up and validates BIMI record for a domain and selector
 func (d *DNSAnalyzer) checkBIMIRecord(domain, selector string) *model.BIMIRecord {
 	// BIMI records are at: selector._bimi.domain
@@ -94,7 +102,7 @@ func (d *DNSAnalyzer) checkBIMIRecord(domain, selector string) *model.BIMIRecord
 		}
 	}
 
-	return &model.BIMIRecord{
+	result := &model.BIMIRecord{
 		Selector: selector,
 		Domain:   domain,
 		Record:   &bimiRecord,
@@ -102,6 +110,106 @@ func (d *DNSAnalyzer) checkBIMIRecord(domain, selector string) *model.BIMIRecord
 		VmcUrl:   &vmcURL,
 		Valid:    true,
 	}
+
+	// Validate the logo and mark certificate the record points to
+	var svg []byte
+	if logoURL != "" {
+		result.Logo, svg = d.checkBIMILogo(logoURL)
+	}
+	if vmcURL != "" {
+		result.Certificate = d.checkBIMICertificate(vmcURL, domain, selector, svg)
+	}
+
+	return result
+}
+
+// fetchBIMIResource downloads a logo or mark certificate. BIMI requires
+// both to be served over HTTPS.
+func (d *DNSAnalyzer) fetchBIMIResource(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTPS URL", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: HTTP status %d", rawURL, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, bimiMaxFetchSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	if len(data) > bimiMaxFetchSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", rawURL, bimiMaxFetchSize)
+	}
+
+	return data, nil
+}
+
+// httpClient returns the client fetching the resources a sender points
+// to. Unless HTTPClient is set, it only connects to public addresses, so
+// that a DNS record cannot make the server query its own network.
+func (d *DNSAnalyzer) httpClient() *http.Client {
+	client := d.HTTPClient
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = d.dialPublic
+		transport.DisableKeepAlives = true
+		client = &http.Client{Transport: transport}
+	}
+
+	if d.httpTransport != nil {
+		replaced := *client
+		replaced.Transport = d.httpTransport
+		client = &replaced
+	}
+	return client
+}
+
+// dialPublic resolves the host of addr with the analyzer resolver and
+// connects to the first of its addresses reachable from the Internet,
+// refusing the reserved ones. Only the address checked is dialed.
+func (d *DNSAnalyzer) dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{host}
+	if _, err := netip.ParseAddr(host); err != nil {
+		if addrs, err = d.resolver.LookupHost(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	var dialer net.Dialer
+	err = fmt.Errorf("%s has no address", host)
+	for _, ip := range addrs {
+		if kind := reservedAddressKind(ip); kind != "" {
+			err = fmt.Errorf("refusing to connect to %s: %s is a %s address", host, ip, kind)
+			continue
+		}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
 }
 
 // parseBIMITags parses a BIMI record into its tag=value pairs. Pairs are
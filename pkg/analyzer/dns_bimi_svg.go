@@ -0,0 +1,267 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// bimiMaxLogoSize is the largest logo size recommended by the BIMI Group.
+const bimiMaxLogoSize = 32 * 1024
+
+// svgForbiddenElements lists the elements the SVG Tiny Portable/Secure
+// profile does not allow, as they could run code, pull external content or
+// animate the logo.
+var svgForbiddenElements = []string{
+	"script",
+	"image",
+	"foreignObject",
+	"animate",
+	"animateColor",
+	"animateMotion",
+	"animateTransform",
+	"set",
+	"audio",
+	"video",
+}
+
+// svgTinyPSReport is the outcome of checking an SVG document against the
+// SVG Tiny Portable/Secure profile.
+type svgTinyPSReport struct {
+	Title    string
+	Issues   []string
+	Warnings []string
+}
+
+func (r *svgTinyPSReport) issue(format string, args ...any) {
+	if msg := fmt.Sprintf(format, args...); !slices.Contains(r.Issues, msg) {
+		r.Issues = append(r.Issues, msg)
+	}
+}
+
+func (r *svgTinyPSReport) warning(format string, args ...any) {
+	if msg := fmt.Sprintf(format, args...); !slices.Contains(r.Warnings, msg) {
+		r.Warnings = append(r.Warnings, msg)
+	}
+}
+
+// checkBIMILogo fetches the logo referenced by a BIMI record and validates
+// it. It also returns the logo, uncompressed, for comparison with the one
+// embedded in the mark certificate.
+func (d *DNSAnalyzer) checkBIMILogo(logoURL string) (*model.BIMILogo, []byte) {
+	data, err := d.fetchBIMIResource(logoURL)
+	if err != nil {
+		return &model.BIMILogo{
+			Valid: false,
+			Error: utils.PtrTo(err.Error()),
+		}, nil
+	}
+
+	digest := sha256.Sum256(data)
+	logo := &model.BIMILogo{
+		Size:   utils.PtrTo(len(data)),
+		Sha256: utils.PtrTo(hex.EncodeToString(digest[:])),
+	}
+
+	svg, err := decompressSVG(data)
+	if err != nil {
+		logo.Error = utils.PtrTo(err.Error())
+		return logo, nil
+	}
+
+	report := validateSVGTinyPS(svg)
+	if len(data) > bimiMaxLogoSize {
+		report.warning("logo is larger than %d KiB", bimiMaxLogoSize/1024)
+	}
+
+	logo.Valid = len(report.Issues) == 0
+	if report.Title != "" {
+		logo.Title = utils.PtrTo(report.Title)
+	}
+	if len(report.Issues) > 0 {
+		logo.Issues = &report.Issues
+	}
+	if len(report.Warnings) > 0 {
+		logo.Warnings = &report.Warnings
+	}
+
+	return logo, svg
+}
+
+// decompressSVG returns the SVG document, gunzipping it first when it is
+// served compressed (SVGZ).
+func decompressSVG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed logo: %w", err)
+	}
+	defer zr.Close()
+
+	svg, err := io.ReadAll(io.LimitReader(zr, bimiMaxFetchSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed logo: %w", err)
+	}
+	if len(svg) > bimiMaxFetchSize {
+		return nil, fmt.Errorf("compressed logo expands to more than %d bytes", bimiMaxFetchSize)
+	}
+	return svg, nil
+}
+
+// validateSVGTinyPS checks an SVG document against the SVG Tiny
+// Portable/Secure profile required for BIMI logos.
+func validateSVGTinyPS(svg []byte) *svgTinyPSReport {
+	report := &svgTinyPSReport{}
+
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	depth := 0
+	rootSeen, inTitle := false, false
+	var title strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.issue("logo is not well-formed XML: %v", err)
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.Directive:
+			if bytes.Contains(bytes.ToUpper(t), []byte("ENTITY")) {
+				report.issue("entity declarations are not allowed")
+			}
+
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				rootSeen = true
+				checkSVGRoot(t, report)
+			}
+			if depth == 2 && t.Name.Local == "title" {
+				inTitle = true
+			}
+
+			if slices.Contains(svgForbiddenElements, t.Name.Local) {
+				report.issue("<%s> elements are not allowed", t.Name.Local)
+			}
+
+			for _, attr := range t.Attr {
+				name := strings.ToLower(attr.Name.Local)
+				if name == "href" && !strings.HasPrefix(strings.TrimSpace(attr.Value), "#") {
+					report.issue("external references are not allowed (%s)", attr.Value)
+				}
+				if strings.HasPrefix(name, "on") {
+					report.issue("event handler attributes are not allowed (%s)", attr.Name.Local)
+				}
+			}
+
+		case xml.EndElement:
+			if depth == 2 && inTitle {
+				inTitle = false
+			}
+			depth--
+
+		case xml.CharData:
+			if inTitle {
+				title.Write(t)
+			}
+		}
+	}
+
+	if !rootSeen {
+		report.issue("logo contains no SVG element")
+		return report
+	}
+
+	report.Title = strings.TrimSpace(title.String())
+	if report.Title == "" {
+		report.issue("logo must have a non-empty <title> element")
+	}
+
+	return report
+}
+
+// checkSVGRoot checks the attributes of the root element of a BIMI logo.
+func checkSVGRoot(root xml.StartElement, report *svgTinyPSReport) {
+	if root.Name.Local != "svg" {
+		report.issue("root element is <%s>, not <svg>", root.Name.Local)
+		return
+	}
+	if root.Name.Space != "http://www.w3.org/2000/svg" {
+		report.issue("root element is not in the SVG namespace")
+	}
+
+	attrs := map[string]string{}
+	for _, attr := range root.Attr {
+		if attr.Name.Space == "" {
+			attrs[attr.Name.Local] = attr.Value
+		}
+	}
+
+	if attrs["version"] != "1.2" {
+		report.issue("root element must have version=\"1.2\"")
+	}
+	if attrs["baseProfile"] != "tiny-ps" {
+		report.issue("root element must have baseProfile=\"tiny-ps\"")
+	}
+	for _, attr := range []string{"x", "y"} {
+		if _, ok := attrs[attr]; ok {
+			report.issue("root element must not have an %s= attribute", attr)
+		}
+	}
+
+	viewBox, ok := attrs["viewBox"]
+	if !ok {
+		report.warning("root element has no viewBox attribute")
+		return
+	}
+	fields := strings.FieldsFunc(viewBox, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) != 4 {
+		report.issue("invalid viewBox %q", viewBox)
+		return
+	}
+	width, errW := strconv.ParseFloat(fields[2], 64)
+	height, errH := strconv.ParseFloat(fields[3], 64)
+	if errW != nil || errH != nil {
+		report.issue("invalid viewBox %q", viewBox)
+	} else if width != height {
+		report.warning("logo is not square (viewBox %s)", viewBox)
+	}
+}
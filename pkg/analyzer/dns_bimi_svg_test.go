@@ -0,0 +1,145 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"slices"
+	"testing"
+)
+
+const testBIMILogo = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 100 100">
+  <title>Example Inc.</title>
+  <defs><linearGradient id="g"><stop offset="0" stop-color="#000"/></linearGradient></defs>
+  <rect width="100" height="100" fill="url(#g)"/>
+  <use href="#g"/>
+</svg>
+`
+
+func TestValidateSVGTinyPS(t *testing.T) {
+	tests := []struct {
+		name        string
+		svg         string
+		wantIssue   string
+		wantWarning string
+		wantTitle   string
+	}{
+		{
+			name:      "valid logo",
+			svg:       testBIMILogo,
+			wantTitle: "Example Inc.",
+		},
+		{
+			name:      "missing baseProfile",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" viewBox="0 0 1 1"><title>x</title></svg>`,
+			wantIssue: `root element must have baseProfile="tiny-ps"`,
+		},
+		{
+			name:      "wrong version",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title></svg>`,
+			wantIssue: `root element must have version="1.2"`,
+		},
+		{
+			name:      "x attribute on root",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" x="0" viewBox="0 0 1 1"><title>x</title></svg>`,
+			wantIssue: "root element must not have an x= attribute",
+		},
+		{
+			name:      "script",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title><script>alert(1)</script></svg>`,
+			wantIssue: "<script> elements are not allowed",
+		},
+		{
+			name:      "animation",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title><rect><animate attributeName="x"/></rect></svg>`,
+			wantIssue: "<animate> elements are not allowed",
+		},
+		{
+			name:      "external reference",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title><use xlink:href="https://example.com/a.svg#a"/></svg>`,
+			wantIssue: "external references are not allowed (https://example.com/a.svg#a)",
+		},
+		{
+			name:      "event handler",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1" onload="x()"><title>x</title></svg>`,
+			wantIssue: "event handler attributes are not allowed (onload)",
+		},
+		{
+			name:      "missing title",
+			svg:       `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><rect/></svg>`,
+			wantIssue: "logo must have a non-empty <title> element",
+		},
+		{
+			name:      "entity declaration",
+			svg:       `<!DOCTYPE svg [<!ENTITY x "y">]><svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title></svg>`,
+			wantIssue: "entity declarations are not allowed",
+		},
+		{
+			name:      "not an SVG",
+			svg:       `<html><title>x</title></html>`,
+			wantIssue: "root element is <html>, not <svg>",
+		},
+		{
+			name:        "not square",
+			svg:         `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 200 100"><title>x</title></svg>`,
+			wantWarning: "logo is not square (viewBox 0 0 200 100)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := validateSVGTinyPS([]byte(tt.svg))
+
+			if tt.wantIssue == "" && len(report.Issues) > 0 {
+				t.Errorf("unexpected issues: %v", report.Issues)
+			}
+			if tt.wantIssue != "" && !slices.Contains(report.Issues, tt.wantIssue) {
+				t.Errorf("issues = %v, want %q", report.Issues, tt.wantIssue)
+			}
+			if tt.wantWarning != "" && !slices.Contains(report.Warnings, tt.wantWarning) {
+				t.Errorf("warnings = %v, want %q", report.Warnings, tt.wantWarning)
+			}
+			if tt.wantTitle != "" && report.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", report.Title, tt.wantTitle)
+			}
+		})
+	}
+}
+
+func TestDecompressSVG(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(testBIMILogo))
+	zw.Close()
+
+	for name, data := range map[string][]byte{"plain": []byte(testBIMILogo), "gzip": buf.Bytes()} {
+		svg, err := decompressSVG(data)
+		if err != nil {
+			t.Fatalf("%s: decompressSVG: %v", name, err)
+		}
+		if string(svg) != testBIMILogo {
+			t.Errorf("%s: decompressSVG returned %q", name, svg)
+		}
+	}
+}
@@ -189,3 +189,30 @@ func TestNotABIMIRecordError(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchBIMIResourceRefusesReservedAddresses(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"private.example.com":  {"10.0.0.1"},
+			"loopback.example.com": {"127.0.0.1", "::1"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, resolver)
+
+	for _, rawURL := range []string{
+		"https://127.0.0.1/logo.svg",
+		"https://[::1]/logo.svg",
+		"https://169.254.169.254/latest/meta-data/",
+		"https://private.example.com/logo.svg",
+		"https://loopback.example.com:8443/vmc.pem",
+	} {
+		_, err := analyzer.fetchBIMIResource(rawURL)
+		if err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+			t.Errorf("fetchBIMIResource(%q) error = %v, want the address refused", rawURL, err)
+		}
+	}
+
+	if _, err := analyzer.fetchBIMIResource("https://unknown.example.com/logo.svg"); err == nil || strings.Contains(err.Error(), "refusing") {
+		t.Errorf("fetchBIMIResource() of an unknown host error = %v, want a lookup failure", err)
+	}
+}
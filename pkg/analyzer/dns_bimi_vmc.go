@@ -0,0 +1,377 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+var (
+	// id-kp-BrandIndicatorforMessageIdentification, the BIMI extended key usage
+	oidBIMIExtKeyUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 31}
+	// id-pe-logotype (RFC 3709)
+	oidLogotype = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 12}
+	// Mark type extension defined by the AuthIndicators Working Group
+	oidMarkType = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53087, 1, 13}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// logotypeHash is a HashAlgAndValue from RFC 3709.
+type logotypeHash struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Value     []byte
+}
+
+// logotypeDetails is a LogotypeDetails from RFC 3709.
+type logotypeDetails struct {
+	MediaType string `asn1:"ia5"`
+	Hashes    []logotypeHash
+	URIs      []string
+}
+
+// logotypeImage is a LogotypeImage from RFC 3709.
+type logotypeImage struct {
+	Details logotypeDetails
+	Info    asn1.RawValue `asn1:"optional"`
+}
+
+// checkBIMICertificate fetches the mark certificate referenced by a BIMI
+// record and validates its chain, its names and the logo it embeds against
+// the SVG served at the l= URL.
+func (d *DNSAnalyzer) checkBIMICertificate(vmcURL, domain, selector string, svg []byte) *model.BIMICertificate {
+	data, err := d.fetchBIMIResource(vmcURL)
+	if err != nil {
+		return &model.BIMICertificate{
+			Valid: false,
+			Error: utils.PtrTo(err.Error()),
+		}
+	}
+
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return &model.BIMICertificate{
+			Valid: false,
+			Error: utils.PtrTo(err.Error()),
+		}
+	}
+	leaf := certs[0]
+
+	cert := &model.BIMICertificate{
+		Type:      utils.PtrTo(model.BIMICertificateTypeUnknown),
+		Subject:   utils.PtrTo(leaf.Subject.String()),
+		Issuer:    utils.PtrTo(leaf.Issuer.String()),
+		NotBefore: utils.PtrTo(leaf.NotBefore),
+		NotAfter:  utils.PtrTo(leaf.NotAfter),
+	}
+	var issues []string
+
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		issues = append(issues, "certificate is not yet valid")
+	} else if now.After(leaf.NotAfter) {
+		issues = append(issues, "certificate has expired")
+	}
+
+	if !slices.ContainsFunc(leaf.UnknownExtKeyUsage, oidBIMIExtKeyUsage.Equal) {
+		issues = append(issues, "certificate is not issued for BIMI (missing extended key usage)")
+	}
+
+	if len(leaf.DNSNames) > 0 {
+		cert.San = utils.PtrTo(leaf.DNSNames)
+	}
+	sanMatches := bimiSANMatches(leaf.DNSNames, domain, selector)
+	cert.SanMatches = &sanMatches
+	if !sanMatches {
+		issues = append(issues, fmt.Sprintf("certificate does not cover %s", domain))
+	}
+
+	// Verify the chain up to a trusted mark certificate authority
+	if d.BIMIRoots == nil {
+		cert.ChainError = utils.PtrTo("No mark certificate authority is configured, the chain was not verified")
+	} else {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         d.BIMIRoots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		chainValid := err == nil
+		cert.ChainValid = &chainValid
+		if err != nil {
+			cert.ChainError = utils.PtrTo(err.Error())
+			issues = append(issues, "certificate chain could not be verified")
+		}
+	}
+
+	var logotype []byte
+	for _, ext := range leaf.Extensions {
+		switch {
+		case ext.Id.Equal(oidLogotype):
+			logotype = ext.Value
+		case ext.Id.Equal(oidMarkType):
+			var markType asn1.RawValue
+			if _, err := asn1.Unmarshal(ext.Value, &markType); err == nil {
+				cert.MarkType = utils.PtrTo(string(markType.Bytes))
+				cert.Type = utils.PtrTo(bimiCertificateType(string(markType.Bytes)))
+			}
+		}
+	}
+
+	if logotype == nil {
+		issues = append(issues, "certificate has no logotype extension")
+	} else {
+		issues = append(issues, checkBIMILogotype(cert, logotype, svg)...)
+	}
+
+	cert.Valid = len(issues) == 0
+	if len(issues) > 0 {
+		cert.Issues = &issues
+	}
+
+	return cert
+}
+
+// checkBIMILogotype validates the logo embedded in a mark certificate and
+// compares it with the served SVG, if any. It returns the issues found.
+func checkBIMILogotype(cert *model.BIMICertificate, logotype, svg []byte) []string {
+	details, err := parseLogotypeDetails(logotype)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var issues []string
+	if !strings.EqualFold(details.MediaType, "image/svg+xml") {
+		issues = append(issues, fmt.Sprintf("embedded logo has media type %q, expected image/svg+xml", details.MediaType))
+	}
+	if len(details.URIs) == 0 {
+		return append(issues, "logotype extension has no logo URI")
+	}
+
+	data, err := decodeDataURI(details.URIs[0])
+	if err != nil {
+		return append(issues, err.Error())
+	}
+
+	hashValid := logotypeHashMatches(details.Hashes, data)
+	cert.LogoHashValid = &hashValid
+	if !hashValid {
+		issues = append(issues, "embedded logo does not match its declared hash")
+	}
+
+	if svg != nil {
+		embedded, err := decompressSVG(data)
+		matches := err == nil && bytes.Equal(embedded, svg)
+		cert.LogoMatchesSvg = &matches
+		if !matches {
+			issues = append(issues, "embedded logo differs from the logo served at the l= URL")
+		}
+	}
+
+	return issues
+}
+
+// parsePEMCertificates parses every certificate of a PEM bundle, leaf first.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in PEM data: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in PEM data")
+	}
+	return certs, nil
+}
+
+// LoadBIMIRoots reads the trusted mark certificate authorities from a PEM
+// file.
+func LoadBIMIRoots(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the mark certificate authorities: %w", err)
+	}
+
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mark certificate authorities in %s: %w", filename, err)
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		roots.AddCert(cert)
+	}
+	return roots, nil
+}
+
+// bimiSANMatches reports whether a mark certificate covers the BIMI domain,
+// either directly, through its organizational domain or through the
+// selector-specific name.
+func bimiSANMatches(names []string, domain, selector string) bool {
+	domain = strings.ToLower(domain)
+	accepted := []string{domain, getOrganizationalDomain(domain), selector + "._bimi." + domain}
+	for _, name := range names {
+		if slices.Contains(accepted, strings.ToLower(strings.TrimSuffix(name, "."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// bimiCertificateType tells a Verified Mark Certificate from a Common Mark
+// Certificate by the mark type it declares.
+func bimiCertificateType(markType string) model.BIMICertificateType {
+	switch strings.ToLower(strings.TrimSpace(markType)) {
+	case "registered mark", "government mark":
+		return model.BIMICertificateTypeVmc
+	case "prior use mark", "modified registered mark":
+		return model.BIMICertificateTypeCmc
+	}
+	return model.BIMICertificateTypeUnknown
+}
+
+// parseLogotypeDetails extracts the details of the first image of the
+// subject logo from an RFC 3709 logotype extension.
+func parseLogotypeDetails(ext []byte) (*logotypeDetails, error) {
+	var extn asn1.RawValue
+	if _, err := asn1.Unmarshal(ext, &extn); err != nil || extn.Tag != asn1.TagSequence {
+		return nil, fmt.Errorf("malformed logotype extension")
+	}
+
+	for data := extn.Bytes; len(data) > 0; {
+		var field asn1.RawValue
+		var err error
+		if data, err = asn1.Unmarshal(data, &field); err != nil {
+			return nil, fmt.Errorf("malformed logotype extension")
+		}
+
+		// subjectLogo [2] EXPLICIT LogotypeInfo
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 2 {
+			continue
+		}
+
+		var info asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &info); err != nil {
+			return nil, fmt.Errorf("malformed subject logo")
+		}
+		// direct [0] LogotypeData, whose first member is the image list
+		if info.Class != asn1.ClassContextSpecific || info.Tag != 0 {
+			return nil, fmt.Errorf("subject logo is not embedded in the certificate")
+		}
+
+		var images []logotypeImage
+		if _, err := asn1.Unmarshal(info.Bytes, &images); err != nil || len(images) == 0 {
+			return nil, fmt.Errorf("subject logo has no image")
+		}
+		return &images[0].Details, nil
+	}
+
+	return nil, fmt.Errorf("logotype extension has no subject logo")
+}
+
+// decodeDataURI decodes the content of a data: URI.
+func decodeDataURI(uri string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return nil, fmt.Errorf("embedded logo is not a data: URI")
+	}
+
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, fmt.Errorf("malformed data: URI")
+	}
+
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed base64 in data: URI")
+		}
+		return data, nil
+	}
+
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed data: URI")
+	}
+	return []byte(data), nil
+}
+
+// logotypeHashMatches reports whether data matches the first declared hash
+// using a supported algorithm.
+func logotypeHashMatches(hashes []logotypeHash, data []byte) bool {
+	for _, h := range hashes {
+		var sum []byte
+		switch alg := h.Algorithm.Algorithm; {
+		case alg.Equal(oidSHA1):
+			s := sha1.Sum(data)
+			sum = s[:]
+		case alg.Equal(oidSHA256):
+			s := sha256.Sum256(data)
+			sum = s[:]
+		case alg.Equal(oidSHA384):
+			s := sha512.Sum384(data)
+			sum = s[:]
+		case alg.Equal(oidSHA512):
+			s := sha512.Sum512(data)
+			sum = s[:]
+		default:
+			continue
+		}
+		return bytes.Equal(sum, h.Value)
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// marshalTestLogotype builds an RFC 3709 logotype extension embedding svg
+// as a gzipped data: URI, the way mark certificate authorities do.
+func marshalTestLogotype(t *testing.T, svg []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(svg)
+	zw.Close()
+	sum := sha256.Sum256(buf.Bytes())
+
+	images, err := asn1.Marshal([]logotypeImage{{
+		Details: logotypeDetails{
+			MediaType: "image/svg+xml",
+			Hashes:    []logotypeHash{{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, Value: sum[:]}},
+			URIs:      []string{"data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var der []byte
+	for _, v := range []asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: images},
+		{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true},
+		{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true},
+	} {
+		if der != nil {
+			v.Bytes = der
+		}
+		if der, err = asn1.Marshal(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return der
+}
+
+// newTestMarkCertificate issues a mark certificate for names embedding svg,
+// and returns it as a PEM bundle along with the issuing root.
+func newTestMarkCertificate(t *testing.T, names []string, svg []byte) ([]byte, *x509.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Mark CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	markType, _ := asn1.MarshalWithParams("Registered Mark", "utf8")
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber:       big.NewInt(2),
+		Subject:            pkix.Name{CommonName: "Example Inc."},
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		DNSNames:           names,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidBIMIExtKeyUsage},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidLogotype, Value: marshalTestLogotype(t, svg)},
+			{Id: oidMarkType, Value: markType},
+		},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), ca
+}
+
+func TestCheckBIMIRecordAssets(t *testing.T) {
+	vmc, ca := newTestMarkCertificate(t, []string{"example.com"}, []byte(testBIMILogo))
+	tampered := strings.Replace(testBIMILogo, "Example Inc.", "Evil Inc.", 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logo.svg", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(testBIMILogo)) })
+	mux.HandleFunc("/tampered.svg", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(tampered)) })
+	mux.HandleFunc("/vmc.pem", func(w http.ResponseWriter, r *http.Request) { w.Write(vmc) })
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	tests := []struct {
+		name            string
+		domain          string
+		record          string
+		roots           *x509.CertPool
+		wantLogoValid   bool
+		wantLogoError   bool
+		wantCertValid   bool
+		wantCertError   bool
+		wantIssue       string
+		wantLogoMatches *bool
+	}{
+		{
+			name:            "valid logo and certificate",
+			domain:          "example.com",
+			record:          "v=BIMI1; l=" + server.URL + "/logo.svg; a=" + server.URL + "/vmc.pem",
+			roots:           roots,
+			wantLogoValid:   true,
+			wantCertValid:   true,
+			wantLogoMatches: utils.PtrTo(true),
+		},
+		{
+			name:            "served logo differs from the certificate",
+			domain:          "example.com",
+			record:          "v=BIMI1; l=" + server.URL + "/tampered.svg; a=" + server.URL + "/vmc.pem",
+			roots:           roots,
+			wantLogoValid:   true,
+			wantIssue:       "embedded logo differs from the logo served at the l= URL",
+			wantLogoMatches: utils.PtrTo(false),
+		},
+		{
+			name:          "certificate for another domain",
+			domain:        "example.net",
+			record:        "v=BIMI1; l=" + server.URL + "/logo.svg; a=" + server.URL + "/vmc.pem",
+			roots:         roots,
+			wantLogoValid: true,
+			wantIssue:     "certificate does not cover example.net",
+		},
+		{
+			name:          "untrusted certificate authority",
+			domain:        "example.com",
+			record:        "v=BIMI1; l=" + server.URL + "/logo.svg; a=" + server.URL + "/vmc.pem",
+			roots:         x509.NewCertPool(),
+			wantLogoValid: true,
+			wantIssue:     "certificate chain could not be verified",
+		},
+		{
+			name:            "no mark certificate authority configured",
+			domain:          "example.com",
+			record:          "v=BIMI1; l=" + server.URL + "/logo.svg; a=" + server.URL + "/vmc.pem",
+			wantLogoValid:   true,
+			wantCertValid:   true,
+			wantLogoMatches: utils.PtrTo(true),
+		},
+		{
+			name:          "missing certificate",
+			domain:        "example.com",
+			record:        "v=BIMI1; l=" + server.URL + "/logo.svg; a=" + server.URL + "/missing.pem",
+			roots:         roots,
+			wantLogoValid: true,
+			wantCertError: true,
+		},
+		{
+			name:          "logo not served over HTTPS",
+			domain:        "example.com",
+			record:        "v=BIMI1; l=" + strings.Replace(server.URL, "https://", "http://", 1) + "/logo.svg",
+			roots:         roots,
+			wantLogoError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &mockDNSResolver{
+				txt: map[string][]string{"default._bimi." + tt.domain: {tt.record}},
+				err: map[string]error{},
+			}
+			analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+			analyzer.HTTPClient = server.Client()
+			analyzer.BIMIRoots = tt.roots
+
+			result := analyzer.checkBIMIRecord(tt.domain, "default")
+			if !result.Valid {
+				t.Fatalf("expected a valid BIMI record, got error %v", result.Error)
+			}
+
+			if result.Logo == nil {
+				t.Fatal("expected a logo validation")
+			}
+			if result.Logo.Valid != tt.wantLogoValid {
+				t.Errorf("Logo.Valid = %v, want %v (issues: %v)", result.Logo.Valid, tt.wantLogoValid, result.Logo.Issues)
+			}
+			if (result.Logo.Error != nil) != tt.wantLogoError {
+				t.Errorf("Logo.Error = %v, want error: %v", result.Logo.Error, tt.wantLogoError)
+			}
+
+			if !strings.Contains(tt.record, "a=") {
+				if result.Certificate != nil {
+					t.Errorf("expected no certificate validation, got %+v", result.Certificate)
+				}
+				return
+			}
+			cert := result.Certificate
+			if cert == nil {
+				t.Fatal("expected a certificate validation")
+			}
+			if (cert.Error != nil) != tt.wantCertError {
+				t.Errorf("Certificate.Error = %v, want error: %v", cert.Error, tt.wantCertError)
+			}
+			if cert.Valid != tt.wantCertValid {
+				t.Errorf("Certificate.Valid = %v, want %v (issues: %v)", cert.Valid, tt.wantCertValid, cert.Issues)
+			}
+			if tt.wantIssue != "" && (cert.Issues == nil || !slices.Contains(*cert.Issues, tt.wantIssue)) {
+				t.Errorf("Certificate.Issues = %v, want %q", cert.Issues, tt.wantIssue)
+			}
+			if tt.roots == nil && !tt.wantCertError && (cert.ChainValid != nil || cert.ChainError == nil) {
+				t.Errorf("Certificate.ChainValid = %v, ChainError = %v, want the chain reported as not verified", cert.ChainValid, cert.ChainError)
+			}
+			if tt.wantLogoMatches != nil && (cert.LogoMatchesSvg == nil || *cert.LogoMatchesSvg != *tt.wantLogoMatches) {
+				t.Errorf("Certificate.LogoMatchesSvg = %v, want %v", cert.LogoMatchesSvg, *tt.wantLogoMatches)
+			}
+			if tt.wantCertValid {
+				if cert.Type == nil || *cert.Type != model.BIMICertificateTypeVmc {
+					t.Errorf("Certificate.Type = %v, want vmc", cert.Type)
+				}
+				if cert.LogoHashValid == nil || !*cert.LogoHashValid {
+					t.Errorf("Certificate.LogoHashValid = %v, want true", cert.LogoHashValid)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBIMIRoots(t *testing.T) {
+	_, ca := newTestMarkCertificate(t, []string{"example.com"}, []byte(testBIMILogo))
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "roots.pem")
+	if err := os.WriteFile(valid, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	roots, err := LoadBIMIRoots(valid)
+	if err != nil {
+		t.Fatalf("LoadBIMIRoots() error = %v", err)
+	}
+	if _, err := ca.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		t.Errorf("the loaded pool does not trust the CA: %v", err)
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{empty, filepath.Join(dir, "missing.pem")} {
+		if _, err := LoadBIMIRoots(filename); err == nil {
+			t.Errorf("LoadBIMIRoots(%q) should fail", filename)
+		}
+	}
+}
+
+func TestBIMISANMatches(t *testing.T) {
+	tests := []struct {
+		names  []string
+		domain string
+		want   bool
+	}{
+		{[]string{"example.com"}, "example.com", true},
+		{[]string{"EXAMPLE.com."}, "example.com", true},
+		{[]string{"example.com"}, "mail.example.com", true},
+		{[]string{"default._bimi.mail.example.com"}, "mail.example.com", true},
+		{[]string{"other.example.com"}, "mail.example.com", false},
+		{nil, "example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := bimiSANMatches(tt.names, tt.domain, "default"); got != tt.want {
+			t.Errorf("bimiSANMatches(%v, %q) = %v, want %v", tt.names, tt.domain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsCacheFallbackTTL is how long answers are kept when the resolver does
+// not tell their TTL, such as custom or mock resolvers.
+const dnsCacheFallbackTTL = time.Minute
+
+// ttlObserverKey is the context key of the function notified of the TTL of
+// the answers a lookup receives.
+type ttlObserverKey struct{}
+
+// withTTLObserver returns a context whose wire lookups report the TTL of
+// their answers to observer.
+func withTTLObserver(ctx context.Context, observer func(ttl uint32)) context.Context {
+	return context.WithValue(ctx, ttlObserverKey{}, observer)
+}
+
+// reportResponseTTL notifies the observer of ctx, if any, of how long resp
+// may be cached: the lowest TTL of its answers or, for a negative answer,
+// the SOA negative caching TTL (RFC 2308).
+func reportResponseTTL(ctx context.Context, resp *dnsmessage.Message) {
+	observer, ok := ctx.Value(ttlObserverKey{}).(func(ttl uint32))
+	if !ok {
+		return
+	}
+
+	found := false
+	var ttl uint32
+	for _, rr := range resp.Answers {
+		if rr.Header.Type != dnsmessage.TypeOPT && (!found || rr.Header.TTL < ttl) {
+			ttl, found = rr.Header.TTL, true
+		}
+	}
+	if !found {
+		for _, rr := range resp.Authorities {
+			if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+				ttl, found = min(rr.Header.TTL, soa.MinTTL), true
+			}
+		}
+	}
+	if found {
+		observer(ttl)
+	}
+}
+
+// DNSCacheStats holds the counters of a DNSCache.
+type DNSCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// DNSCache is a bounded cache of DNS answers honouring their TTLs, shared by
+// the caching resolvers it creates. Not-found answers are cached as well,
+// other errors never are.
+type DNSCache struct {
+	maxEntries int
+	maxTTL     time.Duration
+
+	mu      sync.Mutex
+	entries map[dnsCacheKey]*list.Element
+	lru     *list.List // most recently used first
+	scopes  uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type dnsCacheKey struct {
+	scope uint64
+	kind  string
+	name  string
+}
+
+type dnsCacheEntry struct {
+	key      dnsCacheKey
+	value    any
+	err      error
+	verdicts []dnssecObservation
+	expires  time.Time
+}
+
+// dnssecObservation is a DNSSEC status reported while filling a cache
+// entry, reported again on each hit.
+type dnssecObservation struct {
+	name    string
+	qtype   dnsmessage.Type
+	verdict dnssecVerdict
+}
+
+// NewDNSCache creates a cache holding at most maxEntries answers, each for
+// at most maxTTL whatever their TTL.
+func NewDNSCache(maxEntries int, maxTTL time.Duration) *DNSCache {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	if maxTTL <= 0 {
+		maxTTL = time.Hour
+	}
+	return &DNSCache{
+		maxEntries: maxEntries,
+		maxTTL:     maxTTL,
+		entries:    map[dnsCacheKey]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// Resolver returns a resolver answering from the cache, querying resolver
+// on misses. Each returned resolver has its own entries, as resolvers may
+// give different answers. A StandardDNSResolver relying on net.Resolver,
+// which hides the TTLs, queries the system nameservers directly instead:
+// /etc/hosts and the search domains of /etc/resolv.conf then don't apply.
+func (c *DNSCache) Resolver(resolver DNSResolver) *CachingDNSResolver {
+	if standard, ok := resolver.(*StandardDNSResolver); ok && standard.resolver != nil {
+		resolver = &StandardDNSResolver{upstreams: standard.upstreams}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes++
+	return &CachingDNSResolver{cache: c, resolver: resolver, scope: c.scopes}
+}
+
+// Stats returns the hit and miss counters of the cache, and its size.
+func (c *DNSCache) Stats() DNSCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return DNSCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+func (c *DNSCache) get(key dnsCacheKey) (*dnsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*dnsCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *DNSCache) put(entry *dnsCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).key)
+	}
+}
+
+// CachingDNSResolver is a DNSResolver answering from a DNSCache.
+type CachingDNSResolver struct {
+	cache    *DNSCache
+	resolver DNSResolver
+	scope    uint64
+	refresh  bool
+}
+
+// Fresh returns a resolver sharing the cache of r that always queries the
+// underlying resolver, updating the cache with the answers.
+func (r *CachingDNSResolver) Fresh() *CachingDNSResolver {
+	fresh := *r
+	fresh.refresh = true
+	return &fresh
+}
+
+// freshDNSResolver bypasses the cache of resolver, if any.
+func freshDNSResolver(resolver DNSResolver) DNSResolver {
+	if caching, ok := resolver.(*CachingDNSResolver); ok {
+		return caching.Fresh()
+	}
+	return resolver
+}
+
+// cached returns the answer of the lookup of kind at name from the cache,
+// or fills the cache with the outcome of lookup.
+func (r *CachingDNSResolver) cached(ctx context.Context, kind, name string, lookup func(ctx context.Context) (any, error)) (any, error) {
+	key := dnsCacheKey{scope: r.scope, kind: kind, name: strings.ToLower(strings.TrimSuffix(name, "."))}
+
+	if !r.refresh {
+		if entry, ok := r.cache.get(key); ok {
+			r.cache.hits.Add(1)
+			for _, o := range entry.verdicts {
+				reportDNSSEC(ctx, o.name, o.qtype, o.verdict)
+			}
+			return entry.value, entry.err
+		}
+	}
+	r.cache.misses.Add(1)
+
+	var mu sync.Mutex
+	ttl := dnsCacheFallbackTTL
+	reported := false
+	var verdicts []dnssecObservation
+	lookupCtx := withTTLObserver(ctx, func(answerTTL uint32) {
+		mu.Lock()
+		defer mu.Unlock()
+		if d := time.Duration(answerTTL) * time.Second; !reported || d < ttl {
+			ttl, reported = d, true
+		}
+	})
+	lookupCtx = withDNSSECObserver(lookupCtx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+		mu.Lock()
+		verdicts = append(verdicts, dnssecObservation{name, qtype, verdict})
+		mu.Unlock()
+		reportDNSSEC(ctx, name, qtype, verdict)
+	})
+
+	value, err := lookup(lookupCtx)
+	if err != nil && !isDNSNotFound(err) {
+		return value, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ttl = min(ttl, r.cache.maxTTL); ttl > 0 {
+		r.cache.put(&dnsCacheEntry{
+			key:      key,
+			value:    value,
+			err:      err,
+			verdicts: verdicts,
+			expires:  time.Now().Add(ttl),
+		})
+	}
+	return value, err
+}
+
+// cachedSlice is cached for lookups returning a slice, handing out copies
+// made by clone that callers are free to modify.
+func cachedSlice[S ~[]E, E any](ctx context.Context, r *CachingDNSResolver, kind, name string, clone func(S) S, lookup func(ctx context.Context) (S, error)) (S, error) {
+	value, err := r.cached(ctx, kind, name, func(ctx context.Context) (any, error) {
+		return lookup(ctx)
+	})
+	return clone(value.(S)), err
+}
+
+// clonePointers copies records along with the records they point to.
+func clonePointers[E any](records []*E) []*E {
+	if records == nil {
+		return nil
+	}
+	clone := make([]*E, len(records))
+	for i, record := range records {
+		if record != nil {
+			copied := *record
+			clone[i] = &copied
+		}
+	}
+	return clone
+}
+
+// cloneTLSA copies TLSA records, their association data included.
+func cloneTLSA(records []*TLSA) []*TLSA {
+	clone := clonePointers(records)
+	for _, record := range clone {
+		if record != nil {
+			record.Data = bytes.Clone(record.Data)
+		}
+	}
+	return clone
+}
+
+// LookupMX implements DNSResolver.LookupMX.
+func (r *CachingDNSResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return cachedSlice(ctx, r, "MX", name, clonePointers, func(ctx context.Context) ([]*net.MX, error) {
+		return r.resolver.LookupMX(ctx, name)
+	})
+}
+
+// LookupTXT implements DNSResolver.LookupTXT.
+func (r *CachingDNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return cachedSlice(ctx, r, "TXT", name, slices.Clone, func(ctx context.Context) ([]string, error) {
+		return r.resolver.LookupTXT(ctx, name)
+	})
+}
+
+// LookupAddr implements DNSResolver.LookupAddr.
+func (r *CachingDNSResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return cachedSlice(ctx, r, "PTR", addr, slices.Clone, func(ctx context.Context) ([]string, error) {
+		return r.resolver.LookupAddr(ctx, addr)
+	})
+}
+
+// LookupHost implements DNSResolver.LookupHost.
+func (r *CachingDNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return cachedSlice(ctx, r, "HOST", host, slices.Clone, func(ctx context.Context) ([]string, error) {
+		return r.resolver.LookupHost(ctx, host)
+	})
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *CachingDNSResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	return cachedSlice(ctx, r, "NS", name, clonePointers, func(ctx context.Context) ([]*net.NS, error) {
+		return extendedResolver(r.resolver).LookupNS(ctx, name)
+	})
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME.
+func (r *CachingDNSResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	value, err := r.cached(ctx, "CNAME", name, func(ctx context.Context) (any, error) {
+		return extendedResolver(r.resolver).LookupCNAME(ctx, name)
+	})
+	return value.(string), err
+}
+
+// tlsaAnswer is the outcome of a TLSA lookup kept in the cache.
+type tlsaAnswer struct {
+	records       []*TLSA
+	authenticated bool
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA.
+func (r *CachingDNSResolver) LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error) {
+	value, err := r.cached(ctx, "TLSA", name, func(ctx context.Context) (any, error) {
+		records, authenticated, err := extendedResolver(r.resolver).LookupTLSA(ctx, name)
+		return tlsaAnswer{records, authenticated}, err
+	})
+	answer := value.(tlsaAnswer)
+	return cloneTLSA(answer.records), answer.authenticated, err
+}
@@ -0,0 +1,271 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestCachingDNSResolver(t *testing.T) {
+	var queries atomic.Int32
+	addr := startTestDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		queries.Add(1)
+		hdr := func(ttl uint32) dnsmessage.ResourceHeader {
+			return dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: ttl}
+		}
+		msg := dnsmessage.Message{Header: dnsmessage.Header{RecursionAvailable: true}}
+		switch q.Name.String() {
+		case "cached.test.":
+			msg.Answers = []dnsmessage.Resource{{Header: hdr(300), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}}}
+		case "volatile.test.":
+			msg.Answers = []dnsmessage.Resource{{Header: hdr(0), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}}}
+		case "missing.test.":
+			msg.RCode = dnsmessage.RCodeNameError
+			msg.Authorities = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("test."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+				Body: &dnsmessage.SOAResource{
+					NS:     dnsmessage.MustNewName("ns.test."),
+					MBox:   dnsmessage.MustNewName("hostmaster.test."),
+					MinTTL: 60,
+				},
+			}}
+		default:
+			msg.RCode = dnsmessage.RCodeServerFailure
+		}
+		return msg
+	})
+
+	cache := NewDNSCache(100, time.Hour)
+	resolver := cache.Resolver(NewStandardDNSResolverWithUpstreams([]DNSUpstream{{Network: "udp", Address: addr}}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lookupTwice := func(name string) error {
+		t.Helper()
+		queries.Store(0)
+		for range 2 {
+			if _, err := resolver.LookupTXT(ctx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := lookupTwice("cached.test"); err != nil || queries.Load() != 1 {
+		t.Errorf("cached.test: err = %v, %d queries, want 1", err, queries.Load())
+	}
+	if err := lookupTwice("volatile.test"); err != nil || queries.Load() != 2 {
+		t.Errorf("volatile.test: err = %v, %d queries, want 2 with a zero TTL", err, queries.Load())
+	}
+
+	queries.Store(0)
+	for range 2 {
+		if _, err := resolver.LookupTXT(ctx, "missing.test"); !isDNSNotFound(err) {
+			t.Errorf("missing.test: err = %v, want not found", err)
+		}
+	}
+	if queries.Load() != 1 {
+		t.Errorf("missing.test: %d queries, want 1 with negative caching", queries.Load())
+	}
+
+	queries.Store(0)
+	for range 2 {
+		if _, err := resolver.LookupTXT(ctx, "broken.test"); err == nil || isDNSNotFound(err) {
+			t.Errorf("broken.test: err = %v, want a server failure", err)
+		}
+	}
+	if queries.Load() != 2 {
+		t.Errorf("broken.test: %d queries, failures must not be cached", queries.Load())
+	}
+
+	// Callers may modify the answers they get
+	txt, _ := resolver.LookupTXT(ctx, "cached.test")
+	txt[0] = "modified"
+	if txt, _ := resolver.LookupTXT(ctx, "cached.test"); !slices.Equal(txt, []string{"v=spf1 -all"}) {
+		t.Errorf("cached answer was modified: %v", txt)
+	}
+
+	// A fresh resolver queries again, and refreshes the cache
+	queries.Store(0)
+	if _, err := resolver.Fresh().LookupTXT(ctx, "cached.test"); err != nil || queries.Load() != 1 {
+		t.Errorf("fresh lookup: err = %v, %d queries, want 1", err, queries.Load())
+	}
+	if _, err := resolver.LookupTXT(ctx, "cached.test"); err != nil || queries.Load() != 1 {
+		t.Errorf("lookup after refresh: err = %v, %d queries, want 1", err, queries.Load())
+	}
+
+	// Resolvers of the same cache don't share their entries
+	other := cache.Resolver(NewStandardDNSResolverWithUpstreams([]DNSUpstream{{Network: "udp", Address: addr}}))
+	if _, err := other.LookupTXT(ctx, "cached.test"); err != nil || queries.Load() != 2 {
+		t.Errorf("other resolver: err = %v, %d queries, want 2", err, queries.Load())
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 5 || stats.Misses != 8 || stats.Entries != 3 {
+		t.Errorf("stats = %+v, want 5 hits, 8 misses and 3 entries", stats)
+	}
+	// The system resolver queries its nameservers directly to learn the TTLs
+	system := cache.Resolver(&StandardDNSResolver{
+		resolver:  &net.Resolver{PreferGo: true},
+		upstreams: []DNSUpstream{{Network: "udp", Address: addr}},
+	})
+	queries.Store(0)
+	for range 2 {
+		if _, err := system.LookupTXT(ctx, "volatile.test"); err != nil {
+			t.Errorf("system resolver: err = %v", err)
+		}
+	}
+	if queries.Load() != 2 {
+		t.Errorf("system resolver: %d queries, want 2 with a zero TTL", queries.Load())
+	}
+}
+
+func TestCachingDNSResolverCopies(t *testing.T) {
+	resolver := NewDNSCache(100, time.Hour).Resolver(&spfMockResolver{
+		mx:   map[string][]*net.MX{"example.com": {{Host: "mx.example.com.", Pref: 10}}},
+		tlsa: map[string][]*TLSA{"_25._tcp.mx.example.com": {{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{1, 2, 3}}}},
+	})
+	ctx := context.Background()
+
+	mxs, _ := resolver.LookupMX(ctx, "example.com")
+	mxs[0].Host = "modified."
+	if mxs, _ := resolver.LookupMX(ctx, "example.com"); mxs[0].Host != "mx.example.com." {
+		t.Errorf("cached MX record was modified: %+v", mxs[0])
+	}
+
+	tlsas, _, _ := resolver.LookupTLSA(ctx, "_25._tcp.mx.example.com")
+	tlsas[0].Usage = 2
+	tlsas[0].Data[0] = 0
+	if tlsas, _, _ := resolver.LookupTLSA(ctx, "_25._tcp.mx.example.com"); tlsas[0].Usage != 3 || tlsas[0].Data[0] != 1 {
+		t.Errorf("cached TLSA record was modified: %+v", tlsas[0])
+	}
+}
+
+func TestDNSCacheBounds(t *testing.T) {
+	mock := &countingResolver{spfMockResolver: spfMockResolver{txt: map[string][]string{
+		"a.test": {"a"},
+		"b.test": {"b"},
+		"c.test": {"c"},
+	}}}
+	ctx := context.Background()
+
+	t.Run("least recently used entries are evicted", func(t *testing.T) {
+		mock.calls.Store(0)
+		resolver := NewDNSCache(2, time.Hour).Resolver(mock)
+		for _, name := range []string{"a.test", "b.test", "a.test", "c.test", "a.test", "b.test"} {
+			resolver.LookupTXT(ctx, name)
+		}
+		// b.test is evicted by c.test, a.test having been used since
+		if calls := mock.calls.Load(); calls != 4 {
+			t.Errorf("%d lookups, want 4", calls)
+		}
+		if entries := resolver.cache.Stats().Entries; entries != 2 {
+			t.Errorf("%d entries, want 2", entries)
+		}
+	})
+
+	t.Run("answers expire after the maximum TTL", func(t *testing.T) {
+		mock.calls.Store(0)
+		resolver := NewDNSCache(10, 20*time.Millisecond).Resolver(mock)
+		resolver.LookupTXT(ctx, "a.test")
+		resolver.LookupTXT(ctx, "a.test")
+		time.Sleep(30 * time.Millisecond)
+		resolver.LookupTXT(ctx, "a.test")
+		if calls := mock.calls.Load(); calls != 2 {
+			t.Errorf("%d lookups, want 2", calls)
+		}
+	})
+}
+
+// countingResolver counts the TXT lookups reaching a spfMockResolver.
+type countingResolver struct {
+	spfMockResolver
+	calls atomic.Int32
+}
+
+func (m *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	m.calls.Add(1)
+	return m.spfMockResolver.LookupTXT(ctx, name)
+}
+
+func TestCachingDNSResolverDNSSEC(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	resolver := NewDNSCache(100, time.Hour).Resolver(tree.resolver())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := resolver.LookupTXT(ctx, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.Lock()
+	first := tree.queries
+	tree.mu.Unlock()
+
+	// Cached answers still report their DNSSEC status
+	var got []dnssecVerdict
+	observed := withDNSSECObserver(ctx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+		got = append(got, verdict)
+	})
+	if _, err := resolver.LookupTXT(observed, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.Lock()
+	second := tree.queries - first
+	tree.mu.Unlock()
+
+	if second != 0 {
+		t.Errorf("cached lookup sent %d queries", second)
+	}
+	if len(got) != 1 || got[0].status != model.DNSSECAnswerStatusSecure {
+		t.Errorf("reported %+v, want a single secure status", got)
+	}
+}
+
+func TestReportGeneratorWithFreshDNS(t *testing.T) {
+	cache := NewDNSCache(100, time.Hour)
+	resolver := cache.Resolver(&spfMockResolver{})
+	generator := NewReportGeneratorWithResolvers(resolver, resolver, "mx.test", time.Second, time.Second, nil, nil, false, "")
+
+	fresh := generator.withFreshDNS()
+	for name, r := range map[string]DNSResolver{
+		"authentication": fresh.authAnalyzer.resolver,
+		"dns":            fresh.dnsAnalyzer.resolver,
+		"rbl":            fresh.rblChecker.resolver,
+		"dnswl":          fresh.dnswlChecker.resolver,
+	} {
+		if caching, ok := r.(*CachingDNSResolver); !ok || !caching.refresh {
+			t.Errorf("%s resolver does not bypass the cache", name)
+		}
+	}
+	if generator.dnsAnalyzer.resolver != DNSResolver(resolver) {
+		t.Error("the original generator was modified")
+	}
+}
@@ -0,0 +1,171 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+var (
+	tlsaUsageNames        = []string{"PKIX-TA", "PKIX-EE", "DANE-TA", "DANE-EE"}
+	tlsaSelectorNames     = []string{"Cert", "SPKI"}
+	tlsaMatchingTypeNames = []string{"Full", "SHA2-256", "SHA2-512"}
+
+	// tlsaDigestSizes is the data length expected for each matching type;
+	// 0 means variable.
+	tlsaDigestSizes = []int{0, 32, 64}
+)
+
+// checkDANERecords looks up the TLSA records of every MX host of a domain.
+// It returns nil when the resolver can't look up TLSA records.
+func (d *DNSAnalyzer) checkDANERecords(mxRecords *[]model.MXRecord) *[]model.DANERecord {
+	if mxRecords == nil {
+		return nil
+	}
+
+	var results []model.DANERecord
+	seen := make(map[string]bool)
+	for _, mx := range *mxRecords {
+		host := strings.ToLower(strings.TrimSuffix(mx.Host, "."))
+		// Skip failed lookups and null MX (RFC 7505)
+		if !mx.Valid || host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		record, supported := d.checkDANERecord(host)
+		if !supported {
+			return nil
+		}
+		results = append(results, record)
+	}
+
+	if len(results) == 0 {
+		return nil
+	}
+	return &results
+}
+
+// checkDANERecord looks up and validates the TLSA records of an MX host
+// (RFC 7672). It reports false when the resolver can't look them up.
+func (d *DNSAnalyzer) checkDANERecord(host string) (model.DANERecord, bool) {
+	result := model.DANERecord{
+		Host:     host,
+		TlsaName: "_25._tcp." + host,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	records, authenticated, err := extendedResolver(d.resolver).LookupTLSA(ctx, result.TlsaName)
+	if errors.Is(err, errUnsupportedLookup) {
+		return result, false
+	}
+	result.Dnssec = authenticated
+	if err != nil {
+		if isDNSNotFound(err) {
+			result.Error = utils.PtrTo("No TLSA records found")
+		} else {
+			result.Error = utils.PtrTo(fmt.Sprintf("Failed to lookup TLSA records: %s", formatDNSError(err)))
+		}
+		return result, true
+	}
+
+	usable := 0
+	tlsaRecords := make([]model.TLSARecord, 0, len(records))
+	for _, record := range records {
+		tlsa := checkTLSARecord(record)
+		if tlsa.Valid {
+			usable++
+		}
+		tlsaRecords = append(tlsaRecords, tlsa)
+	}
+	result.Records = &tlsaRecords
+
+	switch {
+	case !authenticated:
+		result.Error = utils.PtrTo("TLSA records are not DNSSEC-authenticated: senders ignore them")
+	case usable == 0:
+		result.Error = utils.PtrTo("No usable TLSA record: senders cannot authenticate this host")
+	default:
+		result.Valid = true
+	}
+
+	return result, true
+}
+
+// checkTLSARecord validates the parameters and association data of a TLSA
+// record for use with SMTP.
+func checkTLSARecord(record *TLSA) model.TLSARecord {
+	tlsa := model.TLSARecord{
+		Usage:        int(record.Usage),
+		Selector:     int(record.Selector),
+		MatchingType: int(record.MatchingType),
+		Data:         hex.EncodeToString(record.Data),
+	}
+	invalid := func(format string, args ...any) model.TLSARecord {
+		tlsa.Error = utils.PtrTo(fmt.Sprintf(format, args...))
+		return tlsa
+	}
+
+	if int(record.Usage) >= len(tlsaUsageNames) {
+		return invalid("Unknown certificate usage %d", record.Usage)
+	}
+	if int(record.Selector) >= len(tlsaSelectorNames) {
+		return invalid("Unknown selector %d", record.Selector)
+	}
+	if int(record.MatchingType) >= len(tlsaMatchingTypeNames) {
+		return invalid("Unknown matching type %d", record.MatchingType)
+	}
+	tlsa.Description = utils.PtrTo(fmt.Sprintf("%s %s %s",
+		tlsaUsageNames[record.Usage], tlsaSelectorNames[record.Selector], tlsaMatchingTypeNames[record.MatchingType]))
+
+	if size := tlsaDigestSizes[record.MatchingType]; size != 0 && len(record.Data) != size {
+		return invalid("%s data must be %d bytes long, got %d", tlsaMatchingTypeNames[record.MatchingType], size, len(record.Data))
+	}
+	if record.MatchingType == 0 {
+		var err error
+		if record.Selector == 0 {
+			_, err = x509.ParseCertificate(record.Data)
+		} else {
+			_, err = x509.ParsePKIXPublicKey(record.Data)
+		}
+		if err != nil {
+			return invalid("Association data is not a valid %s: %s", tlsaSelectorNames[record.Selector], err)
+		}
+	}
+
+	// SMTP has no agreed set of trust anchors: PKIX usages are unusable
+	if record.Usage < 2 {
+		return invalid("%s usage is not supported for SMTP (RFC 7672 section 3.1.3): use DANE-TA or DANE-EE", tlsaUsageNames[record.Usage])
+	}
+
+	tlsa.Valid = true
+	return tlsa
+}
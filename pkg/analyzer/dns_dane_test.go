@@ -0,0 +1,172 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestCheckTLSARecord(t *testing.T) {
+	digest256 := bytes.Repeat([]byte{0x01}, 32)
+	digest512 := bytes.Repeat([]byte{0x02}, 64)
+
+	tests := []struct {
+		name      string
+		record    TLSA
+		wantValid bool
+		wantDesc  string
+		wantErr   string
+	}{
+		{name: "DANE-EE SPKI SHA2-256", record: TLSA{3, 1, 1, digest256}, wantValid: true, wantDesc: "DANE-EE SPKI SHA2-256"},
+		{name: "DANE-TA Cert SHA2-512", record: TLSA{2, 0, 2, digest512}, wantValid: true, wantDesc: "DANE-TA Cert SHA2-512"},
+		{name: "PKIX-EE is unusable for SMTP", record: TLSA{1, 1, 1, digest256}, wantDesc: "PKIX-EE SPKI SHA2-256", wantErr: "not supported for SMTP"},
+		{name: "unknown usage", record: TLSA{4, 1, 1, digest256}, wantErr: "Unknown certificate usage 4"},
+		{name: "unknown selector", record: TLSA{3, 2, 1, digest256}, wantErr: "Unknown selector 2"},
+		{name: "unknown matching type", record: TLSA{3, 1, 3, digest256}, wantErr: "Unknown matching type 3"},
+		{name: "wrong digest length", record: TLSA{3, 1, 1, digest512}, wantErr: "must be 32 bytes long"},
+		{name: "full data that is not a key", record: TLSA{3, 1, 0, []byte("not a key")}, wantErr: "not a valid SPKI"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkTLSARecord(&tt.record)
+			if got.Valid != tt.wantValid {
+				t.Errorf("Valid = %t, want %t (error: %v)", got.Valid, tt.wantValid, got.Error)
+			}
+			if tt.wantDesc != "" && (got.Description == nil || *got.Description != tt.wantDesc) {
+				t.Errorf("Description = %v, want %q", got.Description, tt.wantDesc)
+			}
+			if tt.wantErr != "" && (got.Error == nil || !strings.Contains(*got.Error, tt.wantErr)) {
+				t.Errorf("Error = %v, want to contain %q", got.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDANERecords(t *testing.T) {
+	usable := &TLSA{3, 1, 1, bytes.Repeat([]byte{0x01}, 32)}
+	pkix := &TLSA{1, 1, 1, bytes.Repeat([]byte{0x01}, 32)}
+
+	resolver := &spfMockResolver{
+		tlsa: map[string][]*TLSA{
+			"_25._tcp.mx1.example.com": {usable},
+			"_25._tcp.mx2.example.com": {usable},
+			"_25._tcp.mx3.example.com": {pkix},
+		},
+		signed: map[string]bool{
+			"_25._tcp.mx1.example.com": true,
+			"_25._tcp.mx3.example.com": true,
+			"_25._tcp.mx4.example.com": true,
+		},
+		err: map[string]error{
+			"_25._tcp.mx5.example.com": errors.New("server failure"),
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	mx := []model.MXRecord{
+		{Host: "mx1.example.com.", Priority: 10, Valid: true},
+		{Host: "MX1.example.com", Priority: 15, Valid: true},
+		{Host: "mx2.example.com.", Priority: 20, Valid: true},
+		{Host: "mx3.example.com.", Priority: 30, Valid: true},
+		{Host: "mx4.example.com.", Priority: 40, Valid: true},
+		{Host: "mx5.example.com.", Priority: 50, Valid: true},
+		{Host: ".", Priority: 0, Valid: true},
+	}
+
+	results := analyzer.checkDANERecords(&mx)
+	if results == nil || len(*results) != 5 {
+		t.Fatalf("expected 5 DANE results (duplicates and null MX skipped), got %v", results)
+	}
+
+	want := []struct {
+		host    string
+		valid   bool
+		dnssec  bool
+		wantErr string
+	}{
+		{"mx1.example.com", true, true, ""},
+		{"mx2.example.com", false, false, "not DNSSEC-authenticated"},
+		{"mx3.example.com", false, true, "No usable TLSA record"},
+		{"mx4.example.com", false, true, "No TLSA records found"},
+		{"mx5.example.com", false, false, "Failed to lookup TLSA records"},
+	}
+	for i, w := range want {
+		got := (*results)[i]
+		if got.Host != w.host || got.Valid != w.valid || got.Dnssec != w.dnssec {
+			t.Errorf("result %d = {%s valid=%t dnssec=%t}, want {%s valid=%t dnssec=%t}", i, got.Host, got.Valid, got.Dnssec, w.host, w.valid, w.dnssec)
+		}
+		if w.wantErr != "" && (got.Error == nil || !strings.Contains(*got.Error, w.wantErr)) {
+			t.Errorf("%s: Error = %v, want to contain %q", w.host, got.Error, w.wantErr)
+		}
+	}
+}
+
+func TestCheckDANERecordsBasicResolver(t *testing.T) {
+	// A DNSResolver without the extended lookups, as outside implementations
+	// written before they existed
+	basic := struct{ DNSResolver }{&spfMockResolver{
+		tlsa: map[string][]*TLSA{
+			"_25._tcp.mx1.example.com": {{3, 1, 1, bytes.Repeat([]byte{0x01}, 32)}},
+		},
+		ns: map[string][]*net.NS{
+			"example.com": {{Host: "ns1.example.com."}},
+		},
+	}}
+	mx := []model.MXRecord{{Host: "mx1.example.com.", Priority: 10, Valid: true}}
+
+	for name, resolver := range map[string]DNSResolver{
+		"direct": basic,
+		"cached": NewDNSCache(0, 0).Resolver(basic),
+	} {
+		analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+		if results := analyzer.checkDANERecords(&mx); results != nil {
+			t.Errorf("%s: checkDANERecords() = %v, want the check to be skipped", name, *results)
+		}
+		if result := analyzer.checkNSConsistency("example.com", nil); result != nil {
+			t.Errorf("%s: checkNSConsistency() = %+v, want the check to be skipped", name, result)
+		}
+	}
+}
+
+func TestFlagMissingTLSRPTWithTLSA(t *testing.T) {
+	results := &model.DNSResults{
+		DaneRecords: &[]model.DANERecord{{
+			Host:     "mx.example.com",
+			TlsaName: "_25._tcp.mx.example.com",
+			Records:  &[]model.TLSARecord{{Usage: 3, Selector: 1, MatchingType: 1, Valid: true}},
+		}},
+		TlsRptRecord: &model.TLSRPTRecord{Domain: "example.com"},
+	}
+
+	flagMissingTLSRPT(results)
+	if results.TlsRptRecord.Warnings == nil || !strings.Contains((*results.TlsRptRecord.Warnings)[0], "TLSA") {
+		t.Errorf("Warnings = %v, want TLSA to be flagged", results.TlsRptRecord.Warnings)
+	}
+}
@@ -27,11 +27,51 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync"
 
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/utils"
 )
 
+// dkimProbeWorkers is the number of selectors looked up at once when
+// probing the DKIM keys of a domain.
+const dkimProbeWorkers = 8
+
+// DefaultDKIMSelectors is a list of commonly used DKIM selectors, probed
+// by domain-only tests
+var DefaultDKIMSelectors = []string{
+	"default",     // Common default (cPanel, Plesk, OpenDKIM setups)
+	"dkim",        // Common default
+	"mail",        // Common default
+	"google",      // Google Workspace
+	"selector1",   // Microsoft 365
+	"selector2",   // Microsoft 365
+	"k1",          // Mailchimp
+	"k2",          // Mailchimp
+	"k3",          // Mailchimp
+	"s1",          // SendGrid
+	"s2",          // SendGrid
+	"smtpapi",     // SendGrid (legacy)
+	"mandrill",    // Mandrill
+	"mailjet",     // Mailjet
+	"mx",          // Mailgun
+	"krs",         // Mailgun
+	"pic",         // Mailgun
+	"cm",          // Campaign Monitor
+	"hs1",         // HubSpot
+	"hs2",         // HubSpot
+	"zoho",        // Zoho Mail
+	"zmail",       // Zoho Mail
+	"fm1",         // Fastmail
+	"fm2",         // Fastmail
+	"fm3",         // Fastmail
+	"protonmail",  // Proton Mail
+	"protonmail2", // Proton Mail
+	"protonmail3", // Proton Mail
+	"sig1",        // iCloud Mail
+	"mxvault",     // MXroute
+}
+
 // DKIMHeader holds the domain, selector and signing algorithm from a DKIM-Signature header.
 type DKIMHeader struct {
 	Domain    string
@@ -181,6 +221,20 @@ func (d *DNSAnalyzer) checkDKIMRecord(h DKIMHeader) *model.DKIMRecord {
 		keyType = "rsa" // RFC 6376 default
 	}
 
+	// An empty key means the key has been revoked (RFC 6376 section 3.6.1)
+	if tags["p"] == "" {
+		return &model.DKIMRecord{
+			Selector:         h.Selector,
+			Domain:           h.Domain,
+			Record:           &dkimRecord,
+			KeyType:          utils.PtrTo(keyType),
+			SigningAlgorithm: signingAlgorithmPtr(h.Algorithm),
+			Revoked:          utils.PtrTo(true),
+			Valid:            false,
+			Error:            utils.PtrTo("DKIM key has been revoked (empty p= tag)"),
+		}
+	}
+
 	var hashAlgorithms []string
 	if h, ok := tags["h"]; ok && h != "" {
 		for _, alg := range strings.Split(h, ":") {
@@ -193,16 +247,89 @@ func (d *DNSAnalyzer) checkDKIMRecord(h DKIMHeader) *model.DKIMRecord {
 		hashAlgorithms = []string{}
 	}
 
-	return &model.DKIMRecord{
+	keySize := parseKeySize(keyType, tags["p"])
+
+	record := &model.DKIMRecord{
 		Selector:         h.Selector,
 		Domain:           h.Domain,
 		Record:           &dkimRecord,
 		KeyType:          utils.PtrTo(keyType),
 		HashAlgorithms:   &hashAlgorithms,
 		SigningAlgorithm: signingAlgorithmPtr(h.Algorithm),
-		KeySize:          parseKeySize(keyType, tags["p"]),
+		KeySize:          keySize,
 		Valid:            true,
 	}
+	// RSA keys of 1024 bits or less can be factored (RFC 8301)
+	if strings.EqualFold(keyType, "rsa") && keySize != nil && *keySize <= 1024 {
+		record.WeakKey = utils.PtrTo(true)
+	}
+	return record
+}
+
+// probeDKIMSelectors looks up the DKIM keys published under each selector
+// at domain, returning the records found in the order of the selectors.
+// Selectors without a DKIM record are left out.
+func (d *DNSAnalyzer) probeDKIMSelectors(domain string, selectors []string) []model.DKIMRecord {
+	found := make([]*model.DKIMRecord, len(selectors))
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range min(dkimProbeWorkers, len(selectors)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				record := d.checkDKIMRecord(DKIMHeader{Domain: domain, Selector: selectors[i]})
+				if record.Valid || utils.DerefOrZero(record.Revoked) || isDKIMKeyRecord(record) {
+					found[i] = record
+				}
+			}
+		}()
+	}
+	for i, selector := range selectors {
+		if isValidDKIMSelector(selector) {
+			next <- i
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	records := []model.DKIMRecord{}
+	for _, record := range found {
+		if record != nil {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// isDKIMKeyRecord tells whether an invalid record found while probing is
+// still a DKIM key record, rather than a lookup failure or an unrelated
+// record (e.g. a wildcard TXT record).
+func isDKIMKeyRecord(record *model.DKIMRecord) bool {
+	if record.Record == nil {
+		return false
+	}
+	v, hasV := parseDKIMTags(*record.Record)["v"]
+	return hasV && strings.EqualFold(v, "DKIM1")
+}
+
+// isValidDKIMSelector tells whether selector is made of DNS labels.
+func isValidDKIMSelector(selector string) bool {
+	if selector == "" || len(selector) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(selector, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 func signingAlgorithmPtr(a string) *string {
@@ -26,8 +26,12 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseDKIMSignatures(t *testing.T) {
@@ -306,6 +310,112 @@ func TestCheckDKIMRecordUnrelatedTXT(t *testing.T) {
 	}
 }
 
+func TestCheckDKIMRecordKeyFlags(t *testing.T) {
+	rsaKey1024, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaKey2048, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der1024, _ := x509.MarshalPKIXPublicKey(&rsaKey1024.PublicKey)
+	der2048, _ := x509.MarshalPKIXPublicKey(&rsaKey2048.PublicKey)
+
+	analyzer := newMockAnalyzer(map[string][]string{
+		"revoked._domainkey.example.com": {"v=DKIM1; k=rsa; p="},
+		"weak._domainkey.example.com":    {"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der1024)},
+		"strong._domainkey.example.com":  {"v=DKIM1; p=" + base64.StdEncoding.EncodeToString(der2048)},
+		"ed._domainkey.example.com":      {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS"},
+	}, nil)
+
+	tests := []struct {
+		selector    string
+		wantValid   bool
+		wantRevoked bool
+		wantWeak    bool
+	}{
+		{selector: "revoked", wantRevoked: true},
+		{selector: "weak", wantValid: true, wantWeak: true},
+		{selector: "strong", wantValid: true},
+		{selector: "ed", wantValid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			rec := analyzer.checkDKIMRecord(DKIMHeader{Domain: "example.com", Selector: tt.selector})
+			if rec.Valid != tt.wantValid {
+				t.Errorf("Valid = %t, want %t (error: %v)", rec.Valid, tt.wantValid, rec.Error)
+			}
+			if got := rec.Revoked != nil && *rec.Revoked; got != tt.wantRevoked {
+				t.Errorf("Revoked = %t, want %t", got, tt.wantRevoked)
+			}
+			if got := rec.WeakKey != nil && *rec.WeakKey; got != tt.wantWeak {
+				t.Errorf("WeakKey = %t, want %t", got, tt.wantWeak)
+			}
+		})
+	}
+}
+
+func TestProbeDKIMSelectors(t *testing.T) {
+	analyzer := newMockAnalyzer(map[string][]string{
+		"google._domainkey.example.com":    {"v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA"},
+		"selector1._domainkey.example.com": {"v=DKIM1; k=rsa; p="},
+		"k1._domainkey.example.com":        {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS"},
+		"s1._domainkey.example.com":        {"google-site-verification=abc"},
+	}, map[string]error{
+		"s2._domainkey.example.com": errors.New("server failure"),
+	})
+
+	records := analyzer.probeDKIMSelectors("example.com", []string{"default", "google", "selector1", "s1", "s2", "k1", "bad selector"})
+
+	var selectors []string
+	for _, rec := range records {
+		selectors = append(selectors, rec.Selector)
+	}
+	if want := []string{"google", "selector1", "k1"}; !slices.Equal(selectors, want) {
+		t.Fatalf("found selectors %v, want %v", selectors, want)
+	}
+	if records[1].Revoked == nil || !*records[1].Revoked {
+		t.Errorf("expected selector1 to be reported as revoked, got %+v", records[1])
+	}
+	if records[2].KeySize == nil || *records[2].KeySize != 256 {
+		t.Errorf("expected the Ed25519 key size, got %v", records[2].KeySize)
+	}
+}
+
+func TestProbeDKIMSelectorsConcurrency(t *testing.T) {
+	resolver := &delayResolver{
+		spfMockResolver: spfMockResolver{txt: map[string][]string{
+			"s42._domainkey.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS"},
+		}},
+		delay: 5 * time.Millisecond,
+	}
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, resolver)
+
+	var selectors []string
+	for i := range 50 {
+		selectors = append(selectors, fmt.Sprintf("s%d", i))
+	}
+	records := analyzer.probeDKIMSelectors("example.com", selectors)
+
+	if len(records) != 1 || records[0].Selector != "s42" {
+		t.Errorf("records = %+v, want the s42 selector", records)
+	}
+	if n := resolver.maxInFlight.Load(); n > dkimProbeWorkers {
+		t.Errorf("%d lookups in flight, want at most %d", n, dkimProbeWorkers)
+	}
+}
+
+func TestAnalyzeDomainOnlyDKIMProbing(t *testing.T) {
+	analyzer := newMockAnalyzer(map[string][]string{
+		"google._domainkey.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS"},
+	}, nil)
+
+	if results := analyzer.AnalyzeDomainOnly("example.com"); results.DkimRecords != nil {
+		t.Errorf("DKIM selectors were probed without being asked: %+v", *results.DkimRecords)
+	}
+
+	results := analyzer.AnalyzeDomainOnly("example.com", analyzer.DKIMSelectors...)
+	if results.DkimRecords == nil || len(*results.DkimRecords) != 1 || (*results.DkimRecords)[0].Selector != "google" {
+		t.Errorf("DkimRecords = %+v, want the google selector", results.DkimRecords)
+	}
+}
+
 func TestParseDKIMTags(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -137,6 +137,16 @@ func (d *DNSAnalyzer) parseDMARCRecord(foundDomain, rawRecord string) *model.DMA
 		rec.DeprecatedRi = utils.PtrTo(true)
 	}
 
+	// Report destinations, and whether external ones accept the reports
+	if destinations := d.checkDMARCReportDestinations(foundDomain, tags); len(destinations) > 0 {
+		rec.ReportDestinations = &destinations
+	}
+	if rua, ok := tags["rua"]; !ok {
+		rec.Warnings = &[]string{"No aggregate report destination (rua=): you will not receive DMARC reports about your domain"}
+	} else if strings.TrimSpace(rua) == "" {
+		rec.Warnings = &[]string{"Empty aggregate report destination (rua=): add a mailto: URI to receive DMARC reports about your domain"}
+	}
+
 	if !d.validateDMARC(rawRecord) {
 		rec.Valid = false
 		rec.Error = utils.PtrTo("DMARC record appears malformed")
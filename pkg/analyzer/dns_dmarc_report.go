@@ -0,0 +1,130 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// dmarcReportSizeRe matches the optional size limit of a report URI (RFC 7489 section 6.4).
+var dmarcReportSizeRe = regexp.MustCompile(`^[0-9]+[kmgtKMGT]?$`)
+
+// checkDMARCReportDestinations parses the rua= and ruf= tags of a DMARC
+// record found at domain, and checks that every external destination has
+// authorized itself to receive the reports (RFC 7489 section 7.1).
+func (d *DNSAnalyzer) checkDMARCReportDestinations(domain string, tags map[string]string) []model.DMARCReportDestination {
+	var destinations []model.DMARCReportDestination
+
+	for _, tag := range []model.DMARCReportDestinationTag{model.DMARCReportDestinationTagRua, model.DMARCReportDestinationTagRuf} {
+		value, ok := tags[string(tag)]
+		if !ok {
+			continue
+		}
+		for _, uri := range strings.Split(value, ",") {
+			if uri = strings.TrimSpace(uri); uri == "" {
+				continue
+			}
+			destinations = append(destinations, d.checkDMARCReportDestination(domain, tag, uri))
+		}
+	}
+
+	return destinations
+}
+
+// checkDMARCReportDestination validates one report URI and, when it points
+// to another organizational domain, looks up its authorization record.
+func (d *DNSAnalyzer) checkDMARCReportDestination(domain string, tag model.DMARCReportDestinationTag, uri string) model.DMARCReportDestination {
+	dest := model.DMARCReportDestination{
+		Tag:    tag,
+		Uri:    uri,
+		Status: model.DMARCReportDestinationStatusOk,
+	}
+	invalid := func(reason string) model.DMARCReportDestination {
+		dest.Status = model.DMARCReportDestinationStatusInvalid
+		dest.Error = utils.PtrTo(reason)
+		return dest
+	}
+
+	address, size, hasSize := strings.Cut(uri, "!")
+	if hasSize {
+		if !dmarcReportSizeRe.MatchString(size) {
+			return invalid(fmt.Sprintf("invalid size limit %q: expected a number optionally followed by k, m, g or t", size))
+		}
+		dest.MaxSize = utils.PtrTo(size)
+	}
+
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme == "" {
+		return invalid("not a valid URI")
+	}
+	if !strings.EqualFold(u.Scheme, "mailto") {
+		return invalid(fmt.Sprintf("unsupported %s: URI, reports are only sent to mailto: URIs", u.Scheme))
+	}
+
+	addr, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return invalid("malformed percent-encoding in address")
+	}
+	local, destDomain, ok := strings.Cut(addr, "@")
+	destDomain = strings.ToLower(strings.TrimSuffix(destDomain, "."))
+	if !ok || local == "" || !isValidSPFDomain(destDomain) {
+		return invalid(fmt.Sprintf("%q is not a valid email address", addr))
+	}
+	dest.Address = utils.PtrTo(addr)
+
+	external := getOrganizationalDomain(destDomain) != getOrganizationalDomain(domain)
+	dest.External = &external
+	if !external {
+		return dest
+	}
+
+	// RFC 7489 section 7.1: the destination must publish a DMARC1 record at
+	// <domain>._report._dmarc.<destination domain>
+	authName := fmt.Sprintf("%s._report._dmarc.%s", domain, destDomain)
+	dest.AuthorizationRecord = utils.PtrTo(authName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	records, err := d.resolver.LookupTXT(ctx, authName)
+	if err != nil && !isDNSNotFound(err) {
+		dest.Status = model.DMARCReportDestinationStatusError
+		dest.Error = utils.PtrTo(fmt.Sprintf("Failed to lookup %s: %s", authName, formatDNSError(err)))
+		return dest
+	}
+	for _, record := range records {
+		if strings.HasPrefix(record, "v=DMARC1") {
+			return dest
+		}
+	}
+
+	dest.Status = model.DMARCReportDestinationStatusUnauthorized
+	dest.Error = utils.PtrTo(fmt.Sprintf("%s has not authorized reports for %s (no v=DMARC1 record at %s)", destDomain, domain, authName))
+	return dest
+}
@@ -0,0 +1,108 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"net"
+	"testing"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestCheckDMARCReportDestinations(t *testing.T) {
+	analyzer := newMockAnalyzer(map[string][]string{
+		"example.com._report._dmarc.reports.example.net": {"v=DMARC1"},
+		"example.com._report._dmarc.bogus.example.org":   {"some other record"},
+	}, map[string]error{
+		"example.com._report._dmarc.broken.example.org": &net.DNSError{Err: "server misbehaving", Name: "broken.example.org"},
+	})
+
+	tests := []struct {
+		name       string
+		uri        string
+		wantStatus model.DMARCReportDestinationStatus
+		wantSize   string
+		external   bool
+	}{
+		{"same domain", "mailto:dmarc@example.com", model.DMARCReportDestinationStatusOk, "", false},
+		{"same organizational domain", "mailto:dmarc@reports.example.com", model.DMARCReportDestinationStatusOk, "", false},
+		{"authorized external destination", "mailto:dmarc@reports.example.net", model.DMARCReportDestinationStatusOk, "", true},
+		{"size limit", "mailto:dmarc@reports.example.net!10m", model.DMARCReportDestinationStatusOk, "10m", true},
+		{"unauthorized external destination", "mailto:dmarc@unknown.example.org", model.DMARCReportDestinationStatusUnauthorized, "", true},
+		{"authorization record is not DMARC", "mailto:dmarc@bogus.example.org", model.DMARCReportDestinationStatusUnauthorized, "", true},
+		{"DNS failure", "mailto:dmarc@broken.example.org", model.DMARCReportDestinationStatusError, "", true},
+		{"invalid size unit", "mailto:dmarc@example.com!10x", model.DMARCReportDestinationStatusInvalid, "", false},
+		{"empty size", "mailto:dmarc@example.com!", model.DMARCReportDestinationStatusInvalid, "", false},
+		{"not a mailto URI", "https://example.com/dmarc", model.DMARCReportDestinationStatusInvalid, "", false},
+		{"missing scheme", "dmarc@example.com", model.DMARCReportDestinationStatusInvalid, "", false},
+		{"invalid address", "mailto:example.com", model.DMARCReportDestinationStatusInvalid, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := analyzer.checkDMARCReportDestination("example.com", model.DMARCReportDestinationTagRua, tt.uri)
+
+			if dest.Status != tt.wantStatus {
+				t.Errorf("Status = %s, want %s (error: %v)", dest.Status, tt.wantStatus, dest.Error)
+			}
+			if tt.wantSize != "" && (dest.MaxSize == nil || *dest.MaxSize != tt.wantSize) {
+				t.Errorf("MaxSize = %v, want %s", dest.MaxSize, tt.wantSize)
+			}
+			if dest.Status != model.DMARCReportDestinationStatusInvalid && (dest.External == nil || *dest.External != tt.external) {
+				t.Errorf("External = %v, want %v", dest.External, tt.external)
+			}
+		})
+	}
+}
+
+func TestParseDMARCRecordReportDestinations(t *testing.T) {
+	analyzer := newMockAnalyzer(map[string][]string{
+		"example.com._report._dmarc.reports.example.net": {"v=DMARC1"},
+	}, nil)
+
+	rec := analyzer.parseDMARCRecord("example.com", "v=DMARC1; p=reject; rua=mailto:dmarc@example.com,mailto:agg@reports.example.net!5m; ruf=mailto:forensic@other.example.org")
+	if rec.ReportDestinations == nil || len(*rec.ReportDestinations) != 3 {
+		t.Fatalf("expected 3 report destinations, got %v", rec.ReportDestinations)
+	}
+
+	want := []struct {
+		tag    model.DMARCReportDestinationTag
+		status model.DMARCReportDestinationStatus
+	}{
+		{model.DMARCReportDestinationTagRua, model.DMARCReportDestinationStatusOk},
+		{model.DMARCReportDestinationTagRua, model.DMARCReportDestinationStatusOk},
+		{model.DMARCReportDestinationTagRuf, model.DMARCReportDestinationStatusUnauthorized},
+	}
+	for i, dest := range *rec.ReportDestinations {
+		if dest.Tag != want[i].tag || dest.Status != want[i].status {
+			t.Errorf("destination %d = %s/%s, want %s/%s", i, dest.Tag, dest.Status, want[i].tag, want[i].status)
+		}
+	}
+	if rec.Warnings != nil {
+		t.Errorf("unexpected warnings: %v", *rec.Warnings)
+	}
+
+	rec = analyzer.parseDMARCRecord("example.com", "v=DMARC1; p=reject")
+	if rec.Warnings == nil || len(*rec.Warnings) != 1 {
+		t.Errorf("expected a warning about the missing rua=, got %v", rec.Warnings)
+	}
+}
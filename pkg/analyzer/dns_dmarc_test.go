@@ -25,6 +25,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -57,6 +58,15 @@ func (m *mockDNSResolver) LookupAddr(_ context.Context, _ string) ([]string, err
 func (m *mockDNSResolver) LookupHost(_ context.Context, _ string) ([]string, error) {
 	return nil, nil
 }
+func (m *mockDNSResolver) LookupNS(_ context.Context, name string) ([]*net.NS, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+func (m *mockDNSResolver) LookupCNAME(_ context.Context, name string) (string, error) {
+	return "", &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+func (m *mockDNSResolver) LookupTLSA(_ context.Context, name string) ([]*TLSA, bool, error) {
+	return nil, false, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
 
 func newMockAnalyzer(txt map[string][]string, errMap map[string]error) *DNSAnalyzer {
 	if errMap == nil {
@@ -387,6 +397,40 @@ func TestParseDMARCRecordDeprecatedTags(t *testing.T) {
 	}
 }
 
+func TestParseDMARCRecordReportWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      string
+		wantWarning string
+	}{
+		{name: "rua present", record: "v=DMARC1; p=none; rua=mailto:dmarc@example.com"},
+		{name: "rua absent", record: "v=DMARC1; p=none", wantWarning: "No aggregate report destination"},
+		{name: "rua empty", record: "v=DMARC1; p=none; rua=", wantWarning: "Empty aggregate report destination"},
+		{name: "rua whitespace only", record: "v=DMARC1; p=none; rua= ;", wantWarning: "Empty aggregate report destination"},
+	}
+
+	analyzer := NewDNSAnalyzer(5 * time.Second)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := analyzer.parseDMARCRecord("example.com", tt.record)
+			var warnings []string
+			if rec.Warnings != nil {
+				warnings = *rec.Warnings
+			}
+			if tt.wantWarning == "" {
+				if len(warnings) != 0 {
+					t.Errorf("parseDMARCRecord(%q).Warnings = %v, want none", tt.record, warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.HasPrefix(warnings[0], tt.wantWarning) {
+				t.Errorf("parseDMARCRecord(%q).Warnings = %v, want %q", tt.record, warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
 func TestValidateDMARC(t *testing.T) {
 	tests := []struct {
 		name     string
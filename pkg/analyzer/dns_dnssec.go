@@ -0,0 +1,1103 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// dnssecMaxCacheTTL bounds how long validated zone keys are kept.
+const dnssecMaxCacheTTL = 10 * time.Minute
+
+// dnssecMaxZones bounds the number of zones whose keys are kept, the least
+// recently used ones being dropped first.
+const dnssecMaxZones = 1000
+
+// dnssecRootAnchors are the DS records of the root zone key signing keys, as
+// published by IANA.
+var dnssecRootAnchors = []*dsRecord{
+	{keyTag: 20326, algorithm: 8, digestType: 2, digest: hexDigest("E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D")},
+	{keyTag: 38696, algorithm: 8, digestType: 2, digest: hexDigest("683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16")},
+}
+
+func hexDigest(s string) []byte {
+	digest, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
+// dnssecVerdict is the DNSSEC status of an answer, with the reason when it
+// is not secure.
+type dnssecVerdict struct {
+	status model.DNSSECAnswerStatus
+	reason string
+}
+
+var dnssecSecure = dnssecVerdict{status: model.DNSSECAnswerStatusSecure}
+
+func dnssecInsecure(format string, args ...any) dnssecVerdict {
+	return dnssecVerdict{status: model.DNSSECAnswerStatusInsecure, reason: fmt.Sprintf(format, args...)}
+}
+
+func dnssecBogus(format string, args ...any) dnssecVerdict {
+	return dnssecVerdict{status: model.DNSSECAnswerStatusBogus, reason: fmt.Sprintf(format, args...)}
+}
+
+func dnssecIndeterminate(err error) dnssecVerdict {
+	return dnssecVerdict{status: model.DNSSECAnswerStatusIndeterminate, reason: formatDNSError(err)}
+}
+
+// worse returns the least trustworthy of two verdicts: an answer is only as
+// secure as each RRset and zone it depends on.
+func (v dnssecVerdict) worse(other dnssecVerdict) dnssecVerdict {
+	rank := func(s model.DNSSECAnswerStatus) int {
+		switch s {
+		case model.DNSSECAnswerStatusSecure:
+			return 0
+		case model.DNSSECAnswerStatusInsecure:
+			return 1
+		case model.DNSSECAnswerStatusIndeterminate:
+			return 2
+		default:
+			return 3
+		}
+	}
+	if rank(other.status) > rank(v.status) {
+		return other
+	}
+	return v
+}
+
+// dnssecObserverKey is the context key of the function notified of the
+// DNSSEC status of each lookup.
+type dnssecObserverKey struct{}
+
+// dnssecObserver receives the DNSSEC status of a lookup.
+type dnssecObserver func(name string, qtype dnsmessage.Type, verdict dnssecVerdict)
+
+// withDNSSECObserver returns a context whose lookups through a
+// DNSSECResolver report their DNSSEC status to observer.
+func withDNSSECObserver(ctx context.Context, observer dnssecObserver) context.Context {
+	return context.WithValue(ctx, dnssecObserverKey{}, observer)
+}
+
+// reportDNSSEC notifies the observer of ctx, if any, of a lookup status.
+func reportDNSSEC(ctx context.Context, name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+	if observer, ok := ctx.Value(dnssecObserverKey{}).(dnssecObserver); ok {
+		observer(name, qtype, verdict)
+	}
+}
+
+// dnssecZoneKey marks, in a context, a zone whose chain of trust is being
+// established, to detect signers pointing back down the chain.
+type dnssecZoneKey struct{ zone string }
+
+// dnssecZone holds the validated keys of a zone.
+type dnssecZone struct {
+	name    string
+	keys    []*dnskey
+	verdict dnssecVerdict
+	expires time.Time
+}
+
+// DNSSECResolver is a DNSResolver validating answers with DNSSEC itself,
+// from the root trust anchors, instead of trusting the AD bit of the
+// upstream resolver. Signatures are requested from the configured recursive
+// nameservers with validation disabled, so that bogus answers can be told
+// apart from unsigned ones.
+//
+// Bogus answers are returned as errors. The status of every lookup is
+// reported to the observer of the lookup context, if any.
+type DNSSECResolver struct {
+	upstreams []DNSUpstream
+	anchors   []*dsRecord
+
+	mu    sync.Mutex
+	zones map[string]*list.Element
+	lru   *list.List // most recently used first
+}
+
+// NewDNSSECResolver creates a validating resolver querying the given
+// nameservers. When none are given, the system nameservers are used.
+func NewDNSSECResolver(upstreams []DNSUpstream) DNSResolver {
+	if len(upstreams) == 0 {
+		upstreams = systemNameservers()
+	}
+	return &DNSSECResolver{
+		upstreams: upstreams,
+		anchors:   dnssecRootAnchors,
+		zones:     map[string]*list.Element{},
+		lru:       list.New(),
+	}
+}
+
+// LookupMX implements DNSResolver.LookupMX.
+func (r *DNSSECResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, _, err := r.lookup(ctx, name, dnsmessage.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	return mxRecords(records), nil
+}
+
+// LookupTXT implements DNSResolver.LookupTXT.
+func (r *DNSSECResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, _, err := r.lookup(ctx, name, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	return txtRecords(records), nil
+}
+
+// LookupAddr implements DNSResolver.LookupAddr.
+func (r *DNSSECResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := reverseDNSName(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+
+	records, _, err := r.lookup(ctx, name, dnsmessage.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	return ptrRecords(records), nil
+}
+
+// LookupHost implements DNSResolver.LookupHost.
+func (r *DNSSECResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	var addrs []string
+	var lookupErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		records, verdict, err := r.lookup(ctx, host, qtype)
+		if verdict.status == model.DNSSECAnswerStatusBogus {
+			return nil, err
+		}
+		if err != nil {
+			lookupErr = keepHostLookupError(lookupErr, err)
+			continue
+		}
+		addrs = append(addrs, addressRecords(records)...)
+	}
+
+	if len(addrs) == 0 {
+		return nil, lookupErr
+	}
+	return addrs, nil
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *DNSSECResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	records, _, err := r.lookup(ctx, name, dnsmessage.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	return nsRecords(records), nil
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME.
+func (r *DNSSECResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	records, _, err := r.lookup(ctx, name, dnsmessage.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	return cnameRecord(records), nil
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA, the answer being
+// authenticated when it validated as secure.
+func (r *DNSSECResolver) LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error) {
+	records, verdict, err := r.lookup(ctx, name, dnsTypeTLSA)
+	secure := verdict.status == model.DNSSECAnswerStatusSecure
+	if err != nil {
+		return nil, secure, err
+	}
+
+	tlsas, err := tlsaRecords(records, name)
+	return tlsas, secure, err
+}
+
+// lookup resolves and validates the records of a type at name, reporting
+// the DNSSEC status to the context observer. Bogus answers are turned into
+// errors.
+func (r *DNSSECResolver) lookup(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, dnssecVerdict, error) {
+	records, verdict, err := r.query(ctx, name, qtype)
+	reportDNSSEC(ctx, strings.TrimSuffix(name, "."), qtype, verdict)
+
+	if verdict.status == model.DNSSECAnswerStatusBogus {
+		return nil, verdict, &net.DNSError{Err: "DNSSEC validation failed: " + verdict.reason, Name: name}
+	}
+	if err != nil {
+		return nil, verdict, err
+	}
+	if len(records) == 0 {
+		return nil, verdict, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, verdict, nil
+}
+
+// rrset is a set of records sharing owner and type, with their signatures.
+type rrset struct {
+	name  string
+	rtype dnsmessage.Type
+	ttl   uint32
+	rrs   []dnsmessage.Resource
+	sigs  []*rrsig
+}
+
+// groupRRsets splits a message section into RRsets, keeping their order.
+func groupRRsets(section []dnsmessage.Resource) []*rrset {
+	type key struct {
+		name  string
+		rtype dnsmessage.Type
+	}
+	var sets []*rrset
+	index := map[key]*rrset{}
+	get := func(name string, rtype dnsmessage.Type) *rrset {
+		k := key{fqdn(name), rtype}
+		if set, ok := index[k]; ok {
+			return set
+		}
+		set := &rrset{name: k.name, rtype: rtype}
+		index[k] = set
+		sets = append(sets, set)
+		return set
+	}
+
+	for _, rr := range section {
+		switch rr.Header.Type {
+		case dnsmessage.TypeOPT:
+			continue
+		case dnsTypeRRSIG:
+			body, ok := rr.Body.(*dnsmessage.UnknownResource)
+			if !ok {
+				continue
+			}
+			sig, err := parseRRSIG(body.Data)
+			if err != nil {
+				continue
+			}
+			set := get(rr.Header.Name.String(), sig.typeCovered)
+			set.sigs = append(set.sigs, sig)
+		default:
+			set := get(rr.Header.Name.String(), rr.Header.Type)
+			if len(set.rrs) == 0 || rr.Header.TTL < set.ttl {
+				set.ttl = rr.Header.TTL
+			}
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+
+	// Drop signatures without records
+	return slices.DeleteFunc(sets, func(set *rrset) bool { return len(set.rrs) == 0 })
+}
+
+// query resolves name and validates the whole answer: the records of qtype
+// at the end of the CNAME chain, or the proof that there is none.
+func (r *DNSSECResolver) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, dnssecVerdict, error) {
+	resp, err := exchangeDNS(ctx, r.upstreams, name, qtype, true)
+	if err != nil {
+		return nil, dnssecIndeterminate(err), err
+	}
+	reportResponseTTL(ctx, resp)
+	rcodeErr := dnsResponseError(resp, name)
+	if rcodeErr != nil && resp.RCode != dnsmessage.RCodeNameError {
+		return nil, dnssecIndeterminate(rcodeErr), rcodeErr
+	}
+
+	answers := groupRRsets(resp.Answers)
+	authority := groupRRsets(resp.Authorities)
+
+	verdict := dnssecSecure
+	for _, set := range answers {
+		setVerdict, sig := r.verifyRRset(ctx, set)
+		if setVerdict.status == model.DNSSECAnswerStatusSecure {
+			setVerdict = r.checkWildcardExpansion(ctx, set, sig, authority)
+		}
+		verdict = verdict.worse(setVerdict)
+	}
+
+	// Follow the CNAME chain to the records asked for
+	records, target := answerRecords(resp.Answers, name, qtype)
+
+	if records == nil {
+		verdict = verdict.worse(r.validateDenial(ctx, target, qtype, resp.RCode == dnsmessage.RCodeNameError, authority))
+	}
+	return records, verdict, rcodeErr
+}
+
+// verifyRRset checks the signatures of an RRset against the keys of its
+// signer zone, returning the signature that validated. Unsigned RRsets are
+// only insecure when their zone is.
+func (r *DNSSECResolver) verifyRRset(ctx context.Context, set *rrset) (dnssecVerdict, *rrsig) {
+	what := set.name + " " + dnsTypeName(set.rtype)
+
+	// The DS RRset belongs to the parent zone
+	zone := set.name
+	if set.rtype == dnsTypeDS {
+		zone = parentName(set.name)
+	}
+
+	var signer string
+	for _, sig := range set.sigs {
+		if isSubdomain(zone, sig.signerName) {
+			signer = sig.signerName
+			break
+		}
+	}
+	if signer == "" {
+		if v := r.zoneVerdict(ctx, zone); v.status != model.DNSSECAnswerStatusSecure {
+			return v, nil
+		}
+		return dnssecBogus("%s is not signed", what), nil
+	}
+
+	keys, verdict := r.zoneKeys(ctx, signer)
+	if verdict.status != model.DNSSECAnswerStatusSecure {
+		return verdict, nil
+	}
+
+	err := errors.New("no RRSIG matches a key of " + signer)
+	for _, sig := range set.sigs {
+		if sig.signerName != signer {
+			continue
+		}
+		if err = checkRRSIG(sig, set, keys, time.Now()); err == nil {
+			return dnssecSecure, sig
+		}
+	}
+	return dnssecBogus("%s: %v", what, err), nil
+}
+
+// checkRRSIG verifies one signature of an RRset with the given keys.
+func checkRRSIG(sig *rrsig, set *rrset, keys []*dnskey, now time.Time) error {
+	t := uint32(now.Unix())
+	// Serial number arithmetic (RFC 4034 section 3.1.5)
+	if int32(t-sig.inception) < 0 {
+		return errors.New("RRSIG is not valid yet")
+	}
+	if int32(sig.expiration-t) < 0 {
+		return errors.New("RRSIG has expired")
+	}
+
+	data, err := rrsigSignedData(sig, set.name, set.rrs)
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("no DNSKEY with tag %d", sig.keyTag)
+	for _, key := range keys {
+		if key.keyTag() != sig.keyTag || key.algorithm != sig.algorithm || key.flags&dnskeyFlagZone == 0 {
+			continue
+		}
+		if err = verifySignature(sig.algorithm, key.publicKey, data, sig.signature); err == nil {
+			return nil
+		}
+		err = fmt.Errorf("RRSIG verification failed: %w", err)
+	}
+	return err
+}
+
+// zoneVerdict tells whether the zone holding name is signed, by
+// establishing its chain of trust.
+func (r *DNSSECResolver) zoneVerdict(ctx context.Context, name string) dnssecVerdict {
+	apex, err := r.zoneApex(ctx, name)
+	if err != nil {
+		return dnssecIndeterminate(err)
+	}
+	_, verdict := r.zoneKeys(ctx, apex)
+	return verdict
+}
+
+// zoneApex finds the apex of the zone holding name from the SOA record
+// returned for it.
+func (r *DNSSECResolver) zoneApex(ctx context.Context, name string) (string, error) {
+	for n := fqdn(name); ; n = parentName(n) {
+		resp, err := exchangeDNS(ctx, r.upstreams, n, dnsmessage.TypeSOA, true)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range resp.Answers {
+			if rr.Header.Type == dnsmessage.TypeSOA && fqdn(rr.Header.Name.String()) == n {
+				return n, nil
+			}
+		}
+		for _, rr := range resp.Authorities {
+			if owner := fqdn(rr.Header.Name.String()); rr.Header.Type == dnsmessage.TypeSOA && isSubdomain(n, owner) {
+				return owner, nil
+			}
+		}
+		// A CNAME, or a resolver not telling the zone: the apex is above
+		if n == "." {
+			return "", &net.DNSError{Err: "cannot find the zone apex", Name: name}
+		}
+	}
+}
+
+// zoneKeys returns the keys of a zone once its chain of trust up to a trust
+// anchor has been established, or why it could not be.
+func (r *DNSSECResolver) zoneKeys(ctx context.Context, zone string) ([]*dnskey, dnssecVerdict) {
+	zone = fqdn(zone)
+
+	if cached, ok := r.cachedZone(zone); ok {
+		return cached.keys, cached.verdict
+	}
+
+	if ctx.Value(dnssecZoneKey{zone}) != nil {
+		return nil, dnssecBogus("circular chain of trust at %s", zone)
+	}
+	ctx = context.WithValue(ctx, dnssecZoneKey{zone}, true)
+
+	keys, verdict, ttl := r.establishZoneKeys(ctx, zone)
+	if verdict.status != model.DNSSECAnswerStatusIndeterminate {
+		r.cacheZone(&dnssecZone{
+			name:    zone,
+			keys:    keys,
+			verdict: verdict,
+			expires: time.Now().Add(min(time.Duration(ttl)*time.Second, dnssecMaxCacheTTL)),
+		})
+	}
+	return keys, verdict
+}
+
+// cachedZone returns the keys of a zone established earlier, unless they
+// have expired.
+func (r *DNSSECResolver) cachedZone(zone string) (*dnssecZone, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.zones[zone]
+	if !ok {
+		return nil, false
+	}
+	cached := elem.Value.(*dnssecZone)
+	if !time.Now().Before(cached.expires) {
+		r.lru.Remove(elem)
+		delete(r.zones, zone)
+		return nil, false
+	}
+	r.lru.MoveToFront(elem)
+	return cached, true
+}
+
+// cacheZone keeps the keys of a zone, dropping the expired zones and then
+// the least recently used ones beyond dnssecMaxZones.
+func (r *DNSSECResolver) cacheZone(zone *dnssecZone) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.zones[zone.name]; ok {
+		elem.Value = zone
+		r.lru.MoveToFront(elem)
+		return
+	}
+	r.zones[zone.name] = r.lru.PushFront(zone)
+
+	if r.lru.Len() <= dnssecMaxZones {
+		return
+	}
+	now := time.Now()
+	for elem := r.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if cached := elem.Value.(*dnssecZone); !now.Before(cached.expires) {
+			r.lru.Remove(elem)
+			delete(r.zones, cached.name)
+		}
+		elem = prev
+	}
+	for r.lru.Len() > dnssecMaxZones {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.zones, oldest.Value.(*dnssecZone).name)
+	}
+}
+
+// establishZoneKeys authenticates the DNSKEY RRset of a zone with the DS
+// records of its parent (or the trust anchors for the root).
+func (r *DNSSECResolver) establishZoneKeys(ctx context.Context, zone string) ([]*dnskey, dnssecVerdict, uint32) {
+	ttl := uint32(dnssecMaxCacheTTL / time.Second)
+
+	var dsSet []*dsRecord
+	if zone == "." {
+		dsSet = r.anchors
+	} else {
+		records, verdict, err := r.query(ctx, zone, dnsTypeDS)
+		if verdict.status != model.DNSSECAnswerStatusSecure {
+			return nil, verdict, ttl
+		}
+		if isDNSNotFound(err) {
+			return nil, dnssecBogus("%s does not exist", zone), ttl
+		} else if err != nil {
+			return nil, dnssecIndeterminate(err), ttl
+		}
+		if len(records) == 0 {
+			return nil, dnssecInsecure("%s is an unsigned delegation", zone), ttl
+		}
+		for _, rr := range records {
+			ttl = min(ttl, rr.Header.TTL)
+			if body, ok := rr.Body.(*dnsmessage.UnknownResource); ok {
+				if ds, err := parseDS(body.Data); err == nil {
+					dsSet = append(dsSet, ds)
+				}
+			}
+		}
+	}
+
+	dsSet = slices.DeleteFunc(slices.Clone(dsSet), func(ds *dsRecord) bool {
+		return !dnssecAlgorithmSupported(ds.algorithm) || !dsDigestSupported(ds.digestType)
+	})
+	if len(dsSet) == 0 {
+		return nil, dnssecInsecure("the DS records of %s only use unsupported algorithms", zone), ttl
+	}
+
+	resp, err := exchangeDNS(ctx, r.upstreams, zone, dnsTypeDNSKEY, true)
+	if err != nil {
+		return nil, dnssecIndeterminate(err), ttl
+	}
+	var set *rrset
+	for _, s := range groupRRsets(resp.Answers) {
+		if s.name == zone && s.rtype == dnsTypeDNSKEY {
+			set = s
+		}
+	}
+	if set == nil {
+		return nil, dnssecBogus("%s has DS records but no DNSKEY", zone), ttl
+	}
+	ttl = min(ttl, set.ttl)
+
+	var keys, anchored []*dnskey
+	for _, rr := range set.rrs {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		key, err := parseDNSKEY(body.Data)
+		if err != nil || key.flags&dnskeyFlagZone == 0 {
+			continue
+		}
+		keys = append(keys, key)
+		if slices.ContainsFunc(dsSet, func(ds *dsRecord) bool { return key.matchesDS(zone, ds) }) {
+			anchored = append(anchored, key)
+		}
+	}
+	if len(anchored) == 0 {
+		return nil, dnssecBogus("no DNSKEY of %s matches its DS records", zone), ttl
+	}
+
+	err = errors.New("the DNSKEY RRset is not signed")
+	for _, sig := range set.sigs {
+		if sig.signerName != zone {
+			continue
+		}
+		if err = checkRRSIG(sig, set, anchored, time.Now()); err == nil {
+			return keys, dnssecSecure, ttl
+		}
+	}
+	return nil, dnssecBogus("%s DNSKEY: %v", zone, err), ttl
+}
+
+// denialRecords validates and parses the NSEC and NSEC3 records of the
+// authority section.
+func (r *DNSSECResolver) denialRecords(ctx context.Context, authority []*rrset) ([]*nsecRecord, []*nsec3Record, dnssecVerdict) {
+	var nsecs []*nsecRecord
+	var nsec3s []*nsec3Record
+	verdict := dnssecSecure
+
+	for _, set := range authority {
+		if set.rtype != dnsTypeNSEC && set.rtype != dnsTypeNSEC3 {
+			continue
+		}
+		v, _ := r.verifyRRset(ctx, set)
+		verdict = verdict.worse(v)
+
+		for _, rr := range set.rrs {
+			body, ok := rr.Body.(*dnsmessage.UnknownResource)
+			if !ok {
+				continue
+			}
+			if set.rtype == dnsTypeNSEC {
+				if rec, err := parseNSEC(set.name, body.Data); err == nil {
+					nsecs = append(nsecs, rec)
+				}
+			} else if rec, err := parseNSEC3(set.name, body.Data); err == nil {
+				nsec3s = append(nsec3s, rec)
+			} else if errors.Is(err, errDNSSECUnsupportedAlgorithm) {
+				verdict = verdict.worse(dnssecInsecure("NSEC3 records of %s use an unsupported hash algorithm", set.name))
+			}
+		}
+	}
+	return nsecs, nsec3s, verdict
+}
+
+// validateDenial checks the proof that qname does not exist (nxdomain) or
+// has no record of qtype.
+func (r *DNSSECResolver) validateDenial(ctx context.Context, qname string, qtype dnsmessage.Type, nxdomain bool, authority []*rrset) dnssecVerdict {
+	nsecs, nsec3s, verdict := r.denialRecords(ctx, authority)
+	if verdict.status != model.DNSSECAnswerStatusSecure {
+		return verdict
+	}
+
+	if len(nsecs) == 0 && len(nsec3s) == 0 {
+		zone := qname
+		if qtype == dnsTypeDS {
+			zone = parentName(qname)
+		}
+		if v := r.zoneVerdict(ctx, zone); v.status != model.DNSSECAnswerStatusSecure {
+			return v
+		}
+		return dnssecBogus("no NSEC or NSEC3 record proves that %s %s does not exist", qname, dnsTypeName(qtype))
+	}
+
+	if len(nsecs) > 0 {
+		return checkNSECDenial(nsecs, qname, qtype, nxdomain)
+	}
+	return checkNSEC3Denial(nsec3s, qname, qtype, nxdomain)
+}
+
+// checkWildcardExpansion requires, for an RRset synthesized from a
+// wildcard, the proof that the queried name does not exist itself (RFC 4035
+// section 5.3.4).
+func (r *DNSSECResolver) checkWildcardExpansion(ctx context.Context, set *rrset, sig *rrsig, authority []*rrset) dnssecVerdict {
+	labels := dnsLabels(set.name)
+	if sig == nil || int(sig.labels) >= len(labels) {
+		return dnssecSecure
+	}
+
+	nsecs, nsec3s, verdict := r.denialRecords(ctx, authority)
+	if verdict.status != model.DNSSECAnswerStatusSecure {
+		return verdict
+	}
+
+	if slices.ContainsFunc(nsecs, func(n *nsecRecord) bool { return n.covers(set.name) }) {
+		return dnssecSecure
+	}
+	if len(nsec3s) > 0 {
+		chain := newNSEC3Chain(nsec3s)
+		if chain.iterations > nsec3MaxIterations {
+			return dnssecInsecure("NSEC3 records of %s use %d iterations", chain.zone, chain.iterations)
+		}
+		nextCloser := strings.Join(labels[len(labels)-int(sig.labels)-1:], ".") + "."
+		if cover := chain.cover(nextCloser); cover != nil {
+			if cover.flags&nsec3FlagOptOut != 0 {
+				return dnssecInsecure("%s is covered by an opt-out NSEC3 record", nextCloser)
+			}
+			return dnssecSecure
+		}
+	}
+	return dnssecBogus("%s %s was expanded from a wildcard without proof that the name does not exist", set.name, dnsTypeName(set.rtype))
+}
+
+// covers tells whether name sorts strictly between the owner and next names
+// of an NSEC record, wrapping around at the end of the zone.
+func (n *nsecRecord) covers(name string) bool {
+	name = fqdn(name)
+	if canonicalNameCompare(n.owner, name) >= 0 {
+		return false
+	}
+	if canonicalNameCompare(n.owner, n.next) < 0 {
+		return canonicalNameCompare(name, n.next) < 0
+	}
+	// Last NSEC of the zone, the next name is the apex
+	return isSubdomain(name, n.next)
+}
+
+// checkDelegationTypes rejects denials that cannot be trusted for qtype:
+// a missing DS has to be proved at a delegation, and the parent side of a
+// delegation proves nothing about the child zone.
+func checkDelegationTypes(name string, types []dnsmessage.Type, qtype dnsmessage.Type) dnssecVerdict {
+	delegation := slices.Contains(types, dnsmessage.TypeNS) && !slices.Contains(types, dnsmessage.TypeSOA)
+	if qtype == dnsTypeDS && !slices.Contains(types, dnsmessage.TypeNS) {
+		return dnssecBogus("%s has no DS record but is not a delegation", name)
+	}
+	if qtype != dnsTypeDS && delegation {
+		return dnssecBogus("the denial of %s %s comes from the parent zone", name, dnsTypeName(qtype))
+	}
+	return dnssecSecure
+}
+
+// checkNSECDenial checks an NSEC proof of non-existence (RFC 4035 section
+// 5.4).
+func checkNSECDenial(nsecs []*nsecRecord, qname string, qtype dnsmessage.Type, nxdomain bool) dnssecVerdict {
+	qname = fqdn(qname)
+
+	if !nxdomain {
+		for _, n := range nsecs {
+			if n.owner != qname {
+				continue
+			}
+			if slices.Contains(n.types, qtype) || slices.Contains(n.types, dnsmessage.TypeCNAME) {
+				return dnssecBogus("the NSEC record of %s lists the %s type", qname, dnsTypeName(qtype))
+			}
+			return checkDelegationTypes(qname, n.types, qtype)
+		}
+
+		for _, n := range nsecs {
+			// Empty non-terminal: names exist below qname
+			if n.covers(qname) && isSubdomain(n.next, qname) {
+				return dnssecSecure
+			}
+		}
+
+		// Wildcard without the type asked for
+		if ce := nsecClosestEncloser(nsecs, qname); ce != "" {
+			wildcard := wildcardName(ce)
+			for _, n := range nsecs {
+				if n.owner == wildcard && !slices.Contains(n.types, qtype) && !slices.Contains(n.types, dnsmessage.TypeCNAME) {
+					return dnssecSecure
+				}
+			}
+		}
+		return dnssecBogus("no NSEC record proves that %s has no %s record", qname, dnsTypeName(qtype))
+	}
+
+	ce := nsecClosestEncloser(nsecs, qname)
+	if ce == "" {
+		return dnssecBogus("no NSEC record proves that %s does not exist", qname)
+	}
+	wildcard := wildcardName(ce)
+	if !slices.ContainsFunc(nsecs, func(n *nsecRecord) bool { return n.covers(wildcard) }) {
+		return dnssecBogus("no NSEC record proves that %s does not exist", wildcard)
+	}
+	return dnssecSecure
+}
+
+// nsecClosestEncloser returns the closest encloser of qname according to
+// the NSEC record covering it, or "" when none does.
+func nsecClosestEncloser(nsecs []*nsecRecord, qname string) string {
+	for _, n := range nsecs {
+		if !n.covers(qname) {
+			continue
+		}
+		a, b := commonAncestor(qname, n.owner), commonAncestor(qname, n.next)
+		if len(dnsLabels(b)) > len(dnsLabels(a)) {
+			return b
+		}
+		return a
+	}
+	return ""
+}
+
+// commonAncestor returns the longest name both a and b are subdomains of.
+func commonAncestor(a, b string) string {
+	la, lb := dnsLabels(fqdn(a)), dnsLabels(fqdn(b))
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	if n == 0 {
+		return "."
+	}
+	return strings.Join(la[len(la)-n:], ".") + "."
+}
+
+// wildcardName returns the wildcard name directly below name.
+func wildcardName(name string) string {
+	if name == "." {
+		return "*."
+	}
+	return "*." + name
+}
+
+// nsec3Chain is the set of NSEC3 records of a response sharing the
+// parameters of the first one.
+type nsec3Chain struct {
+	zone       string
+	salt       []byte
+	iterations uint16
+	records    []*nsec3Record
+	hashes     map[string]string
+}
+
+func newNSEC3Chain(records []*nsec3Record) *nsec3Chain {
+	first := records[0]
+	chain := &nsec3Chain{
+		zone:       first.zone,
+		salt:       first.salt,
+		iterations: first.iterations,
+		hashes:     map[string]string{},
+	}
+	for _, rec := range records {
+		if rec.zone == first.zone && rec.iterations == first.iterations && string(rec.salt) == string(first.salt) {
+			chain.records = append(chain.records, rec)
+		}
+	}
+	return chain
+}
+
+func (c *nsec3Chain) hash(name string) string {
+	name = fqdn(name)
+	if h, ok := c.hashes[name]; ok {
+		return h
+	}
+	h := nsec3Hash(name, c.salt, c.iterations)
+	c.hashes[name] = h
+	return h
+}
+
+// match returns the NSEC3 record of name, if any.
+func (c *nsec3Chain) match(name string) *nsec3Record {
+	h := c.hash(name)
+	for _, rec := range c.records {
+		if rec.hash == h {
+			return rec
+		}
+	}
+	return nil
+}
+
+// cover returns the NSEC3 record whose hash range covers name, if any.
+func (c *nsec3Chain) cover(name string) *nsec3Record {
+	h := c.hash(name)
+	for _, rec := range c.records {
+		if rec.hash < rec.next {
+			if rec.hash < h && h < rec.next {
+				return rec
+			}
+		} else if h > rec.hash || h < rec.next {
+			return rec
+		}
+	}
+	return nil
+}
+
+// closestEncloser returns the closest encloser of qname, the next closer
+// name and the NSEC3 record covering it (RFC 5155 section 8.3).
+func (c *nsec3Chain) closestEncloser(qname string) (string, string, *nsec3Record) {
+	nextCloser := ""
+	for n := fqdn(qname); isSubdomain(n, c.zone); n = parentName(n) {
+		if c.match(n) != nil {
+			if nextCloser == "" {
+				return n, "", nil
+			}
+			return n, nextCloser, c.cover(nextCloser)
+		}
+		nextCloser = n
+		if n == "." {
+			break
+		}
+	}
+	return "", "", nil
+}
+
+// checkNSEC3Denial checks an NSEC3 proof of non-existence (RFC 5155 section
+// 8).
+func checkNSEC3Denial(records []*nsec3Record, qname string, qtype dnsmessage.Type, nxdomain bool) dnssecVerdict {
+	qname = fqdn(qname)
+	chain := newNSEC3Chain(records)
+	if chain.iterations > nsec3MaxIterations {
+		return dnssecInsecure("NSEC3 records of %s use %d iterations", chain.zone, chain.iterations)
+	}
+
+	ce, nextCloser, cover := chain.closestEncloser(qname)
+
+	if !nxdomain {
+		if m := chain.match(qname); m != nil {
+			if slices.Contains(m.types, qtype) || slices.Contains(m.types, dnsmessage.TypeCNAME) {
+				return dnssecBogus("the NSEC3 record of %s lists the %s type", qname, dnsTypeName(qtype))
+			}
+			return checkDelegationTypes(qname, m.types, qtype)
+		}
+		if cover != nil {
+			if qtype == dnsTypeDS && cover.flags&nsec3FlagOptOut != 0 {
+				return dnssecInsecure("%s is covered by an opt-out NSEC3 record", nextCloser)
+			}
+			if w := chain.match(wildcardName(ce)); w != nil && !slices.Contains(w.types, qtype) && !slices.Contains(w.types, dnsmessage.TypeCNAME) {
+				return dnssecSecure
+			}
+		}
+		return dnssecBogus("no NSEC3 record proves that %s has no %s record", qname, dnsTypeName(qtype))
+	}
+
+	if cover == nil {
+		return dnssecBogus("no NSEC3 closest encloser proof for %s", qname)
+	}
+	if chain.cover(wildcardName(ce)) == nil {
+		return dnssecBogus("no NSEC3 record proves that %s does not exist", wildcardName(ce))
+	}
+	if cover.flags&nsec3FlagOptOut != 0 {
+		return dnssecInsecure("%s is covered by an opt-out NSEC3 record", nextCloser)
+	}
+	return dnssecSecure
+}
+
+// dnsTypeName returns the mnemonic of a record type.
+func dnsTypeName(t dnsmessage.Type) string {
+	switch t {
+	case dnsTypeDS:
+		return "DS"
+	case dnsTypeRRSIG:
+		return "RRSIG"
+	case dnsTypeNSEC:
+		return "NSEC"
+	case dnsTypeDNSKEY:
+		return "DNSKEY"
+	case dnsTypeNSEC3:
+		return "NSEC3"
+	case dnsTypeTLSA:
+		return "TLSA"
+	}
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+// reverseDNSName returns the in-addr.arpa or ip6.arpa name of an address.
+func reverseDNSName(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", errors.New("unrecognized address")
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]), nil
+	}
+
+	var b strings.Builder
+	const hexDigits = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xF])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
+
+// dnssecRecorder wraps a resolver to collect the DNSSEC status of the
+// lookups of one analysis.
+type dnssecRecorder struct {
+	resolver DNSResolver
+
+	mu      sync.Mutex
+	answers []model.DNSSECAnswer
+	index   map[string]int
+}
+
+func newDNSSECRecorder(resolver DNSResolver) *dnssecRecorder {
+	return &dnssecRecorder{
+		resolver: resolver,
+		index:    map[string]int{},
+	}
+}
+
+func (r *dnssecRecorder) observe(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+	answer := model.DNSSECAnswer{
+		Name:   strings.ToLower(name),
+		Type:   dnsTypeName(qtype),
+		Status: verdict.status,
+	}
+	if verdict.reason != "" {
+		answer.Reason = &verdict.reason
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Keep a single entry per question, the same records being looked up
+	// by several checks
+	key := answer.Name + " " + answer.Type
+	if i, ok := r.index[key]; ok {
+		r.answers[i] = answer
+		return
+	}
+	r.index[key] = len(r.answers)
+	r.answers = append(r.answers, answer)
+}
+
+func (r *dnssecRecorder) context(ctx context.Context) context.Context {
+	return withDNSSECObserver(ctx, r.observe)
+}
+
+// LookupMX implements DNSResolver.LookupMX.
+func (r *dnssecRecorder) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return r.resolver.LookupMX(r.context(ctx), name)
+}
+
+// LookupTXT implements DNSResolver.LookupTXT.
+func (r *dnssecRecorder) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.resolver.LookupTXT(r.context(ctx), name)
+}
+
+// LookupAddr implements DNSResolver.LookupAddr.
+func (r *dnssecRecorder) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.resolver.LookupAddr(r.context(ctx), addr)
+}
+
+// LookupHost implements DNSResolver.LookupHost.
+func (r *dnssecRecorder) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.resolver.LookupHost(r.context(ctx), host)
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *dnssecRecorder) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	return extendedResolver(r.resolver).LookupNS(r.context(ctx), name)
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME.
+func (r *dnssecRecorder) LookupCNAME(ctx context.Context, name string) (string, error) {
+	return extendedResolver(r.resolver).LookupCNAME(r.context(ctx), name)
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA.
+func (r *dnssecRecorder) LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error) {
+	return extendedResolver(r.resolver).LookupTLSA(r.context(ctx), name)
+}
+
+// apply stores the recorded statuses into the results, reporting broken
+// chains of trust as errors.
+func (r *dnssecRecorder) apply(results *model.DNSResults) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.answers) == 0 {
+		return
+	}
+	answers := slices.Clone(r.answers)
+	results.DnssecAnswers = &answers
+
+	for _, answer := range answers {
+		if answer.Status != model.DNSSECAnswerStatusBogus {
+			continue
+		}
+		if results.Errors == nil {
+			results.Errors = &[]string{}
+		}
+		*results.Errors = append(*results.Errors, fmt.Sprintf("DNSSEC validation failed for %s %s: %s", answer.Name, answer.Type, *answer.Reason))
+	}
+}
@@ -0,0 +1,573 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSSEC record types (RFC 4034, RFC 5155), unknown to dnsmessage.
+const (
+	dnsTypeDS     dnsmessage.Type = 43
+	dnsTypeRRSIG  dnsmessage.Type = 46
+	dnsTypeNSEC   dnsmessage.Type = 47
+	dnsTypeDNSKEY dnsmessage.Type = 48
+	dnsTypeNSEC3  dnsmessage.Type = 50
+)
+
+const (
+	// dnskeyFlagZone marks a key usable to verify zone data (RFC 4034
+	// section 2.1.1).
+	dnskeyFlagZone = 0x0100
+
+	// nsec3FlagOptOut marks an NSEC3 record that may cover unsigned
+	// delegations (RFC 5155 section 3.1.2.1).
+	nsec3FlagOptOut = 0x01
+
+	// nsec3MaxIterations is the iteration count above which NSEC3 denials
+	// are treated as insecure (RFC 9276 section 3.2).
+	nsec3MaxIterations = 150
+)
+
+var (
+	errDNSSECUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+	nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+)
+
+// dnssecAlgorithmSupported tells whether signatures of a DNSSEC algorithm
+// can be verified.
+func dnssecAlgorithmSupported(algorithm uint8) bool {
+	switch algorithm {
+	case 8, 10, 13, 14, 15: // RSASHA256, RSASHA512, ECDSAP256SHA256, ECDSAP384SHA384, ED25519
+		return true
+	}
+	return false
+}
+
+// rrsig is a parsed RRSIG record (RFC 4034 section 3).
+type rrsig struct {
+	typeCovered dnsmessage.Type
+	algorithm   uint8
+	labels      uint8
+	originalTTL uint32
+	expiration  uint32
+	inception   uint32
+	keyTag      uint16
+	signerName  string
+	signature   []byte
+}
+
+// dnskey is a parsed DNSKEY record (RFC 4034 section 2).
+type dnskey struct {
+	flags     uint16
+	algorithm uint8
+	publicKey []byte
+	rdata     []byte
+}
+
+// dsRecord is a parsed DS record (RFC 4034 section 5).
+type dsRecord struct {
+	keyTag     uint16
+	algorithm  uint8
+	digestType uint8
+	digest     []byte
+}
+
+// nsecRecord is a parsed NSEC record (RFC 4034 section 4).
+type nsecRecord struct {
+	owner string
+	next  string
+	types []dnsmessage.Type
+}
+
+// nsec3Record is a parsed NSEC3 record (RFC 5155 section 3).
+type nsec3Record struct {
+	hash       string // owner hash, base32hex
+	zone       string
+	flags      uint8
+	iterations uint16
+	salt       []byte
+	next       string // next hash, base32hex
+	types      []dnsmessage.Type
+}
+
+// fqdn returns name in lower case, fully qualified.
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// dnsLabels splits a fully qualified name into its labels, the root having
+// none.
+func dnsLabels(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// parentName strips the leftmost label of a fully qualified name.
+func parentName(name string) string {
+	labels := dnsLabels(name)
+	if len(labels) <= 1 {
+		return "."
+	}
+	return strings.Join(labels[1:], ".") + "."
+}
+
+// isSubdomain tells whether name is zone or below it.
+func isSubdomain(name, zone string) bool {
+	name, zone = fqdn(name), fqdn(zone)
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// nameToWire encodes a name in canonical (lower case, uncompressed) wire
+// format.
+func nameToWire(name string) []byte {
+	var wire []byte
+	for _, label := range dnsLabels(fqdn(name)) {
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	return append(wire, 0)
+}
+
+// readWireName decodes an uncompressed name at offset off of data.
+func readWireName(data []byte, off int) (string, int, error) {
+	var labels []string
+	for {
+		if off >= len(data) {
+			return "", 0, errors.New("truncated name")
+		}
+		length := int(data[off])
+		off++
+		if length == 0 {
+			break
+		}
+		if length > 63 || off+length > len(data) {
+			return "", 0, errors.New("malformed name")
+		}
+		labels = append(labels, strings.ToLower(string(data[off:off+length])))
+		off += length
+	}
+	return strings.Join(labels, ".") + ".", off, nil
+}
+
+// canonicalNameCompare orders names as in RFC 4034 section 6.1.
+func canonicalNameCompare(a, b string) int {
+	la, lb := dnsLabels(fqdn(a)), dnsLabels(fqdn(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare([]byte(la[i]), []byte(lb[j])); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// parseTypeBitmap decodes the type bitmap of NSEC and NSEC3 records.
+func parseTypeBitmap(data []byte) ([]dnsmessage.Type, error) {
+	var types []dnsmessage.Type
+	for len(data) > 0 {
+		if len(data) < 2 || int(data[1]) > 32 || len(data) < 2+int(data[1]) {
+			return nil, errors.New("malformed type bitmap")
+		}
+		window, length := int(data[0]), int(data[1])
+		for i, b := range data[2 : 2+length] {
+			for bit := range 8 {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, dnsmessage.Type(window*256+i*8+bit))
+				}
+			}
+		}
+		data = data[2+length:]
+	}
+	return types, nil
+}
+
+func parseRRSIG(data []byte) (*rrsig, error) {
+	if len(data) < 18 {
+		return nil, errors.New("malformed RRSIG record")
+	}
+	sig := &rrsig{
+		typeCovered: dnsmessage.Type(binary.BigEndian.Uint16(data[0:])),
+		algorithm:   data[2],
+		labels:      data[3],
+		originalTTL: binary.BigEndian.Uint32(data[4:]),
+		expiration:  binary.BigEndian.Uint32(data[8:]),
+		inception:   binary.BigEndian.Uint32(data[12:]),
+		keyTag:      binary.BigEndian.Uint16(data[16:]),
+	}
+	signer, off, err := readWireName(data, 18)
+	if err != nil {
+		return nil, fmt.Errorf("malformed RRSIG record: %w", err)
+	}
+	sig.signerName = signer
+	sig.signature = data[off:]
+	return sig, nil
+}
+
+func parseDNSKEY(data []byte) (*dnskey, error) {
+	if len(data) < 4 {
+		return nil, errors.New("malformed DNSKEY record")
+	}
+	return &dnskey{
+		flags:     binary.BigEndian.Uint16(data[0:]),
+		algorithm: data[3],
+		publicKey: data[4:],
+		rdata:     data,
+	}, nil
+}
+
+func parseDS(data []byte) (*dsRecord, error) {
+	if len(data) < 5 {
+		return nil, errors.New("malformed DS record")
+	}
+	return &dsRecord{
+		keyTag:     binary.BigEndian.Uint16(data[0:]),
+		algorithm:  data[2],
+		digestType: data[3],
+		digest:     data[4:],
+	}, nil
+}
+
+func parseNSEC(owner string, data []byte) (*nsecRecord, error) {
+	next, off, err := readWireName(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("malformed NSEC record: %w", err)
+	}
+	types, err := parseTypeBitmap(data[off:])
+	if err != nil {
+		return nil, err
+	}
+	return &nsecRecord{owner: fqdn(owner), next: next, types: types}, nil
+}
+
+func parseNSEC3(owner string, data []byte) (*nsec3Record, error) {
+	if len(data) < 5 {
+		return nil, errors.New("malformed NSEC3 record")
+	}
+	labels := dnsLabels(fqdn(owner))
+	if len(labels) < 1 {
+		return nil, errors.New("malformed NSEC3 owner")
+	}
+	rec := &nsec3Record{
+		hash:       labels[0],
+		zone:       parentName(fqdn(owner)),
+		flags:      data[1],
+		iterations: binary.BigEndian.Uint16(data[2:]),
+	}
+	if data[0] != 1 { // SHA-1 is the only hash algorithm
+		return nil, errDNSSECUnsupportedAlgorithm
+	}
+
+	off := 4
+	saltLen := int(data[off])
+	off++
+	if off+saltLen >= len(data) {
+		return nil, errors.New("malformed NSEC3 record")
+	}
+	rec.salt = data[off : off+saltLen]
+	off += saltLen
+	hashLen := int(data[off])
+	off++
+	if off+hashLen > len(data) {
+		return nil, errors.New("malformed NSEC3 record")
+	}
+	rec.next = strings.ToLower(nsec3Encoding.EncodeToString(data[off : off+hashLen]))
+
+	types, err := parseTypeBitmap(data[off+hashLen:])
+	if err != nil {
+		return nil, err
+	}
+	rec.types = types
+	return rec, nil
+}
+
+// keyTag computes the key tag of a DNSKEY (RFC 4034 appendix B).
+func (k *dnskey) keyTag() uint16 {
+	var ac uint32
+	for i, b := range k.rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac & 0xFFFF)
+}
+
+// matchesDS tells whether the key of zone is the one a DS record points to.
+func (k *dnskey) matchesDS(zone string, ds *dsRecord) bool {
+	if ds.keyTag != k.keyTag() || ds.algorithm != k.algorithm {
+		return false
+	}
+
+	data := append(nameToWire(zone), k.rdata...)
+	var digest []byte
+	switch ds.digestType {
+	case 1:
+		sum := sha1.Sum(data)
+		digest = sum[:]
+	case 2:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case 4:
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(digest, ds.digest)
+}
+
+// dsDigestSupported tells whether the digest of a DS record can be checked.
+func dsDigestSupported(digestType uint8) bool {
+	return digestType == 1 || digestType == 2 || digestType == 4
+}
+
+// nsec3Hash hashes a name with the parameters of an NSEC3 chain (RFC 5155
+// section 5), returning it base32hex-encoded as in NSEC3 owner names.
+func nsec3Hash(name string, salt []byte, iterations uint16) string {
+	h := sha1.Sum(append(nameToWire(name), salt...))
+	for range iterations {
+		h = sha1.Sum(append(h[:], salt...))
+	}
+	return strings.ToLower(nsec3Encoding.EncodeToString(h[:]))
+}
+
+// canonicalRData returns the RDATA of a record in canonical form: names
+// uncompressed and, for the types listed in RFC 4034 section 6.2, in lower
+// case.
+func canonicalRData(r dnsmessage.Resource) ([]byte, error) {
+	lower := func(n dnsmessage.Name) (dnsmessage.Name, error) {
+		return dnsmessage.NewName(strings.ToLower(n.String()))
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	hdr := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: r.Header.Type, Class: r.Header.Class}
+	var err error
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		err = b.AResource(hdr, *body)
+	case *dnsmessage.AAAAResource:
+		err = b.AAAAResource(hdr, *body)
+	case *dnsmessage.TXTResource:
+		err = b.TXTResource(hdr, *body)
+	case *dnsmessage.UnknownResource:
+		err = b.UnknownResource(hdr, *body)
+	case *dnsmessage.CNAMEResource:
+		c := *body
+		if c.CNAME, err = lower(c.CNAME); err == nil {
+			err = b.CNAMEResource(hdr, c)
+		}
+	case *dnsmessage.NSResource:
+		c := *body
+		if c.NS, err = lower(c.NS); err == nil {
+			err = b.NSResource(hdr, c)
+		}
+	case *dnsmessage.PTRResource:
+		c := *body
+		if c.PTR, err = lower(c.PTR); err == nil {
+			err = b.PTRResource(hdr, c)
+		}
+	case *dnsmessage.MXResource:
+		c := *body
+		if c.MX, err = lower(c.MX); err == nil {
+			err = b.MXResource(hdr, c)
+		}
+	case *dnsmessage.SRVResource:
+		c := *body
+		if c.Target, err = lower(c.Target); err == nil {
+			err = b.SRVResource(hdr, c)
+		}
+	case *dnsmessage.SOAResource:
+		c := *body
+		if c.NS, err = lower(c.NS); err == nil {
+			if c.MBox, err = lower(c.MBox); err == nil {
+				err = b.SOAResource(hdr, c)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cannot canonicalize %s records", r.Header.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	// Skip the message header, the root owner name, type, class, TTL and
+	// RDATA length
+	return msg[12+1+10:], nil
+}
+
+// rrsigSignedData builds the data an RRSIG signs over an RRset (RFC 4034
+// section 3.1.8.1), expanding wildcard owners back to their wildcard name.
+func rrsigSignedData(sig *rrsig, owner string, rrs []dnsmessage.Resource) ([]byte, error) {
+	labels := dnsLabels(fqdn(owner))
+	if len(labels) > 0 && labels[0] == "*" {
+		labels = labels[1:]
+	}
+	if int(sig.labels) > len(labels) {
+		return nil, errors.New("RRSIG has more labels than its owner")
+	}
+	signedOwner := fqdn(owner)
+	if int(sig.labels) < len(labels) {
+		signedOwner = "*." + strings.Join(labels[len(labels)-int(sig.labels):], ".") + "."
+	}
+	ownerWire := nameToWire(signedOwner)
+
+	var rdatas [][]byte
+	for _, rr := range rrs {
+		rdata, err := canonicalRData(rr)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	slices.SortFunc(rdatas, bytes.Compare)
+	rdatas = slices.CompactFunc(rdatas, bytes.Equal)
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(sig.typeCovered))
+	data = append(data, sig.algorithm, sig.labels)
+	data = binary.BigEndian.AppendUint32(data, sig.originalTTL)
+	data = binary.BigEndian.AppendUint32(data, sig.expiration)
+	data = binary.BigEndian.AppendUint32(data, sig.inception)
+	data = binary.BigEndian.AppendUint16(data, sig.keyTag)
+	data = append(data, nameToWire(sig.signerName)...)
+
+	class := uint16(dnsmessage.ClassINET)
+	if len(rrs) > 0 {
+		class = uint16(rrs[0].Header.Class)
+	}
+	for _, rdata := range rdatas {
+		data = append(data, ownerWire...)
+		data = binary.BigEndian.AppendUint16(data, uint16(sig.typeCovered))
+		data = binary.BigEndian.AppendUint16(data, class)
+		data = binary.BigEndian.AppendUint32(data, sig.originalTTL)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data, nil
+}
+
+// verifySignature checks a DNSSEC signature over data with a DNSKEY.
+func verifySignature(algorithm uint8, publicKey, data, signature []byte) error {
+	switch algorithm {
+	case 8, 10:
+		pub, err := parseDNSSECRSAKey(publicKey)
+		if err != nil {
+			return err
+		}
+		hash := crypto.SHA256
+		if algorithm == 10 {
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature)
+
+	case 13, 14:
+		curve, hash, size := elliptic.P256(), crypto.SHA256, 32
+		if algorithm == 14 {
+			curve, hash, size = elliptic.P384(), crypto.SHA384, 48
+		}
+		if len(publicKey) != 2*size || len(signature) != 2*size {
+			return errors.New("malformed ECDSA key or signature")
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, append([]byte{4}, publicKey...))
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(data)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return errors.New("ECDSA verification failure")
+		}
+		return nil
+
+	case 15:
+		if len(publicKey) != ed25519.PublicKeySize {
+			return errors.New("malformed Ed25519 key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(publicKey), data, signature) {
+			return errors.New("Ed25519 verification failure")
+		}
+		return nil
+	}
+
+	return errDNSSECUnsupportedAlgorithm
+}
+
+// parseDNSSECRSAKey decodes an RSA public key in DNSKEY format (RFC 3110).
+func parseDNSSECRSAKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 3 {
+		return nil, errors.New("malformed RSA key")
+	}
+	expLen, off := int(key[0]), 1
+	if expLen == 0 {
+		expLen, off = int(binary.BigEndian.Uint16(key[1:])), 3
+	}
+	if expLen > 4 || off+expLen >= len(key) {
+		return nil, errors.New("malformed RSA key")
+	}
+
+	exponent := 0
+	for _, b := range key[off : off+expLen] {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(key[off+expLen:]),
+		E: exponent,
+	}, nil
+}
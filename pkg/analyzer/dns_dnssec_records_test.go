@@ -0,0 +1,206 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestNSEC3Hash(t *testing.T) {
+	// RFC 5155 appendix A
+	salt := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	tests := map[string]string{
+		"example.":   "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example.": "35mthgpgcu1qg68fab165klnsnk3dpvl",
+	}
+	for name, want := range tests {
+		if got := nsec3Hash(name, salt, 12); got != want {
+			t.Errorf("nsec3Hash(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestCanonicalNameCompare(t *testing.T) {
+	// RFC 4034 section 6.1
+	ordered := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"\001.z.example.",
+		"*.z.example.",
+		"\200.z.example.",
+	}
+	shuffled := slices.Clone(ordered)
+	slices.Reverse(shuffled)
+	slices.SortFunc(shuffled, canonicalNameCompare)
+	if !slices.Equal(shuffled, ordered) {
+		t.Errorf("sorted = %q, want %q", shuffled, ordered)
+	}
+}
+
+func TestParseTypeBitmap(t *testing.T) {
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeMX, dnsTypeRRSIG, dnsTypeNSEC, dnsTypeTLSA, 1234}
+	got, err := parseTypeBitmap(testTypeBitmap(types))
+	if err != nil || !slices.Equal(got, types) {
+		t.Errorf("parseTypeBitmap = %v, %v, want %v", got, err, types)
+	}
+
+	if _, err := parseTypeBitmap([]byte{0, 40}); err == nil {
+		t.Error("parseTypeBitmap accepted a truncated window")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	data := []byte("signed data")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub := append([]byte{3}, big.NewInt(int64(rsaKey.E)).Bytes()...)
+	rsaPub = append(rsaPub, rsaKey.N.Bytes()...)
+	sum512 := sha512.Sum512(data)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA512, sum512[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum384 := sha512.Sum384(data)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, sum384[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig := append(r.FillBytes(make([]byte, 48)), s.FillBytes(make([]byte, 48))...)
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edKey, data)
+
+	tests := []struct {
+		name      string
+		algorithm uint8
+		key       []byte
+		signature []byte
+	}{
+		{"RSASHA512", 10, rsaPub, rsaSig},
+		{"ECDSAP384SHA384", 14, ecPub[1:], ecSig},
+		{"ED25519", 15, edPub, edSig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifySignature(tt.algorithm, tt.key, data, tt.signature); err != nil {
+				t.Errorf("valid signature: %v", err)
+			}
+			if err := verifySignature(tt.algorithm, tt.key, []byte("other data"), tt.signature); err == nil {
+				t.Error("signature verified over other data")
+			}
+		})
+	}
+
+	sum256 := sha256.Sum256(data)
+	if err := verifySignature(5, rsaPub, data, sum256[:]); err != errDNSSECUnsupportedAlgorithm {
+		t.Errorf("RSASHA1: err = %v, want unsupported", err)
+	}
+}
+
+func TestCheckNSECDenial(t *testing.T) {
+	nsec := func(owner, next string, types ...dnsmessage.Type) *nsecRecord {
+		return &nsecRecord{owner: owner, next: next, types: types}
+	}
+	chain := []*nsecRecord{
+		nsec("example.", "a.example.", dnsmessage.TypeSOA, dnsmessage.TypeNS, dnsmessage.TypeMX),
+		nsec("a.example.", "child.example.", dnsmessage.TypeTXT),
+		nsec("child.example.", "x.y.example.", dnsmessage.TypeNS),
+		nsec("x.y.example.", "example.", dnsmessage.TypeA),
+	}
+
+	tests := []struct {
+		name     string
+		nsecs    []*nsecRecord
+		qname    string
+		qtype    dnsmessage.Type
+		nxdomain bool
+		want     model.DNSSECAnswerStatus
+	}{
+		{"no data", chain, "a.example.", dnsmessage.TypeMX, false, model.DNSSECAnswerStatusSecure},
+		{"type present", chain, "a.example.", dnsmessage.TypeTXT, false, model.DNSSECAnswerStatusBogus},
+		{"empty non-terminal", chain, "y.example.", dnsmessage.TypeTXT, false, model.DNSSECAnswerStatusSecure},
+		{"no DS at delegation", chain, "child.example.", dnsTypeDS, false, model.DNSSECAnswerStatusSecure},
+		{"no DS but no delegation", chain, "a.example.", dnsTypeDS, false, model.DNSSECAnswerStatusBogus},
+		{"parent side of a delegation", chain, "child.example.", dnsmessage.TypeTXT, false, model.DNSSECAnswerStatusBogus},
+		{"name error", chain, "b.example.", dnsmessage.TypeTXT, true, model.DNSSECAnswerStatusSecure},
+		{"wrap around", chain, "z.example.", dnsmessage.TypeTXT, true, model.DNSSECAnswerStatusSecure},
+		{"existing name", chain, "a.example.", dnsmessage.TypeTXT, true, model.DNSSECAnswerStatusBogus},
+		{"wildcard not denied", chain[1:3], "b.example.", dnsmessage.TypeTXT, true, model.DNSSECAnswerStatusBogus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkNSECDenial(tt.nsecs, tt.qname, tt.qtype, tt.nxdomain); got.status != tt.want {
+				t.Errorf("checkNSECDenial = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseDNSName(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1":   "1.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	}
+	for addr, want := range tests {
+		if got, err := reverseDNSName(addr); err != nil || got != want {
+			t.Errorf("reverseDNSName(%q) = %q, %v, want %q", addr, got, err, want)
+		}
+	}
+	if _, err := reverseDNSName("not an address"); err == nil {
+		t.Error("reverseDNSName accepted an invalid address")
+	}
+}
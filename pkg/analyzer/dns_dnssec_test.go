@@ -0,0 +1,593 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+type testRRKey struct {
+	name  string
+	rtype dnsmessage.Type
+}
+
+// testZone is an authoritative zone of the local stand-in, signed on the
+// fly with an ECDSA P-256 key unless unsigned.
+type testZone struct {
+	t      *testing.T
+	apex   string
+	key    *ecdsa.PrivateKey
+	nsec3  bool
+	rrsets map[testRRKey][]dnsmessage.Resource
+
+	badSig map[testRRKey]bool // signatures corrupted
+	noSig  map[testRRKey]bool // signatures stripped
+}
+
+// testDNSSECTree is a hierarchy of zones answered by a single server, acting
+// as the recursive resolver the validator queries.
+type testDNSSECTree struct {
+	t       *testing.T
+	mu      sync.Mutex
+	zones   map[string]*testZone
+	queries int
+}
+
+func newTestDNSSECTree(t *testing.T) *testDNSSECTree {
+	tree := &testDNSSECTree{t: t, zones: map[string]*testZone{}}
+	tree.addZone(".", true, false)
+	return tree
+}
+
+// addZone creates a zone delegated from its parent, with a matching DS
+// record when signed.
+func (tr *testDNSSECTree) addZone(apex string, signed, nsec3 bool) *testZone {
+	z := &testZone{
+		t:      tr.t,
+		apex:   apex,
+		nsec3:  nsec3,
+		rrsets: map[testRRKey][]dnsmessage.Resource{},
+		badSig: map[testRRKey]bool{},
+		noSig:  map[testRRKey]bool{},
+	}
+	z.add(dnsmessage.Resource{
+		Header: testHeader(apex, dnsmessage.TypeSOA),
+		Body: &dnsmessage.SOAResource{
+			NS: dnsmessage.MustNewName("ns." + strings.TrimPrefix(apex, ".")), MBox: dnsmessage.MustNewName("hostmaster." + strings.TrimPrefix(apex, ".")),
+			Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: 300,
+		},
+	})
+	z.add(dnsmessage.Resource{Header: testHeader(apex, dnsmessage.TypeNS), Body: &dnsmessage.NSResource{NS: dnsmessage.MustNewName("ns." + strings.TrimPrefix(apex, "."))}})
+
+	if signed {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			tr.t.Fatal(err)
+		}
+		z.key = key
+		z.add(testUnknownRR(apex, dnsTypeDNSKEY, z.dnskey().rdata))
+	}
+
+	if apex != "." {
+		parent := tr.zoneFor(parentName(apex), false)
+		parent.add(dnsmessage.Resource{Header: testHeader(apex, dnsmessage.TypeNS), Body: &dnsmessage.NSResource{NS: dnsmessage.MustNewName("ns." + apex)}})
+		if signed {
+			parent.add(testUnknownRR(apex, dnsTypeDS, z.dsRData()))
+		}
+	}
+
+	tr.zones[apex] = z
+	return z
+}
+
+// anchors returns the trust anchor of the test root.
+func (tr *testDNSSECTree) anchors() []*dsRecord {
+	ds, err := parseDS(tr.zones["."].dsRData())
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return []*dsRecord{ds}
+}
+
+// zoneFor returns the zone answering for name; DS records are served by
+// the parent of a zone.
+func (tr *testDNSSECTree) zoneFor(name string, ds bool) *testZone {
+	name = fqdn(name)
+	if ds && name != "." && tr.zones[name] != nil {
+		name = parentName(name)
+	}
+	for n := name; ; n = parentName(n) {
+		if z, ok := tr.zones[n]; ok {
+			return z
+		}
+	}
+}
+
+func (tr *testDNSSECTree) handle(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.queries++
+	return tr.zoneFor(q.Name.String(), q.Type == dnsTypeDS).answer(fqdn(q.Name.String()), q.Type)
+}
+
+// resolver returns a validator querying the stand-in and trusting its root.
+func (tr *testDNSSECTree) resolver() *DNSSECResolver {
+	server := startTestDNSServer(tr.t, tr.handle)
+	r := NewDNSSECResolver([]DNSUpstream{{Network: "udp", Address: server}}).(*DNSSECResolver)
+	r.anchors = tr.anchors()
+	return r
+}
+
+func testHeader(name string, rtype dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: rtype, Class: dnsmessage.ClassINET, TTL: 300}
+}
+
+func testUnknownRR(name string, rtype dnsmessage.Type, rdata []byte) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: testHeader(name, rtype), Body: &dnsmessage.UnknownResource{Type: rtype, Data: rdata}}
+}
+
+func testTXTRR(name, txt string) dnsmessage.Resource {
+	return dnsmessage.Resource{Header: testHeader(name, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{txt}}}
+}
+
+func (z *testZone) add(rr dnsmessage.Resource) {
+	k := testRRKey{fqdn(rr.Header.Name.String()), rr.Header.Type}
+	z.rrsets[k] = append(z.rrsets[k], rr)
+}
+
+func (z *testZone) dnskey() *dnskey {
+	pub, err := z.key.PublicKey.Bytes()
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	rdata := append([]byte{0x01, 0x01, 3, 13}, pub[1:]...) // KSK and ZSK, ECDSAP256SHA256
+	key, err := parseDNSKEY(rdata)
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	return key
+}
+
+func (z *testZone) dsRData() []byte {
+	key := z.dnskey()
+	digest := sha256.Sum256(append(nameToWire(z.apex), key.rdata...))
+	rdata := binary.BigEndian.AppendUint16(nil, key.keyTag())
+	return append(append(rdata, 13, 2), digest[:]...)
+}
+
+// sign returns the RRset with its signature; rrs are owned by owner, which
+// may be an expansion of a wildcard name.
+func (z *testZone) sign(owner string, rtype dnsmessage.Type, rrs []dnsmessage.Resource, wildcard bool) []dnsmessage.Resource {
+	k := testRRKey{owner, rtype}
+	if z.key == nil || z.noSig[k] {
+		return rrs
+	}
+
+	labels := len(dnsLabels(owner))
+	if wildcard {
+		labels--
+	}
+	now := uint32(time.Now().Unix())
+	sig := &rrsig{
+		typeCovered: rtype,
+		algorithm:   13,
+		labels:      uint8(labels),
+		originalTTL: 300,
+		expiration:  now + 3600,
+		inception:   now - 3600,
+		keyTag:      z.dnskey().keyTag(),
+		signerName:  z.apex,
+	}
+	data, err := rrsigSignedData(sig, owner, rrs)
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, z.key, digest[:])
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	if z.badSig[k] {
+		signature[0] ^= 0xff
+	}
+
+	rdata := binary.BigEndian.AppendUint16(nil, uint16(rtype))
+	rdata = append(rdata, sig.algorithm, sig.labels)
+	rdata = binary.BigEndian.AppendUint32(rdata, sig.originalTTL)
+	rdata = binary.BigEndian.AppendUint32(rdata, sig.expiration)
+	rdata = binary.BigEndian.AppendUint32(rdata, sig.inception)
+	rdata = binary.BigEndian.AppendUint16(rdata, sig.keyTag)
+	rdata = append(append(rdata, nameToWire(z.apex)...), signature...)
+
+	return append(slices.Clone(rrs), testUnknownRR(owner, dnsTypeRRSIG, rdata))
+}
+
+func (z *testZone) exists(name string) bool {
+	for k := range z.rrsets {
+		if k.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *testZone) answer(name string, qtype dnsmessage.Type) dnsmessage.Message {
+	msg := dnsmessage.Message{Header: dnsmessage.Header{RecursionAvailable: true}}
+
+	if rrs, ok := z.rrsets[testRRKey{name, qtype}]; ok {
+		msg.Answers = z.sign(name, qtype, rrs, false)
+		return msg
+	}
+
+	if !z.exists(name) {
+		if rrs, ok := z.rrsets[testRRKey{"*." + parentName(name), qtype}]; ok {
+			var expanded []dnsmessage.Resource
+			for _, rr := range rrs {
+				rr.Header.Name = dnsmessage.MustNewName(name)
+				expanded = append(expanded, rr)
+			}
+			msg.Answers = z.sign(name, qtype, expanded, true)
+			msg.Authorities = z.denial()
+			return msg
+		}
+		msg.RCode = dnsmessage.RCodeNameError
+	}
+
+	msg.Authorities = append(z.sign(z.apex, dnsmessage.TypeSOA, z.rrsets[testRRKey{z.apex, dnsmessage.TypeSOA}], false), z.denial()...)
+	return msg
+}
+
+// denial returns the whole NSEC or NSEC3 chain of the zone, enough to prove
+// the non-existence of any name or type.
+func (z *testZone) denial() []dnsmessage.Resource {
+	if z.key == nil {
+		return nil
+	}
+
+	types := map[string][]dnsmessage.Type{}
+	for k := range z.rrsets {
+		types[k.name] = append(types[k.name], k.rtype)
+	}
+	var owners []string
+	for name := range types {
+		owners = append(owners, name)
+		types[name] = append(types[name], dnsTypeRRSIG)
+	}
+
+	var denial []dnsmessage.Resource
+	if !z.nsec3 {
+		slices.SortFunc(owners, canonicalNameCompare)
+		for i, owner := range owners {
+			next := owners[(i+1)%len(owners)]
+			rdata := append(nameToWire(next), testTypeBitmap(append(types[owner], dnsTypeNSEC))...)
+			denial = append(denial, z.sign(owner, dnsTypeNSEC, []dnsmessage.Resource{testUnknownRR(owner, dnsTypeNSEC, rdata)}, false)...)
+		}
+		return denial
+	}
+
+	salt := []byte{0xab, 0xcd}
+	hashes := map[string]string{}
+	for _, owner := range owners {
+		hashes[nsec3Hash(owner, salt, 2)] = owner
+	}
+	sorted := slices.Sorted(func(yield func(string) bool) {
+		for h := range hashes {
+			if !yield(h) {
+				return
+			}
+		}
+	})
+	for i, h := range sorted {
+		next, err := nsec3Encoding.DecodeString(strings.ToUpper(sorted[(i+1)%len(sorted)]))
+		if err != nil {
+			z.t.Fatal(err)
+		}
+		rdata := []byte{1, 0, 0, 2, byte(len(salt))}
+		rdata = append(append(rdata, salt...), byte(len(next)))
+		rdata = append(append(rdata, next...), testTypeBitmap(types[hashes[h]])...)
+		owner := h + "." + z.apex
+		denial = append(denial, z.sign(owner, dnsTypeNSEC3, []dnsmessage.Resource{testUnknownRR(owner, dnsTypeNSEC3, rdata)}, false)...)
+	}
+	return denial
+}
+
+// testTypeBitmap encodes the type bitmap of NSEC and NSEC3 records.
+func testTypeBitmap(types []dnsmessage.Type) []byte {
+	windows := map[int][]byte{}
+	for _, t := range types {
+		window, bit := int(t)/256, int(t)%256
+		bits := windows[window]
+		for len(bits) <= bit/8 {
+			bits = append(bits, 0)
+		}
+		bits[bit/8] |= 0x80 >> (bit % 8)
+		windows[window] = bits
+	}
+
+	var bitmap []byte
+	for window := range 256 {
+		if bits, ok := windows[window]; ok {
+			bitmap = append(append(bitmap, byte(window), byte(len(bits))), bits...)
+		}
+	}
+	return bitmap
+}
+
+// newTestDNSSECTree populates the zones used by the resolver tests:
+//
+//	test.               signed, NSEC
+//	secure.test.        signed, NSEC3, with a wildcard
+//	unsigned.test.      unsigned delegation
+//	bogus.test.         signed, with a corrupted TXT signature
+//	stripped.test.      signed, with an unsigned TXT RRset
+//	mismatch.test.      signed, with a DS matching no DNSKEY
+func newTestDNSSECZones(t *testing.T) *testDNSSECTree {
+	tree := newTestDNSSECTree(t)
+	tree.addZone("test.", true, false)
+
+	secure := tree.addZone("secure.test.", true, true)
+	secure.add(testTXTRR("secure.test.", "v=spf1 -all"))
+	secure.add(dnsmessage.Resource{Header: testHeader("secure.test.", dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx.secure.test.")}})
+	secure.add(dnsmessage.Resource{Header: testHeader("mx.secure.test.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 25}}})
+	secure.add(tlsaResource(t, "_25._tcp.mx.secure.test.", append([]byte{3, 1, 1}, make([]byte, 32)...)))
+	secure.add(testTXTRR("*.wild.secure.test.", "wildcard"))
+	secure.add(testTXTRR("wild.secure.test.", "parent"))
+
+	unsigned := tree.addZone("unsigned.test.", false, false)
+	unsigned.add(testTXTRR("unsigned.test.", "v=spf1 -all"))
+
+	bogus := tree.addZone("bogus.test.", true, false)
+	bogus.add(testTXTRR("bogus.test.", "v=spf1 -all"))
+	bogus.add(dnsmessage.Resource{Header: testHeader("bogus.test.", dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx.bogus.test.")}})
+	bogus.badSig[testRRKey{"bogus.test.", dnsmessage.TypeTXT}] = true
+
+	stripped := tree.addZone("stripped.test.", true, false)
+	stripped.add(testTXTRR("stripped.test.", "v=spf1 -all"))
+	stripped.noSig[testRRKey{"stripped.test.", dnsmessage.TypeTXT}] = true
+
+	mismatch := tree.addZone("mismatch.test.", true, false)
+	mismatch.add(testTXTRR("mismatch.test.", "v=spf1 -all"))
+	ds := tree.zones["test."].rrsets[testRRKey{"mismatch.test.", dnsTypeDS}][0].Body.(*dnsmessage.UnknownResource)
+	ds.Data[len(ds.Data)-1] ^= 0xff
+
+	return tree
+}
+
+func TestDNSSECResolverLookupTXT(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	resolver := tree.resolver()
+
+	tests := []struct {
+		name       string
+		wantStatus model.DNSSECAnswerStatus
+		wantTXT    string
+		wantErr    string
+		notFound   bool
+	}{
+		{name: "secure.test", wantStatus: model.DNSSECAnswerStatusSecure, wantTXT: "v=spf1 -all"},
+		{name: "foo.wild.secure.test", wantStatus: model.DNSSECAnswerStatusSecure, wantTXT: "wildcard"},
+		{name: "missing.secure.test", wantStatus: model.DNSSECAnswerStatusSecure, notFound: true},
+		{name: "mx.secure.test", wantStatus: model.DNSSECAnswerStatusSecure, notFound: true},
+		{name: "missing.test", wantStatus: model.DNSSECAnswerStatusSecure, notFound: true},
+		{name: "unsigned.test", wantStatus: model.DNSSECAnswerStatusInsecure, wantTXT: "v=spf1 -all"},
+		{name: "missing.unsigned.test", wantStatus: model.DNSSECAnswerStatusInsecure, notFound: true},
+		{name: "bogus.test", wantStatus: model.DNSSECAnswerStatusBogus, wantErr: "RRSIG verification failed"},
+		{name: "stripped.test", wantStatus: model.DNSSECAnswerStatusBogus, wantErr: "is not signed"},
+		{name: "mismatch.test", wantStatus: model.DNSSECAnswerStatusBogus, wantErr: "no DNSKEY of mismatch.test. matches its DS records"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []dnssecVerdict
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ctx = withDNSSECObserver(ctx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+				if name != tt.name || qtype != dnsmessage.TypeTXT {
+					t.Errorf("observed %s %s, want %s TXT", name, dnsTypeName(qtype), tt.name)
+				}
+				got = append(got, verdict)
+			})
+
+			txts, err := resolver.LookupTXT(ctx, tt.name)
+
+			if len(got) != 1 || got[0].status != tt.wantStatus {
+				t.Fatalf("verdicts = %+v, want a single %s", got, tt.wantStatus)
+			}
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), "DNSSEC validation failed") || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want a validation failure containing %q", err, tt.wantErr)
+				}
+			case tt.notFound:
+				if !isDNSNotFound(err) {
+					t.Errorf("err = %v, want not found", err)
+				}
+			default:
+				if err != nil || len(txts) != 1 || txts[0] != tt.wantTXT {
+					t.Errorf("LookupTXT = %v, %v, want [%s]", txts, err, tt.wantTXT)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSSECResolverOtherTypes(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	resolver := tree.resolver()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mxs, err := resolver.LookupMX(ctx, "secure.test")
+	if err != nil || len(mxs) != 1 || mxs[0].Host != "mx.secure.test." || mxs[0].Pref != 10 {
+		t.Errorf("LookupMX = %v, %v", mxs, err)
+	}
+
+	addrs, err := resolver.LookupHost(ctx, "mx.secure.test")
+	if err != nil || !slices.Equal(addrs, []string{"192.0.2.25"}) {
+		t.Errorf("LookupHost = %v, %v", addrs, err)
+	}
+
+	records, authenticated, err := resolver.LookupTLSA(ctx, "_25._tcp.mx.secure.test")
+	if err != nil || !authenticated || len(records) != 1 || records[0].Usage != 3 {
+		t.Errorf("LookupTLSA = %v, %t, %v, want an authenticated record", records, authenticated, err)
+	}
+
+	_, authenticated, err = resolver.LookupTLSA(ctx, "_25._tcp.unsigned.test")
+	if !isDNSNotFound(err) || authenticated {
+		t.Errorf("LookupTLSA(unsigned) = %t, %v, want an unauthenticated not-found", authenticated, err)
+	}
+
+	// The broken chain of trust fails the lookup as a whole
+	if _, err := resolver.LookupHost(ctx, "mx.mismatch.test"); err == nil || isDNSNotFound(err) {
+		t.Errorf("LookupHost(mismatch) err = %v, want a validation failure", err)
+	}
+}
+
+func TestDNSSECResolverCachesZoneKeys(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	resolver := tree.resolver()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := resolver.LookupTXT(ctx, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.Lock()
+	first := tree.queries
+	tree.mu.Unlock()
+
+	if _, err := resolver.LookupTXT(ctx, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.Lock()
+	second := tree.queries - first
+	tree.mu.Unlock()
+
+	if second != 1 {
+		t.Errorf("second lookup sent %d queries, want 1 with the chain of trust cached", second)
+	}
+}
+
+func TestDNSSECResolverZoneCacheBound(t *testing.T) {
+	resolver := NewDNSSECResolver([]DNSUpstream{{Network: "udp", Address: "192.0.2.53:53"}}).(*DNSSECResolver)
+	now := time.Now()
+
+	resolver.cacheZone(&dnssecZone{name: "expired.test.", expires: now.Add(-time.Second)})
+	if _, ok := resolver.cachedZone("expired.test."); ok {
+		t.Error("an expired zone must not be returned")
+	}
+	if _, ok := resolver.zones["expired.test."]; ok {
+		t.Error("an expired zone must be dropped when looked up")
+	}
+
+	resolver.cacheZone(&dnssecZone{name: "stale.test.", expires: now.Add(-time.Second)})
+	resolver.cacheZone(&dnssecZone{name: "first.test.", expires: now.Add(time.Hour)})
+	for i := range dnssecMaxZones {
+		resolver.cacheZone(&dnssecZone{name: fmt.Sprintf("zone%d.test.", i), expires: now.Add(time.Hour)})
+		if i == 0 {
+			// Recently used zones are kept
+			resolver.cachedZone("first.test.")
+		}
+	}
+
+	if len(resolver.zones) != dnssecMaxZones || resolver.lru.Len() != dnssecMaxZones {
+		t.Fatalf("%d zones kept (%d in the LRU list), want %d", len(resolver.zones), resolver.lru.Len(), dnssecMaxZones)
+	}
+	if _, ok := resolver.zones["stale.test."]; ok {
+		t.Error("expired zones must be dropped first")
+	}
+	if _, ok := resolver.zones["zone0.test."]; ok {
+		t.Error("the least recently used zone must be dropped")
+	}
+	if _, ok := resolver.cachedZone("first.test."); !ok {
+		t.Error("a recently used zone must be kept")
+	}
+}
+
+func TestDNSSECResolverUnreachable(t *testing.T) {
+	// Nothing listens on the discard port: the status is unknown
+	resolver := NewDNSSECResolver([]DNSUpstream{{Network: "udp", Address: "127.0.0.1:9"}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var got dnssecVerdict
+	ctx = withDNSSECObserver(ctx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) { got = verdict })
+
+	_, err := resolver.LookupTXT(ctx, "example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || got.status != model.DNSSECAnswerStatusIndeterminate {
+		t.Errorf("err = %v, status = %s, want an indeterminate failure", err, got.status)
+	}
+}
+
+func TestAnalyzeDomainOnlyDNSSEC(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, tree.resolver())
+
+	results := analyzer.AnalyzeDomainOnly("bogus.test")
+	if results.DnssecAnswers == nil {
+		t.Fatal("DnssecAnswers is nil")
+	}
+
+	statuses := map[string]model.DNSSECAnswerStatus{}
+	for _, answer := range *results.DnssecAnswers {
+		statuses[answer.Name+" "+answer.Type] = answer.Status
+	}
+	if got := statuses["bogus.test MX"]; got != model.DNSSECAnswerStatusSecure {
+		t.Errorf("bogus.test MX = %q, want secure", got)
+	}
+	if got := statuses["bogus.test TXT"]; got != model.DNSSECAnswerStatusBogus {
+		t.Errorf("bogus.test TXT = %q, want bogus", got)
+	}
+	if got := statuses["_dmarc.bogus.test TXT"]; got != model.DNSSECAnswerStatusSecure {
+		t.Errorf("_dmarc.bogus.test TXT = %q, want a secure denial", got)
+	}
+
+	if results.Errors == nil || !slices.ContainsFunc(*results.Errors, func(e string) bool {
+		return strings.HasPrefix(e, "DNSSEC validation failed for bogus.test TXT")
+	}) {
+		t.Errorf("Errors = %v, want the broken TXT signature reported", results.Errors)
+	}
+
+	// The analyzer itself keeps its resolver for the next analysis
+	if _, ok := analyzer.resolver.(*DNSSECResolver); !ok {
+		t.Errorf("analyzer resolver = %T, want the DNSSEC resolver", analyzer.resolver)
+	}
+}
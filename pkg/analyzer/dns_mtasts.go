@@ -0,0 +1,319 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+const (
+	// mtaSTSMaxPolicySize bounds the size of a downloaded policy file.
+	mtaSTSMaxPolicySize = 64 * 1024
+
+	// mtaSTSMaxAge is the largest max_age allowed by RFC 8461 (one year).
+	mtaSTSMaxAge = 31557600
+
+	// mtaSTSShortAge is the max_age under which the policy barely survives
+	// between two deliveries, defeating its purpose.
+	mtaSTSShortAge = 86400
+)
+
+var mtaSTSIDRe = regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
+
+// checkMTASTS discovers, fetches and validates the MTA-STS policy of a
+// domain (RFC 8461), and checks that every MX host is allowed by it.
+func (d *DNSAnalyzer) checkMTASTS(domain string, mxRecords *[]model.MXRecord) *model.MTASTSRecord {
+	result := &model.MTASTSRecord{
+		Domain: domain,
+		Valid:  false,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	txtRecords, err := d.resolver.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil {
+		if isDNSNotFound(err) {
+			result.Error = utils.PtrTo("No MTA-STS record found")
+		} else {
+			result.Error = utils.PtrTo(fmt.Sprintf("Failed to lookup MTA-STS record: %s", formatDNSError(err)))
+		}
+		return result
+	}
+
+	var records []string
+	for _, txt := range txtRecords {
+		if strings.HasPrefix(txt, "v=STSv1") {
+			records = append(records, txt)
+		}
+	}
+	if len(records) == 0 {
+		result.Error = utils.PtrTo("No MTA-STS record found")
+		return result
+	}
+	result.Record = &records[0]
+	if len(records) > 1 {
+		result.Error = utils.PtrTo("Multiple MTA-STS records found: senders ignore the policy (RFC 8461 section 3.1)")
+		return result
+	}
+
+	id, err := parseMTASTSRecord(records[0])
+	if err != nil {
+		result.Error = utils.PtrTo(err.Error())
+		return result
+	}
+	result.Id = &id
+
+	policyURL := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+	result.PolicyUrl = &policyURL
+
+	body, contentType, err := d.fetchMTASTSPolicy(policyURL)
+	if err != nil {
+		result.Error = utils.PtrTo(fmt.Sprintf("Failed to fetch MTA-STS policy: %s", err))
+		return result
+	}
+
+	var warnings []string
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/plain" {
+		warnings = append(warnings, fmt.Sprintf("Policy is served as %q instead of text/plain", contentType))
+	}
+
+	policy, policyWarnings, err := parseMTASTSPolicy(body)
+	result.Policy = policy
+	warnings = append(warnings, policyWarnings...)
+	if len(warnings) > 0 {
+		result.Warnings = &warnings
+	}
+	if err != nil {
+		result.Error = utils.PtrTo(err.Error())
+		return result
+	}
+
+	// Senders only deliver to MX hosts listed in the policy (or report them
+	// in testing mode)
+	var unmatched []string
+	if mxRecords != nil && policy.Mx != nil {
+		var matches []model.MTASTSMXMatch
+		for _, mx := range *mxRecords {
+			if !mx.Valid || mx.Host == "" {
+				continue
+			}
+			host := strings.TrimSuffix(mx.Host, ".")
+			match := model.MTASTSMXMatch{Host: host}
+			for _, pattern := range *policy.Mx {
+				if mtaSTSMXMatches(pattern, host) {
+					match.Matched = true
+					match.Pattern = utils.PtrTo(pattern)
+					break
+				}
+			}
+			if !match.Matched {
+				unmatched = append(unmatched, host)
+			}
+			matches = append(matches, match)
+		}
+		if len(matches) > 0 {
+			result.MxMatches = &matches
+		}
+	}
+
+	if len(unmatched) > 0 && *policy.Mode != model.MTASTSPolicyModeNone {
+		result.Error = utils.PtrTo(fmt.Sprintf("MX hosts not allowed by the MTA-STS policy: %s", strings.Join(unmatched, ", ")))
+		return result
+	}
+
+	result.Valid = true
+	return result
+}
+
+// parseMTASTSRecord validates the TXT record at _mta-sts.<domain> and
+// returns its policy id.
+func parseMTASTSRecord(record string) (string, error) {
+	var id string
+	for i, field := range strings.Split(record, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return "", fmt.Errorf("Invalid MTA-STS record field %q", field)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case i == 0:
+			if key != "v" || value != "STSv1" {
+				return "", fmt.Errorf("MTA-STS record must start with v=STSv1")
+			}
+		case key == "id":
+			id = value
+		}
+	}
+
+	if id == "" {
+		return "", fmt.Errorf("MTA-STS record has no id")
+	}
+	if !mtaSTSIDRe.MatchString(id) {
+		return "", fmt.Errorf("Invalid MTA-STS id %q: must be 1 to 32 letters and digits", id)
+	}
+	return id, nil
+}
+
+// fetchMTASTSPolicy downloads a policy file, returning its body and content
+// type. Redirects are not followed, as required by RFC 8461 section 3.3.
+func (d *DNSAnalyzer) fetchMTASTSPolicy(policyURL string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, policyURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	client := *d.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return "", "", fmt.Errorf("HTTP status %d: redirects must not be followed", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, mtaSTSMaxPolicySize+1))
+	if err != nil {
+		return "", "", err
+	}
+	if len(data) > mtaSTSMaxPolicySize {
+		return "", "", fmt.Errorf("policy is larger than %d bytes", mtaSTSMaxPolicySize)
+	}
+
+	return string(data), resp.Header.Get("Content-Type"), nil
+}
+
+// parseMTASTSPolicy parses and validates a policy file. The policy is
+// returned even when invalid so that it can be displayed.
+func parseMTASTSPolicy(body string) (*model.MTASTSPolicy, []string, error) {
+	policy := &model.MTASTSPolicy{Raw: body}
+	var warnings []string
+
+	var mx []string
+	var maxAge string
+	for line := range strings.SplitSeq(body, "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return policy, warnings, fmt.Errorf("Invalid MTA-STS policy line %q", line)
+		}
+		value = strings.TrimSpace(value)
+
+		// Unknown keys are extensions and must be ignored
+		switch strings.TrimSpace(key) {
+		case "version":
+			policy.Version = utils.PtrTo(value)
+		case "mode":
+			policy.Mode = utils.PtrTo(model.MTASTSPolicyMode(value))
+		case "mx":
+			mx = append(mx, strings.ToLower(value))
+		case "max_age":
+			maxAge = value
+		}
+	}
+	if len(mx) > 0 {
+		policy.Mx = &mx
+	}
+
+	if policy.Version == nil || *policy.Version != "STSv1" {
+		return policy, warnings, fmt.Errorf("MTA-STS policy must declare version: STSv1")
+	}
+
+	if policy.Mode == nil {
+		return policy, warnings, fmt.Errorf("MTA-STS policy has no mode")
+	}
+	switch *policy.Mode {
+	case model.MTASTSPolicyModeEnforce:
+	case model.MTASTSPolicyModeTesting:
+		warnings = append(warnings, "Policy is in testing mode: senders report TLS failures but still deliver without TLS")
+	case model.MTASTSPolicyModeNone:
+		warnings = append(warnings, "Policy mode is none: MTA-STS is being withdrawn")
+	default:
+		return policy, warnings, fmt.Errorf("Invalid MTA-STS mode %q: must be enforce, testing or none", *policy.Mode)
+	}
+
+	if maxAge == "" {
+		return policy, warnings, fmt.Errorf("MTA-STS policy has no max_age")
+	}
+	age, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil || age < 0 || age > mtaSTSMaxAge {
+		return policy, warnings, fmt.Errorf("Invalid MTA-STS max_age %q: must be between 0 and %d seconds", maxAge, mtaSTSMaxAge)
+	}
+	policy.MaxAge = utils.PtrTo(age)
+	if age < mtaSTSShortAge && *policy.Mode != model.MTASTSPolicyModeNone {
+		warnings = append(warnings, "max_age is shorter than one day: senders will frequently be left without a cached policy (weeks are recommended)")
+	}
+
+	if len(mx) == 0 && *policy.Mode != model.MTASTSPolicyModeNone {
+		return policy, warnings, fmt.Errorf("MTA-STS policy has no mx pattern")
+	}
+	for _, pattern := range mx {
+		if strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return policy, warnings, fmt.Errorf("Invalid MTA-STS mx pattern %q: only a leading \"*.\" wildcard is allowed", pattern)
+		}
+	}
+
+	return policy, warnings, nil
+}
+
+// mtaSTSMXMatches tells whether an MX host is allowed by a policy mx
+// pattern; a leading "*." matches exactly one label.
+func mtaSTSMXMatches(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		label, rest, found := strings.Cut(host, ".")
+		return found && label != "" && "."+rest == suffix
+	}
+	return pattern == host
+}
@@ -0,0 +1,239 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+const testMTASTSPolicy = "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\n"
+
+// newMTASTSTestServer serves policy at the well-known location over TLS and
+// returns a client that reaches it whatever the requested host.
+func newMTASTSTestServer(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	// The test certificate is issued for example.com
+	transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+	transport.TLSClientConfig.ServerName = "example.com"
+
+	return &http.Client{Transport: transport}
+}
+
+func servePolicy(contentType, policy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(policy))
+	}
+}
+
+func TestCheckMTASTS(t *testing.T) {
+	mx := &[]model.MXRecord{
+		{Host: "mail.example.com.", Priority: 10, Valid: true},
+		{Host: "mx1.example.net.", Priority: 20, Valid: true},
+	}
+
+	tests := []struct {
+		name        string
+		txt         []string
+		handler     http.HandlerFunc
+		mx          *[]model.MXRecord
+		wantValid   bool
+		wantErr     string
+		wantWarning string
+	}{
+		{
+			name:      "valid policy covering every MX",
+			txt:       []string{"v=STSv1; id=20240101T000000;"},
+			handler:   servePolicy("text/plain; charset=utf-8", testMTASTSPolicy),
+			mx:        mx,
+			wantValid: true,
+		},
+		{
+			name:    "no record",
+			wantErr: "No MTA-STS record found",
+		},
+		{
+			name:    "multiple records",
+			txt:     []string{"v=STSv1; id=a1;", "v=STSv1; id=a2;"},
+			wantErr: "Multiple MTA-STS records",
+		},
+		{
+			name:    "missing id",
+			txt:     []string{"v=STSv1;"},
+			wantErr: "no id",
+		},
+		{
+			name:    "MX not covered",
+			txt:     []string{"v=STSv1; id=1;"},
+			handler: servePolicy("text/plain", testMTASTSPolicy),
+			mx: &[]model.MXRecord{
+				{Host: "mail.example.com.", Priority: 10, Valid: true},
+				{Host: "backup.example.org.", Priority: 20, Valid: true},
+			},
+			wantErr: "backup.example.org",
+		},
+		{
+			name: "redirect is not followed",
+			txt:  []string{"v=STSv1; id=1;"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "https://example.com/elsewhere.txt", http.StatusFound)
+			},
+			mx:      mx,
+			wantErr: "redirects must not be followed",
+		},
+		{
+			name:        "wrong content type",
+			txt:         []string{"v=STSv1; id=1;"},
+			handler:     servePolicy("text/html", testMTASTSPolicy),
+			mx:          mx,
+			wantValid:   true,
+			wantWarning: "text/html",
+		},
+		{
+			name:        "testing mode with short max_age",
+			txt:         []string{"v=STSv1; id=1;"},
+			handler:     servePolicy("text/plain", "version: STSv1\nmode: testing\nmx: *.example.com\nmx: *.example.net\nmax_age: 3600\n"),
+			mx:          mx,
+			wantValid:   true,
+			wantWarning: "testing mode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txt := map[string][]string{}
+			if tt.txt != nil {
+				txt["_mta-sts.example.com"] = tt.txt
+			}
+			analyzer := newMockAnalyzer(txt, nil)
+			if tt.handler != nil {
+				analyzer.HTTPClient = newMTASTSTestServer(t, tt.handler)
+			}
+
+			result := analyzer.checkMTASTS("example.com", tt.mx)
+			if result.Valid != tt.wantValid {
+				t.Errorf("Valid = %t, want %t (error: %v)", result.Valid, tt.wantValid, result.Error)
+			}
+			if tt.wantErr != "" && (result.Error == nil || !strings.Contains(*result.Error, tt.wantErr)) {
+				t.Errorf("Error = %v, want to contain %q", result.Error, tt.wantErr)
+			}
+			if tt.wantWarning != "" {
+				found := false
+				if result.Warnings != nil {
+					for _, w := range *result.Warnings {
+						found = found || strings.Contains(w, tt.wantWarning)
+					}
+				}
+				if !found {
+					t.Errorf("Warnings = %v, want one containing %q", result.Warnings, tt.wantWarning)
+				}
+			}
+		})
+	}
+}
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "valid", policy: testMTASTSPolicy},
+		{name: "extension keys are ignored", policy: "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\nfoo: bar\n"},
+		{name: "mode none without mx", policy: "version: STSv1\nmode: none\nmax_age: 86400\n"},
+		{name: "wrong version", policy: "version: STSv2\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", wantErr: "version"},
+		{name: "bad mode", policy: "version: STSv1\nmode: strict\nmx: mail.example.com\nmax_age: 86400\n", wantErr: "mode"},
+		{name: "missing max_age", policy: "version: STSv1\nmode: enforce\nmx: mail.example.com\n", wantErr: "max_age"},
+		{name: "max_age too large", policy: "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 99999999\n", wantErr: "max_age"},
+		{name: "missing mx", policy: "version: STSv1\nmode: enforce\nmax_age: 86400\n", wantErr: "mx"},
+		{name: "inner wildcard", policy: "version: STSv1\nmode: enforce\nmx: mail.*.example.com\nmax_age: 86400\n", wantErr: "wildcard"},
+		{name: "garbage line", policy: "version: STSv1\nthis is not a policy\n", wantErr: "line"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseMTASTSPolicy(tt.policy)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMTASTSMXMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"mail.example.com", "mail.example.com", true},
+		{"mail.example.com", "MAIL.example.com.", true},
+		{"mail.example.com", "mx.example.com", false},
+		{"*.example.com", "mx1.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := mtaSTSMXMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("mtaSTSMXMatches(%q, %q) = %t, want %t", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestFetchMTASTSPolicyRefusesReservedAddresses(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{"mta-sts.example.com": {"192.168.1.10"}},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, resolver)
+
+	_, _, err := analyzer.fetchMTASTSPolicy("https://mta-sts.example.com/.well-known/mta-sts.txt")
+	if err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+		t.Errorf("fetchMTASTSPolicy() error = %v, want the address refused", err)
+	}
+}
@@ -23,13 +23,53 @@ package analyzer
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
 
 	"git.happydns.org/happyDeliver/internal/model"
 	"git.happydns.org/happyDeliver/internal/utils"
 )
 
-// checkMXRecords looks up MX records for a domain
+// smtpProbeTimeout bounds an SMTP probe, MX hosts often delaying their
+// greeting by a few seconds to catch impatient spammers.
+const smtpProbeTimeout = 30 * time.Second
+
+// reservedRanges are the address blocks that cannot be reached from the
+// Internet, with a description of each.
+var reservedRanges = []struct {
+	prefix netip.Prefix
+	kind   string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "unspecified"},
+	{netip.MustParsePrefix("10.0.0.0/8"), "private"},
+	{netip.MustParsePrefix("100.64.0.0/10"), "shared address space"},
+	{netip.MustParsePrefix("127.0.0.0/8"), "loopback"},
+	{netip.MustParsePrefix("169.254.0.0/16"), "link-local"},
+	{netip.MustParsePrefix("172.16.0.0/12"), "private"},
+	{netip.MustParsePrefix("192.0.0.0/24"), "IETF protocol assignment"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation"},
+	{netip.MustParsePrefix("192.168.0.0/16"), "private"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation"},
+	{netip.MustParsePrefix("224.0.0.0/4"), "multicast"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+	{netip.MustParsePrefix("::/128"), "unspecified"},
+	{netip.MustParsePrefix("::1/128"), "loopback"},
+	{netip.MustParsePrefix("100::/64"), "discard-only"},
+	{netip.MustParsePrefix("2001:db8::/32"), "documentation"},
+	{netip.MustParsePrefix("fc00::/7"), "unique local"},
+	{netip.MustParsePrefix("fe80::/10"), "link-local"},
+	{netip.MustParsePrefix("ff00::/8"), "multicast"},
+}
+
+// checkMXRecords looks up MX records for a domain and checks each MX host
 func (d *DNSAnalyzer) checkMXRecords(domain string) *[]model.MXRecord {
 	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
 	defer cancel()
@@ -53,64 +93,267 @@ func (d *DNSAnalyzer) checkMXRecords(domain string) *[]model.MXRecord {
 		}
 	}
 
-	var results []model.MXRecord
-	for _, mx := range mxRecords {
-		results = append(results, model.MXRecord{
-			Host:     mx.Host,
-			Priority: mx.Pref,
+	// A lone Null MX declares that the domain accepts no mail (RFC 7505)
+	if len(mxRecords) == 1 && isRootMX(mxRecords[0]) {
+		record := model.MXRecord{
+			Host:     mxRecords[0].Host,
+			Priority: mxRecords[0].Pref,
 			Valid:    true,
-		})
+			NullMx:   utils.PtrTo(true),
+		}
+		if !isNullMX(mxRecords[0]) {
+			record.Valid = false
+			record.Error = utils.PtrTo("Misconfigured Null MX: its preference must be 0 (RFC 7505 section 3), senders may not recognize it and keep retrying")
+		}
+		return &[]model.MXRecord{record}
 	}
 
+	results := make([]model.MXRecord, len(mxRecords))
+
+	var wg sync.WaitGroup
+	for i, mx := range mxRecords {
+		if isRootMX(mx) {
+			results[i] = model.MXRecord{
+				Host:     mx.Host,
+				Priority: mx.Pref,
+				Valid:    false,
+				NullMx:   utils.PtrTo(true),
+				Error:    utils.PtrTo("A Null MX must be the only MX record of the domain (RFC 7505 section 3)"),
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = d.checkMXHost(mx)
+		}()
+	}
+	wg.Wait()
+
 	return &results
 }
 
-func (d *DNSAnalyzer) calculateMXScore(results *model.DNSResults) (score int) {
-	// Having valid MX records is critical for email deliverability
-	// From domain MX records (half points) - needed for replies
-	if results.FromMxRecords != nil && len(*results.FromMxRecords) > 0 {
-		hasValidFromMX := false
-		for _, mx := range *results.FromMxRecords {
-			if mx.Valid {
-				hasValidFromMX = true
-				break
-			}
+// isNullMX tells whether an MX record is a Null MX: preference 0 and the
+// root domain as exchange (RFC 7505 section 3).
+func isNullMX(mx *net.MX) bool {
+	return mx.Pref == 0 && isRootMX(mx)
+}
+
+// isRootMX tells whether the exchange of an MX record is the root domain,
+// which is only meaningful in a Null MX.
+func isRootMX(mx *net.MX) bool {
+	return mx.Host == "" || mx.Host == "."
+}
+
+// checkMXHost checks that an MX host is a canonical hostname resolving to
+// addresses reachable from the Internet, and probes its SMTP server when
+// enabled.
+func (d *DNSAnalyzer) checkMXHost(mx *net.MX) model.MXRecord {
+	record := model.MXRecord{
+		Host:     mx.Host,
+		Priority: mx.Pref,
+		Valid:    true,
+	}
+	host := strings.TrimSuffix(mx.Host, ".")
+
+	// The exchange is a domain name, senders won't connect to an address
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		record.Valid = false
+		record.Error = utils.PtrTo("MX host is an IP address literal instead of a hostname, senders will look it up as a name and fail to deliver")
+		return record
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	var warnings []string
+
+	// MX records must not point to an alias (RFC 2181 section 10.3)
+	if target, err := extendedResolver(d.resolver).LookupCNAME(ctx, host); err == nil && target != "" {
+		target = strings.TrimSuffix(target, ".")
+		record.Cname = utils.PtrTo(target)
+		warnings = append(warnings, fmt.Sprintf("MX host is an alias (CNAME) of %s: MX records must point to the canonical name (RFC 2181 section 10.3), some senders refuse to deliver", target))
+	}
+
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		record.Valid = false
+		if err != nil {
+			record.Error = utils.PtrTo(fmt.Sprintf("MX host does not resolve: %s", formatDNSError(err)))
+		} else {
+			record.Error = utils.PtrTo("MX host does not resolve: no A or AAAA record")
 		}
-		if hasValidFromMX {
-			score += 50
+		if len(warnings) > 0 {
+			record.Warnings = &warnings
 		}
+		return record
 	}
+	record.Addresses = &addrs
 
-	// Return-Path domain MX records (10 points) - needed for bounces
-	if results.RpMxRecords != nil && len(*results.RpMxRecords) > 0 {
-		hasValidRpMX := false
-		for _, mx := range *results.RpMxRecords {
-			if mx.Valid {
-				hasValidRpMX = true
-				break
-			}
+	var public []string
+	for _, addr := range addrs {
+		if kind := reservedAddressKind(addr); kind != "" {
+			warnings = append(warnings, fmt.Sprintf("MX host resolves to %s (%s address), which cannot be reached from the Internet", addr, kind))
+		} else {
+			public = append(public, addr)
+		}
+	}
+
+	if len(public) == 0 {
+		record.Valid = false
+		record.Error = utils.PtrTo("MX host only resolves to addresses that cannot be reached from the Internet")
+	} else if d.SMTPProbe {
+		// Private addresses are never probed, they would reach our own network
+		record.Smtp = d.probeMX(host, net.JoinHostPort(preferIPv4(public)[0], "25"))
+	}
+
+	if len(warnings) > 0 {
+		record.Warnings = &warnings
+	}
+	return record
+}
+
+// reservedAddressKind describes the reserved block an address belongs to,
+// or returns "" for addresses reachable from the Internet.
+func reservedAddressKind(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "invalid"
+	}
+	ip = ip.Unmap()
+	for _, r := range reservedRanges {
+		if r.prefix.Contains(ip) {
+			return r.kind
+		}
+	}
+	return ""
+}
+
+// probeMX probes the SMTP server of an MX host at addr.
+func (d *DNSAnalyzer) probeMX(host, addr string) *model.MXSMTPProbe {
+	if d.smtpProber != nil {
+		return d.smtpProber(host, addr)
+	}
+	return d.probeSMTP(host, addr)
+}
+
+// probeSMTP connects to the SMTP server of an MX host at addr, reading its
+// greeting and checking that it offers STARTTLS with a certificate valid
+// for host.
+func (d *DNSAnalyzer) probeSMTP(host, addr string) *model.MXSMTPProbe {
+	probe := &model.MXSMTPProbe{Address: addr}
+
+	ctx, cancel := context.WithTimeout(context.Background(), smtpProbeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		probe.Error = utils.PtrTo(err.Error())
+		return probe
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	text := textproto.NewConn(conn)
+	_, banner, err := text.ReadResponse(220)
+	if err != nil {
+		probe.Error = utils.PtrTo(fmt.Sprintf("Unexpected SMTP greeting: %s", err))
+		return probe
+	}
+	probe.Connected = true
+	probe.Banner = utils.PtrTo(strings.SplitN(banner, "\n", 2)[0])
+
+	helo := d.SMTPHelo
+	if helo == "" {
+		helo = "localhost"
+	}
+	extensions, err := smtpCommand(text, 250, "EHLO %s", helo)
+	if err != nil {
+		probe.Error = utils.PtrTo(fmt.Sprintf("EHLO refused: %s", err))
+		return probe
+	}
+
+	starttls := false
+	for _, ext := range strings.Split(extensions, "\n")[1:] {
+		if strings.EqualFold(strings.TrimSpace(ext), "STARTTLS") {
+			starttls = true
 		}
-		if hasValidRpMX {
+	}
+	probe.Starttls = utils.PtrTo(starttls)
+	if !starttls {
+		smtpCommand(text, 221, "QUIT")
+		return probe
+	}
+
+	if _, err := smtpCommand(text, 220, "STARTTLS"); err != nil {
+		probe.TlsError = utils.PtrTo(fmt.Sprintf("STARTTLS refused: %s", err))
+		return probe
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: host,
+		RootCAs:    d.SMTPRoots,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		probe.TlsError = utils.PtrTo(err.Error())
+		return probe
+	}
+	probe.TlsVersion = utils.PtrTo(tls.VersionName(tlsConn.ConnectionState().Version))
+
+	smtpCommand(textproto.NewConn(tlsConn), 221, "QUIT")
+	return probe
+}
+
+// smtpCommand sends a command and reads its reply, expecting the given code.
+func smtpCommand(text *textproto.Conn, expectCode int, format string, args ...any) (string, error) {
+	if err := text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	_, message, err := text.ReadResponse(expectCode)
+	return message, err
+}
+
+func (d *DNSAnalyzer) calculateMXScore(results *model.DNSResults) (score int) {
+	// Having valid MX records is critical for email deliverability
+	// From domain MX records (half points) - needed for replies. A Null MX
+	// is a valid statement that the domain sends without receiving.
+	if hasValidMX(results.FromMxRecords, true) {
+		score += 50
+	}
+
+	// Return-Path domain MX records (half points) - needed for bounces. A
+	// Null MX gets no points there: bounces can't be delivered, and receivers
+	// SHOULD refuse such envelope senders (RFC 7505 section 4.2).
+	if results.RpMxRecords != nil && len(*results.RpMxRecords) > 0 {
+		if hasValidMX(results.RpMxRecords, false) {
 			score += 50
 		}
 	} else if results.RpDomain != nil && *results.RpDomain != results.FromDomain {
 		// If Return-Path domain is different but has no MX records, it's a problem
 		// Don't deduct points if RP domain is same as From domain (already checked)
 	} else {
-		// If Return-Path is same as From domain, give full 10 points for RP MX
-		if results.FromMxRecords != nil && len(*results.FromMxRecords) > 0 {
-			hasValidFromMX := false
-			for _, mx := range *results.FromMxRecords {
-				if mx.Valid {
-					hasValidFromMX = true
-					break
-				}
-			}
-			if hasValidFromMX {
-				score += 50
-			}
+		// If Return-Path is same as From domain, its MX records also receive bounces
+		if hasValidMX(results.FromMxRecords, false) {
+			score += 50
 		}
 	}
 
 	return
 }
+
+// hasValidMX tells whether a domain has a valid MX record, a Null MX
+// counting only when allowNullMX is set.
+func hasValidMX(mxRecords *[]model.MXRecord, allowNullMX bool) bool {
+	if mxRecords == nil {
+		return false
+	}
+	for _, mx := range *mxRecords {
+		if mx.Valid && (allowNullMX || !utils.DerefOrZero(mx.NullMx)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+func TestCheckMXRecords(t *testing.T) {
+	resolver := &spfMockResolver{
+		mx: map[string][]*net.MX{
+			"ok.test":       {{Host: "mx1.ok.test.", Pref: 10}, {Host: "mx2.ok.test.", Pref: 20}},
+			"null.test":     {{Host: ".", Pref: 0}},
+			"nullpref.test": {{Host: ".", Pref: 10}},
+			"mixed.test":    {{Host: ".", Pref: 0}, {Host: "mx1.ok.test.", Pref: 10}},
+			"literal.test":  {{Host: "93.184.216.34.", Pref: 10}},
+			"alias.test":    {{Host: "mail.alias.test.", Pref: 10}},
+			"dangling.test": {{Host: "mx.dangling.test.", Pref: 10}},
+			"private.test":  {{Host: "mx.private.test.", Pref: 10}},
+			"partial.test":  {{Host: "mx.partial.test.", Pref: 10}},
+		},
+		hosts: map[string][]string{
+			"mx1.ok.test":     {"93.184.216.34"},
+			"mx2.ok.test":     {"2606:2800:220:1:248:1893:25c8:1946"},
+			"mail.alias.test": {"93.184.216.34"},
+			"mx.private.test": {"10.0.0.25", "fd00::25"},
+			"mx.partial.test": {"93.184.216.34", "127.0.0.1"},
+		},
+		cname: map[string]string{"mail.alias.test": "mx.provider.test."},
+		err:   map[string]error{"mx.dangling.test": &net.DNSError{Err: "server misbehaving", Name: "mx.dangling.test", IsTemporary: true}},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, resolver)
+
+	tests := []struct {
+		domain       string
+		wantValid    []bool
+		wantNullMX   bool
+		wantCNAME    string
+		wantWarning  string
+		wantError    string
+		wantAddrsLen int
+	}{
+		{domain: "ok.test", wantValid: []bool{true, true}, wantAddrsLen: 1},
+		{domain: "null.test", wantValid: []bool{true}, wantNullMX: true},
+		{domain: "nullpref.test", wantValid: []bool{false}, wantNullMX: true, wantError: "Misconfigured Null MX"},
+		{domain: "mixed.test", wantValid: []bool{false, true}, wantNullMX: true, wantError: "only MX record", wantAddrsLen: 1},
+		{domain: "literal.test", wantValid: []bool{false}, wantError: "IP address literal"},
+		{domain: "alias.test", wantValid: []bool{true}, wantCNAME: "mx.provider.test", wantWarning: "alias (CNAME)", wantAddrsLen: 1},
+		{domain: "dangling.test", wantValid: []bool{false}, wantError: "does not resolve"},
+		{domain: "private.test", wantValid: []bool{false}, wantWarning: "private address", wantError: "cannot be reached", wantAddrsLen: 2},
+		{domain: "partial.test", wantValid: []bool{true}, wantWarning: "loopback address", wantAddrsLen: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			records := analyzer.checkMXRecords(tt.domain)
+			if records == nil || len(*records) != len(tt.wantValid) {
+				t.Fatalf("got %+v, want %d records", records, len(tt.wantValid))
+			}
+
+			var nullMX bool
+			var warnings, errs []string
+			for i, mx := range *records {
+				if mx.Valid != tt.wantValid[i] {
+					t.Errorf("record %d (%s): Valid = %t, want %t (error: %v)", i, mx.Host, mx.Valid, tt.wantValid[i], utils.DerefOrZero(mx.Error))
+				}
+				nullMX = nullMX || utils.DerefOrZero(mx.NullMx)
+				warnings = append(warnings, utils.DerefOrZero(mx.Warnings)...)
+				errs = append(errs, utils.DerefOrZero(mx.Error))
+			}
+
+			if nullMX != tt.wantNullMX {
+				t.Errorf("NullMx = %t, want %t", nullMX, tt.wantNullMX)
+			}
+			last := (*records)[len(*records)-1]
+			if got := utils.DerefOrZero(last.Cname); got != tt.wantCNAME {
+				t.Errorf("Cname = %q, want %q", got, tt.wantCNAME)
+			}
+			if got := len(utils.DerefOrZero(last.Addresses)); got != tt.wantAddrsLen {
+				t.Errorf("got %d addresses, want %d", got, tt.wantAddrsLen)
+			}
+			if tt.wantWarning != "" && !strings.Contains(strings.Join(warnings, "\n"), tt.wantWarning) {
+				t.Errorf("warnings %q, want one containing %q", warnings, tt.wantWarning)
+			}
+			if tt.wantWarning == "" && len(warnings) > 0 {
+				t.Errorf("unexpected warnings: %q", warnings)
+			}
+			if tt.wantError != "" && !strings.Contains(strings.Join(errs, "\n"), tt.wantError) {
+				t.Errorf("errors %q, want one containing %q", errs, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReservedAddressKind(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"93.184.216.34", ""},
+		{"2606:2800:220:1:248:1893:25c8:1946", ""},
+		{"10.1.2.3", "private"},
+		{"172.31.0.1", "private"},
+		{"192.168.1.1", "private"},
+		{"127.0.0.1", "loopback"},
+		{"::1", "loopback"},
+		{"100.64.0.1", "shared address space"},
+		{"169.254.1.1", "link-local"},
+		{"192.0.2.1", "documentation"},
+		{"2001:db8::1", "documentation"},
+		{"::ffff:10.0.0.1", "private"},
+		{"fd12:3456::1", "unique local"},
+		{"0.0.0.0", "unspecified"},
+		{"255.255.255.255", "reserved"},
+		{"not-an-ip", "invalid"},
+	}
+
+	for _, tt := range tests {
+		if got := reservedAddressKind(tt.addr); got != tt.want {
+			t.Errorf("reservedAddressKind(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateMXScoreNullMX(t *testing.T) {
+	valid := &[]model.MXRecord{{Host: "mx.example.com.", Priority: 10, Valid: true}}
+	nullMX := &[]model.MXRecord{{Host: ".", Valid: true, NullMx: utils.PtrTo(true)}}
+
+	tests := []struct {
+		name    string
+		results *model.DNSResults
+		want    int
+	}{
+		{
+			name:    "regular MX",
+			results: &model.DNSResults{FromDomain: "example.com", FromMxRecords: valid},
+			want:    100,
+		},
+		{
+			name:    "Null MX on a domain sending its own bounces",
+			results: &model.DNSResults{FromDomain: "example.com", FromMxRecords: nullMX},
+			want:    50,
+		},
+		{
+			name:    "Null MX on the Return-Path domain",
+			results: &model.DNSResults{FromDomain: "example.com", RpDomain: utils.PtrTo("bounces.example.com"), FromMxRecords: valid, RpMxRecords: nullMX},
+			want:    50,
+		},
+		{
+			name:    "Null MX on a From domain with a separate Return-Path",
+			results: &model.DNSResults{FromDomain: "example.com", RpDomain: utils.PtrTo("bounces.example.com"), FromMxRecords: nullMX, RpMxRecords: valid},
+			want:    100,
+		},
+	}
+
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, &spfMockResolver{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzer.calculateMXScore(tt.results); got != tt.want {
+				t.Errorf("calculateMXScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// smtpTestSession accepts any SMTP transaction.
+type smtpTestSession struct{}
+
+func (smtpTestSession) Reset()                               {}
+func (smtpTestSession) Logout() error                        { return nil }
+func (smtpTestSession) Mail(string, *smtp.MailOptions) error { return nil }
+func (smtpTestSession) Rcpt(string, *smtp.RcptOptions) error { return nil }
+func (smtpTestSession) Data(r io.Reader) error               { _, err := io.Copy(io.Discard, r); return err }
+
+// startTestSMTPServer runs an SMTP server on a local port, offering
+// STARTTLS when tlsConfig is set, and returns its address.
+func startTestSMTPServer(t *testing.T, tlsConfig *tls.Config) string {
+	t.Helper()
+
+	server := smtp.NewServer(smtp.BackendFunc(func(*smtp.Conn) (smtp.Session, error) {
+		return smtpTestSession{}, nil
+	}))
+	server.Domain = "mx.example.com"
+	server.TLSConfig = tlsConfig
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	return l.Addr().String()
+}
+
+// newTestSMTPCertificate issues a self-signed certificate for names, and
+// returns it along with a pool trusting it.
+func newTestSMTPCertificate(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: names[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              names,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, roots
+}
+
+func TestProbeSMTP(t *testing.T) {
+	cert, roots := newTestSMTPCertificate(t, "mx.example.com")
+	withTLS := startTestSMTPServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	withoutTLS := startTestSMTPServer(t, nil)
+
+	// A port nothing listens on anymore
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	analyzer := NewDNSAnalyzerWithResolver(time.Second, &spfMockResolver{})
+	analyzer.SMTPHelo = "probe.test"
+	analyzer.SMTPRoots = roots
+
+	t.Run("STARTTLS with a valid certificate", func(t *testing.T) {
+		probe := analyzer.probeSMTP("mx.example.com", withTLS)
+		if !probe.Connected || probe.Error != nil {
+			t.Fatalf("expected to connect, got %+v", probe)
+		}
+		if got := utils.DerefOrZero(probe.Banner); got != "mx.example.com ESMTP Service Ready" {
+			t.Errorf("Banner = %q", got)
+		}
+		if !utils.DerefOrZero(probe.Starttls) || probe.TlsVersion == nil || probe.TlsError != nil {
+			t.Errorf("expected a successful STARTTLS, got %+v", probe)
+		}
+	})
+
+	t.Run("STARTTLS with a certificate for another name", func(t *testing.T) {
+		probe := analyzer.probeSMTP("mail.example.org", withTLS)
+		if !utils.DerefOrZero(probe.Starttls) || probe.TlsVersion != nil || probe.TlsError == nil {
+			t.Errorf("expected a certificate error, got %+v", probe)
+		}
+	})
+
+	t.Run("no STARTTLS", func(t *testing.T) {
+		probe := analyzer.probeSMTP("mx.example.com", withoutTLS)
+		if !probe.Connected || probe.Starttls == nil || *probe.Starttls {
+			t.Errorf("expected STARTTLS not to be offered, got %+v", probe)
+		}
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		probe := analyzer.probeSMTP("mx.example.com", closed)
+		if probe.Connected || probe.Error == nil {
+			t.Errorf("expected a connection error, got %+v", probe)
+		}
+	})
+}
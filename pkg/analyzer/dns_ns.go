@@ -0,0 +1,349 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// nsRecordCheck is a record compared across the authoritative nameservers
+// of a zone.
+type nsRecordCheck struct {
+	label  string
+	name   string
+	qtype  dnsmessage.Type
+	prefix string // only TXT records starting with this prefix are compared
+}
+
+// nsRecordChecks lists the records of a sender domain compared across its
+// nameservers: MX, SPF (at spfDomain), DMARC and the DKIM keys of the given
+// signatures.
+func nsRecordChecks(domain, spfDomain string, dkim []DKIMHeader) []nsRecordCheck {
+	checks := []nsRecordCheck{
+		{label: "MX", name: domain, qtype: dnsmessage.TypeMX},
+		{label: "SPF", name: spfDomain, qtype: dnsmessage.TypeTXT, prefix: "v=spf1"},
+		{label: "DMARC", name: "_dmarc." + domain, qtype: dnsmessage.TypeTXT, prefix: "v=DMARC1"},
+	}
+	for _, sig := range dkim {
+		checks = append(checks, nsRecordCheck{
+			label: "DKIM " + sig.Selector,
+			name:  sig.Selector + "._domainkey." + sig.Domain,
+			qtype: dnsmessage.TypeTXT,
+		})
+	}
+	return checks
+}
+
+// dnsExchangeFunc sends a query straight to a nameserver address.
+type dnsExchangeFunc func(ctx context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error)
+
+// exchangeAuthoritative is the dnsExchangeFunc querying the nameserver over
+// UDP, retrying over TCP on truncated answers.
+func exchangeAuthoritative(ctx context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	return exchangeDNS(ctx, []DNSUpstream{{Network: "udp", Address: net.JoinHostPort(address, "53")}}, name, qtype, false)
+}
+
+// queryAuthoritative sends a query straight to a nameserver address.
+func (d *DNSAnalyzer) queryAuthoritative(ctx context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	if d.authoritativeExchange != nil {
+		return d.authoritativeExchange(ctx, address, name, qtype)
+	}
+	return exchangeAuthoritative(ctx, address, name, qtype)
+}
+
+// findZoneNameservers returns the closest enclosing zone of domain that has
+// NS records, along with its nameserver hostnames.
+func (d *DNSAnalyzer) findZoneNameservers(ctx context.Context, domain string) (string, []string, error) {
+	for name := strings.TrimSuffix(domain, "."); strings.Contains(name, "."); name = name[strings.Index(name, ".")+1:] {
+		nss, err := extendedResolver(d.resolver).LookupNS(ctx, name)
+		if err != nil {
+			if isDNSNotFound(err) {
+				continue
+			}
+			return "", nil, err
+		}
+		var hosts []string
+		for _, ns := range nss {
+			host := strings.ToLower(strings.TrimSuffix(ns.Host, "."))
+			if host != "" && !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) > 0 {
+			slices.Sort(hosts)
+			return name, hosts, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// nsServerAnswers holds what one nameserver address served for each record
+// check; a nil entry means no comparable answer.
+type nsServerAnswers struct {
+	check   model.NameserverCheck
+	serial  uint32
+	answers []*[]string
+}
+
+// checkNSConsistency queries each authoritative nameserver of the zone of
+// domain directly, flagging lame servers, servers lagging on the SOA serial
+// and servers whose records differ from the majority. It returns nil when
+// the resolver can't look up NS records.
+func (d *DNSAnalyzer) checkNSConsistency(domain string, checks []nsRecordCheck) *model.NSConsistency {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	zone, hosts, err := d.findZoneNameservers(ctx, domain)
+	if errors.Is(err, errUnsupportedLookup) {
+		return nil
+	}
+	if err != nil {
+		return &model.NSConsistency{
+			Zone:    domain,
+			Servers: []model.NameserverCheck{},
+			Error:   utils.PtrTo(fmt.Sprintf("Failed to lookup NS records: %s", formatDNSError(err))),
+		}
+	}
+	if zone == "" {
+		return nil
+	}
+
+	// Only compare the records served by this zone
+	checks = slices.DeleteFunc(slices.Clone(checks), func(c nsRecordCheck) bool {
+		return !isSubdomain(c.name, zone)
+	})
+
+	var servers []*nsServerAnswers
+	for _, host := range hosts {
+		addrs, err := d.resolver.LookupHost(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			servers = append(servers, &nsServerAnswers{check: model.NameserverCheck{
+				Host:  host,
+				Lame:  true,
+				Error: utils.PtrTo("Cannot resolve the nameserver address"),
+			}})
+			continue
+		}
+		for _, addr := range preferIPv4(addrs) {
+			servers = append(servers, &nsServerAnswers{check: model.NameserverCheck{Host: host, Address: addr}})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		if server.check.Lame {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.queryNameserver(ctx, zone, server, checks)
+		}()
+	}
+	wg.Wait()
+
+	return compareNameservers(zone, servers, checks)
+}
+
+// preferIPv4 keeps the IPv4 addresses of a nameserver, IPv6 connectivity
+// being often missing, unless it has none.
+func preferIPv4(addrs []string) []string {
+	var ipv4 []string
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			ipv4 = append(ipv4, addr)
+		}
+	}
+	if len(ipv4) == 0 {
+		return addrs
+	}
+	return ipv4
+}
+
+// queryNameserver fetches the SOA serial and the compared records from one
+// nameserver address.
+func (d *DNSAnalyzer) queryNameserver(ctx context.Context, zone string, server *nsServerAnswers, checks []nsRecordCheck) {
+	server.answers = make([]*[]string, len(checks))
+
+	resp, err := d.queryAuthoritative(ctx, server.check.Address, zone, dnsmessage.TypeSOA)
+	if err != nil {
+		server.check.Lame = true
+		server.check.Error = utils.PtrTo(formatDNSError(err))
+		return
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		server.check.Lame = true
+		server.check.Error = utils.PtrTo(strings.TrimPrefix(resp.RCode.String(), "RCode"))
+		return
+	}
+	for _, rr := range resp.Answers {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok && resp.Authoritative {
+			server.serial = soa.Serial
+			server.check.Serial = utils.PtrTo(int64(soa.Serial))
+		}
+	}
+	if server.check.Serial == nil {
+		server.check.Lame = true
+		server.check.Error = utils.PtrTo("Not authoritative for " + zone)
+		return
+	}
+
+	var failures []string
+	for i, check := range checks {
+		resp, err := d.queryAuthoritative(ctx, server.check.Address, check.name, check.qtype)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", check.label, formatDNSError(err)))
+			continue
+		}
+		switch {
+		case resp.RCode == dnsmessage.RCodeNameError:
+			server.answers[i] = &[]string{}
+		case resp.RCode != dnsmessage.RCodeSuccess:
+			failures = append(failures, fmt.Sprintf("%s: %s", check.label, strings.TrimPrefix(resp.RCode.String(), "RCode")))
+		case resp.Authoritative:
+			records := normalizeNSAnswer(resp.Answers, check)
+			server.answers[i] = &records
+		}
+		// Otherwise a referral: the name is delegated to other servers
+	}
+	if len(failures) > 0 {
+		server.check.Error = utils.PtrTo(strings.Join(failures, "; "))
+	}
+}
+
+// normalizeNSAnswer renders the records of an answer in a comparable form.
+func normalizeNSAnswer(answers []dnsmessage.Resource, check nsRecordCheck) []string {
+	records := []string{}
+	for _, rr := range answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			records = append(records, "CNAME "+strings.ToLower(body.CNAME.String()))
+		case *dnsmessage.MXResource:
+			records = append(records, fmt.Sprintf("%d %s", body.Pref, strings.ToLower(body.MX.String())))
+		case *dnsmessage.TXTResource:
+			txt := strings.Join(body.TXT, "")
+			if check.prefix == "" || strings.HasPrefix(strings.ToLower(txt), strings.ToLower(check.prefix)) {
+				records = append(records, txt)
+			}
+		}
+	}
+	slices.Sort(records)
+	return records
+}
+
+// compareNameservers builds the report out of the answers of each
+// nameserver.
+func compareNameservers(zone string, servers []*nsServerAnswers, checks []nsRecordCheck) *model.NSConsistency {
+	result := &model.NSConsistency{
+		Zone:       zone,
+		Consistent: true,
+	}
+
+	// Most recent serial, in serial number arithmetic (RFC 1982)
+	var newest uint32
+	found := false
+	for _, server := range servers {
+		if server.check.Serial != nil && (!found || int32(server.serial-newest) > 0) {
+			newest, found = server.serial, true
+		}
+	}
+
+	for _, server := range servers {
+		if server.check.Serial != nil && int32(newest-server.serial) > 0 {
+			server.check.Lagging = true
+		}
+	}
+
+	var records []model.NSRecordComparison
+	for i, check := range checks {
+		comparison := model.NSRecordComparison{
+			Label:      check.label,
+			Name:       check.name,
+			Type:       dnsTypeName(check.qtype),
+			Consistent: true,
+			Answers:    []model.NSRecordAnswer{},
+		}
+
+		groups := map[string]int{}
+		for _, server := range servers {
+			if server.check.Lame || server.answers[i] == nil {
+				continue
+			}
+			key := strings.Join(*server.answers[i], "\n")
+			idx, ok := groups[key]
+			if !ok {
+				idx = len(comparison.Answers)
+				groups[key] = idx
+				comparison.Answers = append(comparison.Answers, model.NSRecordAnswer{Records: *server.answers[i], Servers: []string{}})
+			}
+			comparison.Answers[idx].Servers = append(comparison.Answers[idx].Servers, server.check.Address)
+		}
+		if len(comparison.Answers) == 0 {
+			continue
+		}
+
+		slices.SortStableFunc(comparison.Answers, func(a, b model.NSRecordAnswer) int {
+			return len(b.Servers) - len(a.Servers)
+		})
+		if len(comparison.Answers) > 1 {
+			comparison.Consistent = false
+			for _, answer := range comparison.Answers[1:] {
+				for _, server := range servers {
+					if !slices.Contains(answer.Servers, server.check.Address) {
+						continue
+					}
+					if server.check.DifferingRecords == nil {
+						server.check.DifferingRecords = &[]string{}
+					}
+					*server.check.DifferingRecords = append(*server.check.DifferingRecords, check.label)
+				}
+			}
+		}
+
+		if !comparison.Consistent {
+			result.Consistent = false
+		}
+		records = append(records, comparison)
+	}
+	if len(records) > 0 {
+		result.Records = &records
+	}
+
+	for _, server := range servers {
+		if server.check.Lame || server.check.Lagging {
+			result.Consistent = false
+		}
+		result.Servers = append(result.Servers, server.check)
+	}
+	return result
+}
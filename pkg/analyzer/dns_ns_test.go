@@ -0,0 +1,250 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// fakeNameserver serves a zone as an authoritative nameserver would.
+type fakeNameserver struct {
+	zone   string
+	serial uint32
+	lame   bool
+	err    error
+	txt    map[string][]string
+	mx     map[string][]string
+}
+
+func (ns *fakeNameserver) answer(name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	if ns.err != nil {
+		return nil, ns.err
+	}
+	msg := &dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	if ns.lame {
+		msg.Authoritative = false
+		msg.RCode = dnsmessage.RCodeRefused
+		return msg, nil
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	hdr := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name + "."), Type: qtype, Class: dnsmessage.ClassINET, TTL: 300}
+	switch {
+	case qtype == dnsmessage.TypeSOA && name == ns.zone:
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns1." + ns.zone + "."),
+			MBox:   dnsmessage.MustNewName("hostmaster." + ns.zone + "."),
+			Serial: ns.serial,
+		}})
+	case qtype == dnsmessage.TypeTXT && ns.txt[name] != nil:
+		for _, txt := range ns.txt[name] {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.TXTResource{TXT: []string{txt}}})
+		}
+	case qtype == dnsmessage.TypeMX && ns.mx[name] != nil:
+		for _, mx := range ns.mx[name] {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName(mx + ".")}})
+		}
+	case name != ns.zone && ns.txt[name] == nil && ns.mx[name] == nil:
+		msg.RCode = dnsmessage.RCodeNameError
+	}
+	return msg, nil
+}
+
+func newNSTestAnalyzer(nameservers map[string]*fakeNameserver) *DNSAnalyzer {
+	resolver := &spfMockResolver{
+		ns: map[string][]*net.NS{
+			"example.com": {{Host: "ns1.example.com."}, {Host: "ns2.example.net."}, {Host: "ns3.example.org."}},
+		},
+		hosts: map[string][]string{
+			"ns1.example.com": {"2001:db8::1", "192.0.2.1"},
+			"ns2.example.net": {"192.0.2.2"},
+			"ns3.example.org": {"2001:db8::3"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+	analyzer.authoritativeExchange = func(_ context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+		ns, ok := nameservers[address]
+		if !ok {
+			return nil, errors.New("unexpected query to " + address)
+		}
+		return ns.answer(name, qtype)
+	}
+	return analyzer
+}
+
+func exampleZone(serial uint32) *fakeNameserver {
+	return &fakeNameserver{
+		zone:   "example.com",
+		serial: serial,
+		txt: map[string][]string{
+			"example.com":                    {"v=spf1 mx -all", "site-verification=abc"},
+			"_dmarc.example.com":             {"v=DMARC1; p=reject"},
+			"default._domainkey.example.com": {"v=DKIM1; k=rsa; p=AAAA"},
+		},
+		mx: map[string][]string{
+			"example.com": {"mx.example.com"},
+		},
+	}
+}
+
+func findNameserver(t *testing.T, result *model.NSConsistency, address string) model.NameserverCheck {
+	t.Helper()
+	for _, server := range result.Servers {
+		if server.Address == address {
+			return server
+		}
+	}
+	t.Fatalf("no result for nameserver %s in %+v", address, result.Servers)
+	return model.NameserverCheck{}
+}
+
+func TestCheckNSConsistency(t *testing.T) {
+	dkim := []DKIMHeader{{Domain: "example.com", Selector: "default"}, {Domain: "esp.example", Selector: "s1"}}
+
+	t.Run("consistent", func(t *testing.T) {
+		d := newNSTestAnalyzer(map[string]*fakeNameserver{
+			"192.0.2.1":   exampleZone(2025010101),
+			"192.0.2.2":   exampleZone(2025010101),
+			"2001:db8::3": exampleZone(2025010101),
+		})
+		result := d.checkNSConsistency("example.com", nsRecordChecks("example.com", "example.com", dkim))
+		if result == nil || !result.Consistent {
+			t.Fatalf("expected consistent nameservers, got %+v", result)
+		}
+		if result.Zone != "example.com" {
+			t.Errorf("zone = %q, want example.com", result.Zone)
+		}
+		if len(result.Servers) != 3 {
+			t.Fatalf("expected 3 servers (IPv4 preferred), got %+v", result.Servers)
+		}
+		if result.Records == nil || len(*result.Records) != 4 {
+			t.Fatalf("expected MX, SPF, DMARC and DKIM comparisons, got %+v", result.Records)
+		}
+		for _, record := range *result.Records {
+			if !record.Consistent || len(record.Answers) != 1 {
+				t.Errorf("%s: expected a single answer, got %+v", record.Label, record.Answers)
+			}
+		}
+		spf := (*result.Records)[1]
+		if !slices.Equal(spf.Answers[0].Records, []string{"v=spf1 mx -all"}) {
+			t.Errorf("SPF answer = %v, want only the SPF record", spf.Answers[0].Records)
+		}
+	})
+
+	t.Run("differing and lagging", func(t *testing.T) {
+		stale := exampleZone(2025010100)
+		stale.txt["example.com"] = []string{"v=spf1 a -all"}
+		d := newNSTestAnalyzer(map[string]*fakeNameserver{
+			"192.0.2.1":   exampleZone(2025010101),
+			"192.0.2.2":   stale,
+			"2001:db8::3": exampleZone(2025010101),
+		})
+		result := d.checkNSConsistency("example.com", nsRecordChecks("example.com", "example.com", nil))
+		if result == nil || result.Consistent {
+			t.Fatalf("expected inconsistent nameservers, got %+v", result)
+		}
+
+		server := findNameserver(t, result, "192.0.2.2")
+		if !server.Lagging {
+			t.Error("expected ns2 to lag on SOA serial")
+		}
+		if server.DifferingRecords == nil || !slices.Equal(*server.DifferingRecords, []string{"SPF"}) {
+			t.Errorf("ns2 differing records = %v, want [SPF]", server.DifferingRecords)
+		}
+		if server := findNameserver(t, result, "192.0.2.1"); server.Lagging || server.DifferingRecords != nil {
+			t.Errorf("ns1 should be up to date, got %+v", server)
+		}
+
+		spf := (*result.Records)[1]
+		if spf.Consistent || len(spf.Answers) != 2 || len(spf.Answers[0].Servers) != 2 {
+			t.Errorf("expected the majority SPF answer first, got %+v", spf.Answers)
+		}
+	})
+
+	t.Run("serial wrap around", func(t *testing.T) {
+		d := newNSTestAnalyzer(map[string]*fakeNameserver{
+			"192.0.2.1":   exampleZone(4294967290),
+			"192.0.2.2":   exampleZone(5),
+			"2001:db8::3": exampleZone(5),
+		})
+		result := d.checkNSConsistency("example.com", nil)
+		if !findNameserver(t, result, "192.0.2.1").Lagging {
+			t.Error("expected the serial before the wrap around to lag")
+		}
+		if findNameserver(t, result, "192.0.2.2").Lagging {
+			t.Error("serial after the wrap around should be the newest")
+		}
+	})
+
+	t.Run("lame and unreachable", func(t *testing.T) {
+		d := newNSTestAnalyzer(map[string]*fakeNameserver{
+			"192.0.2.1":   exampleZone(2025010101),
+			"192.0.2.2":   {lame: true},
+			"2001:db8::3": {err: errors.New("i/o timeout")},
+		})
+		result := d.checkNSConsistency("example.com", nsRecordChecks("example.com", "example.com", nil))
+		if result == nil || result.Consistent {
+			t.Fatalf("expected inconsistent nameservers, got %+v", result)
+		}
+		for _, address := range []string{"192.0.2.2", "2001:db8::3"} {
+			if server := findNameserver(t, result, address); !server.Lame || server.Error == nil {
+				t.Errorf("expected %s to be lame, got %+v", address, server)
+			}
+		}
+		for _, record := range *result.Records {
+			if !record.Consistent {
+				t.Errorf("%s: lame servers must not be compared", record.Label)
+			}
+		}
+	})
+
+	t.Run("parent zone", func(t *testing.T) {
+		zone := exampleZone(1)
+		zone.txt["mail.example.com"] = []string{"v=spf1 -all"}
+		d := newNSTestAnalyzer(map[string]*fakeNameserver{
+			"192.0.2.1":   zone,
+			"192.0.2.2":   zone,
+			"2001:db8::3": zone,
+		})
+		result := d.checkNSConsistency("mail.example.com", nsRecordChecks("mail.example.com", "mail.example.com", nil))
+		if result == nil || result.Zone != "example.com" || !result.Consistent {
+			t.Fatalf("expected the example.com zone to be checked, got %+v", result)
+		}
+	})
+
+	t.Run("no nameservers", func(t *testing.T) {
+		d := newNSTestAnalyzer(nil)
+		if result := d.checkNSConsistency("example.org", nil); result != nil {
+			t.Errorf("expected no result, got %+v", result)
+		}
+	})
+}
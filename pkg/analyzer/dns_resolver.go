@@ -26,6 +26,8 @@ import (
 	"errors"
 	"net"
 	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // formatDNSError renders a resolution error without exposing the upstream
@@ -40,6 +42,13 @@ func formatDNSError(err error) string {
 	return err.Error()
 }
 
+// isDNSNotFound reports whether err means the queried name does not exist or
+// has no record of the requested type.
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
 // leadingVersion returns the value of a record's leading "v=" tag (up to the
 // first ';' or whitespace), or "" if the record does not start with one. It
 // handles both ';'-delimited records (BIMI/DKIM/DMARC) and space-delimited
@@ -89,6 +98,8 @@ func describeMisplacedRecord(version, ownFamily string) string {
 // DNSResolver defines the interface for DNS resolution operations.
 // This interface abstracts DNS lookups to allow for custom implementations,
 // such as mock resolvers for testing or caching resolvers for performance.
+// Implementations may also provide LookupNS, LookupCNAME and LookupTLSA
+// (see extendedDNSResolver) to enable the checks that need them.
 type DNSResolver interface {
 	// LookupMX returns the DNS MX records for the given domain.
 	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
@@ -105,36 +116,209 @@ type DNSResolver interface {
 	LookupHost(ctx context.Context, host string) ([]string, error)
 }
 
-// StandardDNSResolver is the default DNS resolver implementation that uses net.Resolver.
+// extendedDNSResolver is implemented by resolvers that also serve the
+// lookups of the nameserver consistency, MX alias and DANE checks. Those
+// checks are skipped with resolvers that don't.
+type extendedDNSResolver interface {
+	DNSResolver
+
+	// LookupNS returns the DNS NS records for the given domain.
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+
+	// LookupCNAME returns the target of the CNAME record at the given
+	// name. Unlike net.Resolver.LookupCNAME, a name that is not an alias
+	// gives a not-found error.
+	LookupCNAME(ctx context.Context, name string) (string, error)
+
+	// LookupTLSA returns the DNS TLSA records for the given name, and
+	// whether the upstream resolver authenticated the answer with DNSSEC
+	// (AD bit). The flag is also meaningful along a not-found error, where
+	// it tells whether the denial of existence was authenticated.
+	LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error)
+}
+
+// errUnsupportedLookup is returned for the lookups of extendedDNSResolver
+// when the underlying resolver doesn't implement them.
+var errUnsupportedLookup = errors.New("lookup not supported by the DNS resolver")
+
+// extendedResolver returns resolver with the lookups of extendedDNSResolver,
+// which fail with errUnsupportedLookup when it doesn't implement them.
+func extendedResolver(resolver DNSResolver) extendedDNSResolver {
+	if extended, ok := resolver.(extendedDNSResolver); ok {
+		return extended
+	}
+	return basicDNSResolver{resolver}
+}
+
+// basicDNSResolver completes a DNSResolver into an extendedDNSResolver.
+type basicDNSResolver struct {
+	DNSResolver
+}
+
+func (basicDNSResolver) LookupNS(context.Context, string) ([]*net.NS, error) {
+	return nil, errUnsupportedLookup
+}
+
+func (basicDNSResolver) LookupCNAME(context.Context, string) (string, error) {
+	return "", errUnsupportedLookup
+}
+
+func (basicDNSResolver) LookupTLSA(context.Context, string) ([]*TLSA, bool, error) {
+	return nil, false, errUnsupportedLookup
+}
+
+// TLSA is a DANE TLSA record (RFC 6698).
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte // certificate association data
+}
+
+// parseTLSA decodes the RDATA of a TLSA record.
+func parseTLSA(rdata []byte) (*TLSA, error) {
+	if len(rdata) < 3 {
+		return nil, errors.New("malformed TLSA record")
+	}
+	return &TLSA{
+		Usage:        rdata[0],
+		Selector:     rdata[1],
+		MatchingType: rdata[2],
+		Data:         rdata[3:],
+	}, nil
+}
+
+// StandardDNSResolver is the default DNS resolver implementation. Without
+// configured nameservers, it uses net.Resolver, and queries the record types
+// net.Resolver doesn't support directly from the system nameservers. When
+// nameservers are configured, all the queries are sent to them instead,
+// over their own transport.
 type StandardDNSResolver struct {
-	resolver *net.Resolver
+	resolver  *net.Resolver // nil when nameservers are configured
+	upstreams []DNSUpstream
 }
 
 // NewStandardDNSResolver creates a new StandardDNSResolver with default settings.
 func NewStandardDNSResolver() DNSResolver {
-	return &StandardDNSResolver{
-		resolver: &net.Resolver{
-			PreferGo: true,
-		},
+	return NewStandardDNSResolverWithUpstreams(nil)
+}
+
+// NewStandardDNSResolverWithUpstreams creates a new StandardDNSResolver
+// querying the given nameservers. If none are given, the system resolver
+// is used.
+func NewStandardDNSResolverWithUpstreams(upstreams []DNSUpstream) DNSResolver {
+	if len(upstreams) == 0 {
+		return &StandardDNSResolver{
+			resolver: &net.Resolver{
+				PreferGo: true,
+			},
+			upstreams: systemNameservers(),
+		}
 	}
+	return &StandardDNSResolver{upstreams: upstreams}
 }
 
-// LookupMX implements DNSResolver.LookupMX using net.Resolver.
+// LookupMX implements DNSResolver.LookupMX.
 func (r *StandardDNSResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
-	return r.resolver.LookupMX(ctx, name)
+	if r.resolver != nil {
+		return r.resolver.LookupMX(ctx, name)
+	}
+
+	records, _, err := lookupDNSRecords(ctx, r.upstreams, name, dnsmessage.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	return mxRecords(records), nil
 }
 
-// LookupTXT implements DNSResolver.LookupTXT using net.Resolver.
+// LookupTXT implements DNSResolver.LookupTXT.
 func (r *StandardDNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
-	return r.resolver.LookupTXT(ctx, name)
+	if r.resolver != nil {
+		return r.resolver.LookupTXT(ctx, name)
+	}
+
+	records, _, err := lookupDNSRecords(ctx, r.upstreams, name, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	return txtRecords(records), nil
 }
 
-// LookupAddr implements DNSResolver.LookupAddr using net.Resolver.
+// LookupAddr implements DNSResolver.LookupAddr.
 func (r *StandardDNSResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
-	return r.resolver.LookupAddr(ctx, addr)
+	if r.resolver != nil {
+		return r.resolver.LookupAddr(ctx, addr)
+	}
+
+	name, err := reverseDNSName(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+	records, _, err := lookupDNSRecords(ctx, r.upstreams, name, dnsmessage.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	return ptrRecords(records), nil
 }
 
-// LookupHost implements DNSResolver.LookupHost using net.Resolver.
+// LookupHost implements DNSResolver.LookupHost.
 func (r *StandardDNSResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
-	return r.resolver.LookupHost(ctx, host)
+	if r.resolver != nil {
+		return r.resolver.LookupHost(ctx, host)
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	var addrs []string
+	var lookupErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		records, _, err := lookupDNSRecords(ctx, r.upstreams, host, qtype)
+		if err != nil {
+			lookupErr = keepHostLookupError(lookupErr, err)
+			continue
+		}
+		addrs = append(addrs, addressRecords(records)...)
+	}
+
+	if len(addrs) == 0 {
+		return nil, lookupErr
+	}
+	return addrs, nil
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *StandardDNSResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if r.resolver != nil {
+		return r.resolver.LookupNS(ctx, name)
+	}
+
+	records, _, err := lookupDNSRecords(ctx, r.upstreams, name, dnsmessage.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	return nsRecords(records), nil
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME with a direct
+// query to the nameservers.
+func (r *StandardDNSResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	records, _, err := lookupDNSRecords(ctx, r.upstreams, name, dnsmessage.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	return cnameRecord(records), nil
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA with a direct query
+// to the nameservers.
+func (r *StandardDNSResolver) LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error) {
+	records, resp, err := lookupDNSRecords(ctx, r.upstreams, name, dnsTypeTLSA)
+	authenticated := resp != nil && resp.AuthenticData
+	if err != nil {
+		return nil, authenticated, err
+	}
+
+	tlsas, err := tlsaRecords(records, name)
+	return tlsas, authenticated, err
 }
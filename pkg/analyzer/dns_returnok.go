@@ -39,19 +39,25 @@ const (
 
 // domainCanReceive reports whether a domain can accept mail, looking up records
 // in the same order as Fastmail's ReturnOK milter: MX first, then A/AAAA.
-func (d *DNSAnalyzer) domainCanReceive(domain string) (hasMX, hasAddress bool) {
+// A Null MX (RFC 7505) tells the domain accepts no mail, without falling back
+// to its addresses. A lone root exchange with a non-zero preference is no
+// Null MX, but no usable MX either.
+func (d *DNSAnalyzer) domainCanReceive(domain string) (hasMX, hasAddress, nullMX bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
 	defer cancel()
 
 	if mxRecords, err := d.resolver.LookupMX(ctx, domain); err == nil && len(mxRecords) > 0 {
-		return true, false
+		if len(mxRecords) == 1 && isRootMX(mxRecords[0]) {
+			return false, false, isNullMX(mxRecords[0])
+		}
+		return true, false, false
 	}
 
 	if addrs, err := d.resolver.LookupHost(ctx, domain); err == nil && len(addrs) > 0 {
-		return false, true
+		return false, true, false
 	}
 
-	return false, false
+	return false, false, false
 }
 
 // checkReturnOKDomain verifies that a domain can receive replies/bounces.
@@ -64,11 +70,12 @@ func (d *DNSAnalyzer) checkReturnOKDomain(domain, orgDomain string) *model.Retur
 
 	result := &model.ReturnOKDomain{Domain: domain}
 
-	hasMX, hasAddress := d.domainCanReceive(domain)
+	hasMX, hasAddress, nullMX := d.domainCanReceive(domain)
 
-	// Fall back to the organizational domain when the domain itself has nothing.
-	if !hasMX && !hasAddress && orgDomain != "" && orgDomain != domain {
-		if orgMX, orgAddr := d.domainCanReceive(orgDomain); orgMX || orgAddr {
+	// Fall back to the organizational domain when the domain itself has
+	// nothing, a Null MX being an explicit answer.
+	if !hasMX && !hasAddress && !nullMX && orgDomain != "" && orgDomain != domain {
+		if orgMX, orgAddr, _ := d.domainCanReceive(orgDomain); orgMX || orgAddr {
 			hasMX, hasAddress = orgMX, orgAddr
 			result.OrgDomain = utils.PtrTo(orgDomain)
 		}
@@ -76,6 +83,9 @@ func (d *DNSAnalyzer) checkReturnOKDomain(domain, orgDomain string) *model.Retur
 
 	result.HasMx = utils.PtrTo(hasMX)
 	result.HasAddress = utils.PtrTo(hasAddress)
+	if nullMX {
+		result.NullMx = utils.PtrTo(true)
+	}
 
 	switch {
 	case hasMX:
@@ -50,6 +50,18 @@ func (m *returnOKMockResolver) LookupHost(_ context.Context, host string) ([]str
 	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
 }
 
+func (m *returnOKMockResolver) LookupTLSA(_ context.Context, name string) ([]*TLSA, bool, error) {
+	return nil, false, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (m *returnOKMockResolver) LookupCNAME(_ context.Context, name string) (string, error) {
+	return "", &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (m *returnOKMockResolver) LookupNS(_ context.Context, name string) ([]*net.NS, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
 func (m *returnOKMockResolver) LookupTXT(_ context.Context, _ string) ([]string, error) {
 	return nil, nil
 }
@@ -69,6 +81,7 @@ func TestCheckReturnOKDomain(t *testing.T) {
 		wantHasMX     bool
 		wantHasAddr   bool
 		wantOrgDomain string // "" means OrgDomain should be nil
+		wantNullMX    bool
 	}{
 		{
 			name:        "domain with MX passes",
@@ -96,6 +109,28 @@ func TestCheckReturnOKDomain(t *testing.T) {
 			wantHasAddr:   false,
 			wantOrgDomain: "example.com",
 		},
+		{
+			name:      "Null MX fails without falling back",
+			domain:    "sub.example.com",
+			orgDomain: "example.com",
+			resolver: &returnOKMockResolver{
+				mx:    map[string][]*net.MX{"sub.example.com": {{Host: ".", Pref: 0}}, "example.com": mx},
+				hosts: map[string][]string{"sub.example.com": {"192.0.2.1"}},
+			},
+			wantStatus:  returnOKStatusFail,
+			wantHasMX:   false,
+			wantHasAddr: false,
+			wantNullMX:  true,
+		},
+		{
+			name:        "Null MX with a non-zero preference fails",
+			domain:      "example.com",
+			resolver:    &returnOKMockResolver{mx: map[string][]*net.MX{"example.com": {{Host: ".", Pref: 10}}}},
+			wantStatus:  returnOKStatusFail,
+			wantHasMX:   false,
+			wantHasAddr: false,
+			wantNullMX:  false,
+		},
 		{
 			name:        "nothing anywhere fails",
 			domain:      "example.com",
@@ -123,6 +158,9 @@ func TestCheckReturnOKDomain(t *testing.T) {
 			if got.HasAddress == nil || *got.HasAddress != tt.wantHasAddr {
 				t.Errorf("HasAddress = %v, want %v", got.HasAddress, tt.wantHasAddr)
 			}
+			if (got.NullMx != nil) != tt.wantNullMX {
+				t.Errorf("NullMx = %v, want %v", got.NullMx, tt.wantNullMX)
+			}
 			if tt.wantOrgDomain == "" {
 				if got.OrgDomain != nil {
 					t.Errorf("OrgDomain = %v, want nil", *got.OrgDomain)
@@ -0,0 +1,689 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// snapshotMaxBodySize bounds the HTTP bodies kept in a snapshot, which is
+// stored with the report: larger ones are only recorded by their digest.
+const snapshotMaxBodySize = 32 * 1024
+
+// dnsSnapshotKey identifies a query of a snapshot.
+func dnsSnapshotKey(qtype, name, server string) string {
+	return qtype + " " + strings.ToLower(strings.TrimSuffix(name, ".")) + " " + server
+}
+
+// fetchSnapshotKey identifies an HTTP request of a snapshot.
+func fetchSnapshotKey(method, url string) string {
+	return "FETCH " + method + " " + url
+}
+
+// smtpProbeSnapshotKey identifies an SMTP probe of a snapshot.
+func smtpProbeSnapshotKey(host, addr string) string {
+	return "SMTP " + strings.ToLower(strings.TrimSuffix(host, ".")) + " " + addr
+}
+
+// dnsSnapshotRecorder captures the DNS queries of one analysis, along with
+// their answers, and the outcome of its HTTP requests and SMTP probes.
+type dnsSnapshotRecorder struct {
+	capturedAt time.Time
+
+	mu      sync.Mutex
+	queries []model.DNSSnapshotQuery
+	fetches []model.DNSSnapshotFetch
+	probes  []model.DNSSnapshotSMTPProbe
+	index   map[string]bool
+}
+
+func newDNSSnapshotRecorder() *dnsSnapshotRecorder {
+	return &dnsSnapshotRecorder{
+		capturedAt: time.Now(),
+		index:      map[string]bool{},
+	}
+}
+
+// record adds a query to the snapshot. Only the first answer to a question
+// is kept, later ones coming from the cache.
+func (s *dnsSnapshotRecorder) record(query model.DNSSnapshotQuery) {
+	s.add(dnsSnapshotKey(query.Type, query.Name, utils.DerefOrZero(query.Server)), func() {
+		s.queries = append(s.queries, query)
+	})
+}
+
+// add calls add under the lock of the recorder, unless key was already
+// recorded.
+func (s *dnsSnapshotRecorder) add(key string, add func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index[key] {
+		return
+	}
+	s.index[key] = true
+	add()
+}
+
+// snapshot returns the queries, requests and probes recorded so far.
+func (s *dnsSnapshotRecorder) snapshot() *model.DNSSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := &model.DNSSnapshot{
+		CapturedAt: s.capturedAt,
+		Queries:    slices.Clone(s.queries),
+	}
+	if len(s.fetches) > 0 {
+		snapshot.Fetches = utils.PtrTo(slices.Clone(s.fetches))
+	}
+	if len(s.probes) > 0 {
+		snapshot.SmtpProbes = utils.PtrTo(slices.Clone(s.probes))
+	}
+	return snapshot
+}
+
+// resolver returns a resolver recording the lookups made through resolver.
+func (s *dnsSnapshotRecorder) resolver(resolver DNSResolver) DNSResolver {
+	return &snapshotResolver{recorder: s, resolver: resolver}
+}
+
+// exchange returns a dnsExchangeFunc recording the answers of the
+// nameservers queried directly through exchange.
+func (s *dnsSnapshotRecorder) exchange(exchange dnsExchangeFunc) dnsExchangeFunc {
+	if exchange == nil {
+		exchange = exchangeAuthoritative
+	}
+	return func(ctx context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+		resp, err := exchange(ctx, address, name, qtype)
+
+		query := model.DNSSnapshotQuery{
+			Type:   dnsTypeName(qtype),
+			Name:   strings.TrimSuffix(name, "."),
+			Server: &address,
+		}
+		if err != nil {
+			setSnapshotError(&query, err)
+		} else if packed, err := resp.Pack(); err == nil {
+			query.Message = &packed
+		}
+		s.record(query)
+
+		return resp, err
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling the function itself.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// transport returns an http.RoundTripper recording the responses to the
+// requests made through transport. The bodies are read up to the largest
+// resource the analyzers accept, so that they still notice larger ones, but
+// only those up to snapshotMaxBodySize are kept.
+func (s *dnsSnapshotRecorder) transport(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetch := model.DNSSnapshotFetch{Method: req.Method, Url: req.URL.String()}
+		defer s.add(fetchSnapshotKey(fetch.Method, fetch.Url), func() {
+			s.fetches = append(s.fetches, fetch)
+		})
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			fetch.Error = utils.PtrTo(err.Error())
+			return nil, err
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, bimiMaxFetchSize+1))
+		resp.Body.Close()
+		if err != nil {
+			fetch.Error = utils.PtrTo(err.Error())
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		fetch.Status = utils.PtrTo(resp.StatusCode)
+		if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+			fetch.ContentType = &contentType
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			fetch.Location = &location
+		}
+		if len(body) > 0 {
+			digest := sha256.Sum256(body)
+			fetch.BodySize = utils.PtrTo(len(body))
+			fetch.BodySha256 = utils.PtrTo(hex.EncodeToString(digest[:]))
+			if len(body) <= snapshotMaxBodySize {
+				fetch.Body = &body
+			}
+		}
+		return resp, nil
+	})
+}
+
+// smtpProber returns a function recording the outcome of the SMTP probes
+// made through probe.
+func (s *dnsSnapshotRecorder) smtpProber(probe func(host, addr string) *model.MXSMTPProbe) func(host, addr string) *model.MXSMTPProbe {
+	return func(host, addr string) *model.MXSMTPProbe {
+		result := probe(host, addr)
+		s.add(smtpProbeSnapshotKey(host, addr), func() {
+			s.probes = append(s.probes, model.DNSSnapshotSMTPProbe{Host: host, Probe: *result})
+		})
+		return result
+	}
+}
+
+// setSnapshotError records the failure of a query.
+func setSnapshotError(query *model.DNSSnapshotQuery, err error) {
+	if isDNSNotFound(err) {
+		query.NotFound = utils.PtrTo(true)
+		return
+	}
+	msg := err.Error()
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		msg = dnsErr.Err
+	}
+	query.Error = &msg
+}
+
+// snapshotResolver is a DNSResolver recording its lookups in a snapshot.
+type snapshotResolver struct {
+	recorder *dnsSnapshotRecorder
+	resolver DNSResolver
+}
+
+// lookup records the outcome of fn, the lookup of qtype at name, which
+// returns its records in presentation format.
+func (r *snapshotResolver) lookup(ctx context.Context, qtype, name string, authenticated *bool, fn func(ctx context.Context) ([]string, error)) error {
+	var mu sync.Mutex
+	var dnssec []model.DNSSECAnswer
+	lookupCtx := withDNSSECObserver(ctx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+		answer := model.DNSSECAnswer{
+			Name:   strings.ToLower(name),
+			Type:   dnsTypeName(qtype),
+			Status: verdict.status,
+		}
+		if verdict.reason != "" {
+			answer.Reason = utils.PtrTo(verdict.reason)
+		}
+		mu.Lock()
+		dnssec = append(dnssec, answer)
+		mu.Unlock()
+		reportDNSSEC(ctx, name, qtype, verdict)
+	})
+
+	records, err := fn(lookupCtx)
+	if errors.Is(err, errUnsupportedLookup) {
+		// Nothing was asked to the DNS
+		return err
+	}
+
+	query := model.DNSSnapshotQuery{
+		Type:          qtype,
+		Name:          strings.TrimSuffix(name, "."),
+		Authenticated: authenticated,
+	}
+	if err != nil {
+		setSnapshotError(&query, err)
+	} else {
+		query.Records = &records
+	}
+	mu.Lock()
+	if len(dnssec) > 0 {
+		query.Dnssec = &dnssec
+	}
+	mu.Unlock()
+	r.recorder.record(query)
+
+	return err
+}
+
+// LookupMX implements DNSResolver.LookupMX.
+func (r *snapshotResolver) LookupMX(ctx context.Context, name string) (mxs []*net.MX, err error) {
+	err = r.lookup(ctx, "MX", name, nil, func(ctx context.Context) ([]string, error) {
+		mxs, err = r.resolver.LookupMX(ctx, name)
+		records := make([]string, 0, len(mxs))
+		for _, mx := range mxs {
+			records = append(records, fmt.Sprintf("%d %s", mx.Pref, mx.Host))
+		}
+		return records, err
+	})
+	return mxs, err
+}
+
+// LookupTXT implements DNSResolver.LookupTXT.
+func (r *snapshotResolver) LookupTXT(ctx context.Context, name string) (txts []string, err error) {
+	err = r.lookup(ctx, "TXT", name, nil, func(ctx context.Context) ([]string, error) {
+		txts, err = r.resolver.LookupTXT(ctx, name)
+		return slices.Clone(txts), err
+	})
+	return txts, err
+}
+
+// LookupAddr implements DNSResolver.LookupAddr.
+func (r *snapshotResolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	err = r.lookup(ctx, "PTR", addr, nil, func(ctx context.Context) ([]string, error) {
+		names, err = r.resolver.LookupAddr(ctx, addr)
+		return slices.Clone(names), err
+	})
+	return names, err
+}
+
+// LookupHost implements DNSResolver.LookupHost.
+func (r *snapshotResolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	err = r.lookup(ctx, "HOST", host, nil, func(ctx context.Context) ([]string, error) {
+		addrs, err = r.resolver.LookupHost(ctx, host)
+		return slices.Clone(addrs), err
+	})
+	return addrs, err
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *snapshotResolver) LookupNS(ctx context.Context, name string) (nss []*net.NS, err error) {
+	err = r.lookup(ctx, "NS", name, nil, func(ctx context.Context) ([]string, error) {
+		nss, err = extendedResolver(r.resolver).LookupNS(ctx, name)
+		records := make([]string, 0, len(nss))
+		for _, ns := range nss {
+			records = append(records, ns.Host)
+		}
+		return records, err
+	})
+	return nss, err
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME.
+func (r *snapshotResolver) LookupCNAME(ctx context.Context, name string) (target string, err error) {
+	err = r.lookup(ctx, "CNAME", name, nil, func(ctx context.Context) ([]string, error) {
+		target, err = extendedResolver(r.resolver).LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	})
+	return target, err
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA.
+func (r *snapshotResolver) LookupTLSA(ctx context.Context, name string) (tlsas []*TLSA, authenticated bool, err error) {
+	err = r.lookup(ctx, "TLSA", name, &authenticated, func(ctx context.Context) ([]string, error) {
+		tlsas, authenticated, err = extendedResolver(r.resolver).LookupTLSA(ctx, name)
+		records := make([]string, 0, len(tlsas))
+		for _, tlsa := range tlsas {
+			records = append(records, fmt.Sprintf("%d %d %d %x", tlsa.Usage, tlsa.Selector, tlsa.MatchingType, tlsa.Data))
+		}
+		return records, err
+	})
+	return tlsas, authenticated, err
+}
+
+// DNSReplayResolver is a DNSResolver answering from a DNS snapshot, to
+// re-run an analysis offline. Queries missing from the snapshot fail. It
+// also answers the HTTP requests and SMTP probes recorded in the snapshot.
+type DNSReplayResolver struct {
+	queries map[string]*model.DNSSnapshotQuery
+	fetches map[string]*model.DNSSnapshotFetch
+	probes  map[string]*model.MXSMTPProbe
+}
+
+// NewDNSReplayResolver creates a resolver answering from snapshot.
+func NewDNSReplayResolver(snapshot *model.DNSSnapshot) *DNSReplayResolver {
+	r := &DNSReplayResolver{
+		queries: map[string]*model.DNSSnapshotQuery{},
+		fetches: map[string]*model.DNSSnapshotFetch{},
+		probes:  map[string]*model.MXSMTPProbe{},
+	}
+	if snapshot == nil {
+		return r
+	}
+
+	for i := range snapshot.Queries {
+		query := &snapshot.Queries[i]
+		key := dnsSnapshotKey(query.Type, query.Name, utils.DerefOrZero(query.Server))
+		if _, ok := r.queries[key]; !ok {
+			r.queries[key] = query
+		}
+	}
+	for _, fetch := range utils.DerefOrZero(snapshot.Fetches) {
+		key := fetchSnapshotKey(fetch.Method, fetch.Url)
+		if _, ok := r.fetches[key]; !ok {
+			r.fetches[key] = &fetch
+		}
+	}
+	for _, probe := range utils.DerefOrZero(snapshot.SmtpProbes) {
+		key := smtpProbeSnapshotKey(probe.Host, probe.Probe.Address)
+		if _, ok := r.probes[key]; !ok {
+			r.probes[key] = &probe.Probe
+		}
+	}
+	return r
+}
+
+// roundTrip answers an HTTP request from the snapshot.
+func (r *DNSReplayResolver) roundTrip(req *http.Request) (*http.Response, error) {
+	fetch, ok := r.fetches[fetchSnapshotKey(req.Method, req.URL.String())]
+	switch {
+	case !ok:
+		return nil, errors.New("not recorded in the DNS snapshot")
+	case fetch.Error != nil:
+		return nil, errors.New(*fetch.Error)
+	case fetch.Status == nil:
+		return nil, errors.New("no response recorded in the DNS snapshot")
+	case fetch.Body == nil && utils.DerefOrZero(fetch.BodySize) > 0:
+		return nil, fmt.Errorf("response body of %d bytes not kept in the DNS snapshot", *fetch.BodySize)
+	}
+
+	header := http.Header{}
+	if fetch.ContentType != nil {
+		header.Set("Content-Type", *fetch.ContentType)
+	}
+	if fetch.Location != nil {
+		header.Set("Location", *fetch.Location)
+	}
+	body := utils.DerefOrZero(fetch.Body)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", *fetch.Status, http.StatusText(*fetch.Status)),
+		StatusCode:    *fetch.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// smtpProbe answers an SMTP probe of the MX host at addr from the
+// snapshot.
+func (r *DNSReplayResolver) smtpProbe(host, addr string) *model.MXSMTPProbe {
+	probe, ok := r.probes[smtpProbeSnapshotKey(host, addr)]
+	if !ok {
+		return &model.MXSMTPProbe{Address: addr, Error: utils.PtrTo("not recorded in the DNS snapshot")}
+	}
+	replayed := *probe
+	return &replayed
+}
+
+// answer returns the recorded records of the lookup of qtype at name,
+// reporting its DNSSEC status again.
+func (r *DNSReplayResolver) answer(ctx context.Context, qtype, name string) ([]string, *model.DNSSnapshotQuery, error) {
+	query, ok := r.queries[dnsSnapshotKey(qtype, name, "")]
+	if !ok {
+		return nil, nil, &net.DNSError{Err: "not recorded in the DNS snapshot", Name: name}
+	}
+
+	if query.Dnssec != nil {
+		for _, answer := range *query.Dnssec {
+			verdict := dnssecVerdict{status: answer.Status, reason: utils.DerefOrZero(answer.Reason)}
+			reportDNSSEC(ctx, answer.Name, dnsTypeFromName(answer.Type), verdict)
+		}
+	}
+
+	switch {
+	case utils.DerefOrZero(query.NotFound):
+		return nil, query, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case query.Error != nil:
+		return nil, query, &net.DNSError{Err: *query.Error, Name: name}
+	}
+	return utils.DerefOrZero(query.Records), query, nil
+}
+
+// LookupMX implements DNSResolver.LookupMX.
+func (r *DNSReplayResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, _, err := r.answer(ctx, "MX", name)
+	if err != nil {
+		return nil, err
+	}
+	var mxs []*net.MX
+	for _, record := range records {
+		pref, host, _ := strings.Cut(record, " ")
+		n, err := strconv.ParseUint(pref, 10, 16)
+		if err != nil {
+			return nil, &net.DNSError{Err: "malformed MX record in the DNS snapshot", Name: name}
+		}
+		mxs = append(mxs, &net.MX{Host: host, Pref: uint16(n)})
+	}
+	return mxs, nil
+}
+
+// LookupTXT implements DNSResolver.LookupTXT.
+func (r *DNSReplayResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, _, err := r.answer(ctx, "TXT", name)
+	return slices.Clone(records), err
+}
+
+// LookupAddr implements DNSResolver.LookupAddr.
+func (r *DNSReplayResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	records, _, err := r.answer(ctx, "PTR", addr)
+	return slices.Clone(records), err
+}
+
+// LookupHost implements DNSResolver.LookupHost.
+func (r *DNSReplayResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	records, _, err := r.answer(ctx, "HOST", host)
+	return slices.Clone(records), err
+}
+
+// LookupNS implements extendedDNSResolver.LookupNS.
+func (r *DNSReplayResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	records, _, err := r.answer(ctx, "NS", name)
+	if err != nil {
+		return nil, err
+	}
+	var nss []*net.NS
+	for _, record := range records {
+		nss = append(nss, &net.NS{Host: record})
+	}
+	return nss, nil
+}
+
+// LookupCNAME implements extendedDNSResolver.LookupCNAME.
+func (r *DNSReplayResolver) LookupCNAME(ctx context.Context, name string) (string, error) {
+	records, _, err := r.answer(ctx, "CNAME", name)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records[0], nil
+}
+
+// LookupTLSA implements extendedDNSResolver.LookupTLSA.
+func (r *DNSReplayResolver) LookupTLSA(ctx context.Context, name string) ([]*TLSA, bool, error) {
+	records, query, err := r.answer(ctx, "TLSA", name)
+	authenticated := query != nil && utils.DerefOrZero(query.Authenticated)
+	if err != nil {
+		return nil, authenticated, err
+	}
+	var tlsas []*TLSA
+	for _, record := range records {
+		var usage, selector, matchingType uint8
+		var data string
+		if _, err := fmt.Sscanf(record, "%d %d %d %s", &usage, &selector, &matchingType, &data); err != nil {
+			return nil, authenticated, &net.DNSError{Err: "malformed TLSA record in the DNS snapshot", Name: name}
+		}
+		raw, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, authenticated, &net.DNSError{Err: "malformed TLSA record in the DNS snapshot", Name: name}
+		}
+		tlsas = append(tlsas, &TLSA{Usage: usage, Selector: selector, MatchingType: matchingType, Data: raw})
+	}
+	return tlsas, authenticated, nil
+}
+
+// exchange is the dnsExchangeFunc answering the queries sent directly to
+// nameservers from the snapshot.
+func (r *DNSReplayResolver) exchange(_ context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	query, ok := r.queries[dnsSnapshotKey(dnsTypeName(qtype), name, address)]
+	switch {
+	case !ok:
+		return nil, &net.DNSError{Err: "not recorded in the DNS snapshot", Name: name, Server: address}
+	case query.Error != nil:
+		return nil, &net.DNSError{Err: *query.Error, Name: name, Server: address}
+	case query.Message == nil:
+		return nil, &net.DNSError{Err: "no answer recorded in the DNS snapshot", Name: name, Server: address}
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(*query.Message); err != nil {
+		return nil, &net.DNSError{Err: "malformed answer in the DNS snapshot", Name: name, Server: address}
+	}
+	return &resp, nil
+}
+
+// dnsTypeFromName is the reverse of dnsTypeName for the record types the
+// analyzers look up.
+func dnsTypeFromName(name string) dnsmessage.Type {
+	for _, t := range []dnsmessage.Type{
+		dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME, dnsmessage.TypeMX,
+		dnsmessage.TypeNS, dnsmessage.TypePTR, dnsmessage.TypeSOA, dnsmessage.TypeTXT,
+		dnsTypeTLSA, dnsTypeDS, dnsTypeDNSKEY,
+	} {
+		if dnsTypeName(t) == name {
+			return t
+		}
+	}
+	return 0
+}
+
+// snapshotAnswer renders the answer of a snapshot query for comparison.
+// Failures render as a single "error: ..." entry, a non-existent name or
+// record as no entries.
+func snapshotAnswer(query *model.DNSSnapshotQuery) []string {
+	switch {
+	case query.Error != nil:
+		return []string{"error: " + *query.Error}
+	case query.Message != nil:
+		var resp dnsmessage.Message
+		if err := resp.Unpack(*query.Message); err != nil {
+			return []string{"error: malformed answer"}
+		}
+		if resp.RCode != dnsmessage.RCodeSuccess && resp.RCode != dnsmessage.RCodeNameError {
+			return []string{"error: " + strings.TrimPrefix(resp.RCode.String(), "RCode")}
+		}
+		records := []string{}
+		for _, rr := range resp.Answers {
+			records = append(records, resourceString(rr))
+		}
+		slices.Sort(records)
+		return records
+	}
+	records := slices.Clone(utils.DerefOrZero(query.Records))
+	if records == nil {
+		records = []string{}
+	}
+	slices.Sort(records)
+	return records
+}
+
+// resourceString renders a record in a presentation-like format.
+func resourceString(rr dnsmessage.Resource) string {
+	switch body := rr.Body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return "CNAME " + body.CNAME.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", body.Pref, body.MX.String())
+	case *dnsmessage.NSResource:
+		return body.NS.String()
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d", body.NS.String(), body.MBox.String(), body.Serial)
+	case *dnsmessage.TXTResource:
+		return strings.Join(body.TXT, "")
+	}
+	return dnsTypeName(rr.Header.Type)
+}
+
+// DiffDNSSnapshots lists the answers differing between two snapshots: the
+// queries of current whose answer changed or that are new, then those of
+// previous not made anymore.
+func DiffDNSSnapshots(previous, current *model.DNSSnapshot) []model.DNSChange {
+	before := map[string]*model.DNSSnapshotQuery{}
+	if previous != nil {
+		for i := range previous.Queries {
+			query := &previous.Queries[i]
+			before[dnsSnapshotKey(query.Type, query.Name, utils.DerefOrZero(query.Server))] = query
+		}
+	}
+
+	var changes []model.DNSChange
+	seen := map[string]bool{}
+	if current != nil {
+		for i := range current.Queries {
+			query := &current.Queries[i]
+			key := dnsSnapshotKey(query.Type, query.Name, utils.DerefOrZero(query.Server))
+			seen[key] = true
+
+			after := snapshotAnswer(query)
+			change := model.DNSChange{Type: query.Type, Name: query.Name, Server: query.Server, After: &after}
+			if old, ok := before[key]; ok {
+				previousAnswer := snapshotAnswer(old)
+				if slices.Equal(previousAnswer, after) {
+					continue
+				}
+				change.Before = &previousAnswer
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	if previous != nil {
+		for i := range previous.Queries {
+			query := &previous.Queries[i]
+			if seen[dnsSnapshotKey(query.Type, query.Name, utils.DerefOrZero(query.Server))] {
+				continue
+			}
+			previousAnswer := snapshotAnswer(query)
+			changes = append(changes, model.DNSChange{Type: query.Type, Name: query.Name, Server: query.Server, Before: &previousAnswer})
+		}
+	}
+	return changes
+}
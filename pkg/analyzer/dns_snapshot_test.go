@@ -0,0 +1,331 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// roundTripSnapshot encodes and decodes a snapshot as it is stored with the
+// report.
+func roundTripSnapshot(t *testing.T, snapshot *model.DNSSnapshot) *model.DNSSnapshot {
+	t.Helper()
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	var decoded model.DNSSnapshot
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal snapshot: %v", err)
+	}
+	return &decoded
+}
+
+func TestDNSSnapshotReplay(t *testing.T) {
+	mock := &spfMockResolver{
+		mx:     map[string][]*net.MX{"example.com": {{Host: "mx1.example.com.", Pref: 10}, {Host: "mx2.example.com.", Pref: 20}}},
+		txt:    map[string][]string{"example.com": {"v=spf1 mx -all", "verification=abc"}},
+		hosts:  map[string][]string{"mx1.example.com": {"192.0.2.25", "2001:db8::25"}},
+		ptr:    map[string][]string{"192.0.2.25": {"mx1.example.com."}},
+		ns:     map[string][]*net.NS{"example.com": {{Host: "ns1.example.com."}}},
+		tlsa:   map[string][]*TLSA{"_25._tcp.mx1.example.com": {{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{0xca, 0xfe}}}},
+		signed: map[string]bool{"_25._tcp.mx1.example.com": true},
+		err:    map[string]error{"broken.example.com": &net.DNSError{Err: "server misbehaving", Name: "broken.example.com", IsTemporary: true}},
+	}
+
+	recorder := newDNSSnapshotRecorder()
+	resolver := recorder.resolver(mock)
+	ctx := context.Background()
+
+	type lookup struct {
+		name string
+		fn   func(r DNSResolver) (any, error)
+	}
+	lookups := []lookup{
+		{"MX", func(r DNSResolver) (any, error) { return r.LookupMX(ctx, "example.com") }},
+		{"TXT", func(r DNSResolver) (any, error) { return r.LookupTXT(ctx, "example.com") }},
+		{"HOST", func(r DNSResolver) (any, error) { return r.LookupHost(ctx, "mx1.example.com") }},
+		{"PTR", func(r DNSResolver) (any, error) { return r.LookupAddr(ctx, "192.0.2.25") }},
+		{"NS", func(r DNSResolver) (any, error) { return extendedResolver(r).LookupNS(ctx, "example.com") }},
+		{"TLSA", func(r DNSResolver) (any, error) {
+			records, authenticated, err := extendedResolver(r).LookupTLSA(ctx, "_25._tcp.mx1.example.com")
+			return []any{records, authenticated}, err
+		}},
+		{"not found", func(r DNSResolver) (any, error) { return r.LookupTXT(ctx, "_dmarc.example.com") }},
+		{"failure", func(r DNSResolver) (any, error) { return r.LookupMX(ctx, "broken.example.com") }},
+	}
+
+	want := make([]any, len(lookups))
+	wantErr := make([]error, len(lookups))
+	for i, l := range lookups {
+		want[i], wantErr[i] = l.fn(resolver)
+	}
+
+	snapshot := roundTripSnapshot(t, recorder.snapshot())
+	if len(snapshot.Queries) != len(lookups) {
+		t.Fatalf("snapshot has %d queries, want %d", len(snapshot.Queries), len(lookups))
+	}
+
+	replay := NewDNSReplayResolver(snapshot)
+	for i, l := range lookups {
+		got, err := l.fn(replay)
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%s: replayed %#v, want %#v", l.name, got, want[i])
+		}
+		if (err == nil) != (wantErr[i] == nil) || isDNSNotFound(err) != isDNSNotFound(wantErr[i]) {
+			t.Errorf("%s: replayed error %v, want %v", l.name, err, wantErr[i])
+		}
+	}
+
+	// Questions that were not asked cannot be answered
+	if _, err := replay.LookupTXT(ctx, "other.example.com"); err == nil || isDNSNotFound(err) {
+		t.Errorf("unrecorded lookup err = %v, want a failure", err)
+	}
+}
+
+func TestDNSSnapshotExchange(t *testing.T) {
+	ns := exampleZone(2025010101)
+	recorder := newDNSSnapshotRecorder()
+	exchange := recorder.exchange(func(_ context.Context, address, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+		if address != "192.0.2.1" {
+			return nil, errors.New("i/o timeout")
+		}
+		return ns.answer(name, qtype)
+	})
+
+	ctx := context.Background()
+	want, err := exchange(ctx, "192.0.2.1", "example.com", dnsmessage.TypeSOA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchange(ctx, "192.0.2.2", "example.com", dnsmessage.TypeSOA); err == nil {
+		t.Fatal("expected a failure")
+	}
+
+	replay := NewDNSReplayResolver(roundTripSnapshot(t, recorder.snapshot()))
+	got, err := replay.exchange(ctx, "192.0.2.1", "example.com", dnsmessage.TypeSOA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if soa, ok := got.Answers[0].Body.(*dnsmessage.SOAResource); !ok || soa.Serial != 2025010101 || got.Authoritative != want.Authoritative {
+		t.Errorf("replayed %+v, want %+v", got, want)
+	}
+	if _, err := replay.exchange(ctx, "192.0.2.2", "example.com", dnsmessage.TypeSOA); err == nil {
+		t.Error("expected the recorded failure")
+	}
+}
+
+func TestDNSSnapshotFetches(t *testing.T) {
+	recorder := newDNSSnapshotRecorder()
+	live := &http.Client{Transport: recorder.transport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("")), Request: req}
+		switch req.URL.Path {
+		case "/old.svg":
+			resp.StatusCode = http.StatusMovedPermanently
+			resp.Header.Set("Location", "/logo.svg")
+		case "/logo.svg":
+			resp.Header.Set("Content-Type", "image/svg+xml")
+			resp.Body = io.NopCloser(strings.NewReader("<svg/>"))
+		case "/large.svg":
+			resp.Body = io.NopCloser(strings.NewReader(strings.Repeat(" ", snapshotMaxBodySize+1)))
+		default:
+			return nil, errors.New("connection refused")
+		}
+		return resp, nil
+	}))}
+
+	fetch := func(client *http.Client, url string) (string, string, error) {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get("Content-Type"), nil
+	}
+
+	if body, contentType, err := fetch(live, "https://example.com/old.svg"); err != nil || body != "<svg/>" || contentType != "image/svg+xml" {
+		t.Fatalf("live fetch = %q, %q, %v", body, contentType, err)
+	}
+	if _, _, err := fetch(live, "https://example.com/missing.svg"); err == nil {
+		t.Fatal("expected a failure")
+	}
+	if body, _, err := fetch(live, "https://example.com/large.svg"); err != nil || len(body) != snapshotMaxBodySize+1 {
+		t.Fatalf("live fetch of a large body = %d bytes, %v", len(body), err)
+	}
+
+	prober := recorder.smtpProber(func(host, addr string) *model.MXSMTPProbe {
+		return &model.MXSMTPProbe{Address: addr, Connected: true, Banner: utils.PtrTo(host + " ESMTP")}
+	})
+	prober("mx.example.com", "192.0.2.25:25")
+
+	snapshot := roundTripSnapshot(t, recorder.snapshot())
+	fetches := utils.DerefOrZero(snapshot.Fetches)
+	if len(fetches) != 4 {
+		t.Fatalf("recorded %d fetches, want 4: %+v", len(fetches), fetches)
+	}
+	if large := fetches[3]; large.Body != nil || utils.DerefOrZero(large.BodySize) != snapshotMaxBodySize+1 || large.BodySha256 == nil {
+		t.Errorf("large body recorded as %d bytes of %v, want only its size and digest", len(utils.DerefOrZero(large.Body)), large.BodySize)
+	}
+
+	replay := NewDNSReplayResolver(snapshot)
+	offline := &http.Client{Transport: roundTripperFunc(replay.roundTrip)}
+	if body, contentType, err := fetch(offline, "https://example.com/old.svg"); err != nil || body != "<svg/>" || contentType != "image/svg+xml" {
+		t.Errorf("replayed fetch = %q, %q, %v", body, contentType, err)
+	}
+	if _, _, err := fetch(offline, "https://example.com/missing.svg"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("replayed failure = %v, want the recorded one", err)
+	}
+	if _, _, err := fetch(offline, "https://example.com/large.svg"); err == nil || !strings.Contains(err.Error(), "not kept") {
+		t.Errorf("replayed large fetch = %v, want a failure", err)
+	}
+	if _, _, err := fetch(offline, "https://example.com/other.svg"); err == nil || !strings.Contains(err.Error(), "not recorded") {
+		t.Errorf("unrecorded fetch = %v, want a failure", err)
+	}
+
+	if probe := replay.smtpProbe("MX.example.com.", "192.0.2.25:25"); !probe.Connected || utils.DerefOrZero(probe.Banner) != "mx.example.com ESMTP" {
+		t.Errorf("replayed probe = %+v", probe)
+	}
+	if probe := replay.smtpProbe("mx.example.com", "192.0.2.26:25"); probe.Connected || probe.Error == nil {
+		t.Errorf("unrecorded probe = %+v, want a failure", probe)
+	}
+}
+
+func TestDNSSnapshotDNSSEC(t *testing.T) {
+	tree := newTestDNSSECZones(t)
+	recorder := newDNSSnapshotRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := recorder.resolver(tree.resolver()).LookupTXT(ctx, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []dnssecVerdict
+	ctx = withDNSSECObserver(ctx, func(name string, qtype dnsmessage.Type, verdict dnssecVerdict) {
+		if name != "secure.test" || qtype != dnsmessage.TypeTXT {
+			t.Errorf("reported %s %s", name, dnsTypeName(qtype))
+		}
+		got = append(got, verdict)
+	})
+	replay := NewDNSReplayResolver(roundTripSnapshot(t, recorder.snapshot()))
+	if _, err := replay.LookupTXT(ctx, "secure.test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].status != model.DNSSECAnswerStatusSecure {
+		t.Errorf("replayed %+v, want a secure status", got)
+	}
+}
+
+func TestDiffDNSSnapshots(t *testing.T) {
+	query := func(qtype, name string, records ...string) model.DNSSnapshotQuery {
+		return model.DNSSnapshotQuery{Type: qtype, Name: name, Records: &records}
+	}
+	previous := &model.DNSSnapshot{Queries: []model.DNSSnapshotQuery{
+		query("MX", "example.com", "10 mx.example.com."),
+		query("TXT", "_dmarc.example.com", "v=DMARC1; p=none"),
+		query("TXT", "example.com", "verification=abc", "v=spf1 mx -all"),
+		query("TXT", "old._domainkey.example.com", "v=DKIM1; p=AAAA"),
+	}}
+	current := &model.DNSSnapshot{Queries: []model.DNSSnapshotQuery{
+		query("MX", "example.com", "10 mx.example.com."),
+		query("TXT", "_dmarc.example.com", "v=DMARC1; p=reject"),
+		query("TXT", "example.com", "v=spf1 mx -all", "verification=abc"),
+		{Type: "TLSA", Name: "_25._tcp.mx.example.com", NotFound: utils.PtrTo(true)},
+	}}
+
+	changes := DiffDNSSnapshots(previous, current)
+	if len(changes) != 3 {
+		t.Fatalf("got %d changes, want 3: %+v", len(changes), changes)
+	}
+
+	if c := changes[0]; c.Name != "_dmarc.example.com" || c.Before == nil || c.After == nil ||
+		!slices.Equal(*c.Before, []string{"v=DMARC1; p=none"}) || !slices.Equal(*c.After, []string{"v=DMARC1; p=reject"}) {
+		t.Errorf("changed record: %+v", c)
+	}
+	if c := changes[1]; c.Type != "TLSA" || c.Before != nil || c.After == nil || len(*c.After) != 0 {
+		t.Errorf("new query: %+v", c)
+	}
+	if c := changes[2]; c.Name != "old._domainkey.example.com" || c.Before == nil || c.After != nil {
+		t.Errorf("query not made anymore: %+v", c)
+	}
+}
+
+func TestReplayEmail(t *testing.T) {
+	mock := &spfMockResolver{
+		mx:    map[string][]*net.MX{"example.com": {{Host: "mx.example.com.", Pref: 10}}},
+		hosts: map[string][]string{"mx.example.com": {"192.0.2.25"}, "mx.example.com.": {"192.0.2.25"}},
+		ptr:   map[string][]string{"192.0.2.25": {"mx.example.com."}},
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 ip4:192.0.2.25 -all"},
+			"_dmarc.example.com": {"v=DMARC1; p=reject"},
+		},
+	}
+	email := createTestEmail()
+	email.Header[textproto.CanonicalMIMEHeaderKey("Received")] = []string{
+		"from mx.example.com (mx.example.com [192.0.2.25]) by mx.test with ESMTPS id abc; Mon, 01 Jan 2024 12:00:00 +0000",
+	}
+
+	generator := NewReportGeneratorWithResolvers(mock, mock, "mx.test", time.Second, time.Second, nil, nil, false, "")
+	original := generator.AnalyzeEmail(email)
+	if original.DNSSnapshot == nil || len(original.DNSSnapshot.Queries) == 0 {
+		t.Fatal("no DNS snapshot was captured")
+	}
+	if original.DNS.DmarcRecord == nil || !original.DNS.DmarcRecord.Valid {
+		t.Fatalf("expected the DMARC record to be found, got %+v", original.DNS.DmarcRecord)
+	}
+	if chain := original.Headers.ReceivedChain; chain == nil || len(*chain) == 0 || utils.DerefOrZero((*chain)[0].Reverse) != "mx.example.com" {
+		t.Fatalf("expected the reverse lookup of the received chain, got %+v", chain)
+	}
+
+	// Replaying doesn't need the original resolver anymore
+	offline := NewReportGeneratorWithResolvers(&spfMockResolver{}, &spfMockResolver{}, "mx.test", time.Second, time.Second, nil, nil, false, "")
+	replayed := offline.ReplayEmail(email, roundTripSnapshot(t, original.DNSSnapshot))
+
+	wantDNS, _ := json.Marshal(original.DNS)
+	gotDNS, _ := json.Marshal(replayed.DNS)
+	if string(gotDNS) != string(wantDNS) {
+		t.Errorf("replayed DNS results differ:\n got %s\nwant %s", gotDNS, wantDNS)
+	}
+	wantHeaders, _ := json.Marshal(original.Headers)
+	gotHeaders, _ := json.Marshal(replayed.Headers)
+	if string(gotHeaders) != string(wantHeaders) {
+		t.Errorf("replayed header analysis differs:\n got %s\nwant %s", gotHeaders, wantHeaders)
+	}
+}
@@ -33,8 +33,23 @@ import (
 
 // checkSPFRecords looks up and validates SPF records for a domain, including resolving include: directives
 func (d *DNSAnalyzer) checkSPFRecords(domain string) *[]model.SPFRecord {
+	records, _ := d.checkSPFRecordsWithBudget(domain)
+	return records
+}
+
+// checkSPFRecordsWithBudget is checkSPFRecords that also returns the DNS
+// lookup accounting of the whole include/redirect tree, which comes from
+// evaluating it as receivers do.
+func (d *DNSAnalyzer) checkSPFRecordsWithBudget(domain string) (*[]model.SPFRecord, *model.SPFLookupBudget) {
 	visited := make(map[string]bool)
-	return d.resolveSPFRecords(domain, visited, 0, true)
+	records := d.resolveSPFRecords(domain, visited, 0, true)
+	if len(*records) == 0 || (*records)[0].Record == nil {
+		return records, nil
+	}
+
+	audit := d.auditSPF(domain)
+	annotateSPFRecords(*records, audit)
+	return records, d.summarizeSPFLookupBudget(audit)
 }
 
 // resolveSPFRecords recursively resolves SPF records including include: directives
@@ -48,6 +63,7 @@ func (d *DNSAnalyzer) resolveSPFRecords(domain string, visited map[string]bool,
 				Domain: &domain,
 				Valid:  false,
 				Error:  utils.PtrTo("Maximum SPF include depth exceeded"),
+				Depth:  utils.PtrTo(depth),
 			},
 		}
 	}
@@ -68,6 +84,7 @@ func (d *DNSAnalyzer) resolveSPFRecords(domain string, visited map[string]bool,
 				Domain: &domain,
 				Valid:  false,
 				Error:  utils.PtrTo(fmt.Sprintf("Failed to lookup TXT records: %s", formatDNSError(err))),
+				Depth:  utils.PtrTo(depth),
 			},
 		}
 	}
@@ -88,6 +105,7 @@ func (d *DNSAnalyzer) resolveSPFRecords(domain string, visited map[string]bool,
 				Domain: &domain,
 				Valid:  false,
 				Error:  utils.PtrTo(noSPFRecordError(txtRecords)),
+				Depth:  utils.PtrTo(depth),
 			},
 		}
 	}
@@ -100,6 +118,7 @@ func (d *DNSAnalyzer) resolveSPFRecords(domain string, visited map[string]bool,
 			Record: &spfRecord,
 			Valid:  false,
 			Error:  utils.PtrTo("Multiple SPF records found (RFC violation)"),
+			Depth:  utils.PtrTo(depth),
 		})
 		return &results
 	}
@@ -135,6 +154,7 @@ func (d *DNSAnalyzer) resolveSPFRecords(domain string, visited map[string]bool,
 		Valid:        validationErr == nil,
 		AllQualifier: allQualifier,
 		Error:        errMsg,
+		Depth:        utils.PtrTo(depth),
 	})
 
 	// Check for redirect= modifier first (it replaces the entire SPF policy)
@@ -337,8 +357,13 @@ func (d *DNSAnalyzer) calculateSPFScore(results *model.DNSResults) (score int) {
 			}
 		}
 
+		// A tree over the DNS lookup budget makes receivers return permerror
+		// however well the record itself is written
+		budgetExceeded := results.SpfLookupBudget != nil &&
+			results.SpfLookupBudget.Status == model.SPFLookupBudgetStatusExceeded
+
 		mainSPF := (*results.SpfRecords)[mainSPFIndex]
-		if mainSPF.Valid {
+		if mainSPF.Valid && !budgetExceeded {
 			// Full points for valid SPF
 			score += 75
 
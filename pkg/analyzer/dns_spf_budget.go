@@ -0,0 +1,319 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// spfNearLookupLimit is the lookup count from which a flattened record is
+// suggested even though the tree still fits: one more include from a
+// provider is enough to break it.
+const spfNearLookupLimit = 8
+
+// spfAudit is the evaluation of an SPF tree for a sender it doesn't list,
+// which is the path where receivers resolve the most terms.
+type spfAudit struct {
+	*spfEvaluator
+	result model.SPFEvaluationResult
+	err    *spfError // why receivers stop evaluating, nil when they don't
+	errAt  int       // trace step err was raised at, -1 when unknown
+}
+
+// auditSPF evaluates the SPF policy of domain without a sender IP, with the
+// same lookup accounting as the evaluation of an actual message.
+func (d *DNSAnalyzer) auditSPF(domain string) *spfAudit {
+	e := &spfEvaluator{
+		resolver: d.resolver,
+		timeout:  d.Timeout,
+		sender:   "postmaster@" + domain,
+		active:   make(map[string]bool),
+	}
+
+	result, err := e.checkHost(domain, 0)
+	audit := &spfAudit{spfEvaluator: e, result: result, err: err, errAt: -1}
+
+	switch {
+	case e.overLimit != nil:
+		// Receivers stop there, before anything the audit found later
+		audit.err, audit.errAt = e.overLimit, e.overLimitAt
+	case err != nil:
+		// The failing step is the innermost one, hence the last marked
+		for i := len(e.trace) - 1; i >= 0; i-- {
+			if e.trace[i].Result == model.SPFTraceStepResultError {
+				audit.errAt = i
+				break
+			}
+		}
+	}
+
+	return audit
+}
+
+// annotateSPFRecords reports on each record of the tree the lookups the
+// audit charged to it, and the problems receivers run into there. Records
+// the audit didn't reach are left as they are.
+func annotateSPFRecords(records []model.SPFRecord, audit *spfAudit) {
+	type annotation struct {
+		lookups     int
+		voidLookups int
+		cumulative  int
+		warnings    []string
+	}
+	annotations := make(map[string]*annotation)
+
+	for i, step := range audit.trace {
+		a, ok := annotations[step.Domain]
+		if !ok {
+			a = &annotation{}
+			annotations[step.Domain] = a
+		}
+
+		// Lookups are only ever counted for the latest step
+		a.lookups += step.Lookups
+		if i > 0 {
+			a.lookups -= audit.trace[i-1].Lookups
+		}
+
+		// The running count once the step and what it references are done
+		end := i
+		for end+1 < len(audit.trace) && audit.trace[end+1].Depth > step.Depth {
+			end++
+		}
+		a.cumulative = audit.trace[end].Lookups
+
+		if term, err := parseSPFTerm(step.Term); err == nil && term.name == "ptr" {
+			a.warnings = append(a.warnings, fmt.Sprintf("%s is deprecated (RFC 7208 section 5.5): it is slow and receivers only check the first %d host names", step.Term, spfMaxPTRRecords))
+		}
+		if slices.Contains(audit.voidSteps, i) {
+			a.voidLookups++
+			a.warnings = append(a.warnings, fmt.Sprintf("%s returns no answer (void lookup, limit is %d per evaluation)", step.Term, spfMaxVoidLookups))
+		}
+		if i == audit.errAt {
+			a.warnings = append(a.warnings, fmt.Sprintf("%s: %s, receivers stop evaluating here and return %s", step.Term, audit.err.reason, audit.err.result))
+		}
+	}
+
+	for i := range records {
+		if records[i].Record == nil || records[i].Domain == nil {
+			continue
+		}
+		a, ok := annotations[strings.ToLower(strings.TrimSuffix(*records[i].Domain, "."))]
+		if !ok {
+			continue
+		}
+		records[i].DnsLookups = utils.PtrTo(a.lookups)
+		records[i].VoidLookups = utils.PtrTo(a.voidLookups)
+		records[i].CumulativeLookups = utils.PtrTo(a.cumulative)
+		if len(a.warnings) > 0 {
+			records[i].Warnings = &a.warnings
+		}
+	}
+}
+
+// summarizeSPFLookupBudget reports the lookup accounting of an SPF audit,
+// suggesting a flattened record when it is over or close to the limit.
+func (d *DNSAnalyzer) summarizeSPFLookupBudget(audit *spfAudit) *model.SPFLookupBudget {
+	summary := &model.SPFLookupBudget{
+		DnsLookups:      audit.lookups,
+		DnsLookupLimit:  spfMaxDNSLookups,
+		VoidLookups:     audit.voidLookups,
+		VoidLookupLimit: spfMaxVoidLookups,
+		Status:          model.SPFLookupBudgetStatusOk,
+	}
+
+	switch {
+	case audit.err != nil && audit.err.result == model.SPFEvaluationResultPermerror:
+		summary.Status = model.SPFLookupBudgetStatusExceeded
+		reason := audit.err.reason
+		if audit.errAt >= 0 {
+			reason = fmt.Sprintf("%s, in %s", reason, audit.trace[audit.errAt].Domain)
+		}
+		summary.Error = utils.PtrTo("permerror: " + reason)
+	case audit.lookups >= spfNearLookupLimit:
+		summary.Status = model.SPFLookupBudgetStatusNearLimit
+	default:
+		return summary
+	}
+
+	record, remaining, notes := d.flattenSPF(audit.trace, audit.result)
+	summary.FlattenedRecord = &record
+	summary.FlattenedLookups = utils.PtrTo(remaining)
+	summary.FlatteningNotes = &notes
+
+	return summary
+}
+
+// flattenSPF builds a record equivalent to the audited tree for passing
+// senders, replacing include, a and mx terms with the addresses they
+// currently resolve to, and ending with the result other senders get. It
+// returns the record, the lookups it still costs and caveats.
+func (d *DNSAnalyzer) flattenSPF(trace []model.SPFTraceStep, result model.SPFEvaluationResult) (string, int, []string) {
+	var (
+		terms     []string
+		notes     []string
+		remaining int
+		seen      = make(map[string]bool)
+		evaluated = make(map[string]bool)
+	)
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	keep := func(token, domain, why string) {
+		add(token)
+		remaining++
+		notes = append(notes, fmt.Sprintf("%s (from %s) was kept: %s", token, domain, why))
+	}
+
+	for _, step := range trace {
+		// A record referenced twice is evaluated twice
+		key := step.Domain + " " + step.Term
+		if evaluated[key] {
+			continue
+		}
+		evaluated[key] = true
+
+		term, err := parseSPFTerm(step.Term)
+		if err != nil || term.modifier || term.name == "all" || term.name == "include" {
+			// Included and redirected records are part of the trace
+			continue
+		}
+		if term.qualifier != '+' {
+			notes = append(notes, fmt.Sprintf("%s (from %s) was dropped: only terms that let senders pass can be flattened", step.Term, step.Domain))
+			continue
+		}
+
+		switch term.name {
+		case "ip4", "ip6":
+			add(formatSPFNetwork(term.network))
+		case "a", "mx":
+			target := term.value
+			if target == "" {
+				target = step.Domain
+			}
+			if strings.Contains(target, "%") {
+				keep(step.Term, step.Domain, "it uses macros")
+				continue
+			}
+			addrs, err := d.resolveSPFFlattenTarget(term.name, target)
+			if err != nil || len(addrs) == 0 {
+				keep(step.Term, step.Domain, "its addresses could not be resolved")
+				continue
+			}
+			for _, addr := range addrs {
+				add(spfAddressTerm(addr, term.cidr4, term.cidr6))
+			}
+		default:
+			keep(step.Term, step.Domain, "it cannot be expressed as address ranges")
+		}
+	}
+
+	all := "~all"
+	switch result {
+	case model.SPFEvaluationResultFail:
+		all = "-all"
+	case model.SPFEvaluationResultPass:
+		all = "+all"
+	case model.SPFEvaluationResultNeutral:
+		all = "?all"
+	}
+	record := strings.Join(append(append([]string{"v=spf1"}, terms...), all), " ")
+
+	notes = append(notes, "Flattened ranges are a snapshot: regenerate the record whenever your providers change their published SPF records")
+	if len(record) > 255 {
+		notes = append(notes, "The record is longer than 255 characters: publish it as several strings of a single TXT record, or split it across include records")
+	}
+
+	return record, remaining, notes
+}
+
+// resolveSPFFlattenTarget returns the addresses an a or mx mechanism
+// currently matches.
+func (d *DNSAnalyzer) resolveSPFFlattenTarget(mechanism, target string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	if mechanism == "a" {
+		return d.resolver.LookupHost(ctx, target)
+	}
+
+	mxs, err := d.resolver.LookupMX(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, mx := range mxs {
+		hostAddrs, err := d.resolver.LookupHost(ctx, strings.TrimSuffix(mx.Host, "."))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, hostAddrs...)
+	}
+	return addrs, nil
+}
+
+// spfAddressTerm turns an address matched by an a or mx mechanism into the
+// ip4/ip6 term covering the same range.
+func spfAddressTerm(addr string, cidr4, cidr6 int) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "ip4:" + addr
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if cidr4 < 0 {
+			return "ip4:" + ip4.String()
+		}
+		return formatSPFNetwork(&net.IPNet{IP: ip4.Mask(net.CIDRMask(cidr4, 32)), Mask: net.CIDRMask(cidr4, 32)})
+	}
+
+	if cidr6 < 0 {
+		return "ip6:" + ip.String()
+	}
+	return formatSPFNetwork(&net.IPNet{IP: ip.Mask(net.CIDRMask(cidr6, 128)), Mask: net.CIDRMask(cidr6, 128)})
+}
+
+// formatSPFNetwork writes a network as an ip4/ip6 term, omitting the prefix
+// length for single addresses.
+func formatSPFNetwork(network *net.IPNet) string {
+	prefix := "ip6:"
+	if network.IP.To4() != nil {
+		prefix = "ip4:"
+	}
+
+	ones, bits := network.Mask.Size()
+	if ones == bits {
+		return prefix + network.IP.String()
+	}
+	return prefix + network.String()
+}
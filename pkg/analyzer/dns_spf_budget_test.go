@@ -0,0 +1,333 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+// spfBudgetResolver publishes a main record with a, mx and one include
+// pointing at a provider record that itself includes n sub-records.
+func spfBudgetResolver(n int) *spfMockResolver {
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com": {"v=spf1 a mx include:_spf.example.net -all"},
+		},
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mail.example.com.", Pref: 10}},
+		},
+		hosts: map[string][]string{
+			"example.com":      {"198.51.100.1"},
+			"mail.example.com": {"198.51.100.2"},
+		},
+	}
+
+	provider := []string{"v=spf1"}
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf("s%d.example.net", i)
+		provider = append(provider, "include:"+name)
+		resolver.txt[name] = []string{fmt.Sprintf("v=spf1 ip4:192.0.2.%d ~all", i)}
+	}
+	resolver.txt["_spf.example.net"] = []string{strings.Join(append(provider, "~all"), " ")}
+
+	return resolver
+}
+
+func findSPFRecord(t *testing.T, records []model.SPFRecord, domain string) model.SPFRecord {
+	t.Helper()
+	for _, rec := range records {
+		if rec.Domain != nil && *rec.Domain == domain {
+			return rec
+		}
+	}
+	t.Fatalf("no SPF record for %s in %d records", domain, len(records))
+	return model.SPFRecord{}
+}
+
+func TestCheckSPFRecordsWithBudgetWithinLimit(t *testing.T) {
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, spfBudgetResolver(2))
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget == nil {
+		t.Fatal("expected a lookup budget")
+	}
+	if budget.DnsLookups != 5 || budget.Status != model.SPFLookupBudgetStatusOk {
+		t.Errorf("budget = %d lookups, status %s; want 5, ok", budget.DnsLookups, budget.Status)
+	}
+	if budget.FlattenedRecord != nil {
+		t.Errorf("no flattened record expected within budget, got %q", *budget.FlattenedRecord)
+	}
+
+	main := findSPFRecord(t, *records, "example.com")
+	if main.DnsLookups == nil || *main.DnsLookups != 3 {
+		t.Errorf("main record lookups = %v, want 3", main.DnsLookups)
+	}
+	if main.CumulativeLookups == nil || *main.CumulativeLookups != 5 {
+		t.Errorf("main record cumulative lookups = %v, want 5", main.CumulativeLookups)
+	}
+	provider := findSPFRecord(t, *records, "_spf.example.net")
+	if provider.Depth == nil || *provider.Depth != 1 {
+		t.Errorf("provider depth = %v, want 1", provider.Depth)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetExceeded(t *testing.T) {
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, spfBudgetResolver(9))
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget == nil {
+		t.Fatal("expected a lookup budget")
+	}
+	if budget.DnsLookups != 12 {
+		t.Errorf("DnsLookups = %d, want 12", budget.DnsLookups)
+	}
+	if budget.Status != model.SPFLookupBudgetStatusExceeded {
+		t.Errorf("Status = %s, want exceeded", budget.Status)
+	}
+	if budget.Error == nil || !strings.HasPrefix(*budget.Error, "permerror:") || !strings.Contains(*budget.Error, "_spf.example.net") {
+		t.Errorf("Error = %v, want a permerror naming _spf.example.net", budget.Error)
+	}
+
+	provider := findSPFRecord(t, *records, "_spf.example.net")
+	if provider.Warnings == nil || !strings.Contains((*provider.Warnings)[0], "too many DNS lookups") {
+		t.Errorf("provider warnings = %v, want the crossing to be flagged", provider.Warnings)
+	}
+
+	want := "v=spf1 ip4:198.51.100.1 ip4:198.51.100.2 ip4:192.0.2.1 ip4:192.0.2.2 ip4:192.0.2.3 ip4:192.0.2.4 ip4:192.0.2.5 ip4:192.0.2.6 ip4:192.0.2.7 ip4:192.0.2.8 ip4:192.0.2.9 -all"
+	if budget.FlattenedRecord == nil || *budget.FlattenedRecord != want {
+		t.Errorf("FlattenedRecord = %v, want %q", budget.FlattenedRecord, want)
+	}
+	if budget.FlattenedLookups == nil || *budget.FlattenedLookups != 0 {
+		t.Errorf("FlattenedLookups = %v, want 0", budget.FlattenedLookups)
+	}
+
+	results := &model.DNSResults{SpfRecords: records, SpfLookupBudget: budget}
+	if score := analyzer.calculateSPFScore(results); score != 25 {
+		t.Errorf("calculateSPFScore() = %d, want 25 for a tree over budget", score)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetNearLimit(t *testing.T) {
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, spfBudgetResolver(5))
+
+	_, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget == nil || budget.Status != model.SPFLookupBudgetStatusNearLimit {
+		t.Fatalf("budget = %+v, want near_limit", budget)
+	}
+	if budget.FlattenedRecord == nil {
+		t.Error("expected a flattened record suggestion near the limit")
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetVoidLookups(t *testing.T) {
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com": {"v=spf1 a:gone1.example.com mx:gone2.example.com exists:gone3.example.com -all"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget.VoidLookups != 3 {
+		t.Errorf("VoidLookups = %d, want 3", budget.VoidLookups)
+	}
+	if budget.Status != model.SPFLookupBudgetStatusExceeded || budget.Error == nil || !strings.Contains(*budget.Error, "void") {
+		t.Errorf("budget = %s (%v), want exceeded on void lookups", budget.Status, budget.Error)
+	}
+
+	main := (*records)[0]
+	if main.VoidLookups == nil || *main.VoidLookups != 3 {
+		t.Errorf("main record void lookups = %v, want 3", main.VoidLookups)
+	}
+	if main.Warnings == nil || len(*main.Warnings) != 4 || !strings.Contains((*main.Warnings)[3], "too many void DNS lookups") {
+		t.Errorf("warnings = %v, want three void lookups and the crossing to be flagged", main.Warnings)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetMissingInclude(t *testing.T) {
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com": {"v=spf1 include:gone.example.net -all"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget.Status != model.SPFLookupBudgetStatusExceeded || budget.Error == nil || !strings.Contains(*budget.Error, "no SPF record") {
+		t.Errorf("budget = %s (%v), want a permerror on the missing include", budget.Status, budget.Error)
+	}
+
+	main := (*records)[0]
+	if main.Warnings == nil || !strings.HasPrefix((*main.Warnings)[0], "include:gone.example.net:") {
+		t.Errorf("warnings = %v, want the include to be flagged", main.Warnings)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetMXFanOut(t *testing.T) {
+	var mxs []*net.MX
+	for i := range 11 {
+		mxs = append(mxs, &net.MX{Host: fmt.Sprintf("mx%d.example.com.", i), Pref: 10})
+	}
+	resolver := &spfMockResolver{
+		txt: map[string][]string{"example.com": {"v=spf1 ptr mx -all"}},
+		mx:  map[string][]*net.MX{"example.com": mxs},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget.Status != model.SPFLookupBudgetStatusExceeded || budget.Error == nil || !strings.Contains(*budget.Error, "MX records") {
+		t.Errorf("budget = %s (%v), want exceeded on MX fan-out", budget.Status, budget.Error)
+	}
+
+	main := (*records)[0]
+	if main.Warnings == nil || len(*main.Warnings) != 2 {
+		t.Fatalf("warnings = %v, want ptr and MX fan-out warnings", main.Warnings)
+	}
+	if !strings.Contains((*main.Warnings)[0], "deprecated") {
+		t.Errorf("warnings[0] = %q, want the ptr deprecation", (*main.Warnings)[0])
+	}
+	if !strings.Contains((*main.Warnings)[1], "more than 10 MX records") {
+		t.Errorf("warnings[1] = %q, want the MX fan-out", (*main.Warnings)[1])
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetRepeatedInclude(t *testing.T) {
+	// Receivers don't cache: an include reached twice costs its lookups twice
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 include:a.example.net include:b.example.net -all"},
+			"a.example.net":      {"v=spf1 include:common.example.net ~all"},
+			"b.example.net":      {"v=spf1 include:common.example.net ~all"},
+			"common.example.net": {"v=spf1 exists:%{i}.example.net exists:%{l}.example.net ~all"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	_, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget.DnsLookups != 8 {
+		t.Errorf("DnsLookups = %d, want 8", budget.DnsLookups)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetLoop(t *testing.T) {
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com":   {"v=spf1 include:a.example.net -all"},
+			"a.example.net": {"v=spf1 include:example.com ~all"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if budget.Status != model.SPFLookupBudgetStatusExceeded {
+		t.Errorf("Status = %s, want exceeded for an include loop", budget.Status)
+	}
+	if len(*records) != 2 {
+		t.Fatalf("got %d records, want each record listed once", len(*records))
+	}
+	included := findSPFRecord(t, *records, "a.example.net")
+	if included.Warnings == nil || !strings.Contains((*included.Warnings)[0], "referenced from its own include/redirect tree") {
+		t.Errorf("included record warnings = %v, want the loop to be flagged", included.Warnings)
+	}
+}
+
+func TestCheckSPFRecordsWithBudgetFollowsRedirectOnly(t *testing.T) {
+	// Receivers only follow redirect= when no mechanism matched, and the
+	// tree lists what redirect= points to instead of the includes
+	resolver := &spfMockResolver{
+		txt: map[string][]string{
+			"example.com":      {"v=spf1 include:a.example.net redirect=_spf.example.net"},
+			"a.example.net":    {"v=spf1 ip4:192.0.2.0/24 ~all"},
+			"_spf.example.net": {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+	}
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, resolver)
+
+	records, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+	if len(*records) != 2 || *(*records)[1].Domain != "_spf.example.net" {
+		t.Errorf("records = %d, want the main record then the redirect target", len(*records))
+	}
+	if budget.DnsLookups != 2 {
+		t.Errorf("DnsLookups = %d, want 2", budget.DnsLookups)
+	}
+}
+
+func TestSPFLookupBudgetMatchesEvaluation(t *testing.T) {
+	// The budget is the cost of a sender the policy doesn't list
+	for _, n := range []int{0, 2, 5} {
+		analyzer := NewDNSAnalyzerWithResolver(5*time.Second, spfBudgetResolver(n))
+
+		_, budget := analyzer.checkSPFRecordsWithBudget("example.com")
+		eval := analyzer.evaluateSPF("example.com", "203.0.113.1", "", "")
+		if budget.DnsLookups != eval.DnsLookups || budget.VoidLookups != eval.VoidLookups {
+			t.Errorf("n=%d: budget = %d/%d lookups, evaluation = %d/%d", n, budget.DnsLookups, budget.VoidLookups, eval.DnsLookups, eval.VoidLookups)
+		}
+	}
+}
+
+func TestFlattenSPFKeepsUnflattenableTerms(t *testing.T) {
+	analyzer := NewDNSAnalyzerWithResolver(5*time.Second, &spfMockResolver{})
+
+	var trace []model.SPFTraceStep
+	for _, term := range strings.Fields("ip4:192.0.2.0/24 ip6:2001:db8::/32 -ip4:192.0.2.1 exists:%{i}.example.com a:%{d}.example.com -all") {
+		trace = append(trace, model.SPFTraceStep{Domain: "example.com", Term: term})
+	}
+	record, remaining, notes := analyzer.flattenSPF(trace, model.SPFEvaluationResultFail)
+
+	want := "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 exists:%{i}.example.com a:%{d}.example.com -all"
+	if record != want {
+		t.Errorf("record = %q, want %q", record, want)
+	}
+	if remaining != 2 {
+		t.Errorf("remaining = %d, want 2", remaining)
+	}
+	if len(notes) != 4 {
+		t.Errorf("notes = %v, want 4 entries", notes)
+	}
+}
+
+func TestSPFAddressTerm(t *testing.T) {
+	tests := []struct {
+		addr  string
+		cidr4 int
+		cidr6 int
+		want  string
+	}{
+		{"192.0.2.10", -1, -1, "ip4:192.0.2.10"},
+		{"192.0.2.10", 24, -1, "ip4:192.0.2.0/24"},
+		{"192.0.2.10", 32, -1, "ip4:192.0.2.10"},
+		{"2001:db8::1", -1, -1, "ip6:2001:db8::1"},
+		{"2001:db8::1", 24, 64, "ip6:2001:db8::/64"},
+	}
+
+	for _, tt := range tests {
+		if got := spfAddressTerm(tt.addr, tt.cidr4, tt.cidr6); got != tt.want {
+			t.Errorf("spfAddressTerm(%q, %d, %d) = %q, want %q", tt.addr, tt.cidr4, tt.cidr6, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,975 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// Processing limits from RFC 7208 section 4.6.4
+const (
+	spfMaxDNSLookups  = 10
+	spfMaxVoidLookups = 2
+	spfMaxMXRecords   = 10
+	spfMaxPTRRecords  = 10
+)
+
+// spfAuditMaxLookups is where audits give up walking a tree over the limit.
+const spfAuditMaxLookups = 100
+
+// spfMacroDetails is the trace detail of terms an audit leaves unresolved.
+const spfMacroDetails = "depends on the message: not resolved"
+
+var spfModifierNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9\-_.]*$`)
+
+// spfError aborts an SPF evaluation with a temperror or permerror result.
+type spfError struct {
+	result model.SPFEvaluationResult
+	reason string
+}
+
+func (e *spfError) Error() string {
+	return e.reason
+}
+
+func spfPermError(format string, args ...any) *spfError {
+	return &spfError{result: model.SPFEvaluationResultPermerror, reason: fmt.Sprintf(format, args...)}
+}
+
+func spfTempError(format string, args ...any) *spfError {
+	return &spfError{result: model.SPFEvaluationResultTemperror, reason: fmt.Sprintf(format, args...)}
+}
+
+// spfTerm is a parsed SPF mechanism or modifier.
+type spfTerm struct {
+	raw       string
+	qualifier byte
+	name      string
+	value     string     // domain-spec or macro-string, "" when absent
+	network   *net.IPNet // for ip4 and ip6
+	cidr4     int        // for a and mx, -1 when absent
+	cidr6     int        // for a and mx, -1 when absent
+	modifier  bool
+}
+
+// qualifierResult maps an SPF qualifier to the result of a matching mechanism.
+func qualifierResult(qualifier byte) model.SPFEvaluationResult {
+	switch qualifier {
+	case '-':
+		return model.SPFEvaluationResultFail
+	case '~':
+		return model.SPFEvaluationResultSoftfail
+	case '?':
+		return model.SPFEvaluationResultNeutral
+	}
+	return model.SPFEvaluationResultPass
+}
+
+// splitSPFCIDR separates the optional "/cidr4" and "//cidr6" suffixes of an
+// a or mx mechanism from its domain-spec.
+func splitSPFCIDR(s string) (string, int, int, error) {
+	cidr4, cidr6 := -1, -1
+
+	if i := strings.Index(s, "//"); i >= 0 {
+		n, err := strconv.Atoi(s[i+2:])
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, fmt.Errorf("invalid IPv6 prefix length %q", s[i+2:])
+		}
+		cidr6, s = n, s[:i]
+	}
+
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		if n, err := strconv.Atoi(s[i+1:]); err == nil {
+			if n < 0 || n > 32 {
+				return "", 0, 0, fmt.Errorf("invalid IPv4 prefix length %q", s[i+1:])
+			}
+			cidr4, s = n, s[:i]
+		}
+	}
+
+	return s, cidr4, cidr6, nil
+}
+
+// parseSPFTerm parses a single term of an SPF record.
+func parseSPFTerm(token string) (spfTerm, error) {
+	t := spfTerm{raw: token, qualifier: '+', cidr4: -1, cidr6: -1}
+
+	// Modifiers are name=value, with no ':' or '/' before the '='
+	if i := strings.IndexAny(token, "=:/"); i > 0 && token[i] == '=' {
+		if !spfModifierNameRe.MatchString(token[:i]) {
+			return t, fmt.Errorf("invalid modifier %q", token)
+		}
+		switch strings.ToLower(token[:i]) {
+		case "all", "include", "a", "mx", "ptr", "ip4", "ip6", "exists":
+			return t, fmt.Errorf("mechanism %q should use ':' not '='", token)
+		}
+		t.modifier = true
+		t.name = strings.ToLower(token[:i])
+		t.value = token[i+1:]
+		if (t.name == "redirect" || t.name == "exp") && t.value == "" {
+			return t, fmt.Errorf("modifier %q has no value", token)
+		}
+		return t, nil
+	}
+
+	mechanism := token
+	if mechanism != "" && strings.ContainsRune("+-~?", rune(mechanism[0])) {
+		t.qualifier = mechanism[0]
+		mechanism = mechanism[1:]
+	}
+
+	name, rest := mechanism, ""
+	if i := strings.IndexAny(mechanism, ":/"); i >= 0 {
+		name, rest = mechanism[:i], mechanism[i:]
+	}
+	t.name = strings.ToLower(name)
+
+	switch t.name {
+	case "all":
+		if rest != "" {
+			return t, fmt.Errorf("invalid mechanism %q", token)
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+			return t, fmt.Errorf("mechanism %q requires a domain", token)
+		}
+		t.value = rest[1:]
+	case "ptr":
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") || len(rest) == 1 {
+				return t, fmt.Errorf("invalid mechanism %q", token)
+			}
+			t.value = rest[1:]
+		}
+	case "a", "mx":
+		hasDomain := strings.HasPrefix(rest, ":")
+		if hasDomain {
+			rest = rest[1:]
+		}
+		value, cidr4, cidr6, err := splitSPFCIDR(rest)
+		if err != nil {
+			return t, fmt.Errorf("invalid mechanism %q: %w", token, err)
+		}
+		if hasDomain && value == "" || !hasDomain && value != "" {
+			return t, fmt.Errorf("invalid mechanism %q", token)
+		}
+		t.value, t.cidr4, t.cidr6 = value, cidr4, cidr6
+	case "ip4", "ip6":
+		if !strings.HasPrefix(rest, ":") {
+			return t, fmt.Errorf("mechanism %q requires an address", token)
+		}
+		addr := rest[1:]
+		bits := 32
+		if t.name == "ip6" {
+			bits = 128
+		}
+		if i := strings.Index(addr, "/"); i >= 0 {
+			n, err := strconv.Atoi(addr[i+1:])
+			if err != nil || n < 0 || n > bits {
+				return t, fmt.Errorf("invalid prefix length in %q", token)
+			}
+			addr, bits = addr[:i], n
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || (t.name == "ip4") != (ip.To4() != nil && !strings.Contains(addr, ":")) {
+			return t, fmt.Errorf("invalid address in %q", token)
+		}
+		if t.name == "ip4" {
+			ip = ip.To4()
+		}
+		t.network = &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, len(ip)*8)), Mask: net.CIDRMask(bits, len(ip)*8)}
+	default:
+		return t, fmt.Errorf("unknown mechanism %q", token)
+	}
+
+	return t, nil
+}
+
+// spfMatch remembers the mechanism that determined the current result.
+type spfMatch struct {
+	mechanism   string
+	domain      string
+	explanation string
+}
+
+// spfEvaluator implements the check_host() function of RFC 7208 for one
+// sender IP, accumulating the lookup counts and the evaluation trace.
+//
+// Without a sender IP, it audits the policy instead: no address-based
+// mechanism matches, so it follows the path of a sender the policy doesn't
+// list, and it carries on past the lookup limits so that the whole tree is
+// accounted for.
+type spfEvaluator struct {
+	resolver    DNSResolver
+	timeout     time.Duration
+	ip          net.IP
+	sender      string
+	helo        string
+	lookups     int
+	voidLookups int
+	voidSteps   []int // trace steps whose lookup returned no answer
+	trace       []model.SPFTraceStep
+	matched     spfMatch
+
+	// Audit only
+	overLimit   *spfError       // first lookup limit exceeded
+	overLimitAt int             // trace step overLimit was raised at
+	active      map[string]bool // records being evaluated, to stop loops
+}
+
+// evaluateSPF evaluates the SPF policy of domain for a message sent from
+// senderIP with the given envelope sender and HELO name. It returns nil when
+// senderIP is not a valid IP address.
+func (d *DNSAnalyzer) evaluateSPF(domain, senderIP, sender, helo string) *model.SPFEvaluation {
+	ip := net.ParseIP(senderIP)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + domain
+	}
+
+	e := &spfEvaluator{
+		resolver: d.resolver,
+		timeout:  d.Timeout,
+		ip:       ip,
+		sender:   sender,
+		helo:     helo,
+	}
+
+	result, err := e.checkHost(domain, 0)
+
+	evaluation := &model.SPFEvaluation{
+		Result:      result,
+		Domain:      domain,
+		Ip:          ip.String(),
+		Sender:      utils.PtrTo(sender),
+		DnsLookups:  e.lookups,
+		VoidLookups: e.voidLookups,
+	}
+	if helo != "" {
+		evaluation.Helo = utils.PtrTo(helo)
+	}
+	if err != nil {
+		evaluation.Result = err.result
+		evaluation.Error = utils.PtrTo(err.reason)
+	} else if e.matched.mechanism != "" {
+		evaluation.MatchedMechanism = utils.PtrTo(e.matched.mechanism)
+		evaluation.MatchedDomain = utils.PtrTo(e.matched.domain)
+		if result == model.SPFEvaluationResultFail && e.matched.explanation != "" {
+			evaluation.Explanation = utils.PtrTo(e.matched.explanation)
+		}
+	}
+	if len(e.trace) > 0 {
+		evaluation.Trace = &e.trace
+	}
+
+	return evaluation
+}
+
+// checkHost evaluates the SPF record of domain (RFC 7208 section 4).
+func (e *spfEvaluator) checkHost(domain string, depth int) (model.SPFEvaluationResult, *spfError) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !isValidSPFDomain(domain) {
+		return model.SPFEvaluationResultNone, nil
+	}
+
+	if e.active != nil {
+		// Receivers stop loops at the lookup limit, which audits carry on past
+		if e.active[domain] {
+			return "", spfPermError("%s is referenced from its own include/redirect tree", domain)
+		}
+		e.active[domain] = true
+		defer delete(e.active, domain)
+	}
+
+	record, err := e.lookupRecord(domain)
+	if err != nil {
+		return "", err
+	}
+	if record == "" {
+		return model.SPFEvaluationResultNone, nil
+	}
+
+	var mechanisms []spfTerm
+	var redirect, exp *spfTerm
+	for _, token := range strings.Fields(record)[1:] {
+		term, parseErr := parseSPFTerm(token)
+		if parseErr != nil {
+			return "", spfPermError("%s: %s", domain, parseErr)
+		}
+		if !term.modifier {
+			mechanisms = append(mechanisms, term)
+			continue
+		}
+		switch term.name {
+		case "redirect":
+			if redirect != nil {
+				return "", spfPermError("%s: more than one redirect= modifier", domain)
+			}
+			redirect = &term
+		case "exp":
+			if exp != nil {
+				return "", spfPermError("%s: more than one exp= modifier", domain)
+			}
+			exp = &term
+		}
+	}
+
+	for _, term := range mechanisms {
+		step := e.addStep(domain, depth, term.raw)
+		matched, details, err := e.matchMechanism(term, domain, depth, step)
+		if details != "" {
+			e.trace[step].Details = utils.PtrTo(details)
+		}
+		if err != nil {
+			e.trace[step].Result = model.SPFTraceStepResultError
+			e.trace[step].Details = utils.PtrTo(err.reason)
+			return "", err
+		}
+		if !matched {
+			continue
+		}
+
+		e.trace[step].Result = model.SPFTraceStepResultMatch
+		result := qualifierResult(term.qualifier)
+		if term.name != "include" {
+			// For include, the matching mechanism is the one found in the included record
+			e.matched = spfMatch{mechanism: term.raw, domain: domain}
+		}
+		if result == model.SPFEvaluationResultFail && exp != nil {
+			e.matched.explanation = e.explanation(exp.value, domain)
+		}
+		return result, nil
+	}
+
+	if redirect != nil {
+		step := e.addStep(domain, depth, redirect.raw)
+		if err := e.countLookup(step); err != nil {
+			e.trace[step].Result = model.SPFTraceStepResultError
+			e.trace[step].Details = utils.PtrTo(err.reason)
+			return "", err
+		}
+		if e.skipsMacros(redirect.value) {
+			e.trace[step].Details = utils.PtrTo(spfMacroDetails)
+			return model.SPFEvaluationResultNeutral, nil
+		}
+		target, err := e.targetDomain(redirect.value, domain)
+		if err != nil {
+			e.trace[step].Result = model.SPFTraceStepResultError
+			e.trace[step].Details = utils.PtrTo(err.reason)
+			return "", err
+		}
+
+		result, err := e.checkHost(target, depth+1)
+		if err != nil {
+			e.trace[step].Result = model.SPFTraceStepResultError
+			return "", err
+		}
+		if result == model.SPFEvaluationResultNone {
+			e.trace[step].Result = model.SPFTraceStepResultError
+			return "", spfPermError("redirect target %s has no SPF record", target)
+		}
+		e.trace[step].Result = model.SPFTraceStepResultMatch
+		e.trace[step].Details = utils.PtrTo(fmt.Sprintf("%s returned %s", target, result))
+		return result, nil
+	}
+
+	return model.SPFEvaluationResultNeutral, nil
+}
+
+// addStep appends a trace step for a term about to be evaluated, and returns
+// its index so that the outcome can be recorded once known.
+func (e *spfEvaluator) addStep(domain string, depth int, term string) int {
+	e.trace = append(e.trace, model.SPFTraceStep{
+		Domain:  domain,
+		Depth:   depth,
+		Term:    term,
+		Result:  model.SPFTraceStepResultNoMatch,
+		Lookups: e.lookups,
+	})
+	return len(e.trace) - 1
+}
+
+// countLookup accounts for a DNS-querying term against the lookup limit.
+func (e *spfEvaluator) countLookup(step int) *spfError {
+	e.lookups++
+	e.trace[step].Lookups = e.lookups
+	if e.lookups > spfMaxDNSLookups {
+		return e.limitExceeded(step, spfPermError("too many DNS lookups (limit is %d)", spfMaxDNSLookups))
+	}
+	return nil
+}
+
+// countVoidLookup accounts for a lookup of the given step that returned no
+// answer.
+func (e *spfEvaluator) countVoidLookup(step int) *spfError {
+	e.voidLookups++
+	e.voidSteps = append(e.voidSteps, step)
+	if e.voidLookups > spfMaxVoidLookups {
+		return e.limitExceeded(step, spfPermError("too many void DNS lookups (limit is %d)", spfMaxVoidLookups))
+	}
+	return nil
+}
+
+// limitExceeded aborts the evaluation. Audits only remember the first limit
+// exceeded, unless the tree is so large that it isn't worth walking further.
+func (e *spfEvaluator) limitExceeded(step int, err *spfError) *spfError {
+	if e.ip != nil || e.lookups > spfAuditMaxLookups {
+		return err
+	}
+	if e.overLimit == nil {
+		e.overLimit, e.overLimitAt = err, step
+	}
+	return nil
+}
+
+// skipsMacros reports whether the domain-spec of a term is left unresolved:
+// audits have no message to expand its macros with.
+func (e *spfEvaluator) skipsMacros(spec string) bool {
+	return e.ip == nil && strings.Contains(spec, "%")
+}
+
+// lookupRecord returns the SPF record of domain, or "" if it has none.
+func (e *spfEvaluator) lookupRecord(domain string) (string, *spfError) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	txtRecords, err := e.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return "", nil
+		}
+		return "", spfTempError("failed to lookup SPF record of %s: %s", domain, formatDNSError(err))
+	}
+
+	var records []string
+	for _, txt := range txtRecords {
+		lower := strings.ToLower(txt)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+
+	if len(records) > 1 {
+		return "", spfPermError("%s publishes multiple SPF records", domain)
+	}
+	if len(records) == 0 {
+		return "", nil
+	}
+	return records[0], nil
+}
+
+// lookupHost resolves the addresses of name for the given step, counting void
+// lookups.
+func (e *spfEvaluator) lookupHost(name string, step int) ([]string, *spfError) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	addrs, err := e.resolver.LookupHost(ctx, name)
+	if err != nil && !isDNSNotFound(err) {
+		return nil, spfTempError("failed to lookup %s: %s", name, formatDNSError(err))
+	}
+	if len(addrs) == 0 {
+		return nil, e.countVoidLookup(step)
+	}
+	return addrs, nil
+}
+
+// matchAddrs reports whether the sender IP is within one of addrs, using the
+// mechanism's prefix lengths.
+func (e *spfEvaluator) matchAddrs(addrs []string, term spfTerm) bool {
+	if e.ip == nil {
+		return false
+	}
+
+	isIPv4 := e.ip.To4() != nil
+	for _, a := range addrs {
+		addr := net.ParseIP(a)
+		if addr == nil || (addr.To4() != nil) != isIPv4 {
+			continue
+		}
+
+		var mask net.IPMask
+		if isIPv4 {
+			addr = addr.To4()
+			bits := term.cidr4
+			if bits < 0 {
+				bits = 32
+			}
+			mask = net.CIDRMask(bits, 32)
+		} else {
+			bits := term.cidr6
+			if bits < 0 {
+				bits = 128
+			}
+			mask = net.CIDRMask(bits, 128)
+		}
+
+		if addr.Mask(mask).Equal(e.ip.Mask(mask)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMechanism evaluates a mechanism against the sender IP. It returns
+// whether it matched and details for the trace.
+func (e *spfEvaluator) matchMechanism(term spfTerm, domain string, depth, step int) (bool, string, *spfError) {
+	switch term.name {
+	case "all":
+		return true, "", nil
+
+	case "ip4", "ip6":
+		return e.ip != nil && term.network.Contains(e.ip), "", nil
+
+	case "a":
+		if err := e.countLookup(step); err != nil {
+			return false, "", err
+		}
+		if e.skipsMacros(term.value) {
+			return false, spfMacroDetails, nil
+		}
+		target, err := e.targetDomain(term.value, domain)
+		if err != nil {
+			return false, "", err
+		}
+		addrs, err := e.lookupHost(target, step)
+		if err != nil {
+			return false, "", err
+		}
+		return e.matchAddrs(addrs, term), fmt.Sprintf("%s resolves to %s", target, formatAddrs(addrs)), nil
+
+	case "mx":
+		if err := e.countLookup(step); err != nil {
+			return false, "", err
+		}
+		if e.skipsMacros(term.value) {
+			return false, spfMacroDetails, nil
+		}
+		target, err := e.targetDomain(term.value, domain)
+		if err != nil {
+			return false, "", err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+		mxs, lookupErr := e.resolver.LookupMX(ctx, target)
+		cancel()
+		if lookupErr != nil && !isDNSNotFound(lookupErr) {
+			return false, "", spfTempError("failed to lookup MX of %s: %s", target, formatDNSError(lookupErr))
+		}
+		if len(mxs) == 0 {
+			return false, fmt.Sprintf("%s has no MX", target), e.countVoidLookup(step)
+		}
+		if len(mxs) > spfMaxMXRecords {
+			return false, "", spfPermError("%s has more than %d MX records", target, spfMaxMXRecords)
+		}
+
+		var hosts []string
+		for _, mx := range mxs {
+			host := strings.TrimSuffix(mx.Host, ".")
+			if host == "" {
+				continue
+			}
+			hosts = append(hosts, host)
+
+			ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+			addrs, _ := e.resolver.LookupHost(ctx, host)
+			cancel()
+			if e.matchAddrs(addrs, term) {
+				return true, fmt.Sprintf("sender is MX %s", host), nil
+			}
+		}
+		return false, fmt.Sprintf("MX of %s: %s", target, strings.Join(hosts, ", ")), nil
+
+	case "ptr":
+		if err := e.countLookup(step); err != nil {
+			return false, "", err
+		}
+		target, err := e.targetDomain(term.value, domain)
+		if err != nil {
+			return false, "", err
+		}
+		for _, name := range e.validatedNames() {
+			if name == target || strings.HasSuffix(name, "."+target) {
+				return true, fmt.Sprintf("validated PTR %s", name), nil
+			}
+		}
+		return false, "", nil
+
+	case "exists":
+		if err := e.countLookup(step); err != nil {
+			return false, "", err
+		}
+		if e.skipsMacros(term.value) {
+			return false, spfMacroDetails, nil
+		}
+		target, err := e.targetDomain(term.value, domain)
+		if err != nil {
+			return false, "", err
+		}
+		addrs, err := e.lookupHost(target, step)
+		if err != nil {
+			return false, "", err
+		}
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				return true, fmt.Sprintf("%s exists", target), nil
+			}
+		}
+		return false, fmt.Sprintf("%s does not exist", target), nil
+
+	case "include":
+		if err := e.countLookup(step); err != nil {
+			return false, "", err
+		}
+		if e.skipsMacros(term.value) {
+			return false, spfMacroDetails, nil
+		}
+		target, err := e.targetDomain(term.value, domain)
+		if err != nil {
+			return false, "", err
+		}
+
+		previous := e.matched
+		result, err := e.checkHost(target, depth+1)
+		if err != nil {
+			return false, "", err
+		}
+		switch result {
+		case model.SPFEvaluationResultPass:
+			return true, fmt.Sprintf("%s returned pass", target), nil
+		case model.SPFEvaluationResultNone:
+			return false, "", spfPermError("included domain %s has no SPF record", target)
+		default:
+			// fail, softfail and neutral in an included record are not a match
+			e.matched = previous
+			return false, fmt.Sprintf("%s returned %s", target, result), nil
+		}
+	}
+
+	return false, "", spfPermError("unknown mechanism %q", term.raw)
+}
+
+// validatedNames returns the PTR names of the sender IP that resolve back to
+// it (RFC 7208 section 5.5), lowercased and without trailing dot.
+func (e *spfEvaluator) validatedNames() []string {
+	if e.ip == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	names, err := e.resolver.LookupAddr(ctx, e.ip.String())
+	if err != nil {
+		return nil
+	}
+	if len(names) > spfMaxPTRRecords {
+		names = names[:spfMaxPTRRecords]
+	}
+
+	var validated []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		addrs, err := e.resolver.LookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if addr := net.ParseIP(a); addr != nil && addr.Equal(e.ip) {
+				validated = append(validated, name)
+				break
+			}
+		}
+	}
+	return validated
+}
+
+// explanation expands the exp= modifier of a record into the explanation
+// string. Any error results in no explanation (RFC 7208 section 6.2).
+func (e *spfEvaluator) explanation(spec, domain string) string {
+	target, err := e.targetDomain(spec, domain)
+	if err != nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	txtRecords, lookupErr := e.resolver.LookupTXT(ctx, target)
+	if lookupErr != nil || len(txtRecords) != 1 {
+		return ""
+	}
+
+	explanation, err := e.expandMacros(txtRecords[0], domain, true)
+	if err != nil {
+		return ""
+	}
+	return explanation
+}
+
+// targetDomain returns the domain a mechanism applies to: the expanded
+// domain-spec, or the current domain when there is none.
+func (e *spfEvaluator) targetDomain(spec, domain string) (string, *spfError) {
+	if spec == "" {
+		return domain, nil
+	}
+
+	target, err := e.expandMacros(spec, domain, false)
+	if err != nil {
+		return "", err
+	}
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+
+	// Domains longer than 253 characters are shortened from the left
+	for len(target) > 253 {
+		_, rest, found := strings.Cut(target, ".")
+		if !found {
+			break
+		}
+		target = rest
+	}
+
+	return target, nil
+}
+
+// expandMacros expands the macros of an SPF macro-string (RFC 7208 section 7).
+// The c, r and t macros are only allowed in explanations.
+func (e *spfEvaluator) expandMacros(spec, domain string, explanation bool) (string, *spfError) {
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", spfPermError("invalid macro in %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return "", spfPermError("unterminated macro in %q", spec)
+			}
+			value, err := e.expandMacro(spec[i+1:i+end], domain, explanation)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", spfPermError("invalid macro in %q", spec)
+		}
+	}
+	return b.String(), nil
+}
+
+// expandMacro expands the content of a single %{...} macro.
+func (e *spfEvaluator) expandMacro(macro, domain string, explanation bool) (string, *spfError) {
+	if macro == "" {
+		return "", spfPermError("empty macro")
+	}
+
+	localPart, senderDomain := "postmaster", e.sender
+	if i := strings.LastIndex(e.sender, "@"); i >= 0 {
+		if i > 0 {
+			localPart = e.sender[:i]
+		}
+		senderDomain = e.sender[i+1:]
+	}
+
+	letter := macro[0]
+	var value string
+	switch letter | 0x20 {
+	case 's':
+		value = e.sender
+	case 'l':
+		value = localPart
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'i':
+		if ip4 := e.ip.To4(); ip4 != nil {
+			value = ip4.String()
+		} else {
+			nibbles := hex.EncodeToString(e.ip.To16())
+			value = strings.Join(strings.Split(nibbles, ""), ".")
+		}
+	case 'p':
+		value = "unknown"
+		for _, name := range e.validatedNames() {
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				value = name
+				break
+			}
+			if value == "unknown" {
+				value = name
+			}
+		}
+	case 'v':
+		value = "ip6"
+		if e.ip.To4() != nil {
+			value = "in-addr"
+		}
+	case 'h':
+		value = e.helo
+	case 'c', 'r', 't':
+		if !explanation {
+			return "", spfPermError("macro %%{%c} is only allowed in explanations", letter)
+		}
+		switch letter | 0x20 {
+		case 'c':
+			value = e.ip.String()
+		case 'r':
+			value = "unknown"
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", spfPermError("unknown macro letter %q", string(letter))
+	}
+
+	// Transformers: optional digits, optional 'r', then delimiters
+	transformers := macro[1:]
+	digits := 0
+	for digits < len(transformers) && transformers[digits] >= '0' && transformers[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(transformers[:digits])
+		if keep == 0 {
+			return "", spfPermError("invalid macro transformer in %%{%s}", macro)
+		}
+	}
+	transformers = transformers[digits:]
+
+	reverse := false
+	if transformers != "" && transformers[0]|0x20 == 'r' {
+		reverse = true
+		transformers = transformers[1:]
+	}
+
+	delimiters := transformers
+	if strings.Trim(delimiters, ".-+,/_=") != "" {
+		return "", spfPermError("invalid macro delimiter in %%{%s}", macro)
+	}
+	if delimiters == "" {
+		delimiters = "."
+	}
+
+	if keep > 0 || reverse || delimiters != "." {
+		parts := splitAnyOf(value, delimiters)
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+
+	// Uppercase macro letters are URL-escaped
+	if letter >= 'A' && letter <= 'Z' {
+		value = spfURLEscape(value)
+	}
+
+	return value, nil
+}
+
+// splitAnyOf splits s on any of the given delimiter characters, keeping empty
+// parts.
+func splitAnyOf(s, delimiters string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(delimiters, s[i]) >= 0 {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// spfURLEscape escapes every character outside the URI "unreserved" set.
+func spfURLEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// isValidSPFDomain checks that domain is a syntactically usable
+// fully-qualified domain name (RFC 7208 section 4.3).
+func isValidSPFDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatAddrs joins addresses for display, or explains there are none.
+func formatAddrs(addrs []string) string {
+	if len(addrs) == 0 {
+		return "no address"
+	}
+	return strings.Join(addrs, ", ")
+}
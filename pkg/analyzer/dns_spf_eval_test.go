@@ -0,0 +1,504 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// spfMockResolver lets tests control TXT, MX, host, PTR, CNAME and TLSA
+// lookups per name. Missing names answer NXDOMAIN; names in err answer with that error.
+// TLSA answers are authenticated for names in signed.
+type spfMockResolver struct {
+	txt    map[string][]string
+	mx     map[string][]*net.MX
+	hosts  map[string][]string
+	ptr    map[string][]string
+	tlsa   map[string][]*TLSA
+	ns     map[string][]*net.NS
+	cname  map[string]string
+	signed map[string]bool
+	err    map[string]error
+}
+
+func (m *spfMockResolver) lookup(name string) error {
+	if err, ok := m.err[name]; ok {
+		return err
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (m *spfMockResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if recs, ok := m.txt[name]; ok {
+		return recs, nil
+	}
+	return nil, m.lookup(name)
+}
+
+func (m *spfMockResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if recs, ok := m.mx[name]; ok {
+		return recs, nil
+	}
+	return nil, m.lookup(name)
+}
+
+func (m *spfMockResolver) LookupHost(_ context.Context, name string) ([]string, error) {
+	if recs, ok := m.hosts[name]; ok {
+		return recs, nil
+	}
+	return nil, m.lookup(name)
+}
+
+func (m *spfMockResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if recs, ok := m.ptr[addr]; ok {
+		return recs, nil
+	}
+	return nil, m.lookup(addr)
+}
+
+func (m *spfMockResolver) LookupNS(_ context.Context, name string) ([]*net.NS, error) {
+	if recs, ok := m.ns[name]; ok {
+		return recs, nil
+	}
+	return nil, m.lookup(name)
+}
+
+func (m *spfMockResolver) LookupCNAME(_ context.Context, name string) (string, error) {
+	if target, ok := m.cname[name]; ok {
+		return target, nil
+	}
+	return "", m.lookup(name)
+}
+
+func (m *spfMockResolver) LookupTLSA(_ context.Context, name string) ([]*TLSA, bool, error) {
+	if recs, ok := m.tlsa[name]; ok {
+		return recs, m.signed[name], nil
+	}
+	return nil, m.signed[name], m.lookup(name)
+}
+
+func TestParseSPFTerm(t *testing.T) {
+	tests := []struct {
+		token     string
+		wantName  string
+		wantValue string
+		wantCIDR4 int
+		wantCIDR6 int
+		wantMod   bool
+		wantErr   bool
+	}{
+		{token: "-all", wantName: "all", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "include:_spf.example.com", wantName: "include", wantValue: "_spf.example.com", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "a", wantName: "a", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "a/24", wantName: "a", wantCIDR4: 24, wantCIDR6: -1},
+		{token: "mx:mail.example.com/24//64", wantName: "mx", wantValue: "mail.example.com", wantCIDR4: 24, wantCIDR6: 64},
+		{token: "mx//64", wantName: "mx", wantCIDR4: -1, wantCIDR6: 64},
+		{token: "ip4:192.0.2.0/24", wantName: "ip4", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "ip6:2001:db8::/32", wantName: "ip6", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "exists:%{i}.spf.example.com", wantName: "exists", wantValue: "%{i}.spf.example.com", wantCIDR4: -1, wantCIDR6: -1},
+		{token: "redirect=_spf.example.com", wantName: "redirect", wantValue: "_spf.example.com", wantCIDR4: -1, wantCIDR6: -1, wantMod: true},
+		{token: "ip4:2001:db8::1", wantErr: true},
+		{token: "ip4:192.0.2.0/33", wantErr: true},
+		{token: "include", wantErr: true},
+		{token: "foo:bar", wantErr: true},
+		{token: "redirect=", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			term, err := parseSPFTerm(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSPFTerm(%q) error = %v, wantErr %v", tt.token, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if term.name != tt.wantName || term.value != tt.wantValue || term.modifier != tt.wantMod {
+				t.Errorf("got name=%q value=%q modifier=%v", term.name, term.value, term.modifier)
+			}
+			if term.cidr4 != tt.wantCIDR4 || term.cidr6 != tt.wantCIDR6 {
+				t.Errorf("got cidr4=%d cidr6=%d, want %d %d", term.cidr4, term.cidr6, tt.wantCIDR4, tt.wantCIDR6)
+			}
+		})
+	}
+}
+
+func TestExpandSPFMacros(t *testing.T) {
+	// Examples from RFC 7208 section 7.4
+	e := &spfEvaluator{
+		ip:     net.ParseIP("192.0.2.3").To4(),
+		sender: "strong-bad@email.example.com",
+		helo:   "mx.example.org",
+	}
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"%{s}", "strong-bad@email.example.com"},
+		{"%{o}", "email.example.com"},
+		{"%{d}", "email.example.com"},
+		{"%{d4}", "email.example.com"},
+		{"%{d3}", "email.example.com"},
+		{"%{d2}", "example.com"},
+		{"%{d1}", "com"},
+		{"%{dr}", "com.example.email"},
+		{"%{d2r}", "example.email"},
+		{"%{l}", "strong-bad"},
+		{"%{l-}", "strong.bad"},
+		{"%{lr}", "strong-bad"},
+		{"%{lr-}", "bad.strong"},
+		{"%{l1r-}", "strong"},
+		{"%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{"%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{"%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{"%{d2}.trusted-domains.example.net", "example.com.trusted-domains.example.net"},
+		{"%{h}", "mx.example.org"},
+		{"%%%_%-", "% %20"},
+		{"%{S}", "strong-bad%40email.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := e.expandMacros(tt.spec, "email.example.com", false)
+			if err != nil {
+				t.Fatalf("expandMacros(%q) error = %v", tt.spec, err)
+			}
+			if got != tt.want {
+				t.Errorf("expandMacros(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("IPv6", func(t *testing.T) {
+		e := &spfEvaluator{ip: net.ParseIP("2001:db8::cb01"), sender: "a@example.com"}
+		got, err := e.expandMacros("%{ir}.%{v}._spf.%{d2}", "email.example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	for _, spec := range []string{"%{t}", "%{x}", "%{d0}", "%", "%a", "%{d"} {
+		if _, err := e.expandMacros(spec, "email.example.com", false); err == nil {
+			t.Errorf("expandMacros(%q) expected an error", spec)
+		}
+	}
+}
+
+func TestEvaluateSPF(t *testing.T) {
+	tests := []struct {
+		name          string
+		domain        string
+		ip            string
+		resolver      *spfMockResolver
+		wantResult    model.SPFEvaluationResult
+		wantMechanism string
+		wantDomain    string
+		wantLookups   int
+		wantErrSubstr string
+		wantExpl      string
+	}{
+		{
+			name:   "ip4 match",
+			domain: "example.com",
+			ip:     "192.0.2.10",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "ip4:192.0.2.0/24",
+			wantDomain:    "example.com",
+		},
+		{
+			name:   "no match falls to softfail",
+			domain: "example.com",
+			ip:     "198.51.100.1",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 ip4:192.0.2.0/24 ~all"},
+			}},
+			wantResult:    model.SPFEvaluationResultSoftfail,
+			wantMechanism: "~all",
+		},
+		{
+			name:   "match inside include",
+			domain: "example.com",
+			ip:     "203.0.113.5",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com":    {"v=spf1 include:_spf.other.net include:_spf.esp.net -all"},
+				"_spf.other.net": {"v=spf1 ip4:192.0.2.0/24 -all"},
+				"_spf.esp.net":   {"v=spf1 include:_ips.esp.net ?all"},
+				"_ips.esp.net":   {"v=spf1 ip4:203.0.113.0/24 -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "ip4:203.0.113.0/24",
+			wantDomain:    "_ips.esp.net",
+			wantLookups:   3,
+		},
+		{
+			name:   "a and mx mechanisms",
+			domain: "example.com",
+			ip:     "192.0.2.25",
+			resolver: &spfMockResolver{
+				txt:   map[string][]string{"example.com": {"v=spf1 a mx -all"}},
+				hosts: map[string][]string{"example.com": {"198.51.100.1"}, "mail.example.com": {"192.0.2.25"}},
+				mx:    map[string][]*net.MX{"example.com": {{Host: "mail.example.com.", Pref: 10}}},
+			},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "mx",
+			wantLookups:   2,
+		},
+		{
+			name:   "a with prefix length",
+			domain: "example.com",
+			ip:     "192.0.2.200",
+			resolver: &spfMockResolver{
+				txt:   map[string][]string{"example.com": {"v=spf1 a/24 -all"}},
+				hosts: map[string][]string{"example.com": {"192.0.2.1"}},
+			},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "a/24",
+		},
+		{
+			name:   "exists with macro",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{
+				txt:   map[string][]string{"example.com": {"v=spf1 exists:%{ir}._spf.example.com -all"}},
+				hosts: map[string][]string{"3.2.0.192._spf.example.com": {"127.0.0.2"}},
+			},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "exists:%{ir}._spf.example.com",
+		},
+		{
+			name:   "ptr mechanism",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{
+				txt:   map[string][]string{"example.com": {"v=spf1 ptr -all"}},
+				ptr:   map[string][]string{"192.0.2.3": {"mail.example.com."}},
+				hosts: map[string][]string{"mail.example.com": {"192.0.2.3"}},
+			},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "ptr",
+		},
+		{
+			name:   "redirect",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com":      {"v=spf1 redirect=_spf.example.com"},
+				"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "ip4:192.0.2.0/24",
+			wantDomain:    "_spf.example.com",
+			wantLookups:   1,
+		},
+		{
+			name:   "redirect to domain without SPF",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 redirect=nospf.example.com"},
+			}},
+			wantResult:    model.SPFEvaluationResultPermerror,
+			wantErrSubstr: "no SPF record",
+		},
+		{
+			name:       "no record",
+			domain:     "example.com",
+			ip:         "192.0.2.3",
+			resolver:   &spfMockResolver{},
+			wantResult: model.SPFEvaluationResultNone,
+		},
+		{
+			name:   "no match and no all",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 ip4:198.51.100.0/24"},
+			}},
+			wantResult: model.SPFEvaluationResultNeutral,
+		},
+		{
+			name:   "multiple records",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 -all", "v=spf1 +all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPermerror,
+			wantErrSubstr: "multiple SPF records",
+		},
+		{
+			name:   "syntax error",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 ip4:192.0.2.0/24 include=_spf.example.com -all"},
+			}},
+			wantResult: model.SPFEvaluationResultPermerror,
+		},
+		{
+			name:   "too many void lookups",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com":   {"v=spf1 include:a.example.com include:b.example.com -all"},
+				"a.example.com": {"v=spf1 a:h1.example.com a:h2.example.com a:h3.example.com a:h4.example.com -all"},
+				"b.example.com": {"v=spf1 -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPermerror,
+			wantErrSubstr: "void",
+		},
+		{
+			name:   "include of a domain without SPF",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 include:esp.example -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPermerror,
+			wantErrSubstr: "no SPF record",
+		},
+		{
+			name:   "temporary DNS failure",
+			domain: "example.com",
+			ip:     "192.0.2.3",
+			resolver: &spfMockResolver{err: map[string]error{
+				"example.com": errors.New("server misbehaving"),
+			}},
+			wantResult: model.SPFEvaluationResultTemperror,
+		},
+		{
+			name:   "IPv6 sender",
+			domain: "example.com",
+			ip:     "2001:db8::25",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -all"},
+			}},
+			wantResult:    model.SPFEvaluationResultPass,
+			wantMechanism: "ip6:2001:db8::/32",
+		},
+		{
+			name:   "fail with explanation",
+			domain: "example.com",
+			ip:     "198.51.100.1",
+			resolver: &spfMockResolver{txt: map[string][]string{
+				"example.com":         {"v=spf1 ip4:192.0.2.0/24 -all exp=explain.example.com"},
+				"explain.example.com": {"%{i} is not allowed to send mail for %{d}"},
+			}},
+			wantResult:    model.SPFEvaluationResultFail,
+			wantMechanism: "-all",
+			wantExpl:      "198.51.100.1 is not allowed to send mail for example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDNSAnalyzerWithResolver(time.Second, tt.resolver)
+			eval := d.evaluateSPF(tt.domain, tt.ip, "bounce@"+tt.domain, "mail."+tt.domain)
+			if eval == nil {
+				t.Fatal("evaluateSPF() returned nil")
+			}
+
+			if eval.Result != tt.wantResult {
+				t.Errorf("result = %s (error: %v), want %s", eval.Result, eval.Error, tt.wantResult)
+			}
+			if tt.wantMechanism != "" && (eval.MatchedMechanism == nil || *eval.MatchedMechanism != tt.wantMechanism) {
+				t.Errorf("matched mechanism = %v, want %q", eval.MatchedMechanism, tt.wantMechanism)
+			}
+			if tt.wantDomain != "" && (eval.MatchedDomain == nil || *eval.MatchedDomain != tt.wantDomain) {
+				t.Errorf("matched domain = %v, want %q", eval.MatchedDomain, tt.wantDomain)
+			}
+			if tt.wantLookups != 0 && eval.DnsLookups != tt.wantLookups {
+				t.Errorf("lookups = %d, want %d", eval.DnsLookups, tt.wantLookups)
+			}
+			if tt.wantErrSubstr != "" && (eval.Error == nil || !strings.Contains(*eval.Error, tt.wantErrSubstr)) {
+				t.Errorf("error = %v, want it to contain %q", eval.Error, tt.wantErrSubstr)
+			}
+			if tt.wantExpl != "" && (eval.Explanation == nil || *eval.Explanation != tt.wantExpl) {
+				t.Errorf("explanation = %v, want %q", eval.Explanation, tt.wantExpl)
+			}
+		})
+	}
+}
+
+func TestEvaluateSPFLookupLimit(t *testing.T) {
+	txt := map[string][]string{
+		"example.com": {"v=spf1 include:i1.example.com include:i2.example.com include:i3.example.com include:i4.example.com include:i5.example.com include:i6.example.com -all"},
+	}
+	for _, name := range []string{"i1", "i2", "i3", "i4", "i5", "i6"} {
+		txt[name+".example.com"] = []string{"v=spf1 a:ok.example.com ?all"}
+	}
+	resolver := &spfMockResolver{txt: txt, hosts: map[string][]string{"ok.example.com": {"198.51.100.1"}}}
+
+	d := NewDNSAnalyzerWithResolver(time.Second, resolver)
+	eval := d.evaluateSPF("example.com", "192.0.2.1", "", "")
+
+	if eval.Result != model.SPFEvaluationResultPermerror {
+		t.Fatalf("result = %s, want permerror", eval.Result)
+	}
+	if eval.Error == nil || !strings.Contains(*eval.Error, "too many DNS lookups") {
+		t.Errorf("error = %v, want too many DNS lookups", eval.Error)
+	}
+	if eval.DnsLookups != spfMaxDNSLookups+1 {
+		t.Errorf("lookups = %d, want %d", eval.DnsLookups, spfMaxDNSLookups+1)
+	}
+	if eval.Trace == nil || (*eval.Trace)[len(*eval.Trace)-1].Result != model.SPFTraceStepResultError {
+		t.Error("expected the last trace step to be the one exceeding the limit")
+	}
+}
+
+func TestReconcileSPF(t *testing.T) {
+	a := NewAuthenticationAnalyzer("")
+	eval := &model.SPFEvaluation{
+		Result:           model.SPFEvaluationResultSoftfail,
+		Domain:           "example.com",
+		Ip:               "192.0.2.1",
+		MatchedMechanism: utils.PtrTo("~all"),
+	}
+
+	results := &model.AuthenticationResults{}
+	a.ReconcileSPF(results, eval)
+	if results.Spf == nil || results.Spf.Result != model.AuthResultResultSoftfail {
+		t.Fatalf("expected softfail SPF result, got %v", results.Spf)
+	}
+
+	upstream := &model.AuthenticationResults{Spf: &model.AuthResult{Result: model.AuthResultResultPass}}
+	a.ReconcileSPF(upstream, eval)
+	if upstream.Spf.Result != model.AuthResultResultPass {
+		t.Error("upstream SPF result must not be overwritten")
+	}
+}
@@ -0,0 +1,196 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// checkTLSRPTRecord looks up and validates the SMTP TLS Reporting record of
+// a domain (RFC 8460).
+func (d *DNSAnalyzer) checkTLSRPTRecord(domain string) *model.TLSRPTRecord {
+	result := &model.TLSRPTRecord{
+		Domain: domain,
+		Valid:  false,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+
+	txtRecords, err := d.resolver.LookupTXT(ctx, "_smtp._tls."+domain)
+	if err != nil {
+		if isDNSNotFound(err) {
+			result.Error = utils.PtrTo("No TLS-RPT record found")
+		} else {
+			result.Error = utils.PtrTo(fmt.Sprintf("Failed to lookup TLS-RPT record: %s", formatDNSError(err)))
+		}
+		return result
+	}
+
+	// Records not starting with the version are to be ignored
+	var records []string
+	for _, txt := range txtRecords {
+		if strings.HasPrefix(txt, "v=TLSRPTv1") {
+			records = append(records, txt)
+		}
+	}
+	if len(records) == 0 {
+		result.Error = utils.PtrTo("No TLS-RPT record found")
+		return result
+	}
+	result.Record = &records[0]
+	if len(records) > 1 {
+		result.Error = utils.PtrTo("Multiple TLS-RPT records found: senders will not send any report (RFC 8460 section 3)")
+		return result
+	}
+
+	rua, err := parseTLSRPTRecord(records[0])
+	if err != nil {
+		result.Error = utils.PtrTo(err.Error())
+		return result
+	}
+
+	var uris []model.TLSRPTReportURI
+	usable := 0
+	for _, uri := range strings.Split(rua, ",") {
+		if uri = strings.TrimSpace(uri); uri == "" {
+			continue
+		}
+		reportURI := checkTLSRPTReportURI(uri)
+		if reportURI.Valid {
+			usable++
+		}
+		uris = append(uris, reportURI)
+	}
+	result.Rua = &uris
+
+	if usable == 0 {
+		result.Error = utils.PtrTo("TLS-RPT record has no usable rua= destination")
+		return result
+	}
+
+	result.Valid = true
+	return result
+}
+
+// parseTLSRPTRecord checks the syntax of a TLS-RPT record and returns its
+// rua= value.
+func parseTLSRPTRecord(record string) (string, error) {
+	var rua string
+	hasRua := false
+
+	for i, field := range strings.Split(record, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return "", fmt.Errorf("Invalid TLS-RPT record field %q", field)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case i == 0:
+			if key != "v" || value != "TLSRPTv1" {
+				return "", fmt.Errorf("TLS-RPT record must start with v=TLSRPTv1")
+			}
+		case key == "rua":
+			rua, hasRua = value, true
+		}
+	}
+
+	if !hasRua || rua == "" {
+		return "", fmt.Errorf("TLS-RPT record has no rua= tag")
+	}
+	return rua, nil
+}
+
+// checkTLSRPTReportURI validates a single rua= destination: reports are
+// either mailed or POSTed over HTTPS.
+func checkTLSRPTReportURI(uri string) model.TLSRPTReportURI {
+	reportURI := model.TLSRPTReportURI{Uri: uri}
+	invalid := func(reason string) model.TLSRPTReportURI {
+		reportURI.Error = utils.PtrTo(reason)
+		return reportURI
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return invalid("not a valid URI")
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "mailto":
+		reportURI.Scheme = utils.PtrTo(model.TLSRPTReportURISchemeMailto)
+		addr, err := url.PathUnescape(u.Opaque)
+		if err != nil {
+			return invalid("malformed percent-encoding in address")
+		}
+		local, domain, ok := strings.Cut(addr, "@")
+		if !ok || local == "" || !isValidSPFDomain(strings.TrimSuffix(domain, ".")) {
+			return invalid(fmt.Sprintf("%q is not a valid email address", addr))
+		}
+	case "https":
+		reportURI.Scheme = utils.PtrTo(model.TLSRPTReportURISchemeHttps)
+		if u.Host == "" {
+			return invalid("https URI has no host")
+		}
+	default:
+		return invalid(fmt.Sprintf("unsupported %s: URI, reports are only sent to mailto: or https: URIs", u.Scheme))
+	}
+
+	reportURI.Valid = true
+	return reportURI
+}
+
+// flagMissingTLSRPT warns when a domain enforces transport security
+// without a TLS-RPT record: delivery failures caused by a broken setup
+// would then go unnoticed.
+func flagMissingTLSRPT(results *model.DNSResults) {
+	if results.TlsRptRecord == nil || results.TlsRptRecord.Valid {
+		return
+	}
+
+	var warnings []string
+	if results.MtaStsRecord != nil && results.MtaStsRecord.Record != nil {
+		warnings = append(warnings, "MTA-STS is published but senders cannot report TLS failures without a valid TLS-RPT record")
+	}
+	if results.DaneRecords != nil {
+		for _, dane := range *results.DaneRecords {
+			if dane.Records != nil && len(*dane.Records) > 0 {
+				warnings = append(warnings, "TLSA records are published but senders cannot report DANE failures without a valid TLS-RPT record")
+				break
+			}
+		}
+	}
+
+	if len(warnings) > 0 {
+		results.TlsRptRecord.Warnings = &warnings
+	}
+}
@@ -0,0 +1,203 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+func TestCheckTLSRPTRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		txt       []string
+		err       error
+		wantValid bool
+		wantRua   int
+		wantErr   string
+	}{
+		{
+			name:      "mailto and https destinations",
+			txt:       []string{"v=TLSRPTv1; rua=mailto:tlsrpt@example.com,https://reports.example.net/tlsrpt"},
+			wantValid: true,
+			wantRua:   2,
+		},
+		{
+			name:      "other TXT records are ignored",
+			txt:       []string{"some-verification=abc", "v=TLSRPTv1;rua=mailto:tlsrpt@example.com;"},
+			wantValid: true,
+			wantRua:   1,
+		},
+		{
+			name:      "one usable destination is enough",
+			txt:       []string{"v=TLSRPTv1; rua=ftp://example.com/x,mailto:tlsrpt@example.com"},
+			wantValid: true,
+			wantRua:   2,
+		},
+		{
+			name:    "no record",
+			wantErr: "No TLS-RPT record found",
+		},
+		{
+			name:    "lookup failure",
+			err:     errors.New("server failure"),
+			wantErr: "Failed to lookup TLS-RPT record",
+		},
+		{
+			name:    "multiple records",
+			txt:     []string{"v=TLSRPTv1; rua=mailto:a@example.com", "v=TLSRPTv1; rua=mailto:b@example.com"},
+			wantErr: "Multiple TLS-RPT records",
+		},
+		{
+			name:    "version not first",
+			txt:     []string{"v=TLSRPTv1 rua=mailto:a@example.com"},
+			wantErr: "must start with v=TLSRPTv1",
+		},
+		{
+			name:    "missing rua",
+			txt:     []string{"v=TLSRPTv1;"},
+			wantErr: "no rua= tag",
+		},
+		{
+			name:    "no usable destination",
+			txt:     []string{"v=TLSRPTv1; rua=mailto:not-an-address"},
+			wantRua: 1,
+			wantErr: "no usable rua= destination",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txt := map[string][]string{}
+			if tt.txt != nil {
+				txt["_smtp._tls.example.com"] = tt.txt
+			}
+			errMap := map[string]error{}
+			if tt.err != nil {
+				errMap["_smtp._tls.example.com"] = tt.err
+			}
+
+			result := newMockAnalyzer(txt, errMap).checkTLSRPTRecord("example.com")
+			if result.Valid != tt.wantValid {
+				t.Errorf("Valid = %t, want %t (error: %v)", result.Valid, tt.wantValid, result.Error)
+			}
+			if tt.wantErr != "" && (result.Error == nil || !strings.Contains(*result.Error, tt.wantErr)) {
+				t.Errorf("Error = %v, want to contain %q", result.Error, tt.wantErr)
+			}
+			gotRua := 0
+			if result.Rua != nil {
+				gotRua = len(*result.Rua)
+			}
+			if gotRua != tt.wantRua {
+				t.Errorf("len(Rua) = %d, want %d", gotRua, tt.wantRua)
+			}
+		})
+	}
+}
+
+func TestCheckTLSRPTReportURI(t *testing.T) {
+	tests := []struct {
+		uri        string
+		wantValid  bool
+		wantScheme model.TLSRPTReportURIScheme
+	}{
+		{"mailto:tlsrpt@example.com", true, model.TLSRPTReportURISchemeMailto},
+		{"mailto:tls%2Brpt@example.com", true, model.TLSRPTReportURISchemeMailto},
+		{"https://reports.example.com/v1/tlsrpt", true, model.TLSRPTReportURISchemeHttps},
+		{"mailto:example.com", false, model.TLSRPTReportURISchemeMailto},
+		{"https:///tlsrpt", false, model.TLSRPTReportURISchemeHttps},
+		{"http://reports.example.com/tlsrpt", false, ""},
+		{"tlsrpt@example.com", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got := checkTLSRPTReportURI(tt.uri)
+			if got.Valid != tt.wantValid {
+				t.Errorf("Valid = %t, want %t (error: %v)", got.Valid, tt.wantValid, got.Error)
+			}
+			var scheme model.TLSRPTReportURIScheme
+			if got.Scheme != nil {
+				scheme = *got.Scheme
+			}
+			if scheme != tt.wantScheme {
+				t.Errorf("Scheme = %q, want %q", scheme, tt.wantScheme)
+			}
+		})
+	}
+}
+
+func TestFlagMissingTLSRPT(t *testing.T) {
+	results := &model.DNSResults{
+		MtaStsRecord: &model.MTASTSRecord{Domain: "example.com", Record: utils.PtrTo("v=STSv1; id=1;")},
+		TlsRptRecord: &model.TLSRPTRecord{Domain: "example.com", Error: utils.PtrTo("No TLS-RPT record found")},
+	}
+	flagMissingTLSRPT(results)
+	if results.TlsRptRecord.Warnings == nil || !strings.Contains((*results.TlsRptRecord.Warnings)[0], "MTA-STS") {
+		t.Errorf("Warnings = %v, want MTA-STS to be flagged", results.TlsRptRecord.Warnings)
+	}
+
+	results.MtaStsRecord = &model.MTASTSRecord{Domain: "example.com", Error: utils.PtrTo("No MTA-STS record found")}
+	results.TlsRptRecord.Warnings = nil
+	flagMissingTLSRPT(results)
+	if results.TlsRptRecord.Warnings != nil {
+		t.Errorf("Warnings = %v, want none without MTA-STS", *results.TlsRptRecord.Warnings)
+	}
+}
+
+func TestCalculateDomainOnlyScoreTLSRPT(t *testing.T) {
+	analyzer := NewDNSAnalyzer(0)
+	results := &model.DNSResults{
+		FromDomain: "example.com",
+		FromMxRecords: &[]model.MXRecord{
+			{Host: "mx.example.com", Priority: 10, Valid: true},
+		},
+		SpfRecords: &[]model.SPFRecord{{
+			Domain:       utils.PtrTo("example.com"),
+			Record:       utils.PtrTo("v=spf1 ip4:192.0.2.1 -all"),
+			Valid:        true,
+			AllQualifier: utils.PtrTo(model.SPFRecordAllQualifier("-")),
+		}},
+		DmarcRecord: &model.DMARCRecord{
+			Valid:        true,
+			Policy:       utils.PtrTo(model.DMARCRecordPolicyReject),
+			SpfAlignment: utils.PtrTo(model.DMARCRecordSpfAlignmentStrict),
+		},
+	}
+
+	without, _ := analyzer.CalculateDomainOnlyScore(results)
+	results.TlsRptRecord = &model.TLSRPTRecord{Domain: "example.com", Valid: true}
+	with, _ := analyzer.CalculateDomainOnlyScore(results)
+
+	// TLS-RPT is part of the 100 points: without it, a domain that gets
+	// everything else right doesn't reach them
+	if without >= 100 {
+		t.Errorf("score without TLS-RPT = %d, want less than 100", without)
+	}
+	if want := min(without+5, 100); with != want {
+		t.Errorf("score with TLS-RPT = %d, want %d", with, want)
+	}
+}
@@ -0,0 +1,584 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.happydns.org/happyDeliver/internal/config"
+)
+
+const (
+	// dnsTypeTLSA is the TLSA resource record type (RFC 6698), unknown to
+	// dnsmessage.
+	dnsTypeTLSA dnsmessage.Type = 52
+
+	// dnsUDPSize is the EDNS0 payload size advertised in queries, small
+	// enough to avoid IP fragmentation.
+	dnsUDPSize = 1232
+
+	// dnsDefaultTimeout bounds a single exchange when the context has no
+	// deadline.
+	dnsDefaultTimeout = 5 * time.Second
+
+	// dnsMaxIdleConns bounds the idle connections kept to each TCP or
+	// DNS-over-TLS nameserver.
+	dnsMaxIdleConns = 4
+
+	// dnsIdleTimeout is how long an idle connection is kept, below the
+	// timeouts nameservers commonly apply (RFC 7766 section 6.2.3).
+	dnsIdleTimeout = 10 * time.Second
+)
+
+// DNSUpstream is a nameserver queries are sent to.
+type DNSUpstream struct {
+	// Network is "udp" (retrying over TCP when the answer is truncated),
+	// "tcp", "tls" (DNS-over-TLS, RFC 7858) or "https" (DNS-over-HTTPS,
+	// RFC 8484).
+	Network string
+
+	// Address is the host:port of the nameserver, or the URL of the
+	// DNS-over-HTTPS endpoint.
+	Address string
+
+	// tlsConfig overrides the TLS settings of DNS-over-TLS and
+	// DNS-over-HTTPS connections.
+	tlsConfig *tls.Config
+}
+
+func (u DNSUpstream) String() string {
+	if u.Network == "https" {
+		return u.Address
+	}
+	return u.Network + "://" + u.Address
+}
+
+// ParseDNSUpstream parses a nameserver specification, as described by
+// config.ParseNameserver.
+func ParseDNSUpstream(spec string) (DNSUpstream, error) {
+	network, address, err := config.ParseNameserver(spec)
+	if err != nil {
+		return DNSUpstream{}, err
+	}
+	return DNSUpstream{Network: network, Address: address}, nil
+}
+
+// ParseDNSUpstreams parses a list of nameserver specifications.
+func ParseDNSUpstreams(specs []string) ([]DNSUpstream, error) {
+	var upstreams []DNSUpstream
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		upstream, err := ParseDNSUpstream(spec)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+// systemNameservers returns the nameservers of /etc/resolv.conf, falling
+// back to the local host like the Go resolver.
+func systemNameservers() []DNSUpstream {
+	var servers []DNSUpstream
+
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+				servers = append(servers, DNSUpstream{Network: "udp", Address: net.JoinHostPort(fields[1], "53")})
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		servers = []DNSUpstream{
+			{Network: "udp", Address: "127.0.0.1:53"},
+			{Network: "udp", Address: "[::1]:53"},
+		}
+	}
+	return servers
+}
+
+// exchangeDNS sends a query to the first nameserver that answers, retrying
+// UDP queries over TCP when the answer is truncated. The AD bit is set so that a
+// validating upstream reports whether the answer is authenticated (RFC 6840
+// section 5.7). With dnssec, the signatures are requested instead (DO bit)
+// and upstream validation is disabled (CD bit) so that the caller can
+// validate the answer itself, bogus data included.
+func exchangeDNS(ctx context.Context, upstreams []DNSUpstream, name string, qtype dnsmessage.Type, dnssec bool) (*dnsmessage.Message, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, &net.DNSError{Err: "invalid domain name", Name: name}
+	}
+
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, dnssec); err != nil {
+		return nil, err
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Uint32()),
+			RecursionDesired: true,
+			AuthenticData:    !dnssec,
+			CheckingDisabled: dnssec,
+		},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{}},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := errors.New("no nameserver configured")
+	for _, upstream := range upstreams {
+		var resp *dnsmessage.Message
+		if upstream.Network == "https" {
+			resp, err = exchangeDNSOverHTTPS(ctx, upstream, packed, &query)
+		} else {
+			resp, err = exchangeDNSWith(ctx, upstream.Network, upstream, packed, &query)
+			if err == nil && resp.Truncated && upstream.Network == "udp" {
+				resp, err = exchangeDNSWith(ctx, "tcp", upstream, packed, &query)
+			}
+		}
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, &net.DNSError{Err: lastErr.Error(), Name: name, IsTimeout: ctx.Err() != nil, IsTemporary: true}
+}
+
+// dnsTransportKey identifies the connections to a nameserver.
+type dnsTransportKey struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+}
+
+// dnsTransports holds the dnsTransport of each nameserver queried so far.
+var dnsTransports sync.Map
+
+// dnsTransport keeps the connections to a nameserver across queries, so
+// that TCP and DNS-over-TLS queries don't pay for a new handshake each.
+type dnsTransport struct {
+	mu     sync.Mutex
+	idle   []idleDNSConn
+	client *http.Client // DNS-over-HTTPS client, created on first use
+}
+
+type idleDNSConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// dnsTransportFor returns the transport of upstream over network.
+func dnsTransportFor(network string, upstream DNSUpstream) *dnsTransport {
+	key := dnsTransportKey{network: network, address: upstream.Address, tlsConfig: upstream.tlsConfig}
+	if t, ok := dnsTransports.Load(key); ok {
+		return t.(*dnsTransport)
+	}
+	t, _ := dnsTransports.LoadOrStore(key, &dnsTransport{})
+	return t.(*dnsTransport)
+}
+
+// get returns an idle connection, or nil when there is none.
+func (t *dnsTransport) get() net.Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.idle) > 0 {
+		idle := t.idle[len(t.idle)-1]
+		t.idle = t.idle[:len(t.idle)-1]
+		if time.Since(idle.since) < dnsIdleTimeout {
+			return idle.conn
+		}
+		idle.conn.Close()
+	}
+	return nil
+}
+
+// put keeps conn for a later query, or closes it when enough are idle.
+func (t *dnsTransport) put(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.idle) >= dnsMaxIdleConns {
+		conn.Close()
+		return
+	}
+	t.idle = append(t.idle, idleDNSConn{conn: conn, since: time.Now()})
+}
+
+// httpClient returns the DNS-over-HTTPS client of the transport.
+func (t *dnsTransport) httpClient(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig == nil {
+		return http.DefaultClient
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		t.client = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   dnsIdleTimeout,
+		}}
+	}
+	return t.client
+}
+
+// exchangeDNSWith performs a single exchange with a nameserver over
+// network: "udp", "tcp" or "tls". TCP and TLS connections are reused.
+func exchangeDNSWith(ctx context.Context, network string, upstream DNSUpstream, packed []byte, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dnsDefaultTimeout)
+		defer cancel()
+	}
+
+	if network == "udp" {
+		return exchangeDNSOverUDP(ctx, upstream, packed, query)
+	}
+
+	transport := dnsTransportFor(network, upstream)
+	if conn := transport.get(); conn != nil {
+		resp, err := exchangeDNSOverStream(ctx, conn, upstream, packed, query)
+		if err == nil {
+			transport.put(conn)
+			return resp, nil
+		}
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		// The nameserver may have closed the idle connection: retry over a
+		// new one
+	}
+
+	var conn net.Conn
+	var err error
+	if network == "tls" {
+		d := tls.Dialer{Config: upstream.tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", upstream.Address)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, upstream.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := exchangeDNSOverStream(ctx, conn, upstream, packed, query)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	transport.put(conn)
+	return resp, nil
+}
+
+// exchangeDNSOverStream sends a length-prefixed query over a TCP or TLS
+// connection and reads its answer.
+func exchangeDNSOverStream(ctx context.Context, conn net.Conn, upstream DNSUpstream, packed []byte, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+	if _, err := conn.Write(append(msg, packed...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, raw); err != nil {
+		return nil, err
+	}
+
+	return unpackDNSResponse(raw, upstream, query)
+}
+
+// exchangeDNSOverUDP sends a query in a datagram and waits for its answer.
+// Datagrams not answering the query, such as late answers to an earlier
+// query or spoofed ones, are dropped until the deadline.
+func exchangeDNSOverUDP(ctx context.Context, upstream DNSUpstream, packed []byte, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", upstream.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err := unpackDNSResponse(buf[:n], upstream, query); err == nil {
+			return resp, nil
+		}
+	}
+}
+
+// exchangeDNSOverHTTPS performs a single DNS-over-HTTPS exchange (RFC 8484).
+func exchangeDNSOverHTTPS(ctx context.Context, upstream DNSUpstream, packed []byte, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dnsDefaultTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream.Address, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := dnsTransportFor("https", upstream).httpClient(upstream.tlsConfig).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered HTTP %d", upstream, resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+
+	return unpackDNSResponse(raw, upstream, query)
+}
+
+// unpackDNSResponse decodes an answer and checks it matches the query.
+func unpackDNSResponse(raw []byte, upstream DNSUpstream, query *dnsmessage.Message) (*dnsmessage.Message, error) {
+	var resp dnsmessage.Message
+	if err := resp.Unpack(raw); err != nil {
+		return nil, fmt.Errorf("malformed DNS response from %s: %w", upstream, err)
+	}
+	if resp.ID != query.ID || !resp.Response || len(resp.Questions) != 1 ||
+		!strings.EqualFold(resp.Questions[0].Name.String(), query.Questions[0].Name.String()) ||
+		resp.Questions[0].Type != query.Questions[0].Type {
+		return nil, fmt.Errorf("mismatched DNS response from %s", upstream)
+	}
+
+	return &resp, nil
+}
+
+// dnsResponseError converts the response code of an answer into the error
+// net.Resolver would have returned.
+func dnsResponseError(resp *dnsmessage.Message, name string) error {
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+		return nil
+	case dnsmessage.RCodeNameError:
+		return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	default:
+		return &net.DNSError{Err: "server answered " + strings.TrimPrefix(resp.RCode.String(), "RCode"), Name: name}
+	}
+}
+
+// lookupDNSRecords queries the records of qtype at name, following the
+// CNAME chain of the answer. It returns the response along with the
+// records, or a not-found error when there are none.
+func lookupDNSRecords(ctx context.Context, upstreams []DNSUpstream, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, *dnsmessage.Message, error) {
+	resp, err := exchangeDNS(ctx, upstreams, name, qtype, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	reportResponseTTL(ctx, resp)
+	if err := dnsResponseError(resp, name); err != nil {
+		return nil, resp, err
+	}
+
+	records, _ := answerRecords(resp.Answers, name, qtype)
+	if len(records) == 0 {
+		return nil, resp, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, resp, nil
+}
+
+// answerRecords returns the records of qtype found at the end of the CNAME
+// chain starting at name, and the name the chain ends at.
+func answerRecords(answers []dnsmessage.Resource, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, string) {
+	target := fqdn(name)
+	for range 8 {
+		var records []dnsmessage.Resource
+		var cname string
+		for _, rr := range answers {
+			if fqdn(rr.Header.Name.String()) != target {
+				continue
+			}
+			if rr.Header.Type == qtype {
+				records = append(records, rr)
+			} else if body, ok := rr.Body.(*dnsmessage.CNAMEResource); ok {
+				cname = fqdn(body.CNAME.String())
+			}
+		}
+		if records != nil || cname == "" {
+			return records, target
+		}
+		target = cname
+	}
+	return nil, target
+}
+
+// mxRecords converts MX records as net.Resolver returns them, sorted by
+// preference.
+func mxRecords(records []dnsmessage.Resource) []*net.MX {
+	var mxs []*net.MX
+	for _, rr := range records {
+		if mx, ok := rr.Body.(*dnsmessage.MXResource); ok {
+			mxs = append(mxs, &net.MX{Host: mx.MX.String(), Pref: mx.Pref})
+		}
+	}
+	slices.SortStableFunc(mxs, func(a, b *net.MX) int { return int(a.Pref) - int(b.Pref) })
+	return mxs
+}
+
+// txtRecords converts TXT records, joining their character strings.
+func txtRecords(records []dnsmessage.Resource) []string {
+	var txts []string
+	for _, rr := range records {
+		if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+			txts = append(txts, strings.Join(txt.TXT, ""))
+		}
+	}
+	return txts
+}
+
+// ptrRecords converts PTR records.
+func ptrRecords(records []dnsmessage.Resource) []string {
+	var names []string
+	for _, rr := range records {
+		if ptr, ok := rr.Body.(*dnsmessage.PTRResource); ok {
+			names = append(names, ptr.PTR.String())
+		}
+	}
+	return names
+}
+
+// nsRecords converts NS records.
+func nsRecords(records []dnsmessage.Resource) []*net.NS {
+	var nss []*net.NS
+	for _, rr := range records {
+		if ns, ok := rr.Body.(*dnsmessage.NSResource); ok {
+			nss = append(nss, &net.NS{Host: ns.NS.String()})
+		}
+	}
+	return nss
+}
+
+// cnameRecord returns the target of the first CNAME record.
+func cnameRecord(records []dnsmessage.Resource) string {
+	for _, rr := range records {
+		if cname, ok := rr.Body.(*dnsmessage.CNAMEResource); ok {
+			return cname.CNAME.String()
+		}
+	}
+	return ""
+}
+
+// addressRecords converts A and AAAA records.
+func addressRecords(records []dnsmessage.Resource) []string {
+	var addrs []string
+	for _, rr := range records {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return addrs
+}
+
+// tlsaRecords converts TLSA records.
+func tlsaRecords(records []dnsmessage.Resource, name string) ([]*TLSA, error) {
+	var tlsas []*TLSA
+	for _, rr := range records {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		tlsa, err := parseTLSA(body.Data)
+		if err != nil {
+			return nil, &net.DNSError{Err: err.Error(), Name: name}
+		}
+		tlsas = append(tlsas, tlsa)
+	}
+	return tlsas, nil
+}
+
+// keepHostLookupError picks the error to return for a host whose A and
+// AAAA lookups both failed: a failure rather than a not-found answer.
+func keepHostLookupError(current, err error) error {
+	if current == nil || (isDNSNotFound(current) && !isDNSNotFound(err)) {
+		return err
+	}
+	return current
+}
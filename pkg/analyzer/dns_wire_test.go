@@ -0,0 +1,404 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSHandler builds the answer to a query; the server fills in the ID,
+// the question and the response flag. tcp tells how the query arrived.
+type testDNSHandler func(q dnsmessage.Question, tcp bool) dnsmessage.Message
+
+// startTestDNSServer runs a local nameserver answering over UDP and TCP on
+// the same port and returns its address.
+func startTestDNSServer(t *testing.T, handler testDNSHandler) string {
+	t.Helper()
+
+	var (
+		udp *net.UDPConn
+		tcp net.Listener
+		err error
+	)
+	for range 10 {
+		udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen udp: %v", err)
+		}
+		tcp, err = net.Listen("tcp", udp.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		udp.Close()
+	}
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if packed := testDNSAnswer(t, handler, buf[:n], false); packed != nil {
+				udp.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	go serveTestDNSStream(t, tcp, handler)
+
+	return udp.LocalAddr().String()
+}
+
+// testDNSAnswer answers a packed query with handler.
+func testDNSAnswer(t *testing.T, handler testDNSHandler, raw []byte, stream bool) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(raw); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+	resp := handler(query.Questions[0], stream)
+	resp.ID = query.ID
+	resp.Response = true
+	resp.RecursionDesired = query.RecursionDesired
+	resp.Questions = query.Questions
+	packed, err := resp.Pack()
+	if err != nil {
+		t.Errorf("pack response: %v", err)
+		return nil
+	}
+	return packed
+}
+
+// serveTestDNSStream answers length-prefixed queries (TCP or TLS) until the
+// listener is closed.
+func serveTestDNSStream(t *testing.T, l net.Listener, handler testDNSHandler) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, raw); err != nil {
+					return
+				}
+				packed := testDNSAnswer(t, handler, raw, true)
+				if packed == nil {
+					return
+				}
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...))
+			}
+		}()
+	}
+}
+
+func tlsaResource(t *testing.T, name string, rdata []byte) dnsmessage.Resource {
+	t.Helper()
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: dnsTypeTLSA, Class: dnsmessage.ClassINET, TTL: 300},
+		Body:   &dnsmessage.UnknownResource{Type: dnsTypeTLSA, Data: rdata},
+	}
+}
+
+func TestStandardDNSResolverLookupTLSA(t *testing.T) {
+	digest := bytes.Repeat([]byte{0xab}, 32)
+
+	server := startTestDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		switch q.Name.String() {
+		case "_25._tcp.signed.example.com.":
+			return dnsmessage.Message{
+				Header:  dnsmessage.Header{AuthenticData: true},
+				Answers: []dnsmessage.Resource{tlsaResource(t, q.Name.String(), append([]byte{3, 1, 1}, digest...))},
+			}
+		case "_25._tcp.unsigned.example.com.":
+			return dnsmessage.Message{
+				Answers: []dnsmessage.Resource{tlsaResource(t, q.Name.String(), append([]byte{2, 0, 1}, digest...))},
+			}
+		case "_25._tcp.big.example.com.":
+			if !tcp {
+				return dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}}
+			}
+			return dnsmessage.Message{
+				Answers: []dnsmessage.Resource{tlsaResource(t, q.Name.String(), append([]byte{3, 1, 1}, digest...))},
+			}
+		case "_25._tcp.denied.example.com.":
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError, AuthenticData: true}}
+		}
+		return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+	})
+
+	resolver := &StandardDNSResolver{upstreams: []DNSUpstream{{Network: "udp", Address: server}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	records, authenticated, err := resolver.LookupTLSA(ctx, "_25._tcp.signed.example.com")
+	if err != nil || !authenticated || len(records) != 1 {
+		t.Fatalf("signed: records=%v authenticated=%t err=%v", records, authenticated, err)
+	}
+	if r := records[0]; r.Usage != 3 || r.Selector != 1 || r.MatchingType != 1 || !bytes.Equal(r.Data, digest) {
+		t.Errorf("signed: record = %+v", r)
+	}
+
+	records, authenticated, err = resolver.LookupTLSA(ctx, "_25._tcp.unsigned.example.com")
+	if err != nil || authenticated || len(records) != 1 || records[0].Usage != 2 {
+		t.Errorf("unsigned: records=%v authenticated=%t err=%v", records, authenticated, err)
+	}
+
+	records, _, err = resolver.LookupTLSA(ctx, "_25._tcp.big.example.com")
+	if err != nil || len(records) != 1 {
+		t.Errorf("truncated: records=%v err=%v, want a TCP retry", records, err)
+	}
+
+	_, authenticated, err = resolver.LookupTLSA(ctx, "_25._tcp.denied.example.com")
+	if !isDNSNotFound(err) || !authenticated {
+		t.Errorf("denied: authenticated=%t err=%v, want an authenticated not-found", authenticated, err)
+	}
+
+	_, _, err = resolver.LookupTLSA(ctx, "_25._tcp.broken.example.com")
+	if err == nil || isDNSNotFound(err) {
+		t.Errorf("servfail: err=%v, want a lookup failure", err)
+	}
+}
+
+func TestParseDNSUpstream(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DNSUpstream
+		wantErr bool
+	}{
+		{spec: "192.0.2.53", want: DNSUpstream{Network: "udp", Address: "192.0.2.53:53"}},
+		{spec: "192.0.2.53:5353", want: DNSUpstream{Network: "udp", Address: "192.0.2.53:5353"}},
+		{spec: "[2001:db8::53]", want: DNSUpstream{Network: "udp", Address: "[2001:db8::53]:53"}},
+		{spec: "tcp://192.0.2.53", want: DNSUpstream{Network: "tcp", Address: "192.0.2.53:53"}},
+		{spec: "tls://dns.example.net", want: DNSUpstream{Network: "tls", Address: "dns.example.net:853"}},
+		{spec: "tls://192.0.2.53:8853", want: DNSUpstream{Network: "tls", Address: "192.0.2.53:8853"}},
+		{spec: "https://dns.example.net/dns-query", want: DNSUpstream{Network: "https", Address: "https://dns.example.net/dns-query"}},
+		{spec: "quic://dns.example.net", wantErr: true},
+		{spec: "tcp://192.0.2.53/path", wantErr: true},
+		{spec: "udp://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseDNSUpstream(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseDNSUpstream = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseDNSUpstream = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// upstreamTestHandler serves a few records for the configured nameserver
+// tests.
+func upstreamTestHandler(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+	header := func(name string, rtype dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: rtype, Class: dnsmessage.ClassINET, TTL: 300}
+	}
+
+	switch {
+	case q.Name.String() == "example.com." && q.Type == dnsmessage.TypeMX:
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("example.com.", dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 20, MX: dnsmessage.MustNewName("mx2.example.com.")}},
+			{Header: header("example.com.", dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx1.example.com.")}},
+		}}
+	case q.Name.String() == "example.com." && q.Type == dnsmessage.TypeTXT:
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("example.com.", dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}}},
+		}}
+	case q.Name.String() == "www.example.com.":
+		// CNAME to the MX host, which only has an IPv4 address
+		msg := dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("www.example.com.", dnsmessage.TypeCNAME), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("mx1.example.com.")}},
+		}}
+		if q.Type == dnsmessage.TypeA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header("mx1.example.com.", dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 25}}})
+		}
+		return msg
+	case q.Name.String() == "25.2.0.192.in-addr.arpa." && q.Type == dnsmessage.TypePTR:
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("25.2.0.192.in-addr.arpa.", dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("mx1.example.com.")}},
+		}}
+	}
+	return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}}
+}
+
+func TestStandardDNSResolverUpstreams(t *testing.T) {
+	server := startTestDNSServer(t, upstreamTestHandler)
+
+	// DNS-over-HTTPS endpoint, whose certificate also serves DNS-over-TLS
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(testDNSAnswer(t, upstreamTestHandler, raw, true))
+	}))
+	defer doh.Close()
+	clientTLS := doh.Client().Transport.(*http.Transport).TLSClientConfig
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", doh.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := &countingListener{Listener: listener}
+	defer dot.Close()
+	go serveTestDNSStream(t, dot, upstreamTestHandler)
+
+	upstreams := map[string]DNSUpstream{
+		"udp":   {Network: "udp", Address: server},
+		"tcp":   {Network: "tcp", Address: server},
+		"tls":   {Network: "tls", Address: dot.Addr().String(), tlsConfig: clientTLS},
+		"https": {Network: "https", Address: doh.URL + "/dns-query", tlsConfig: clientTLS},
+	}
+
+	for network, upstream := range upstreams {
+		t.Run(network, func(t *testing.T) {
+			resolver := NewStandardDNSResolverWithUpstreams([]DNSUpstream{upstream})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			mxs, err := resolver.LookupMX(ctx, "example.com")
+			if err != nil || len(mxs) != 2 || mxs[0].Host != "mx1.example.com." || mxs[1].Pref != 20 {
+				t.Errorf("LookupMX = %v, %v", mxs, err)
+			}
+
+			txts, err := resolver.LookupTXT(ctx, "example.com")
+			if err != nil || len(txts) != 1 || txts[0] != "v=spf1 -all" {
+				t.Errorf("LookupTXT = %q, %v", txts, err)
+			}
+
+			addrs, err := resolver.LookupHost(ctx, "www.example.com")
+			if err != nil || len(addrs) != 1 || addrs[0] != "192.0.2.25" {
+				t.Errorf("LookupHost = %v, %v", addrs, err)
+			}
+
+			names, err := resolver.LookupAddr(ctx, "192.0.2.25")
+			if err != nil || len(names) != 1 || names[0] != "mx1.example.com." {
+				t.Errorf("LookupAddr = %v, %v", names, err)
+			}
+
+			if _, err := resolver.LookupTXT(ctx, "missing.example.com"); !isDNSNotFound(err) {
+				t.Errorf("LookupTXT(missing) err = %v, want not found", err)
+			}
+		})
+	}
+
+	// The DNS-over-TLS queries, made one after the other, share a connection
+	if n := dot.accepted.Load(); n != 1 {
+		t.Errorf("%d DNS-over-TLS connections, want 1", n)
+	}
+}
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestExchangeDNSOverUDPDropsMismatched(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 65535)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packed := testDNSAnswer(t, upstreamTestHandler, buf[:n], false)
+
+		// A datagram with another ID and a garbage one precede the answer
+		spoofed := bytes.Clone(packed)
+		spoofed[0] ^= 0xff
+		conn.WriteTo(spoofed, addr)
+		conn.WriteTo([]byte("garbage"), addr)
+		conn.WriteTo(packed, addr)
+	}()
+
+	resolver := NewStandardDNSResolverWithUpstreams([]DNSUpstream{{Network: "udp", Address: conn.LocalAddr().String()}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if txts, err := resolver.LookupTXT(ctx, "example.com"); err != nil || len(txts) != 1 || txts[0] != "v=spf1 -all" {
+		t.Errorf("LookupTXT = %q, %v", txts, err)
+	}
+}
+
+func TestStandardDNSResolverFailover(t *testing.T) {
+	server := startTestDNSServer(t, upstreamTestHandler)
+
+	// Nothing listens on the discard port, the second nameserver answers
+	resolver := NewStandardDNSResolverWithUpstreams([]DNSUpstream{
+		{Network: "tcp", Address: "127.0.0.1:9"},
+		{Network: "udp", Address: server},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if txts, err := resolver.LookupTXT(ctx, "example.com"); err != nil || len(txts) != 1 {
+		t.Errorf("LookupTXT = %q, %v", txts, err)
+	}
+}
@@ -22,6 +22,7 @@
 package analyzer
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/mail"
@@ -37,11 +38,30 @@ import (
 )
 
 // HeaderAnalyzer analyzes email header quality and structure
-type HeaderAnalyzer struct{}
+type HeaderAnalyzer struct {
+	Timeout  time.Duration
+	resolver DNSResolver // reverse lookups of the received chain
+}
 
 // NewHeaderAnalyzer creates a new header analyzer
 func NewHeaderAnalyzer() *HeaderAnalyzer {
-	return &HeaderAnalyzer{}
+	return NewHeaderAnalyzerWithResolver(0, nil)
+}
+
+// NewHeaderAnalyzerWithResolver creates a new header analyzer with a custom
+// resolver, used for the reverse lookups of the received chain.
+// If resolver is nil, a StandardDNSResolver is used.
+func NewHeaderAnalyzerWithResolver(timeout time.Duration, resolver DNSResolver) *HeaderAnalyzer {
+	if timeout == 0 {
+		timeout = 10 * time.Second // Default timeout
+	}
+	if resolver == nil {
+		resolver = NewStandardDNSResolver()
+	}
+	return &HeaderAnalyzer{
+		Timeout:  timeout,
+		resolver: resolver,
+	}
 }
 
 // CalculateHeaderScore evaluates email structural quality from header analysis
@@ -718,7 +738,10 @@ func (h *HeaderAnalyzer) parseReceivedHeader(receivedValue string) *model.Receiv
 			hop.Ip = &ipStr
 
 			// Perform reverse DNS lookup
-			if reverseNames, err := net.LookupAddr(ipStr); err == nil && len(reverseNames) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+			reverseNames, err := h.resolver.LookupAddr(ctx, ipStr)
+			cancel()
+			if err == nil && len(reverseNames) > 0 {
 				// Remove trailing dot from PTR record
 				reverse := strings.TrimSuffix(reverseNames[0], ".")
 				hop.Reverse = &reverse
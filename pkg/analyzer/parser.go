@@ -22,6 +22,7 @@
 package analyzer
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"mime"
@@ -43,6 +44,7 @@ type EmailMessage struct {
 	Parts      []MessagePart
 	RawHeaders string
 	RawBody    string
+	RawMessage []byte // Message exactly as received, needed to verify signatures
 }
 
 // MessagePart represents a MIME part of an email
@@ -58,12 +60,18 @@ type MessagePart struct {
 
 // ParseEmail parses an email message from a reader
 func ParseEmail(r io.Reader) (*EmailMessage, error) {
-	msg, err := mail.ReadMessage(r)
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email message: %w", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read email message: %w", err)
 	}
 
 	email := &EmailMessage{
+		RawMessage: raw,
 		Header:     msg.Header,
 		Subject:    msg.Header.Get("Subject"),
 		MessageID:  msg.Header.Get("Message-ID"),
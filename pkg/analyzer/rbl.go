@@ -23,6 +23,7 @@ package analyzer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
@@ -41,8 +42,13 @@ type DNSListChecker struct {
 	Lists            []string
 	CheckAllIPs      bool // Check all IPs found in headers, not just the first one
 	filterErrorCodes bool // When true (RBL mode), treat 127.255.255.253/254/255 as operational errors
-	resolver         *net.Resolver
-	informationalSet map[string]bool // Lists whose hits don't count toward the score
+	resolver         DNSResolver
+	listType         DNSListType
+	catalog          map[string]DNSList // Catalogue entries of the lists, by lower-cased zone (nil = default settings)
+	health           *dnsListHealth     // Last health check of the zones, shared between copies
+
+	Deadline time.Duration // Time budget of all the queries of a message (0 = only the per-query Timeout)
+	slots    chan struct{} // Bounds the queries in flight, shared between checkers (nil = no limit)
 }
 
 // ipAddrPatterns match IPv4 and IPv6 candidates in mail headers. Matches are
@@ -96,28 +102,45 @@ var DefaultDNSWLs = []string{
 
 // NewRBLChecker creates a new RBL checker with configurable timeout and RBL list
 func NewRBLChecker(timeout time.Duration, rbls []string, checkAllIPs bool) *DNSListChecker {
+	return NewRBLCheckerWithResolver(timeout, rbls, checkAllIPs, nil)
+}
+
+// NewRBLCheckerWithResolver creates a new RBL checker querying the lists
+// through a custom resolver.
+// If resolver is nil, a StandardDNSResolver will be used.
+func NewRBLCheckerWithResolver(timeout time.Duration, rbls []string, checkAllIPs bool, resolver DNSResolver) *DNSListChecker {
+	if resolver == nil {
+		resolver = NewStandardDNSResolver()
+	}
 	if timeout == 0 {
 		timeout = 5 * time.Second
 	}
 	if len(rbls) == 0 {
 		rbls = DefaultRBLs
 	}
-	informationalSet := make(map[string]bool, len(DefaultInformationalRBLs))
-	for _, rbl := range DefaultInformationalRBLs {
-		informationalSet[rbl] = true
-	}
 	return &DNSListChecker{
 		Timeout:          timeout,
 		Lists:            rbls,
 		CheckAllIPs:      checkAllIPs,
 		filterErrorCodes: true,
-		resolver:         &net.Resolver{PreferGo: true},
-		informationalSet: informationalSet,
+		resolver:         resolver,
+		listType:         DNSListTypeIP,
+		health:           &dnsListHealth{},
 	}
 }
 
 // NewDNSWLChecker creates a new DNSWL checker with configurable timeout and DNSWL list
 func NewDNSWLChecker(timeout time.Duration, dnswls []string, checkAllIPs bool) *DNSListChecker {
+	return NewDNSWLCheckerWithResolver(timeout, dnswls, checkAllIPs, nil)
+}
+
+// NewDNSWLCheckerWithResolver creates a new DNSWL checker querying the
+// lists through a custom resolver.
+// If resolver is nil, a StandardDNSResolver will be used.
+func NewDNSWLCheckerWithResolver(timeout time.Duration, dnswls []string, checkAllIPs bool, resolver DNSResolver) *DNSListChecker {
+	if resolver == nil {
+		resolver = NewStandardDNSResolver()
+	}
 	if timeout == 0 {
 		timeout = 5 * time.Second
 	}
@@ -129,17 +152,22 @@ func NewDNSWLChecker(timeout time.Duration, dnswls []string, checkAllIPs bool) *
 		Lists:            dnswls,
 		CheckAllIPs:      checkAllIPs,
 		filterErrorCodes: false,
-		resolver:         &net.Resolver{PreferGo: true},
-		informationalSet: make(map[string]bool),
+		resolver:         resolver,
+		listType:         DNSListTypeWhitelist,
+		health:           &dnsListHealth{},
 	}
 }
 
 // DNSListResults represents the results of DNS list checks
 type DNSListResults struct {
-	Checks              map[string][]model.BlacklistCheck // Map of IP -> list of checks for that IP
+	Checks              map[string][]model.BlacklistCheck // Map of IP (or domain) -> list of checks for it
 	IPsChecked          []string
-	ListedCount         int // Total listings including informational entries
-	RelevantListedCount int // Listings on scoring (non-informational) lists only
+	DomainsChecked      []string            // Domains checked against domain lists, in the order they were found
+	DomainSources       map[string][]string // Map of domain -> where it was found in the email
+	ListedCount         int                 // Total listings including informational entries
+	RelevantListedCount int                 // Listings on scoring (non-informational) lists only
+
+	RelevantListedWeight float64 // Sum of the weights of the listings on scoring lists
 }
 
 // CheckEmail checks all IPs found in the email headers against the configured lists
@@ -155,21 +183,17 @@ func (r *DNSListChecker) CheckEmail(email *EmailMessage) *DNSListResults {
 
 	results.IPsChecked = ips
 
+	if !r.CheckAllIPs {
+		ips = ips[:1]
+	}
+
+	results.Checks = r.runChecks(ips, r.checkIP)
 	for _, ip := range ips {
-		for _, list := range r.Lists {
-			check := r.checkIP(ip, list)
-			results.Checks[ip] = append(results.Checks[ip], check)
+		for _, check := range results.Checks[ip] {
 			if check.Listed {
-				results.ListedCount++
-				if !r.informationalSet[list] {
-					results.RelevantListedCount++
-				}
+				r.countListing(results, check.Rbl)
 			}
 		}
-
-		if !r.CheckAllIPs {
-			break
-		}
 	}
 
 	return results
@@ -181,17 +205,7 @@ func (r *DNSListChecker) CheckIP(ip string) ([]model.BlacklistCheck, int, error)
 		return nil, 0, fmt.Errorf("invalid or non-public IP address: %s", ip)
 	}
 
-	checks := make([]model.BlacklistCheck, len(r.Lists))
-	var wg sync.WaitGroup
-
-	for i, list := range r.Lists {
-		wg.Add(1)
-		go func(i int, list string) {
-			defer wg.Done()
-			checks[i] = r.checkIP(ip, list)
-		}(i, list)
-	}
-	wg.Wait()
+	checks := r.runChecks([]string{ip}, r.checkIP)[ip]
 
 	listedCount := 0
 	for _, check := range checks {
@@ -278,12 +292,70 @@ func (r *DNSListChecker) isPublicIP(ipStr string) bool {
 	return true
 }
 
+// runChecks queries every list for each name concurrently, within the
+// concurrency limit and the deadline of the checker, and returns the
+// checks of each name in the order of the lists.
+func (r *DNSListChecker) runChecks(names []string, check func(ctx context.Context, name, list string) model.BlacklistCheck) map[string][]model.BlacklistCheck {
+	ctx := context.Background()
+	if r.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Deadline)
+		defer cancel()
+	}
+
+	checks := make(map[string][]model.BlacklistCheck, len(names))
+	for _, name := range names {
+		checks[name] = make([]model.BlacklistCheck, len(r.Lists))
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		for i, list := range r.Lists {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if r.slots != nil {
+					select {
+					case r.slots <- struct{}{}:
+						defer func() { <-r.slots }()
+					case <-ctx.Done():
+						checks[name][i] = model.BlacklistCheck{
+							Rbl:      list,
+							TimedOut: utils.PtrTo(true),
+							Error:    utils.PtrTo("Deadline reached before the query could be sent"),
+						}
+						return
+					}
+				}
+				checks[name][i] = check(ctx, name, list)
+			}()
+		}
+	}
+	wg.Wait()
+
+	return checks
+}
+
+// lookupTimedOut tells whether a list query failed because it ran out of
+// time, either its own timeout or the deadline of the message.
+func lookupTimedOut(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsTimeout
+}
+
 // checkIP checks a single IP against a single DNS list
-func (r *DNSListChecker) checkIP(ip, list string) model.BlacklistCheck {
+func (r *DNSListChecker) checkIP(ctx context.Context, ip, list string) model.BlacklistCheck {
 	check := model.BlacklistCheck{
 		Rbl: list,
 	}
 
+	if r.quarantined(list) {
+		check.Quarantined = utils.PtrTo(true)
+	}
+
 	reversedIP := r.reverseIP(ip)
 	if reversedIP == "" {
 		check.Error = utils.PtrTo("Failed to reverse IP address")
@@ -292,7 +364,7 @@ func (r *DNSListChecker) checkIP(ip, list string) model.BlacklistCheck {
 
 	query := fmt.Sprintf("%s.%s", reversedIP, list)
 
-	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	addrs, err := r.resolver.LookupHost(ctx, query)
@@ -303,6 +375,11 @@ func (r *DNSListChecker) checkIP(ip, list string) model.BlacklistCheck {
 				return check
 			}
 		}
+		if lookupTimedOut(ctx, err) {
+			check.TimedOut = utils.PtrTo(true)
+			check.Error = utils.PtrTo("DNS lookup timed out, the listing status is unknown")
+			return check
+		}
 		check.Error = utils.PtrTo(fmt.Sprintf("DNS lookup failed: %v", err))
 		return check
 	}
@@ -310,12 +387,16 @@ func (r *DNSListChecker) checkIP(ip, list string) model.BlacklistCheck {
 	if len(addrs) > 0 {
 		check.Response = utils.PtrTo(addrs[0])
 
-		// In RBL mode, 127.255.255.253/254/255 indicate operational errors, not real listings.
-		if r.filterErrorCodes && (addrs[0] == "127.255.255.253" || addrs[0] == "127.255.255.254" || addrs[0] == "127.255.255.255") {
+		code, refused := r.list(list).decodeResponse(addrs)
+		if refused != "" {
+			check.Error = utils.PtrTo(fmt.Sprintf("List %s returned error code %s (%s)", list, addrs[0], refused))
+		} else if r.filterErrorCodes && (addrs[0] == "127.255.255.253" || addrs[0] == "127.255.255.254" || addrs[0] == "127.255.255.255") {
+			// In RBL mode, 127.255.255.253/254/255 indicate operational errors, not real listings.
 			check.Listed = false
 			check.Error = utils.PtrTo(fmt.Sprintf("RBL %s returned error code %s (RBL operational issue)", list, addrs[0]))
 		} else {
 			check.Listed = true
+			r.describeListing(ctx, &check, query, list, code)
 		}
 	}
 
@@ -350,12 +431,34 @@ func (r *DNSListChecker) reverseIP(ipStr string) string {
 	return strings.Join(nibbles, ".")
 }
 
+// countListing records a listing on a list in the results. Listings on
+// quarantined zones are left out.
+func (r *DNSListChecker) countListing(results *DNSListResults, list string) {
+	if r.quarantined(list) {
+		return
+	}
+	results.ListedCount++
+	if l := r.list(list); !l.Informational {
+		results.RelevantListedCount++
+		results.RelevantListedWeight += l.weight()
+	}
+}
+
 // CalculateScore calculates the list contribution to deliverability.
-// Informational lists don't count proportionally; instead, if any
-// informational list triggers, a flat 10% penalty is applied regardless
-// of how many of them fire.
+// Each scoring list weighs its catalogue weight in the score, quarantined
+// zones being left out. Informational
+// lists don't count proportionally; instead, if any informational list
+// triggers, a flat 10% penalty is applied regardless of how many of them
+// fire.
 func (r *DNSListChecker) CalculateScore(results *DNSListResults, forWhitelist bool) (int, string) {
-	scoringListCount := len(r.Lists) - len(r.informationalSet)
+	scoringListCount := 0
+	scoringWeight := 0.0
+	for _, list := range r.Lists {
+		if l := r.list(list); !l.Informational && !r.quarantined(list) {
+			scoringListCount++
+			scoringWeight += l.weight()
+		}
+	}
 
 	if forWhitelist {
 		if results.ListedCount >= scoringListCount {
@@ -367,7 +470,7 @@ func (r *DNSListChecker) CalculateScore(results *DNSListResults, forWhitelist bo
 		}
 	}
 
-	if results == nil || len(results.IPsChecked) == 0 {
+	if results == nil || (len(results.IPsChecked) == 0 && len(results.DomainsChecked) == 0) {
 		return 100, ""
 	}
 
@@ -381,7 +484,13 @@ func (r *DNSListChecker) CalculateScore(results *DNSListResults, forWhitelist bo
 		informationalPenalty = 10
 	}
 
-	percentage := max(0, 100-results.RelevantListedCount*100/scoringListCount-informationalPenalty)
+	// Results built without the weights count each listing as 1.
+	listedWeight := results.RelevantListedWeight
+	if listedWeight == 0 {
+		listedWeight = float64(results.RelevantListedCount)
+	}
+
+	percentage := max(0, 100-int(listedWeight*100/scoringWeight)-informationalPenalty)
 	return percentage, ScoreToGrade(percentage)
 }
 
@@ -0,0 +1,201 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DNSListType tells what a DNS list is queried with, and whether a listing
+// is good or bad news.
+type DNSListType string
+
+const (
+	DNSListTypeIP        DNSListType = "ip"        // IP blocklist (DNSBL)
+	DNSListTypeDomain    DNSListType = "domain"    // Domain blocklist (RHSBL/URIBL)
+	DNSListTypeWhitelist DNSListType = "whitelist" // IP allowlist (DNSWL)
+)
+
+// DNSList describes a DNS list of the catalogue. Lists answering a bitmask
+// set one bit of the last octet per sub-list; lists like DNSWL encode their
+// level in the last octet whatever the other ones are.
+type DNSList struct {
+	Zone          string      `json:"zone"`
+	Type          DNSListType `json:"type"`
+	Weight        float64     `json:"weight,omitempty"`        // Weight of a listing toward the score, relative to the other lists (0 = 1)
+	Informational bool        `json:"informational,omitempty"` // Listings are reported but don't count toward the score
+	TestPoint     string      `json:"test_point,omitempty"`    // IP or domain the list must answer for (empty = the RFC 5782 one)
+
+	Codes     map[string]DNSListCode `json:"codes,omitempty"`      // answer -> meaning
+	Bits      map[byte]DNSListCode   `json:"bits,omitempty"`       // bit of the last octet -> meaning
+	LastOctet map[byte]DNSListCode   `json:"last_octet,omitempty"` // last octet -> meaning
+	Errors    map[string]string      `json:"errors,omitempty"`     // answers telling the query was refused
+	DelistURL string                 `json:"delist_url,omitempty"`
+}
+
+// DNSListCatalog is the set of DNS lists the checkers may query.
+type DNSListCatalog []DNSList
+
+// DefaultDNSListCatalog returns the catalogue of the default lists, with
+// the return codes known for them.
+func DefaultDNSListCatalog() DNSListCatalog {
+	var catalog DNSListCatalog
+	for _, zone := range DefaultRBLs {
+		catalog = append(catalog, knownDNSList(zone, DNSListTypeIP))
+	}
+	for _, zone := range DefaultDNSWLs {
+		catalog = append(catalog, knownDNSList(zone, DNSListTypeWhitelist))
+	}
+	for _, zone := range DefaultDomainLists {
+		catalog = append(catalog, knownDNSList(zone, DNSListTypeDomain))
+	}
+	return catalog
+}
+
+// knownDNSList returns the catalogue entry of a zone missing from the
+// catalogue, with the return codes known for it and the default settings.
+func knownDNSList(zone string, listType DNSListType) DNSList {
+	list := knownDNSLists[strings.ToLower(zone)]
+	list.Zone = zone
+	list.Type = listType
+	list.Informational = listType == DNSListTypeIP && slices.Contains(DefaultInformationalRBLs, zone)
+	return list
+}
+
+// LoadDNSListCatalog reads a catalogue from a JSON file holding an array
+// of lists.
+func LoadDNSListCatalog(filename string) (DNSListCatalog, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog DNSListCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return catalog, nil
+}
+
+// Validate checks the catalogue holds usable list descriptions.
+func (c DNSListCatalog) Validate() error {
+	seen := make(map[string]bool, len(c))
+	for i, list := range c {
+		zone := strings.ToLower(strings.TrimSuffix(list.Zone, "."))
+		if zone == "" {
+			return fmt.Errorf("list #%d: missing zone", i+1)
+		}
+		if seen[zone] {
+			return fmt.Errorf("list %s: listed twice", list.Zone)
+		}
+		seen[zone] = true
+
+		switch list.Type {
+		case DNSListTypeIP, DNSListTypeDomain, DNSListTypeWhitelist:
+		default:
+			return fmt.Errorf("list %s: unknown type %q (ip, domain or whitelist)", list.Zone, list.Type)
+		}
+		if list.Weight < 0 {
+			return fmt.Errorf("list %s: negative weight", list.Zone)
+		}
+
+		for answer, code := range list.Codes {
+			if net.ParseIP(answer).To4() == nil {
+				return fmt.Errorf("list %s: return code %q is not an IPv4 address", list.Zone, answer)
+			}
+			if err := code.validate(); err != nil {
+				return fmt.Errorf("list %s: return code %s: %w", list.Zone, answer, err)
+			}
+		}
+		for answer := range list.Errors {
+			if net.ParseIP(answer).To4() == nil {
+				return fmt.Errorf("list %s: error code %q is not an IPv4 address", list.Zone, answer)
+			}
+		}
+		for _, codes := range []map[byte]DNSListCode{list.Bits, list.LastOctet} {
+			for value, code := range codes {
+				if err := code.validate(); err != nil {
+					return fmt.Errorf("list %s: return code %d: %w", list.Zone, value, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the severity of the code is one of the report ones.
+func (c DNSListCode) validate() error {
+	if c.Severity != "" && severityRank[c.Severity] == 0 {
+		return fmt.Errorf("unknown severity %q", c.Severity)
+	}
+	return nil
+}
+
+// weight returns the weight of a listing toward the score.
+func (l DNSList) weight() float64 {
+	if l.Weight == 0 {
+		return 1
+	}
+	return l.Weight
+}
+
+// UseCatalog makes the checker query the lists of its type found in the
+// catalogue, then the extra zones that are not part of it, with the
+// default settings.
+func (r *DNSListChecker) UseCatalog(catalog DNSListCatalog, extra []string) {
+	r.Lists = nil
+	r.catalog = make(map[string]DNSList)
+	for _, list := range catalog {
+		if list.Type == r.listType {
+			r.Lists = append(r.Lists, list.Zone)
+			r.catalog[strings.ToLower(list.Zone)] = list
+		}
+	}
+
+	known := make(map[string]bool, len(catalog))
+	for _, list := range catalog {
+		known[strings.ToLower(list.Zone)] = true
+	}
+	for _, zone := range extra {
+		if !known[strings.ToLower(zone)] {
+			r.Lists = append(r.Lists, zone)
+			known[strings.ToLower(zone)] = true
+		}
+	}
+}
+
+// list returns the catalogue entry of a zone queried by the checker.
+func (r *DNSListChecker) list(zone string) DNSList {
+	if list, ok := r.catalog[strings.ToLower(zone)]; ok {
+		return list
+	}
+	return knownDNSList(zone, r.listType)
+}
@@ -0,0 +1,196 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/config"
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestLoadDNSListCatalog(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "Valid catalogue",
+			content: `[
+				{"zone": "bl.example.org", "type": "ip", "weight": 3, "codes": {"127.0.0.2": {"category": "spam source", "severity": "high"}}, "delist_url": "https://bl.example.org/remove"},
+				{"zone": "policy.example.org", "type": "ip", "informational": true, "test_point": "127.0.0.3"},
+				{"zone": "wl.example.org", "type": "whitelist", "last_octet": {"1": {"category": "trusted", "severity": "info"}}},
+				{"zone": "uri.example.org", "type": "domain", "bits": {"2": {"category": "phishing"}}, "errors": {"127.0.0.1": "query refused"}}
+			]`,
+		},
+		{name: "Not JSON", content: `zone = bl.example.org`, wantErr: "invalid character"},
+		{name: "Missing zone", content: `[{"type": "ip"}]`, wantErr: "missing zone"},
+		{name: "Unknown type", content: `[{"zone": "bl.example.org", "type": "asn"}]`, wantErr: "unknown type"},
+		{name: "Duplicate zone", content: `[{"zone": "bl.example.org", "type": "ip"}, {"zone": "BL.example.org.", "type": "domain"}]`, wantErr: "listed twice"},
+		{name: "Negative weight", content: `[{"zone": "bl.example.org", "type": "ip", "weight": -1}]`, wantErr: "negative weight"},
+		{name: "Invalid return code", content: `[{"zone": "bl.example.org", "type": "ip", "codes": {"listed": {"category": "spam"}}}]`, wantErr: "not an IPv4 address"},
+		{name: "Unknown severity", content: `[{"zone": "bl.example.org", "type": "ip", "codes": {"127.0.0.2": {"category": "spam", "severity": "fatal"}}}]`, wantErr: "unknown severity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "lists.json")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			catalog, err := LoadDNSListCatalog(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadDNSListCatalog() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDNSListCatalog() error = %v", err)
+			}
+			if len(catalog) != 4 || catalog[0].Weight != 3 || !catalog[1].Informational || catalog[2].LastOctet[1].Category != "trusted" || catalog[3].Bits[2].Category != "phishing" {
+				t.Errorf("LoadDNSListCatalog() = %+v", catalog)
+			}
+		})
+	}
+
+	if _, err := LoadDNSListCatalog(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadDNSListCatalog() of a missing file should fail")
+	}
+}
+
+func TestValidateConfigCatalog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lists.json")
+	if err := os.WriteFile(filename, []byte(`[{"zone": "bl.example.org", "type": "asn"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.DefaultConfig()
+	if err := ValidateConfig(cfg); err != nil {
+		t.Errorf("ValidateConfig() of the defaults = %v", err)
+	}
+	cfg.Analysis.DNSListCatalog = filename
+	if err := ValidateConfig(cfg); err == nil || !strings.Contains(err.Error(), "dns-list-catalog") {
+		t.Errorf("ValidateConfig() of an invalid catalogue = %v, want an error", err)
+	}
+}
+
+func TestDefaultDNSListCatalog(t *testing.T) {
+	catalog := DefaultDNSListCatalog()
+	if err := catalog.Validate(); err != nil {
+		t.Fatalf("default catalogue is invalid: %v", err)
+	}
+	if got, want := len(catalog), len(DefaultRBLs)+len(DefaultDNSWLs)+len(DefaultDomainLists); got != want {
+		t.Errorf("default catalogue holds %d lists, want %d", got, want)
+	}
+
+	checker := NewRBLChecker(time.Second, nil, false)
+	checker.UseCatalog(catalog, nil)
+	if !slices.Equal(checker.Lists, DefaultRBLs) {
+		t.Errorf("Lists = %v, want %v", checker.Lists, DefaultRBLs)
+	}
+	for _, zone := range DefaultInformationalRBLs {
+		if !checker.list(zone).Informational {
+			t.Errorf("%s should be informational", zone)
+		}
+	}
+	if checker.list("zen.spamhaus.org").DelistURL == "" {
+		t.Error("zen.spamhaus.org should keep its return codes")
+	}
+}
+
+func TestUseCatalog(t *testing.T) {
+	catalog := DNSListCatalog{
+		{Zone: "heavy.example.org", Type: DNSListTypeIP, Weight: 3},
+		{Zone: "light.example.org", Type: DNSListTypeIP},
+		{Zone: "info.example.org", Type: DNSListTypeIP, Informational: true, Weight: 5},
+		{Zone: "wl.example.org", Type: DNSListTypeWhitelist},
+		{Zone: "uri.example.org", Type: DNSListTypeDomain},
+	}
+
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"1.113.0.203.heavy.example.org": {"127.0.0.2"},
+			"2.113.0.203.light.example.org": {"127.0.0.2"},
+			"2.113.0.203.info.example.org":  {"127.0.0.2"},
+		},
+	}
+	checker := NewRBLCheckerWithResolver(time.Second, nil, true, resolver)
+	checker.UseCatalog(catalog, []string{"extra.example.org", "Light.example.org"})
+
+	if want := []string{"heavy.example.org", "light.example.org", "info.example.org", "extra.example.org"}; !slices.Equal(checker.Lists, want) {
+		t.Fatalf("Lists = %v, want %v", checker.Lists, want)
+	}
+
+	tests := []struct {
+		name          string
+		received      string
+		expectedScore int
+	}{
+		// Scoring weight: 3 + 1 + 1 (extra list, default weight)
+		{"Listed on the heavy list", "from mail (mail [203.0.113.1])", 40},
+		{"Listed on the light and the informational lists", "from mail (mail [203.0.113.2])", 70},
+		{"Not listed", "from mail (mail [203.0.113.3])", 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := &EmailMessage{Header: map[string][]string{"Received": {tt.received}}}
+			results := checker.CheckEmail(email)
+			if score, _ := checker.CalculateScore(results, false); score != tt.expectedScore {
+				t.Errorf("CalculateScore() = %d, want %d", score, tt.expectedScore)
+			}
+		})
+	}
+
+	// The score of a single IP uses the weights as well
+	checks, _, err := checker.CheckIP("203.0.113.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := &DNSListResults{Checks: map[string][]model.BlacklistCheck{"203.0.113.1": checks}, IPsChecked: []string{"203.0.113.1"}}
+	for _, check := range checks {
+		if check.Listed {
+			checker.countListing(results, check.Rbl)
+		}
+	}
+	if results.RelevantListedWeight != 3 {
+		t.Errorf("RelevantListedWeight = %v, want 3", results.RelevantListedWeight)
+	}
+
+	whitelist := NewDNSWLChecker(time.Second, nil, false)
+	whitelist.UseCatalog(catalog, nil)
+	if !slices.Equal(whitelist.Lists, []string{"wl.example.org"}) {
+		t.Errorf("whitelist Lists = %v", whitelist.Lists)
+	}
+	domains := NewDomainListChecker(time.Second, nil)
+	domains.UseCatalog(catalog, nil)
+	if !slices.Equal(domains.Lists, []string{"uri.example.org"}) {
+		t.Errorf("domain Lists = %v", domains.Lists)
+	}
+}
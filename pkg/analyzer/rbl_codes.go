@@ -0,0 +1,319 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// DNSListCode is the meaning of an answer of a DNS list.
+type DNSListCode struct {
+	Category string                       `json:"category"`
+	Severity model.BlacklistCheckSeverity `json:"severity,omitempty"`
+	Advice   string                       `json:"advice,omitempty"`
+}
+
+// Advice shared by several lists.
+const (
+	adviceExploitedHost = "The IP shows signs of a malware infection, a botnet or an open proxy. Clean the host (or the machines behind its NAT gateway) before requesting removal, or it will be listed again."
+	adviceSpamSource    = "Find and stop the source of the spam (compromised account, vulnerable form, bad list hygiene) before requesting removal."
+	adviceNeighbours    = "The listing is caused by other IPs of the same network. Ask the network provider to deal with the abuse, or move to a provider with a better reputation."
+)
+
+// spamhausIPCodes are the answers of the Spamhaus IP zones.
+var spamhausIPCodes = map[string]DNSListCode{
+	"127.0.0.2": {"SBL: spam source or spam operation", model.BlacklistCheckSeverityCritical,
+		"The IP is on the Spamhaus Block List for sending spam or hosting a spam operation. " + adviceSpamSource},
+	"127.0.0.3": {"CSS: low-reputation or snowshoe spam", model.BlacklistCheckSeverityHigh,
+		"The IP sent mail matching low-reputation or snowshoe spam patterns. Review what this IP sends and the consent of its recipients; the listing expires once the traffic stops."},
+	"127.0.0.4":  {"XBL: exploited host", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+	"127.0.0.5":  {"XBL: exploited host", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+	"127.0.0.6":  {"XBL: exploited host", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+	"127.0.0.7":  {"XBL: exploited host", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+	"127.0.0.9":  {"DROP: hijacked or criminal netblock", model.BlacklistCheckSeverityCritical, "The IP belongs to a netblock controlled by criminals or hijacked. Mail from it will be refused almost everywhere; send from another network."},
+	"127.0.0.10": {"PBL: end-user range (ISP maintained)", model.BlacklistCheckSeverityLow, "The ISP declared this range should not deliver mail directly to the Internet. This is a policy listing, not a sign of abuse: relay your mail through your provider's or a dedicated smarthost instead of requesting removal."},
+	"127.0.0.11": {"PBL: end-user range (Spamhaus maintained)", model.BlacklistCheckSeverityLow, "The range looks dynamic or residential. This is a policy listing, not a sign of abuse: relay your mail through a smarthost, or, if this is a real mail server on a static IP, request its removal from the PBL."},
+}
+
+// spamhausErrors are the answers of Spamhaus zones refusing a query.
+var spamhausErrors = map[string]string{
+	"127.255.255.252": "typing error in the list name",
+	"127.255.255.254": "queries through public resolvers are refused",
+	"127.255.255.255": "query limit exceeded",
+}
+
+// mailspikeCodes are the reputation levels of the Mailspike zones, from the
+// worst (L5) to the best (H5) senders.
+var mailspikeCodes = map[string]DNSListCode{
+	"127.0.0.2":  {"zero-hour spam wave participant", model.BlacklistCheckSeverityHigh, "The IP takes part in an ongoing spam wave. " + adviceSpamSource},
+	"127.0.0.10": {"worst possible reputation (L5)", model.BlacklistCheckSeverityCritical, adviceSpamSource},
+	"127.0.0.11": {"very bad reputation (L4)", model.BlacklistCheckSeverityHigh, adviceSpamSource},
+	"127.0.0.12": {"bad reputation (L3)", model.BlacklistCheckSeverityMedium, adviceSpamSource},
+	"127.0.0.13": {"suspicious behavior (L2)", model.BlacklistCheckSeverityLow, ""},
+	"127.0.0.14": {"neutral, probably spam (L1)", model.BlacklistCheckSeverityLow, ""},
+	"127.0.0.16": {"neutral, probably legitimate (H1)", model.BlacklistCheckSeverityInfo, ""},
+	"127.0.0.17": {"possibly legitimate sender (H2)", model.BlacklistCheckSeverityInfo, ""},
+	"127.0.0.18": {"good reputation (H3)", model.BlacklistCheckSeverityInfo, ""},
+	"127.0.0.19": {"very good reputation (H4)", model.BlacklistCheckSeverityInfo, ""},
+	"127.0.0.20": {"excellent reputation (H5)", model.BlacklistCheckSeverityInfo, ""},
+}
+
+// knownDNSLists holds the return codes of the default lists, and of the
+// individual zones of the combined ones. It is the base of the default
+// catalogue.
+var knownDNSLists = map[string]DNSList{
+	// IP blocklists
+	"zen.spamhaus.org":     {Codes: spamhausIPCodes, Errors: spamhausErrors, DelistURL: "https://check.spamhaus.org/"},
+	"sbl.spamhaus.org":     {Codes: spamhausIPCodes, Errors: spamhausErrors, DelistURL: "https://check.spamhaus.org/"},
+	"xbl.spamhaus.org":     {Codes: spamhausIPCodes, Errors: spamhausErrors, DelistURL: "https://check.spamhaus.org/"},
+	"pbl.spamhaus.org":     {Codes: spamhausIPCodes, Errors: spamhausErrors, DelistURL: "https://check.spamhaus.org/"},
+	"sbl-xbl.spamhaus.org": {Codes: spamhausIPCodes, Errors: spamhausErrors, DelistURL: "https://check.spamhaus.org/"},
+	"bl.spamcop.net": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"reported spam source", model.BlacklistCheckSeverityHigh, "SpamCop users reported spam from this IP. The listing expires automatically about 24 hours after the reports stop."},
+		},
+		DelistURL: "https://www.spamcop.net/bl.shtml",
+	},
+	"b.barracudacentral.org": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"poor sending reputation", model.BlacklistCheckSeverityHigh, adviceSpamSource},
+		},
+		DelistURL: "https://www.barracudacentral.org/rbl/removal-request",
+	},
+	"cbl.abuseat.org": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"exploited host", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+		},
+		DelistURL: "https://check.spamhaus.org/",
+	},
+	"dnsbl-1.uceprotect.net": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"spam source (single IP)", model.BlacklistCheckSeverityHigh, adviceSpamSource},
+		},
+		DelistURL: "https://www.uceprotect.net/en/rblcheck.php",
+	},
+	"dnsbl-2.uceprotect.net": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"spam sources in the same netblock", model.BlacklistCheckSeverityLow, adviceNeighbours},
+		},
+		DelistURL: "https://www.uceprotect.net/en/rblcheck.php",
+	},
+	"dnsbl-3.uceprotect.net": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"spam sources in the same AS", model.BlacklistCheckSeverityLow, adviceNeighbours},
+		},
+		DelistURL: "https://www.uceprotect.net/en/rblcheck.php",
+	},
+	"psbl.surriel.com": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2": {"spam trap hit", model.BlacklistCheckSeverityMedium, adviceSpamSource},
+		},
+		DelistURL: "https://psbl.org/",
+	},
+	"dnsbl.dronebl.org": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.3":  {"IRC drone", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.5":  {"bottler", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.6":  {"unknown spambot or drone", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.7":  {"DDoS drone", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.8":  {"open SOCKS proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.9":  {"open HTTP proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.10": {"proxy chain", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.11": {"web page proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.12": {"open DNS resolver", model.BlacklistCheckSeverityMedium, "Restrict recursion on the DNS resolver running on this IP to your own networks."},
+			"127.0.0.13": {"brute force attacker", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.14": {"open WinGate proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.15": {"compromised router or gateway", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.16": {"autorooting worm", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.17": {"automatically detected botnet IP", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.18": {"DNS or MX record on an IRC network", model.BlacklistCheckSeverityMedium, ""},
+			"127.0.0.19": {"abused VPN service", model.BlacklistCheckSeverityMedium, ""},
+		},
+		DelistURL: "https://dronebl.org/lookup",
+	},
+	"dnsbl.sorbs.net": {
+		Codes: map[string]DNSListCode{
+			"127.0.0.2":  {"open HTTP proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.3":  {"open SOCKS proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.4":  {"open proxy", model.BlacklistCheckSeverityHigh, adviceExploitedHost},
+			"127.0.0.5":  {"open SMTP relay", model.BlacklistCheckSeverityHigh, "The mail server on this IP relays mail for anyone. Restrict relaying to authenticated users and your own networks."},
+			"127.0.0.6":  {"spam source", model.BlacklistCheckSeverityHigh, adviceSpamSource},
+			"127.0.0.7":  {"vulnerable web server", model.BlacklistCheckSeverityHigh, "A web application on this IP is abused to send mail. Fix or remove the vulnerable script."},
+			"127.0.0.8":  {"network owner asked not to be tested", model.BlacklistCheckSeverityLow, ""},
+			"127.0.0.9":  {"hijacked network", model.BlacklistCheckSeverityCritical, ""},
+			"127.0.0.10": {"dynamic IP range", model.BlacklistCheckSeverityLow, "The range looks dynamic. Relay your mail through a smarthost, or ask your provider for a static IP with a proper reverse DNS."},
+			"127.0.0.11": {"bad DNS configuration", model.BlacklistCheckSeverityMedium, ""},
+			"127.0.0.14": {"no server should run on this IP", model.BlacklistCheckSeverityLow, ""},
+		},
+	},
+	"bl.mailspike.net":  {Codes: mailspikeCodes},
+	"z.mailspike.net":   {Codes: mailspikeCodes},
+	"rep.mailspike.net": {Codes: mailspikeCodes},
+
+	// Whitelists
+	"list.dnswl.org": {
+		LastOctet: map[byte]DNSListCode{
+			0: {"trust level: none", model.BlacklistCheckSeverityInfo, ""},
+			1: {"trust level: low", model.BlacklistCheckSeverityInfo, ""},
+			2: {"trust level: medium", model.BlacklistCheckSeverityInfo, ""},
+			3: {"trust level: high", model.BlacklistCheckSeverityInfo, ""},
+		},
+		Errors: map[string]string{
+			"127.0.0.255": "query limit exceeded or public resolver refused",
+		},
+	},
+	"wl.mailspike.net": {Codes: mailspikeCodes},
+
+	// Domain blocklists
+	"dbl.spamhaus.org": {
+		Codes: map[string]DNSListCode{
+			"127.0.1.2":   {"spam domain", model.BlacklistCheckSeverityHigh, ""},
+			"127.0.1.4":   {"phishing domain", model.BlacklistCheckSeverityCritical, ""},
+			"127.0.1.5":   {"malware domain", model.BlacklistCheckSeverityCritical, ""},
+			"127.0.1.6":   {"botnet C&C domain", model.BlacklistCheckSeverityCritical, ""},
+			"127.0.1.102": {"abused legit spam domain", model.BlacklistCheckSeverityMedium, ""},
+			"127.0.1.103": {"abused spammed redirector domain", model.BlacklistCheckSeverityMedium, ""},
+			"127.0.1.104": {"abused legit phishing domain", model.BlacklistCheckSeverityHigh, ""},
+			"127.0.1.105": {"abused legit malware domain", model.BlacklistCheckSeverityHigh, ""},
+			"127.0.1.106": {"abused legit botnet C&C domain", model.BlacklistCheckSeverityHigh, ""},
+		},
+		Errors: map[string]string{
+			"127.0.1.255":     "IP queries are not allowed",
+			"127.255.255.252": "typing error in the list name",
+			"127.255.255.254": "queries through public resolvers are refused",
+			"127.255.255.255": "query limit exceeded",
+		},
+		DelistURL: "https://check.spamhaus.org/",
+		TestPoint: "dbltest.com",
+	},
+	"multi.uribl.com": {
+		Bits: map[byte]DNSListCode{
+			2: {"black list", model.BlacklistCheckSeverityHigh, ""},
+			4: {"grey list", model.BlacklistCheckSeverityLow, ""},
+			8: {"red list", model.BlacklistCheckSeverityMedium, ""},
+		},
+		Errors: map[string]string{
+			"127.0.0.1": "query refused, public resolver or query limit exceeded",
+		},
+		DelistURL: "https://admin.uribl.com/",
+		TestPoint: "test.uribl.com",
+	},
+	"multi.surbl.org": {
+		Bits: map[byte]DNSListCode{
+			8:   {"phishing", model.BlacklistCheckSeverityCritical, ""},
+			16:  {"malware", model.BlacklistCheckSeverityCritical, ""},
+			64:  {"abused or spam domain", model.BlacklistCheckSeverityHigh, ""},
+			128: {"cracked site", model.BlacklistCheckSeverityMedium, ""},
+		},
+		Errors: map[string]string{
+			"127.0.0.1": "query refused, the resolver is blocked",
+		},
+		DelistURL: "https://www.surbl.org/surbl-analysis",
+		TestPoint: "test.surbl.org",
+	},
+}
+
+// severityRank orders the severities, from the least to the most severe.
+var severityRank = map[model.BlacklistCheckSeverity]int{
+	model.BlacklistCheckSeverityInfo:     1,
+	model.BlacklistCheckSeverityLow:      2,
+	model.BlacklistCheckSeverityMedium:   3,
+	model.BlacklistCheckSeverityHigh:     4,
+	model.BlacklistCheckSeverityCritical: 5,
+}
+
+// decode returns the meanings of a single answer of the list.
+func (l DNSList) decode(response string) []DNSListCode {
+	if code, ok := l.Codes[response]; ok {
+		return []DNSListCode{code}
+	}
+
+	ip := net.ParseIP(response).To4()
+	if ip == nil {
+		return nil
+	}
+	if code, ok := l.LastOctet[ip[3]]; ok {
+		return []DNSListCode{code}
+	}
+	var codes []DNSListCode
+	for bit := 1; bit < 256; bit <<= 1 {
+		if code, ok := l.Bits[byte(bit)]; ok && ip[3]&byte(bit) != 0 {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// decodeResponse returns what the answers of the list stand for: the
+// categories of all the answers, with the severity and advice of the most
+// severe one. When the list refused the query, refused tells why.
+func (l DNSList) decodeResponse(addrs []string) (code DNSListCode, refused string) {
+	for _, addr := range addrs {
+		if reason, ok := l.Errors[addr]; ok {
+			return DNSListCode{}, reason
+		}
+	}
+
+	var categories []string
+	for _, addr := range addrs {
+		for _, c := range l.decode(addr) {
+			if !slices.Contains(categories, c.Category) {
+				categories = append(categories, c.Category)
+			}
+			if severityRank[c.Severity] > severityRank[code.Severity] {
+				code.Severity = c.Severity
+				code.Advice = c.Advice
+			}
+		}
+	}
+	code.Category = strings.Join(categories, ", ")
+
+	return code, ""
+}
+
+// describeListing fills a listed check with the meaning of the answers, the
+// reason published in the TXT record of the query and where to request
+// a delisting.
+func (r *DNSListChecker) describeListing(ctx context.Context, check *model.BlacklistCheck, query, list string, code DNSListCode) {
+	if code.Category != "" {
+		check.Category = utils.PtrTo(code.Category)
+	}
+	if code.Severity != "" {
+		check.Severity = utils.PtrTo(code.Severity)
+	}
+	if code.Advice != "" {
+		check.Advice = utils.PtrTo(code.Advice)
+	}
+	if url := r.list(list).DelistURL; url != "" {
+		check.DelistUrl = utils.PtrTo(url)
+	}
+
+	if txts, err := r.resolver.LookupTXT(ctx, query); err == nil && len(txts) > 0 {
+		check.Reason = utils.PtrTo(strings.Join(txts, " "))
+	}
+}
@@ -0,0 +1,103 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		list         string
+		addrs        []string
+		wantCategory string
+		wantSeverity model.BlacklistCheckSeverity
+		wantRefused  bool
+	}{
+		{"zen.spamhaus.org", []string{"127.0.0.2"}, "SBL: spam source or spam operation", model.BlacklistCheckSeverityCritical, false},
+		{"zen.spamhaus.org", []string{"127.0.0.10"}, "PBL: end-user range (ISP maintained)", model.BlacklistCheckSeverityLow, false},
+		{"zen.spamhaus.org", []string{"127.0.0.11", "127.0.0.4"}, "PBL: end-user range (Spamhaus maintained), XBL: exploited host", model.BlacklistCheckSeverityHigh, false},
+		{"zen.spamhaus.org", []string{"127.255.255.254"}, "", "", true},
+		{"bl.mailspike.net", []string{"127.0.0.12"}, "bad reputation (L3)", model.BlacklistCheckSeverityMedium, false},
+		{"wl.mailspike.net", []string{"127.0.0.19"}, "very good reputation (H4)", model.BlacklistCheckSeverityInfo, false},
+		{"list.dnswl.org", []string{"127.0.10.2"}, "trust level: medium", model.BlacklistCheckSeverityInfo, false},
+		{"list.dnswl.org", []string{"127.0.0.255"}, "", "", true},
+		{"dbl.spamhaus.org", []string{"127.0.1.4"}, "phishing domain", model.BlacklistCheckSeverityCritical, false},
+		{"dbl.spamhaus.org", []string{"127.0.1.102"}, "abused legit spam domain", model.BlacklistCheckSeverityMedium, false},
+		{"dbl.spamhaus.org", []string{"127.0.1.255"}, "", "", true},
+		{"DBL.Spamhaus.org", []string{"127.0.1.5"}, "malware domain", model.BlacklistCheckSeverityCritical, false},
+		{"multi.uribl.com", []string{"127.0.0.2"}, "black list", model.BlacklistCheckSeverityHigh, false},
+		{"multi.uribl.com", []string{"127.0.0.14"}, "black list, grey list, red list", model.BlacklistCheckSeverityHigh, false},
+		{"multi.uribl.com", []string{"127.0.0.1"}, "", "", true},
+		{"multi.surbl.org", []string{"127.0.0.72"}, "phishing, abused or spam domain", model.BlacklistCheckSeverityCritical, false},
+		{"multi.surbl.org", []string{"127.0.0.128"}, "cracked site", model.BlacklistCheckSeverityMedium, false},
+		{"zen.spamhaus.org", []string{"127.0.0.200"}, "", "", false},
+		{"rhsbl.example.org", []string{"127.0.0.2"}, "", "", false},
+	}
+
+	for _, tt := range tests {
+		code, refused := knownDNSList(tt.list, DNSListTypeIP).decodeResponse(tt.addrs)
+		if code.Category != tt.wantCategory || code.Severity != tt.wantSeverity || (refused != "") != tt.wantRefused {
+			t.Errorf("decodeResponse(%q, %v) = %+v, %q", tt.list, tt.addrs, code, refused)
+		}
+	}
+}
+
+func TestCheckIPDescribesListing(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"1.113.0.203.zen.spamhaus.org": {"127.0.0.10"},
+			"2.113.0.203.zen.spamhaus.org": {"127.255.255.254"},
+			"1.113.0.203.bl.example.org":   {"127.0.0.2"},
+		},
+		txt: map[string][]string{
+			"1.113.0.203.zen.spamhaus.org": {"https://check.spamhaus.org/query/ip/203.0.113.1"},
+		},
+	}
+	checker := NewRBLCheckerWithResolver(time.Second, []string{"zen.spamhaus.org", "bl.example.org"}, false, resolver)
+
+	pbl := checker.checkIP(context.Background(), "203.0.113.1", "zen.spamhaus.org")
+	if !pbl.Listed || utils.DerefOrZero(pbl.Severity) != model.BlacklistCheckSeverityLow || pbl.Advice == nil {
+		t.Errorf("PBL listing not decoded: %+v", pbl)
+	}
+	if got := utils.DerefOrZero(pbl.Reason); got != "https://check.spamhaus.org/query/ip/203.0.113.1" {
+		t.Errorf("Reason = %q", got)
+	}
+	if got := utils.DerefOrZero(pbl.DelistUrl); got != "https://check.spamhaus.org/" {
+		t.Errorf("DelistUrl = %q", got)
+	}
+
+	refused := checker.checkIP(context.Background(), "203.0.113.2", "zen.spamhaus.org")
+	if refused.Listed || refused.Error == nil || refused.Category != nil {
+		t.Errorf("a refused query must not count as a listing: %+v", refused)
+	}
+
+	unknown := checker.checkIP(context.Background(), "203.0.113.1", "bl.example.org")
+	if !unknown.Listed || unknown.Category != nil || unknown.Severity != nil || unknown.DelistUrl != nil || unknown.Reason != nil {
+		t.Errorf("a listing on an unknown list must not be described: %+v", unknown)
+	}
+}
@@ -0,0 +1,216 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// DefaultDomainLists is a list of commonly used domain blocklists
+// (RHSBL/URIBL), checked for the sender and link domains
+var DefaultDomainLists = []string{
+	"dbl.spamhaus.org", // Spamhaus Domain Block List
+	"multi.uribl.com",  // URIBL combined list
+	"multi.surbl.org",  // SURBL combined list
+}
+
+// DomainBlacklist.Sources values, matching the schema enum.
+const (
+	domainSourceFrom       = "from"
+	domainSourceReturnPath = "return_path"
+	domainSourceDKIM       = "dkim"
+	domainSourceLink       = "link"
+)
+
+// maxLinkDomains caps the number of link domains checked per email, as
+// each one costs a query per list.
+const maxLinkDomains = 20
+
+// NewDomainListChecker creates a new domain blocklist checker with
+// configurable timeout and list of zones
+func NewDomainListChecker(timeout time.Duration, lists []string) *DNSListChecker {
+	return NewDomainListCheckerWithResolver(timeout, lists, nil)
+}
+
+// NewDomainListCheckerWithResolver creates a new domain blocklist checker
+// querying the lists through a custom resolver.
+// If resolver is nil, a StandardDNSResolver will be used.
+func NewDomainListCheckerWithResolver(timeout time.Duration, lists []string, resolver DNSResolver) *DNSListChecker {
+	if resolver == nil {
+		resolver = NewStandardDNSResolver()
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	if len(lists) == 0 {
+		lists = DefaultDomainLists
+	}
+	return &DNSListChecker{
+		Timeout:          timeout,
+		Lists:            lists,
+		filterErrorCodes: true,
+		resolver:         resolver,
+		listType:         DNSListTypeDomain,
+		health:           &dnsListHealth{},
+	}
+}
+
+// CheckEmailDomains checks the registered domains of the From and
+// Return-Path addresses, of the DKIM signatures and of the links of the
+// email against the configured domain lists
+func (r *DNSListChecker) CheckEmailDomains(email *EmailMessage, headers *model.HeaderAnalysis, content *ContentResults) *DNSListResults {
+	results := &DNSListResults{
+		Checks:        make(map[string][]model.BlacklistCheck),
+		DomainSources: make(map[string][]string),
+	}
+
+	results.DomainsChecked = r.extractDomains(email, headers, content, results.DomainSources)
+
+	results.Checks = r.runChecks(results.DomainsChecked, r.checkDomain)
+	for _, domain := range results.DomainsChecked {
+		for _, check := range results.Checks[domain] {
+			if check.Listed {
+				r.countListing(results, check.Rbl)
+			}
+		}
+	}
+
+	return results
+}
+
+// extractDomains returns the registered domains to check, in the order
+// they are found, recording where each one comes from in sources
+func (r *DNSListChecker) extractDomains(email *EmailMessage, headers *model.HeaderAnalysis, content *ContentResults, sources map[string][]string) []string {
+	var domains []string
+	linkDomains := 0
+
+	addDomain := func(name, source string) {
+		name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+		if name == "" || net.ParseIP(name) != nil || !strings.Contains(name, ".") {
+			return
+		}
+		domain := getOrganizationalDomain(name)
+
+		if _, seen := sources[domain]; !seen {
+			if source == domainSourceLink {
+				if linkDomains >= maxLinkDomains {
+					return
+				}
+				linkDomains++
+			}
+			domains = append(domains, domain)
+		}
+		if !slices.Contains(sources[domain], source) {
+			sources[domain] = append(sources[domain], source)
+		}
+	}
+
+	if headers != nil && headers.DomainAlignment != nil {
+		addDomain(utils.DerefOrZero(headers.DomainAlignment.FromDomain), domainSourceFrom)
+		addDomain(utils.DerefOrZero(headers.DomainAlignment.ReturnPathDomain), domainSourceReturnPath)
+	}
+
+	for _, sig := range parseDKIMSignatures(email.Header["Dkim-Signature"]) {
+		addDomain(sig.Domain, domainSourceDKIM)
+	}
+
+	if content != nil {
+		for _, link := range content.Links {
+			if u, err := url.Parse(link.URL); err == nil {
+				addDomain(u.Hostname(), domainSourceLink)
+			}
+		}
+	}
+
+	return domains
+}
+
+// checkDomain checks a single domain against a single domain list
+func (r *DNSListChecker) checkDomain(ctx context.Context, domain, list string) model.BlacklistCheck {
+	check := model.BlacklistCheck{
+		Rbl: list,
+	}
+
+	if r.quarantined(list) {
+		check.Quarantined = utils.PtrTo(true)
+	}
+
+	query := fmt.Sprintf("%s.%s", domain, list)
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupHost(ctx, query)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return check
+		}
+		if lookupTimedOut(ctx, err) {
+			check.TimedOut = utils.PtrTo(true)
+			check.Error = utils.PtrTo("DNS lookup timed out, the listing status is unknown")
+			return check
+		}
+		check.Error = utils.PtrTo(fmt.Sprintf("DNS lookup failed: %s", formatDNSError(err)))
+		return check
+	}
+	if len(addrs) == 0 {
+		return check
+	}
+
+	check.Response = utils.PtrTo(addrs[0])
+
+	code, refused := r.list(list).decodeResponse(addrs)
+	switch {
+	case refused != "":
+		check.Error = utils.PtrTo(fmt.Sprintf("List %s returned error code %s (%s)", list, addrs[0], refused))
+	case r.filterErrorCodes && strings.HasPrefix(addrs[0], "127.255.255."):
+		check.Error = utils.PtrTo(fmt.Sprintf("List %s returned error code %s (list operational issue)", list, addrs[0]))
+	default:
+		check.Listed = true
+		r.describeListing(ctx, &check, query, list, code)
+	}
+
+	return check
+}
+
+// GenerateDomainBlacklists converts the domain list results for the report,
+// in the order the domains were found
+func (r *DNSListChecker) GenerateDomainBlacklists(results *DNSListResults) []model.DomainBlacklist {
+	var blacklists []model.DomainBlacklist
+	for _, domain := range results.DomainsChecked {
+		blacklists = append(blacklists, model.DomainBlacklist{
+			Domain:  domain,
+			Sources: results.DomainSources[domain],
+			Checks:  results.Checks[domain],
+		})
+	}
+	return blacklists
+}
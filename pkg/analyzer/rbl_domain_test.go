@@ -0,0 +1,125 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+func TestCheckEmailDomains(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"example.com.dbl.spamhaus.org":  {"127.0.1.2"},
+			"tracker.test.multi.uribl.com":  {"127.0.0.6"},
+			"tracker.test.multi.surbl.org":  {"127.0.0.1"},
+			"bounces.test.dbl.spamhaus.org": {"127.255.255.254"},
+		},
+	}
+	checker := NewDomainListCheckerWithResolver(time.Second, nil, resolver)
+
+	email := &EmailMessage{Header: mail.Header{
+		"Dkim-Signature": {"v=1; a=rsa-sha256; d=news.example.com; s=sel; h=from; bh=x; b=y"},
+	}}
+	headers := &model.HeaderAnalysis{DomainAlignment: &model.DomainAlignment{
+		FromDomain:       utils.PtrTo("example.com"),
+		ReturnPathDomain: utils.PtrTo("mail.bounces.test"),
+	}}
+	content := &ContentResults{Links: []LinkCheck{
+		{URL: "https://www.example.com/offer"},
+		{URL: "https://click.tracker.test/abc"},
+		{URL: "http://198.51.100.7/login"},
+		{URL: "mailto:"},
+	}}
+
+	results := checker.CheckEmailDomains(email, headers, content)
+
+	if want := []string{"example.com", "bounces.test", "tracker.test"}; !slices.Equal(results.DomainsChecked, want) {
+		t.Fatalf("DomainsChecked = %v, want %v", results.DomainsChecked, want)
+	}
+	if got, want := results.DomainSources["example.com"], []string{domainSourceFrom, domainSourceDKIM, domainSourceLink}; !slices.Equal(got, want) {
+		t.Errorf("sources of example.com = %v, want %v", got, want)
+	}
+	if got, want := results.DomainSources["tracker.test"], []string{domainSourceLink}; !slices.Equal(got, want) {
+		t.Errorf("sources of tracker.test = %v, want %v", got, want)
+	}
+	if results.ListedCount != 2 || results.RelevantListedCount != 2 {
+		t.Errorf("ListedCount = %d, RelevantListedCount = %d, want 2", results.ListedCount, results.RelevantListedCount)
+	}
+
+	check := func(domain, list string) model.BlacklistCheck {
+		for _, c := range results.Checks[domain] {
+			if c.Rbl == list {
+				return c
+			}
+		}
+		t.Fatalf("no check of %s on %s", domain, list)
+		return model.BlacklistCheck{}
+	}
+
+	if c := check("example.com", "dbl.spamhaus.org"); !c.Listed || utils.DerefOrZero(c.Category) != "spam domain" {
+		t.Errorf("example.com on the DBL: %+v", c)
+	}
+	if c := check("tracker.test", "multi.uribl.com"); !c.Listed || utils.DerefOrZero(c.Category) != "black list, grey list" {
+		t.Errorf("tracker.test on URIBL: %+v", c)
+	}
+	if c := check("tracker.test", "multi.surbl.org"); c.Listed || c.Error == nil {
+		t.Errorf("a refused query must not count as a listing: %+v", c)
+	}
+	if c := check("bounces.test", "dbl.spamhaus.org"); c.Listed || c.Error == nil {
+		t.Errorf("a public resolver error must not count as a listing: %+v", c)
+	}
+
+	blacklists := checker.GenerateDomainBlacklists(results)
+	if len(blacklists) != 3 || blacklists[1].Domain != "bounces.test" || len(blacklists[1].Checks) != len(DefaultDomainLists) {
+		t.Errorf("GenerateDomainBlacklists() = %+v", blacklists)
+	}
+
+	score, grade := checker.CalculateScore(results, false)
+	if score != 34 || grade != "F" {
+		t.Errorf("CalculateScore() = %d, %q, want 34, F", score, grade)
+	}
+}
+
+func TestCheckEmailDomainsLinkCap(t *testing.T) {
+	checker := NewDomainListCheckerWithResolver(time.Second, []string{"rhsbl.example.org"}, &spfMockResolver{})
+
+	content := &ContentResults{}
+	for i := range maxLinkDomains + 5 {
+		content.Links = append(content.Links, LinkCheck{URL: fmt.Sprintf("https://link%d.test/", i)})
+	}
+	headers := &model.HeaderAnalysis{DomainAlignment: &model.DomainAlignment{FromDomain: utils.PtrTo("example.com")}}
+
+	results := checker.CheckEmailDomains(&EmailMessage{Header: mail.Header{}}, headers, content)
+	if got := len(results.DomainsChecked); got != maxLinkDomains+1 {
+		t.Errorf("checked %d domains, want %d", got, maxLinkDomains+1)
+	}
+	if score, grade := checker.CalculateScore(results, false); score != 100 || grade != "A+" {
+		t.Errorf("CalculateScore() = %d, %q, want 100, A+", score, grade)
+	}
+}
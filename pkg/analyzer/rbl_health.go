@@ -0,0 +1,213 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+	"git.happydns.org/happyDeliver/internal/utils"
+)
+
+// Test points of RFC 5782: every IP list must list 127.0.0.2 and must not
+// list 127.0.0.1, every domain list must list TEST and must not list
+// INVALID.
+const (
+	defaultIPTestPoint      = "127.0.0.2"
+	ipNegativeTestPoint     = "127.0.0.1"
+	defaultDomainTestPoint  = "test"
+	domainNegativeTestPoint = "invalid"
+)
+
+// dnsListHealth holds the last health check of each zone of a checker. It
+// is shared by the copies of the checker.
+type dnsListHealth struct {
+	mu    sync.RWMutex
+	zones map[string]model.DNSListHealth // by lower-cased zone
+}
+
+// testPoint returns the IP or domain the list must answer for.
+func (l DNSList) testPoint() string {
+	if l.TestPoint != "" {
+		return l.TestPoint
+	}
+	if l.Type == DNSListTypeDomain {
+		return defaultDomainTestPoint
+	}
+	return defaultIPTestPoint
+}
+
+// negativeTestPoint returns the IP or domain the list must not list.
+func (l DNSList) negativeTestPoint() string {
+	if l.Type == DNSListTypeDomain {
+		return domainNegativeTestPoint
+	}
+	return ipNegativeTestPoint
+}
+
+// StartHealthChecks checks the health of the zones now, then at every
+// interval until ctx is done.
+func (r *DNSListChecker) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			r.CheckHealth(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckHealth queries the test points of every zone and quarantines the
+// zones that list everything or no longer list their test point.
+func (r *DNSListChecker) CheckHealth(ctx context.Context) {
+	health := make([]model.DNSListHealth, len(r.Lists))
+	var wg sync.WaitGroup
+	for i, zone := range r.Lists {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health[i] = r.checkZoneHealth(ctx, zone)
+		}()
+	}
+	wg.Wait()
+
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	if r.health.zones == nil {
+		r.health.zones = make(map[string]model.DNSListHealth, len(health))
+	}
+	for _, h := range health {
+		key := strings.ToLower(h.Zone)
+		if previous, ok := r.health.zones[key]; h.Quarantined && (!ok || !previous.Quarantined) {
+			log.Printf("Quarantining DNS list %s: %s", h.Zone, h.Status)
+		} else if !h.Quarantined && ok && previous.Quarantined {
+			log.Printf("DNS list %s is healthy again", h.Zone)
+		}
+		r.health.zones[key] = h
+	}
+}
+
+// checkZoneHealth queries the test points of a zone.
+func (r *DNSListChecker) checkZoneHealth(ctx context.Context, zone string) model.DNSListHealth {
+	list := r.list(zone)
+	health := model.DNSListHealth{
+		Zone:      zone,
+		Type:      string(r.listType),
+		Status:    model.DNSListHealthStatusHealthy,
+		CheckedAt: time.Now(),
+	}
+
+	listed, response, err := r.queryTestPoint(ctx, list, list.testPoint())
+	switch {
+	case err != nil:
+		health.Status = model.DNSListHealthStatusError
+		health.Error = utils.PtrTo(err.Error())
+	case !listed:
+		health.Status = model.DNSListHealthStatusNotListingTestPoint
+	default:
+		health.TestPointResponse = utils.PtrTo(response)
+	}
+
+	// A zone listing what must never be listed would flag every sender,
+	// whatever the answer for its test point.
+	if listed, _, err := r.queryTestPoint(ctx, list, list.negativeTestPoint()); err == nil && listed {
+		health.Status = model.DNSListHealthStatusListingEverything
+		health.Error = nil
+	}
+
+	health.Quarantined = health.Status == model.DNSListHealthStatusListingEverything || health.Status == model.DNSListHealthStatusNotListingTestPoint
+	return health
+}
+
+// queryTestPoint tells whether a zone lists a test point, with its answer.
+// Answers telling the query was refused are errors.
+func (r *DNSListChecker) queryTestPoint(ctx context.Context, list DNSList, point string) (bool, string, error) {
+	name := point
+	if list.Type != DNSListTypeDomain {
+		if name = r.reverseIP(point); name == "" {
+			return false, "", fmt.Errorf("invalid test point %q", point)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupHost(ctx, fmt.Sprintf("%s.%s", name, list.Zone))
+	if err != nil {
+		if isDNSNotFound(err) {
+			return false, "", nil
+		}
+		return false, "", fmt.Errorf("DNS lookup failed: %s", formatDNSError(err))
+	}
+	if len(addrs) == 0 {
+		return false, "", nil
+	}
+
+	if _, refused := list.decodeResponse(addrs); refused != "" {
+		return false, addrs[0], fmt.Errorf("query refused with %s (%s)", addrs[0], refused)
+	}
+	if r.filterErrorCodes && strings.HasPrefix(addrs[0], "127.255.255.") {
+		return false, addrs[0], fmt.Errorf("query refused with %s (list operational issue)", addrs[0])
+	}
+	return true, addrs[0], nil
+}
+
+// Health returns the last health check of the zones, in the order of the
+// lists. Zones not checked yet are left out.
+func (r *DNSListChecker) Health() []model.DNSListHealth {
+	if r.health == nil {
+		return nil
+	}
+	r.health.mu.RLock()
+	defer r.health.mu.RUnlock()
+
+	var health []model.DNSListHealth
+	for _, zone := range r.Lists {
+		if h, ok := r.health.zones[strings.ToLower(zone)]; ok {
+			health = append(health, h)
+		}
+	}
+	return health
+}
+
+// quarantined tells whether the listings of a zone are left out of the
+// score because it failed its last health check.
+func (r *DNSListChecker) quarantined(zone string) bool {
+	if r.health == nil {
+		return false
+	}
+	r.health.mu.RLock()
+	defer r.health.mu.RUnlock()
+	return r.health.zones[strings.ToLower(zone)].Quarantined
+}
@@ -0,0 +1,149 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+func TestCheckHealth(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"2.0.0.127.ok.example.org":           {"127.0.0.2"},
+			"2.0.0.127.everything.example.org":   {"127.0.0.2"},
+			"1.0.0.127.everything.example.org":   {"127.0.0.2"},
+			"2.0.0.127.zen.spamhaus.org":         {"127.255.255.254"},
+			"3.0.0.127.custom.example.org":       {"127.0.0.3"},
+			"1.113.0.203.ok.example.org":         {"127.0.0.2"},
+			"1.113.0.203.everything.example.org": {"127.0.0.2"},
+		},
+	}
+	lists := []string{"ok.example.org", "everything.example.org", "dead.example.org", "zen.spamhaus.org", "custom.example.org"}
+	checker := NewRBLCheckerWithResolver(time.Second, lists, false, resolver)
+	checker.UseCatalog(DNSListCatalog{
+		{Zone: "ok.example.org", Type: DNSListTypeIP},
+		{Zone: "everything.example.org", Type: DNSListTypeIP},
+		{Zone: "dead.example.org", Type: DNSListTypeIP},
+		knownDNSList("zen.spamhaus.org", DNSListTypeIP),
+		{Zone: "custom.example.org", Type: DNSListTypeIP, TestPoint: "127.0.0.3"},
+	}, nil)
+
+	if health := checker.Health(); len(health) != 0 {
+		t.Fatalf("Health() before any check = %+v", health)
+	}
+
+	checker.CheckHealth(context.Background())
+
+	health := checker.Health()
+	if len(health) != len(lists) {
+		t.Fatalf("Health() returned %d zones, want %d", len(health), len(lists))
+	}
+	want := []struct {
+		status      model.DNSListHealthStatus
+		quarantined bool
+	}{
+		{model.DNSListHealthStatusHealthy, false},
+		{model.DNSListHealthStatusListingEverything, true},
+		{model.DNSListHealthStatusNotListingTestPoint, true},
+		{model.DNSListHealthStatusError, false},
+		{model.DNSListHealthStatusHealthy, false},
+	}
+	for i, h := range health {
+		if h.Zone != lists[i] || h.Type != string(DNSListTypeIP) || h.Status != want[i].status || h.Quarantined != want[i].quarantined || h.CheckedAt.IsZero() {
+			t.Errorf("Health()[%d] = %+v, want %s, quarantined %t", i, h, want[i].status, want[i].quarantined)
+		}
+	}
+	if health[3].Error == nil {
+		t.Error("a refused test point query should report why")
+	}
+
+	// Listings on quarantined zones don't count toward the score
+	results := checker.CheckEmail(receivedFrom("203.0.113.1"))
+	if results.ListedCount != 1 || results.RelevantListedCount != 1 {
+		t.Errorf("ListedCount = %d, RelevantListedCount = %d, want 1", results.ListedCount, results.RelevantListedCount)
+	}
+	checks := results.Checks["203.0.113.1"]
+	if !checks[1].Listed || checks[1].Quarantined == nil || !*checks[1].Quarantined {
+		t.Errorf("check on a quarantined zone = %+v", checks[1])
+	}
+	if checks[0].Quarantined != nil {
+		t.Errorf("check on a healthy zone = %+v", checks[0])
+	}
+	// 3 scoring lists left: ok, zen and custom
+	if score, _ := checker.CalculateScore(results, false); score != 67 {
+		t.Errorf("CalculateScore() = %d, want 67", score)
+	}
+}
+
+func TestCheckHealthDomainLists(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"test.uri.example.org":         {"127.0.0.2"},
+			"dbltest.com.dbl.spamhaus.org": {"127.0.1.2"},
+			"test.broken.example.org":      {"127.0.0.2"},
+			"invalid.broken.example.org":   {"127.0.0.2"},
+		},
+	}
+	checker := NewDomainListCheckerWithResolver(time.Second, []string{"uri.example.org", "dbl.spamhaus.org", "broken.example.org"}, resolver)
+	checker.CheckHealth(context.Background())
+
+	health := checker.Health()
+	if len(health) != 3 {
+		t.Fatalf("Health() = %+v", health)
+	}
+	if health[0].Status != model.DNSListHealthStatusHealthy || health[0].Type != string(DNSListTypeDomain) {
+		t.Errorf("uri.example.org = %+v, want healthy", health[0])
+	}
+	if health[1].Status != model.DNSListHealthStatusHealthy || health[1].TestPointResponse == nil || *health[1].TestPointResponse != "127.0.1.2" {
+		t.Errorf("dbl.spamhaus.org = %+v, want healthy through its own test point", health[1])
+	}
+	if health[2].Status != model.DNSListHealthStatusListingEverything || !health[2].Quarantined {
+		t.Errorf("broken.example.org = %+v, want quarantined", health[2])
+	}
+}
+
+func TestStartHealthChecks(t *testing.T) {
+	resolver := &spfMockResolver{hosts: map[string][]string{"2.0.0.127.ok.example.org": {"127.0.0.2"}}}
+	checker := NewRBLCheckerWithResolver(time.Second, []string{"ok.example.org"}, false, resolver)
+
+	// Copies of the checker, as used for reanalyses, share the health
+	copied := *checker
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	checker.StartHealthChecks(ctx, time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(copied.Health()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the first health check did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h := copied.Health()[0]; h.Status != model.DNSListHealthStatusHealthy {
+		t.Errorf("Health() = %+v, want healthy", h)
+	}
+}
@@ -0,0 +1,167 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"git.happydns.org/happyDeliver/internal/model"
+)
+
+const (
+	// MaxScanAddresses is the largest number of addresses a scan may cover:
+	// a /22 in IPv4 or a /118 in IPv6.
+	MaxScanAddresses = 1024
+
+	// scanWorkers is the number of addresses of a scan checked at once; the
+	// queries in flight remain bounded by the concurrency limit of the checker.
+	scanWorkers = 8
+)
+
+// ScanTargets returns the addresses of a scan: those of the CIDR range,
+// then the given IPs, without duplicates. All of them must be public.
+func (r *DNSListChecker) ScanTargets(cidr string, ips []string) ([]string, error) {
+	var targets []string
+	seen := make(map[string]bool)
+
+	add := func(addr netip.Addr) error {
+		ip := addr.Unmap().String()
+		if !r.isPublicIP(ip) {
+			return fmt.Errorf("invalid or non-public IP address: %s", ip)
+		}
+		if !seen[ip] {
+			if len(targets) == MaxScanAddresses {
+				return fmt.Errorf("too many addresses to scan, the limit is %d", MaxScanAddresses)
+			}
+			seen[ip] = true
+			targets = append(targets, ip)
+		}
+		return nil
+	}
+
+	if cidr = strings.TrimSpace(cidr); cidr != "" {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range: %s", cidr)
+		}
+		prefix = prefix.Masked()
+		if hostBits := prefix.Addr().BitLen() - prefix.Bits(); hostBits > 10 {
+			return nil, fmt.Errorf("CIDR range %s is too large, the limit is %d addresses", cidr, MaxScanAddresses)
+		}
+		for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+			if err := add(addr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		if err != nil || addr.Zone() != "" {
+			return nil, fmt.Errorf("invalid or non-public IP address: %s", ip)
+		}
+		if err := add(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no address to scan")
+	}
+
+	return targets, nil
+}
+
+// ScanIPs checks each address against all configured lists, a few addresses
+// at a time, each within its own deadline. It returns the checks of each
+// address, in the order of the addresses, and how many of them each list has
+// listed; listings on quarantined zones are left out of the counts. progress,
+// when not nil, is called with the number of addresses done after each of
+// them.
+func (r *DNSListChecker) ScanIPs(ips []string, progress func(done int)) ([]model.BlacklistScanResult, model.BlacklistScanSummary) {
+	results := make([]model.BlacklistScanResult, len(ips))
+
+	var (
+		mu   sync.Mutex
+		done int
+		wg   sync.WaitGroup
+	)
+	next := make(chan int)
+	for range min(scanWorkers, len(ips)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				checks := r.runChecks([]string{ips[i]}, r.checkIP)[ips[i]]
+
+				listing := &DNSListResults{}
+				for _, check := range checks {
+					if check.Listed {
+						r.countListing(listing, check.Rbl)
+					}
+				}
+				results[i] = model.BlacklistScanResult{
+					Ip:          ips[i],
+					ListedCount: listing.ListedCount,
+					Checks:      checks,
+				}
+
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(done)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range ips {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	summary := model.BlacklistScanSummary{
+		Lists: make([]model.BlacklistScanListSummary, len(r.Lists)),
+	}
+	for j, list := range r.Lists {
+		summary.Lists[j].Rbl = list
+	}
+	for _, result := range results {
+		if result.ListedCount > 0 {
+			summary.ListedIps++
+		}
+		for j, check := range result.Checks {
+			if check.Listed && !r.quarantined(check.Rbl) {
+				summary.Lists[j].ListedCount++
+			}
+			if check.Error != nil {
+				summary.Lists[j].ErrorCount++
+			}
+		}
+	}
+
+	return results, summary
+}
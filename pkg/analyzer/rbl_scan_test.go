@@ -0,0 +1,183 @@
+// This file is part of the happyDeliver (R) project.
+// Copyright (c) 2025 happyDomain
+// Authors: Pierre-Olivier Mercier, et al.
+//
+// This program is offered under a commercial and under the AGPL license.
+// For commercial licensing, contact us at <contact@happydomain.org>.
+//
+// For AGPL licensing:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package analyzer
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"git.happydns.org/happyDeliver/internal/config"
+)
+
+func TestScanTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidr      string
+		ips       []string
+		wantCount int
+		wantFirst string
+		wantLast  string
+		wantErr   bool
+	}{
+		{name: "IPv4 /30", cidr: "203.0.113.0/30", wantCount: 4, wantFirst: "203.0.113.0", wantLast: "203.0.113.3"},
+		{name: "IPv4 /22", cidr: "198.51.100.0/22", wantCount: 1024, wantFirst: "198.51.100.0", wantLast: "198.51.103.255"},
+		{name: "host bits are masked", cidr: "203.0.113.7/31", wantCount: 2, wantFirst: "203.0.113.6", wantLast: "203.0.113.7"},
+		{name: "single host", cidr: "203.0.113.7/32", wantCount: 1, wantFirst: "203.0.113.7", wantLast: "203.0.113.7"},
+		{name: "IPv6 /126", cidr: "2001:db8::/126", wantCount: 4, wantFirst: "2001:db8::", wantLast: "2001:db8::3"},
+		{name: "IPv4 /21 is too large", cidr: "198.51.96.0/21", wantErr: true},
+		{name: "IPv6 /117 is too large", cidr: "2001:db8::/117", wantErr: true},
+		{name: "invalid CIDR", cidr: "203.0.113.0/33", wantErr: true},
+		{name: "private range", cidr: "192.168.1.0/24", wantErr: true},
+		{name: "IP list", ips: []string{"203.0.113.1", "2001:db8::1", "::ffff:203.0.113.1"}, wantCount: 2, wantFirst: "203.0.113.1", wantLast: "2001:db8::1"},
+		{name: "range and list", cidr: "203.0.113.0/31", ips: []string{"203.0.113.1", "198.51.100.1"}, wantCount: 3, wantFirst: "203.0.113.0", wantLast: "198.51.100.1"},
+		{name: "invalid IP", ips: []string{"203.0.113.1", "not-an-ip"}, wantErr: true},
+		{name: "loopback IP", ips: []string{"127.0.0.1"}, wantErr: true},
+		{name: "nothing to scan", wantErr: true},
+	}
+
+	checker := NewRBLCheckerWithResolver(time.Second, nil, false, &spfMockResolver{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := checker.ScanTargets(tt.cidr, tt.ips)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScanTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(targets) != tt.wantCount || targets[0] != tt.wantFirst || targets[len(targets)-1] != tt.wantLast {
+				t.Errorf("ScanTargets() = %d addresses from %s to %s, want %d from %s to %s",
+					len(targets), targets[0], targets[len(targets)-1], tt.wantCount, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+
+	// The limit also holds for lists
+	ips := make([]string, 0, MaxScanAddresses+1)
+	for i := range MaxScanAddresses + 1 {
+		ips = append(ips, net.IPv4(198, 51, byte(100+i/256), byte(i%256)).String())
+	}
+	if _, err := checker.ScanTargets("", ips); err == nil {
+		t.Errorf("ScanTargets() of %d IPs should fail", len(ips))
+	}
+	if _, err := checker.ScanTargets("", ips[:MaxScanAddresses]); err != nil {
+		t.Errorf("ScanTargets() of %d IPs: %v", MaxScanAddresses, err)
+	}
+}
+
+func TestScanIPs(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"2.0.0.127.bl1.example.org":          {"127.0.0.2"},
+			"2.0.0.127.everything.example.org":   {"127.0.0.2"},
+			"1.0.0.127.everything.example.org":   {"127.0.0.2"},
+			"1.113.0.203.bl1.example.org":        {"127.0.0.2"},
+			"3.113.0.203.bl1.example.org":        {"127.0.0.2"},
+			"3.113.0.203.bl2.example.org":        {"127.0.0.2"},
+			"0.113.0.203.everything.example.org": {"127.0.0.2"},
+			"1.113.0.203.everything.example.org": {"127.0.0.2"},
+			"2.113.0.203.everything.example.org": {"127.0.0.2"},
+			"3.113.0.203.everything.example.org": {"127.0.0.2"},
+			"2.0.0.127.bl2.example.org":          {"127.0.0.2"},
+		},
+		err: map[string]error{
+			"0.113.0.203.bl2.example.org": &net.DNSError{Err: "server misbehaving", Name: "0.113.0.203.bl2.example.org", IsTemporary: true},
+		},
+	}
+	lists := []string{"bl1.example.org", "bl2.example.org", "everything.example.org"}
+	checker := NewRBLCheckerWithResolver(time.Second, lists, false, resolver)
+	checker.CheckHealth(context.Background())
+
+	ips, err := checker.ScanTargets("203.0.113.0/30", nil)
+	if err != nil {
+		t.Fatalf("ScanTargets() error = %v", err)
+	}
+
+	var progress []int
+	results, summary := checker.ScanIPs(ips, func(done int) {
+		progress = append(progress, done)
+	})
+
+	if !slices.Equal(progress, []int{1, 2, 3, 4}) {
+		t.Errorf("progress = %v, want each address reported once", progress)
+	}
+
+	// The quarantined zone lists everything, but doesn't count
+	wantListed := []int{0, 1, 0, 2}
+	if len(results) != len(ips) {
+		t.Fatalf("ScanIPs() returned %d results, want %d", len(results), len(ips))
+	}
+	for i, result := range results {
+		if result.Ip != ips[i] || len(result.Checks) != len(lists) || result.ListedCount != wantListed[i] {
+			t.Errorf("results[%d] = %s, %d checks, listed %d; want %s, %d checks, listed %d",
+				i, result.Ip, len(result.Checks), result.ListedCount, ips[i], len(lists), wantListed[i])
+		}
+		for j, check := range result.Checks {
+			if check.Rbl != lists[j] {
+				t.Errorf("results[%d].Checks[%d].Rbl = %s, want %s", i, j, check.Rbl, lists[j])
+			}
+		}
+	}
+
+	if summary.ListedIps != 2 {
+		t.Errorf("summary.ListedIps = %d, want 2", summary.ListedIps)
+	}
+	wantLists := []struct {
+		listed, errors int
+	}{{2, 0}, {1, 1}, {0, 0}}
+	if len(summary.Lists) != len(lists) {
+		t.Fatalf("summary.Lists has %d lists, want %d", len(summary.Lists), len(lists))
+	}
+	for j, list := range summary.Lists {
+		if list.Rbl != lists[j] || list.ListedCount != wantLists[j].listed || list.ErrorCount != wantLists[j].errors {
+			t.Errorf("summary.Lists[%d] = %+v, want %s listing %d with %d errors", j, list, lists[j], wantLists[j].listed, wantLists[j].errors)
+		}
+	}
+
+	if results, summary := checker.ScanIPs(nil, nil); len(results) != 0 || summary.ListedIps != 0 || len(summary.Lists) != len(lists) {
+		t.Errorf("ScanIPs(nil) = %v, %+v", results, summary)
+	}
+}
+
+func TestScanCheckerIsolation(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Analysis.DNSCacheSize = 100
+	cfg.Analysis.DNSListConcurrency = 4
+	a := NewEmailAnalyzer(cfg)
+
+	messages := a.generator.rblChecker
+	if a.scanChecker.slots == nil || a.scanChecker.slots == messages.slots || cap(a.scanChecker.slots) != 4 {
+		t.Errorf("scans share the slots of the messages")
+	}
+	if _, ok := messages.resolver.(*CachingDNSResolver); !ok {
+		t.Errorf("the messages checks don't use the cache")
+	}
+	if _, ok := a.scanChecker.resolver.(*CachingDNSResolver); ok {
+		t.Errorf("scans use the DNS cache")
+	}
+	if !slices.Equal(a.scanChecker.Lists, messages.Lists) {
+		t.Errorf("scan lists = %v, want %v", a.scanChecker.Lists, messages.Lists)
+	}
+}
@@ -22,7 +22,11 @@
 package analyzer
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/mail"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -69,6 +73,45 @@ func TestNewRBLChecker(t *testing.T) {
 	}
 }
 
+func TestDNSListCheckerWithResolver(t *testing.T) {
+	resolver := &spfMockResolver{
+		hosts: map[string][]string{
+			"1.113.0.203.bl.example.org": {"127.0.0.2"},
+			"2.113.0.203.bl.example.org": {"127.255.255.254"},
+			"1.113.0.203.wl.example.org": {"127.0.10.1"},
+		},
+		err: map[string]error{
+			"3.113.0.203.bl.example.org": &net.DNSError{Err: "server misbehaving", Name: "3.113.0.203.bl.example.org", IsTemporary: true},
+		},
+	}
+	rbl := NewRBLCheckerWithResolver(time.Second, []string{"bl.example.org"}, false, resolver)
+	dnswl := NewDNSWLCheckerWithResolver(time.Second, []string{"wl.example.org"}, false, resolver)
+
+	tests := []struct {
+		name       string
+		checker    *DNSListChecker
+		ip         string
+		list       string
+		wantListed bool
+		wantError  bool
+	}{
+		{"listed", rbl, "203.0.113.1", "bl.example.org", true, false},
+		{"not listed", rbl, "203.0.113.4", "bl.example.org", false, false},
+		{"operational error code", rbl, "203.0.113.2", "bl.example.org", false, true},
+		{"lookup failure", rbl, "203.0.113.3", "bl.example.org", false, true},
+		{"whitelisted", dnswl, "203.0.113.1", "wl.example.org", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := tt.checker.checkIP(context.Background(), tt.ip, tt.list)
+			if check.Listed != tt.wantListed || (check.Error != nil) != tt.wantError {
+				t.Errorf("checkIP = listed %t, error %v; want listed %t, error %t", check.Listed, check.Error, tt.wantListed, tt.wantError)
+			}
+		})
+	}
+}
+
 func TestReverseIP(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -498,3 +541,159 @@ func TestDefaultRBLs(t *testing.T) {
 		}
 	}
 }
+
+// delayResolver answers like spfMockResolver after a delay, and never
+// answers the names in hang before the context ends. It records the
+// highest number of lookups in flight.
+type delayResolver struct {
+	spfMockResolver
+	delay       time.Duration
+	hang        map[string]bool
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (m *delayResolver) LookupHost(ctx context.Context, name string) ([]string, error) {
+	if err := m.wait(ctx, name); err != nil {
+		return nil, err
+	}
+	return m.spfMockResolver.LookupHost(ctx, name)
+}
+
+func (m *delayResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := m.wait(ctx, name); err != nil {
+		return nil, err
+	}
+	return m.spfMockResolver.LookupTXT(ctx, name)
+}
+
+func (m *delayResolver) wait(ctx context.Context, name string) error {
+	n := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		current := m.maxInFlight.Load()
+		if n <= current || m.maxInFlight.CompareAndSwap(current, n) {
+			break
+		}
+	}
+
+	wait := m.delay
+	if m.hang[name] {
+		wait = time.Hour
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+}
+
+// receivedFrom returns an email relayed through the given IPs.
+func receivedFrom(ips ...string) *EmailMessage {
+	email := &EmailMessage{Header: mail.Header{}}
+	for _, ip := range ips {
+		email.Header["Received"] = append(email.Header["Received"], fmt.Sprintf("from relay (relay [%s]) by mx.example.com", ip))
+	}
+	return email
+}
+
+func TestCheckEmailConcurrency(t *testing.T) {
+	resolver := &delayResolver{
+		spfMockResolver: spfMockResolver{hosts: map[string][]string{
+			"2.113.0.203.bl2.example.org": {"127.0.0.2"},
+		}},
+		delay: 20 * time.Millisecond,
+	}
+	lists := []string{"bl1.example.org", "bl2.example.org", "bl3.example.org", "bl4.example.org"}
+	email := receivedFrom("203.0.113.1", "203.0.113.2", "203.0.113.3")
+
+	checker := NewRBLCheckerWithResolver(5*time.Second, lists, true, resolver)
+	start := time.Now()
+	results := checker.CheckEmail(email)
+	if elapsed := time.Since(start); elapsed > 6*resolver.delay {
+		t.Errorf("CheckEmail() took %v, the queries should run concurrently", elapsed)
+	}
+	if len(results.Checks) != 3 || results.ListedCount != 1 {
+		t.Fatalf("CheckEmail() = %+v", results)
+	}
+	for ip, checks := range results.Checks {
+		for i, check := range checks {
+			if check.Rbl != lists[i] {
+				t.Errorf("check %d of %s is for %s, want %s", i, ip, check.Rbl, lists[i])
+			}
+		}
+	}
+
+	resolver.maxInFlight.Store(0)
+	checker.slots = make(chan struct{}, 2)
+	results = checker.CheckEmail(email)
+	if got := resolver.maxInFlight.Load(); got > 2 {
+		t.Errorf("%d queries in flight, want at most 2", got)
+	}
+	if results.ListedCount != 1 {
+		t.Errorf("ListedCount = %d, want 1", results.ListedCount)
+	}
+}
+
+func TestCheckEmailDeadline(t *testing.T) {
+	resolver := &delayResolver{
+		spfMockResolver: spfMockResolver{hosts: map[string][]string{
+			"1.113.0.203.bl1.example.org": {"127.0.0.2"},
+		}},
+		hang: map[string]bool{"1.113.0.203.slow.example.org": true},
+	}
+	checker := NewRBLCheckerWithResolver(5*time.Second, []string{"bl1.example.org", "slow.example.org", "bl2.example.org"}, false, resolver)
+	checker.Deadline = 50 * time.Millisecond
+
+	start := time.Now()
+	results := checker.CheckEmail(receivedFrom("203.0.113.1"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CheckEmail() took %v, want the deadline to cut it", elapsed)
+	}
+
+	checks := results.Checks["203.0.113.1"]
+	if len(checks) != 3 {
+		t.Fatalf("got %d checks, want 3", len(checks))
+	}
+	if !checks[0].Listed || checks[0].TimedOut != nil {
+		t.Errorf("bl1 check = %+v, want listed", checks[0])
+	}
+	if checks[1].Listed || checks[1].TimedOut == nil || !*checks[1].TimedOut || checks[1].Error == nil {
+		t.Errorf("slow check = %+v, want timed out", checks[1])
+	}
+	if checks[2].Listed || checks[2].TimedOut != nil || checks[2].Error != nil {
+		t.Errorf("bl2 check = %+v, want not listed", checks[2])
+	}
+}
+
+func BenchmarkCheckEmail(b *testing.B) {
+	resolver := &delayResolver{
+		spfMockResolver: spfMockResolver{hosts: map[string][]string{
+			"1.113.0.203.zen.spamhaus.org": {"127.0.0.2"},
+		}},
+		delay: time.Millisecond,
+	}
+	email := receivedFrom("203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4")
+
+	benchmarks := []struct {
+		name        string
+		checkAllIPs bool
+		slots       int
+	}{
+		{"FirstIP", false, 0},
+		{"AllIPs", true, 0},
+		{"AllIPsLimited", true, 8},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			checker := NewRBLCheckerWithResolver(5*time.Second, nil, bm.checkAllIPs, resolver)
+			if bm.slots > 0 {
+				checker.slots = make(chan struct{}, bm.slots)
+			}
+			for b.Loop() {
+				checker.CheckEmail(email)
+			}
+		})
+	}
+}
@@ -22,6 +22,8 @@
 package analyzer
 
 import (
+	"net/http"
+	"sync"
 	"time"
 
 	"git.happydns.org/happyDeliver/internal/model"
@@ -37,6 +39,7 @@ type ReportGenerator struct {
 	dnsAnalyzer     *DNSAnalyzer
 	rblChecker      *DNSListChecker
 	dnswlChecker    *DNSListChecker
+	domainChecker   *DNSListChecker
 	contentAnalyzer *ContentAnalyzer
 	headerAnalyzer  *HeaderAnalyzer
 }
@@ -51,18 +54,115 @@ func NewReportGenerator(
 	checkAllIPs bool,
 	rspamdAPIURL string,
 ) *ReportGenerator {
+	return NewReportGeneratorWithResolvers(nil, nil, receiverHostname, dnsTimeout, httpTimeout, rbls, dnswls, checkAllIPs, rspamdAPIURL)
+}
+
+// NewReportGeneratorWithResolvers creates a new report generator with
+// custom resolvers: recordResolver for the authentication and DNS record
+// checks, listResolver for the RBL and DNSWL queries.
+// A nil resolver is replaced by a StandardDNSResolver.
+func NewReportGeneratorWithResolvers(
+	recordResolver DNSResolver,
+	listResolver DNSResolver,
+	receiverHostname string,
+	dnsTimeout time.Duration,
+	httpTimeout time.Duration,
+	rbls []string,
+	dnswls []string,
+	checkAllIPs bool,
+	rspamdAPIURL string,
+) *ReportGenerator {
+	if recordResolver == nil {
+		recordResolver = NewStandardDNSResolver()
+	}
+	if listResolver == nil {
+		listResolver = NewStandardDNSResolver()
+	}
+
 	return &ReportGenerator{
-		authAnalyzer:    NewAuthenticationAnalyzer(receiverHostname),
+		authAnalyzer:    NewAuthenticationAnalyzerWithResolver(receiverHostname, dnsTimeout, recordResolver),
 		spamAnalyzer:    NewSpamAssassinAnalyzer(),
 		rspamdAnalyzer:  NewRspamdAnalyzer(LoadRspamdSymbols(rspamdAPIURL)),
-		dnsAnalyzer:     NewDNSAnalyzer(dnsTimeout),
-		rblChecker:      NewRBLChecker(dnsTimeout, rbls, checkAllIPs),
-		dnswlChecker:    NewDNSWLChecker(dnsTimeout, dnswls, checkAllIPs),
+		dnsAnalyzer:     NewDNSAnalyzerWithResolver(dnsTimeout, recordResolver),
+		rblChecker:      NewRBLCheckerWithResolver(dnsTimeout, rbls, checkAllIPs, listResolver),
+		dnswlChecker:    NewDNSWLCheckerWithResolver(dnsTimeout, dnswls, checkAllIPs, listResolver),
+		domainChecker:   NewDomainListCheckerWithResolver(dnsTimeout, nil, listResolver),
 		contentAnalyzer: NewContentAnalyzer(httpTimeout),
-		headerAnalyzer:  NewHeaderAnalyzer(),
+		headerAnalyzer:  NewHeaderAnalyzerWithResolver(dnsTimeout, recordResolver),
 	}
 }
 
+// withFreshDNS returns a copy of the generator whose lookups bypass the DNS
+// cache, still refreshing it with the answers.
+func (r *ReportGenerator) withFreshDNS() *ReportGenerator {
+	return r.withResolvers(freshDNSResolver)
+}
+
+// DNSListHealth returns the last health check of the RBL, DNSWL and domain
+// list zones, for the zones checked so far.
+func (r *ReportGenerator) DNSListHealth() []model.DNSListHealth {
+	var health []model.DNSListHealth
+	for _, checker := range r.dnsListCheckers() {
+		health = append(health, checker.Health()...)
+	}
+	return health
+}
+
+// dnsListCheckers returns the RBL, DNSWL and domain list checkers.
+func (r *ReportGenerator) dnsListCheckers() []*DNSListChecker {
+	return []*DNSListChecker{r.rblChecker, r.dnswlChecker, r.domainChecker}
+}
+
+// withResolvers returns a copy of the generator whose analyzers use the
+// resolvers returned by wrap in place of their own.
+func (r *ReportGenerator) withResolvers(wrap func(DNSResolver) DNSResolver) *ReportGenerator {
+	generator := *r
+
+	authAnalyzer := *r.authAnalyzer
+	authAnalyzer.resolver = wrap(authAnalyzer.resolver)
+	generator.authAnalyzer = &authAnalyzer
+
+	dnsAnalyzer := *r.dnsAnalyzer
+	dnsAnalyzer.resolver = wrap(dnsAnalyzer.resolver)
+	generator.dnsAnalyzer = &dnsAnalyzer
+
+	rblChecker := *r.rblChecker
+	rblChecker.resolver = wrap(rblChecker.resolver)
+	generator.rblChecker = &rblChecker
+
+	dnswlChecker := *r.dnswlChecker
+	dnswlChecker.resolver = wrap(dnswlChecker.resolver)
+	generator.dnswlChecker = &dnswlChecker
+
+	domainChecker := *r.domainChecker
+	domainChecker.resolver = wrap(domainChecker.resolver)
+	generator.domainChecker = &domainChecker
+
+	headerAnalyzer := *r.headerAnalyzer
+	headerAnalyzer.resolver = wrap(headerAnalyzer.resolver)
+	generator.headerAnalyzer = &headerAnalyzer
+
+	return &generator
+}
+
+// withTransports returns a copy of the generator whose HTTP requests go
+// through the transports returned by wrap in place of their own.
+func (r *ReportGenerator) withTransports(wrap func(http.RoundTripper) http.RoundTripper) *ReportGenerator {
+	generator := *r
+
+	dnsAnalyzer := *r.dnsAnalyzer
+	dnsAnalyzer.httpTransport = wrap(r.dnsAnalyzer.httpClient().Transport)
+	generator.dnsAnalyzer = &dnsAnalyzer
+
+	contentAnalyzer := *r.contentAnalyzer
+	httpClient := *r.contentAnalyzer.httpClient
+	httpClient.Transport = wrap(httpClient.Transport)
+	contentAnalyzer.httpClient = &httpClient
+	generator.contentAnalyzer = &contentAnalyzer
+
+	return &generator
+}
+
 // AnalysisResults contains all intermediate analysis results
 type AnalysisResults struct {
 	Email          *EmailMessage
@@ -72,12 +172,41 @@ type AnalysisResults struct {
 	Headers        *model.HeaderAnalysis
 	RBL            *DNSListResults
 	DNSWL          *DNSListResults
+	DomainLists    *DNSListResults
 	SpamAssassin   *model.SpamAssassinResult
 	Rspamd         *model.RspamdResult
+	DNSSnapshot    *model.DNSSnapshot
 }
 
-// AnalyzeEmail performs complete email analysis
+// AnalyzeEmail performs complete email analysis, capturing the DNS answers,
+// HTTP responses and SMTP probes it relied on
 func (r *ReportGenerator) AnalyzeEmail(email *EmailMessage) *AnalysisResults {
+	recorder := newDNSSnapshotRecorder()
+	generator := r.withResolvers(recorder.resolver).withTransports(recorder.transport)
+	generator.dnsAnalyzer.authoritativeExchange = recorder.exchange(r.dnsAnalyzer.authoritativeExchange)
+	generator.dnsAnalyzer.smtpProber = recorder.smtpProber(r.dnsAnalyzer.probeMX)
+
+	results := generator.analyzeEmail(email)
+	results.DNSSnapshot = recorder.snapshot()
+	return results
+}
+
+// ReplayEmail performs complete email analysis offline, answering the DNS
+// queries, HTTP requests and SMTP probes from a snapshot of a previous
+// analysis. Those missing from the snapshot fail.
+func (r *ReportGenerator) ReplayEmail(email *EmailMessage, snapshot *model.DNSSnapshot) *AnalysisResults {
+	replay := NewDNSReplayResolver(snapshot)
+	generator := r.withResolvers(func(DNSResolver) DNSResolver { return replay }).
+		withTransports(func(http.RoundTripper) http.RoundTripper { return roundTripperFunc(replay.roundTrip) })
+	generator.dnsAnalyzer.authoritativeExchange = replay.exchange
+	generator.dnsAnalyzer.smtpProber = replay.smtpProbe
+
+	results := generator.analyzeEmail(email)
+	results.DNSSnapshot = snapshot
+	return results
+}
+
+func (r *ReportGenerator) analyzeEmail(email *EmailMessage) *AnalysisResults {
 	results := &AnalysisResults{
 		Email: email,
 	}
@@ -90,11 +219,24 @@ func (r *ReportGenerator) AnalyzeEmail(email *EmailMessage) *AnalysisResults {
 		r.authAnalyzer.ReconcileXTLS(results.Authentication, results.Headers.ReceivedChain)
 	}
 	results.DNS = r.dnsAnalyzer.AnalyzeDNS(email, results.Headers)
+	// Fall back to our own SPF and DMARC evaluations when no upstream verdict was present.
+	if results.DNS != nil {
+		r.authAnalyzer.ReconcileSPF(results.Authentication, results.DNS.SpfEvaluation)
+		r.authAnalyzer.EvaluateDMARC(results.Authentication, results.DNS)
+	}
+	// The RBL and DNSWL queries run side by side, within the same deadline
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results.DNSWL = r.dnswlChecker.CheckEmail(email)
+	}()
 	results.RBL = r.rblChecker.CheckEmail(email)
-	results.DNSWL = r.dnswlChecker.CheckEmail(email)
+	wg.Wait()
 	results.SpamAssassin = r.spamAnalyzer.AnalyzeSpamAssassin(email)
 	results.Rspamd = r.rspamdAnalyzer.AnalyzeRspamd(email)
 	results.Content = r.contentAnalyzer.AnalyzeContent(email)
+	results.DomainLists = r.domainChecker.CheckEmailDomains(email, results.Headers, results.Content)
 
 	return results
 }
@@ -150,6 +292,16 @@ func (r *ReportGenerator) GenerateReport(testID uuid.UUID, results *AnalysisResu
 		blacklistScore, blacklistGrade = r.rblChecker.CalculateScore(results.RBL, false)
 		_, whitelistGrade = r.dnswlChecker.CalculateScore(results.DNSWL, true)
 	}
+	// A listed sender or link domain weighs as much as a listed IP
+	if results.DomainLists != nil && len(results.DomainLists.DomainsChecked) > 0 {
+		domainScore, domainGrade := r.domainChecker.CalculateScore(results.DomainLists, false)
+		if blacklistGrade == "" {
+			blacklistScore, blacklistGrade = domainScore, domainGrade
+		} else {
+			blacklistScore = min(blacklistScore, domainScore)
+			blacklistGrade = MinGrade(blacklistGrade, domainGrade)
+		}
+	}
 
 	saScore, saGrade := r.spamAnalyzer.CalculateSpamAssassinScore(results.SpamAssassin)
 	rspamdScore, rspamdGrade := r.rspamdAnalyzer.CalculateRspamdScore(results.Rspamd)
@@ -210,11 +362,21 @@ func (r *ReportGenerator) GenerateReport(testID uuid.UUID, results *AnalysisResu
 		report.Blacklists = &results.RBL.Checks
 	}
 
+	// Add domain blocklist checks, per domain found in the email
+	if results.DomainLists != nil && len(results.DomainLists.DomainsChecked) > 0 {
+		domainBlacklists := r.domainChecker.GenerateDomainBlacklists(results.DomainLists)
+		report.DomainBlacklists = &domainBlacklists
+	}
+
 	// Add whitelist checks as a map of IP -> array of BlacklistCheck (informational only)
 	if results.DNSWL != nil && len(results.DNSWL.Checks) > 0 {
 		report.Whitelists = &results.DNSWL.Checks
 	}
 
+	if health := r.DNSListHealth(); len(health) > 0 {
+		report.DnsListHealth = &health
+	}
+
 	// Add SpamAssassin result with individual deliverability score
 	if results.SpamAssassin != nil {
 		saGradeTyped := model.SpamAssassinResultDeliverabilityGrade(saGrade)
@@ -236,6 +398,9 @@ func (r *ReportGenerator) GenerateReport(testID uuid.UUID, results *AnalysisResu
 		report.RawHeaders = &results.Email.RawHeaders
 	}
 
+	// Keep the DNS answers the analysis relied on
+	report.DnsSnapshot = results.DNSSnapshot
+
 	// Calculate overall score as mean of all category scores
 	categoryScores := []int{
 		report.Summary.DnsScore,